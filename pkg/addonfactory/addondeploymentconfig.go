@@ -54,26 +54,34 @@ func GetAddOnDeloymentConfigValues(
 	getter AddOnDeloymentConfigGetter, toValuesFuncs ...AddOnDeloymentConfigToValuesFunc) GetValuesFunc {
 	return func(cluster *clusterv1.ManagedCluster, addon *addonapiv1alpha1.ManagedClusterAddOn) (Values, error) {
 		var lastValues = Values{}
+		var valuesOfConfigs []sourcedValues
 		for _, config := range addon.Status.ConfigReferences {
 			if config.ConfigGroupResource.Group != AddOnDeploymentConfigGVR.Group ||
 				config.ConfigGroupResource.Resource != AddOnDeploymentConfigGVR.Resource {
 				continue
 			}
 
+			if err := checkConfigRolledBack(addon, config); err != nil {
+				return nil, err
+			}
 			addOnDeploymentConfig, err := getter.Get(context.Background(), config.Namespace, config.Name)
 			if err != nil {
 				return nil, err
 			}
 
+			configValues := sourcedValues{source: fmt.Sprintf("%s/%s", config.Namespace, config.Name), values: Values{}}
 			for _, toValuesFunc := range toValuesFuncs {
 				values, err := toValuesFunc(*addOnDeploymentConfig)
 				if err != nil {
 					return nil, err
 				}
 				lastValues = MergeValues(lastValues, values)
+				configValues.values = MergeValues(configValues.values, values)
 			}
+			valuesOfConfigs = append(valuesOfConfigs, configValues)
 		}
 
+		recordValuesSource(addon, lastSetterSource(valuesOfConfigs))
 		return lastValues, nil
 	}
 }
@@ -139,6 +147,42 @@ func ToAddOnNodePlacementValues(config addonapiv1alpha1.AddOnDeploymentConfig) (
 	return values, nil
 }
 
+// ToAddOnRegistriesValues only transform the AddOnDeploymentConfig Registries part into Values object that has
+// a specific for helm chart values, the registries are used by the helper template "addon-framework.image" to
+// override the images in the chart.
+// for example: the spec of one AddOnDeploymentConfig is:
+//
+//	{
+//	 registries: [{source: "quay.io/open-cluster-management", mirror: "quay.io/ocm"}],
+//	}
+//
+// after transformed, the Values will be:
+// map[global:map[registries:[map[mirror:quay.io/ocm source:quay.io/open-cluster-management]]]]
+func ToAddOnRegistriesValues(config addonapiv1alpha1.AddOnDeploymentConfig) (Values, error) {
+	if len(config.Spec.Registries) == 0 {
+		return nil, nil
+	}
+
+	type global struct {
+		Registries []addonapiv1alpha1.ImageMirror `json:"registries"`
+	}
+
+	jsonStruct := struct {
+		Global global `json:"global"`
+	}{
+		Global: global{
+			Registries: config.Spec.Registries,
+		},
+	}
+
+	values, err := JsonStructToValues(jsonStruct)
+	if err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
 // ToAddOnCustomizedVariableValues only transform the CustomizedVariables in the spec of AddOnDeploymentConfig into Values object.
 // for example: the spec of one AddOnDeploymentConfig is:
 //
@@ -179,26 +223,31 @@ func GetAddOnDeploymentConfigValues(
 	getter AddOnDeploymentConfigGetter, toValuesFuncs ...AddOnDeploymentConfigToValuesFunc) GetValuesFunc {
 	return func(cluster *clusterv1.ManagedCluster, addon *addonapiv1alpha1.ManagedClusterAddOn) (Values, error) {
 		var lastValues = Values{}
+		var valuesOfConfigs []sourcedValues
 		for _, config := range addon.Status.ConfigReferences {
 			if config.ConfigGroupResource.Group != AddOnDeploymentConfigGVR.Group ||
 				config.ConfigGroupResource.Resource != AddOnDeploymentConfigGVR.Resource {
 				continue
 			}
 
-			addOnDeploymentConfig, err := getter.Get(context.Background(), config.Namespace, config.Name)
+			addOnDeploymentConfig, err := getAddOnDeploymentConfig(getter, addon, config)
 			if err != nil {
 				return nil, err
 			}
 
+			configValues := sourcedValues{source: fmt.Sprintf("%s/%s", config.Namespace, config.Name), values: Values{}}
 			for _, toValuesFunc := range toValuesFuncs {
 				values, err := toValuesFunc(*addOnDeploymentConfig)
 				if err != nil {
 					return nil, err
 				}
 				lastValues = MergeValues(lastValues, values)
+				configValues.values = MergeValues(configValues.values, values)
 			}
+			valuesOfConfigs = append(valuesOfConfigs, configValues)
 		}
 
+		recordValuesSource(addon, lastSetterSource(valuesOfConfigs))
 		return lastValues, nil
 	}
 }
@@ -209,20 +258,49 @@ func GetAddOnDeploymentConfigValues(
 //	{
 //		customizedVariables: [{name: "Image", value: "img"}, {name: "ImagePullPolicy", value: "Always"}],
 //		nodePlacement: {nodeSelector: {"host": "ssd"}, tolerations: {"key": "test"}},
+//		registries: [{source: "quay.io/open-cluster-management", mirror: "quay.io/ocm"}],
 //	}
 //
-// after transformed, the key set of Values object will be: {"Image", "ImagePullPolicy", "NodeSelector", "Tolerations"}
+// after transformed, the key set of Values object will be:
+// {"Image", "ImagePullPolicy", "NodeSelector", "Tolerations", "Registries"}
+//
+// The customized variables of the proxy are converted to the value "ProxyConfig" with the keys "httpProxy",
+// "httpsProxy", "noProxy" and "caBundle", and the value of the customized variable "ResourceRequirements" is parsed
+// to the resource requirements keyed by the container name, see HTTPProxyVariableName and the other names of the
+// customized variables.
 func ToAddOnDeploymentConfigValues(config addonapiv1alpha1.AddOnDeploymentConfig) (Values, error) {
 	values, err := ToAddOnCustomizedVariableValues(config)
 	if err != nil {
 		return nil, err
 	}
 
+	if proxy := getProxyConfig(config); proxy != nil {
+		values["ProxyConfig"], err = JsonStructToValues(proxy)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	requirements, err := getResourceRequirements(config)
+	if err != nil {
+		return nil, err
+	}
+	if len(requirements) > 0 {
+		values[ResourceRequirementsVariableName], err = JsonStructToValues(requirements)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if config.Spec.NodePlacement != nil {
 		values["NodeSelector"] = config.Spec.NodePlacement.NodeSelector
 		values["Tolerations"] = config.Spec.NodePlacement.Tolerations
 	}
 
+	if len(config.Spec.Registries) > 0 {
+		values["Registries"] = config.Spec.Registries
+	}
+
 	return values, nil
 }
 
@@ -268,39 +346,9 @@ func ToImageOverrideValuesFunc(imageKey, image string) AddOnDeploymentConfigToVa
 	}
 }
 
-// OverrideImage checks whether the source configured in registries can match the imagedName, if yes will use the
-// mirror value in the registries to override the imageName
+// OverrideImage checks whether the source configured in registries can match the imageName, if yes will use the
+// mirror value in the registries to override the imageName. The tag and digest of the image are kept, and the
+// longest matched source wins, see mirrorImage for the details.
 func OverrideImage(registries []addonapiv1alpha1.ImageMirror, imageName string) string {
-	if len(registries) == 0 {
-		return imageName
-	}
-	overrideImageName := imageName
-	for i := 0; i < len(registries); i++ {
-		registry := registries[i]
-		name := overrideImageDirectly(registry.Source, registry.Mirror, imageName)
-		if name != imageName {
-			overrideImageName = name
-		}
-	}
-	return overrideImageName
-}
-
-func overrideImageDirectly(source, mirror, imageName string) string {
-	source = strings.TrimSuffix(source, "/")
-	mirror = strings.TrimSuffix(mirror, "/")
-	imageSegments := strings.Split(imageName, "/")
-	imageNameTag := imageSegments[len(imageSegments)-1]
-	if source == "" {
-		if mirror == "" {
-			return imageNameTag
-		}
-		return fmt.Sprintf("%s/%s", mirror, imageNameTag)
-	}
-
-	if !strings.HasPrefix(imageName, source) {
-		return imageName
-	}
-
-	trimSegment := strings.TrimPrefix(imageName, source)
-	return fmt.Sprintf("%s%s", mirror, trimSegment)
+	return mirrorImage(registries, imageName)
 }
@@ -197,6 +197,89 @@ func TestGetAddOnDeploymentConfigValues(t *testing.T) {
 				"managedKubeConfigSecret": "external-managed-kubeconfig",
 			},
 		},
+		{
+			name:          "to addon registries",
+			toValuesFuncs: []AddOnDeploymentConfigToValuesFunc{ToAddOnNodePlacementValues, ToAddOnRegistriesValues},
+			addOnObjs: []runtime.Object{
+				func() *addonapiv1alpha1.ManagedClusterAddOn {
+					addon := addontesting.NewAddon("test", "cluster1")
+					addon.Status.ConfigReferences = []addonapiv1alpha1.ConfigReference{
+						{
+							ConfigGroupResource: addonapiv1alpha1.ConfigGroupResource{
+								Group:    "addon.open-cluster-management.io",
+								Resource: "addondeploymentconfigs",
+							},
+							ConfigReferent: addonapiv1alpha1.ConfigReferent{
+								Namespace: "cluster1",
+								Name:      "config",
+							},
+						},
+					}
+					return addon
+				}(),
+				&addonapiv1alpha1.AddOnDeploymentConfig{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "config",
+						Namespace: "cluster1",
+					},
+					Spec: addonapiv1alpha1.AddOnDeploymentConfigSpec{
+						NodePlacement: &addonapiv1alpha1.NodePlacement{
+							NodeSelector: nodeSelector,
+						},
+						Registries: []addonapiv1alpha1.ImageMirror{
+							{Source: "quay.io/open-cluster-management", Mirror: "quay.io/ocm"},
+						},
+					},
+				},
+			},
+			expectedValues: Values{
+				"global": map[string]interface{}{
+					"nodeSelector": map[string]interface{}{"kubernetes.io/os": "linux"},
+					"registries": []interface{}{
+						map[string]interface{}{"source": "quay.io/open-cluster-management", "mirror": "quay.io/ocm"},
+					},
+				},
+				"tolerations": nil,
+			},
+		},
+		{
+			name:          "to addon deployment config values with registries",
+			toValuesFuncs: []AddOnDeploymentConfigToValuesFunc{ToAddOnDeploymentConfigValues},
+			addOnObjs: []runtime.Object{
+				func() *addonapiv1alpha1.ManagedClusterAddOn {
+					addon := addontesting.NewAddon("test", "cluster1")
+					addon.Status.ConfigReferences = []addonapiv1alpha1.ConfigReference{
+						{
+							ConfigGroupResource: addonapiv1alpha1.ConfigGroupResource{
+								Group:    "addon.open-cluster-management.io",
+								Resource: "addondeploymentconfigs",
+							},
+							ConfigReferent: addonapiv1alpha1.ConfigReferent{
+								Namespace: "cluster1",
+								Name:      "config",
+							},
+						},
+					}
+					return addon
+				}(),
+				&addonapiv1alpha1.AddOnDeploymentConfig{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "config",
+						Namespace: "cluster1",
+					},
+					Spec: addonapiv1alpha1.AddOnDeploymentConfigSpec{
+						Registries: []addonapiv1alpha1.ImageMirror{
+							{Source: "quay.io/open-cluster-management", Mirror: "quay.io/ocm"},
+						},
+					},
+				},
+			},
+			expectedValues: Values{
+				"Registries": []addonapiv1alpha1.ImageMirror{
+					{Source: "quay.io/open-cluster-management", Mirror: "quay.io/ocm"},
+				},
+			},
+		},
 	}
 
 	for _, c := range cases {
@@ -341,7 +424,7 @@ func TestToImageOverrideValuesFunc(t *testing.T) {
 			expectedValues: Values{
 				"global": map[string]interface{}{
 					"imageOverride": map[string]interface{}{
-						"image": "y/c:v1",
+						"image": "y/a/b/c:v1",
 					},
 				},
 			},
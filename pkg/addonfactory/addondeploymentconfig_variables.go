@@ -0,0 +1,171 @@
+package addonfactory
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+)
+
+// The AddOnDeploymentConfig API has no fields for the proxy, the resource requirements and the agent install
+// namespace, they are set by the customized variables with the names below, and converted to the values by
+// ToAddOnDeploymentConfigValues for the template addons, or ToAddOnProxyConfigValues,
+// ToAddOnResourceRequirementsValues and ToAddOnAgentInstallNamespaceValues for the helm addons.
+const (
+	// HTTPProxyVariableName is the name of the customized variable of the HTTP proxy of the agent.
+	HTTPProxyVariableName = "HTTPProxy"
+	// HTTPSProxyVariableName is the name of the customized variable of the HTTPS proxy of the agent.
+	HTTPSProxyVariableName = "HTTPSProxy"
+	// NoProxyVariableName is the name of the customized variable of the comma separated hosts which should be
+	// excluded from the proxy.
+	NoProxyVariableName = "NoProxy"
+	// ProxyCABundleVariableName is the name of the customized variable of the PEM encoded CA bundle of the proxy.
+	// The value of a customized variable is limited to 1024 characters, a larger bundle is split into the
+	// variables ProxyCABundle, ProxyCABundle_1, ProxyCABundle_2 and so on, which are joined in order.
+	ProxyCABundleVariableName = "ProxyCABundle"
+	// ResourceRequirementsVariableName is the name of the customized variable of the resource requirements of the
+	// agent containers. The value is a json object keyed by the container name, the key "*" is for the containers
+	// without their own requirements, e.g. {"agent":{"requests":{"cpu":"100m","memory":"128Mi"}}}.
+	ResourceRequirementsVariableName = "ResourceRequirements"
+	// AgentInstallNamespaceVariableName is the name of the customized variable of the namespace of the agent
+	// resources.
+	AgentInstallNamespaceVariableName = "AgentInstallNamespace"
+)
+
+// proxyConfig is the proxy set by the customized variables of the AddOnDeploymentConfig.
+type proxyConfig struct {
+	HTTPProxy  string `json:"httpProxy,omitempty"`
+	HTTPSProxy string `json:"httpsProxy,omitempty"`
+	NoProxy    string `json:"noProxy,omitempty"`
+	CABundle   string `json:"caBundle,omitempty"`
+}
+
+// getProxyConfig returns the proxy of the AddOnDeploymentConfig, it is nil if no proxy variable is set.
+func getProxyConfig(config addonapiv1alpha1.AddOnDeploymentConfig) *proxyConfig {
+	variables := customizedVariables(config)
+
+	var caBundle strings.Builder
+	caBundle.WriteString(variables[ProxyCABundleVariableName])
+	for i := 1; ; i++ {
+		part, ok := variables[fmt.Sprintf("%s_%d", ProxyCABundleVariableName, i)]
+		if !ok {
+			break
+		}
+		caBundle.WriteString(part)
+	}
+
+	proxy := proxyConfig{
+		HTTPProxy:  variables[HTTPProxyVariableName],
+		HTTPSProxy: variables[HTTPSProxyVariableName],
+		NoProxy:    variables[NoProxyVariableName],
+		CABundle:   caBundle.String(),
+	}
+	if proxy == (proxyConfig{}) {
+		return nil
+	}
+	return &proxy
+}
+
+// getResourceRequirements returns the resource requirements of the containers of the AddOnDeploymentConfig.
+func getResourceRequirements(config addonapiv1alpha1.AddOnDeploymentConfig) (
+	map[string]corev1.ResourceRequirements, error) {
+	value, ok := customizedVariables(config)[ResourceRequirementsVariableName]
+	if !ok || len(value) == 0 {
+		return nil, nil
+	}
+
+	requirements := map[string]corev1.ResourceRequirements{}
+	if err := json.Unmarshal([]byte(value), &requirements); err != nil {
+		return nil, fmt.Errorf("failed to parse the customized variable %s of AddOnDeploymentConfig %s/%s: %v",
+			ResourceRequirementsVariableName, config.Namespace, config.Name, err)
+	}
+	return requirements, nil
+}
+
+func customizedVariables(config addonapiv1alpha1.AddOnDeploymentConfig) map[string]string {
+	variables := map[string]string{}
+	for _, variable := range config.Spec.CustomizedVariables {
+		variables[variable.Name] = variable.Value
+	}
+	return variables
+}
+
+// ToAddOnProxyConfigValues only transform the proxy customized variables of the AddOnDeploymentConfig into Values
+// object that has a specific for helm chart values, the proxy is used by the helper template
+// "addon-framework.proxyEnv" to set the proxy environment variables of a container.
+// for example: the spec of one AddOnDeploymentConfig is:
+//
+//	{
+//	 customizedVariables: [{name: "HTTPProxy", value: "http://proxy:3128"}, {name: "NoProxy", value: "10.0.0.1"}],
+//	}
+//
+// after transformed, the Values will be:
+// map[global:map[proxyConfig:map[httpProxy:http://proxy:3128 noProxy:10.0.0.1]]]
+func ToAddOnProxyConfigValues(config addonapiv1alpha1.AddOnDeploymentConfig) (Values, error) {
+	proxy := getProxyConfig(config)
+	if proxy == nil {
+		return nil, nil
+	}
+
+	type global struct {
+		ProxyConfig *proxyConfig `json:"proxyConfig"`
+	}
+
+	return JsonStructToValues(struct {
+		Global global `json:"global"`
+	}{
+		Global: global{ProxyConfig: proxy},
+	})
+}
+
+// ToAddOnResourceRequirementsValues only transform the resource requirements customized variable of the
+// AddOnDeploymentConfig into Values object that has a specific for helm chart values, the requirements are used by
+// the helper template "addon-framework.resources" to set the resources of a container.
+// for example: the spec of one AddOnDeploymentConfig is:
+//
+//	{
+//	 customizedVariables: [{name: "ResourceRequirements", value: "{\"agent\":{\"requests\":{\"cpu\":\"100m\"}}}"}],
+//	}
+//
+// after transformed, the Values will be:
+// map[global:map[resourceRequirements:map[agent:map[requests:map[cpu:100m]]]]]
+func ToAddOnResourceRequirementsValues(config addonapiv1alpha1.AddOnDeploymentConfig) (Values, error) {
+	requirements, err := getResourceRequirements(config)
+	if err != nil || len(requirements) == 0 {
+		return nil, err
+	}
+
+	type global struct {
+		ResourceRequirements map[string]corev1.ResourceRequirements `json:"resourceRequirements"`
+	}
+
+	return JsonStructToValues(struct {
+		Global global `json:"global"`
+	}{
+		Global: global{ResourceRequirements: requirements},
+	})
+}
+
+// ToAddOnAgentInstallNamespaceValues only transform the agent install namespace customized variable of the
+// AddOnDeploymentConfig into Values object that has a specific for helm chart values.
+// for example: the spec of one AddOnDeploymentConfig is:
+//
+//	{
+//	 customizedVariables: [{name: "AgentInstallNamespace", value: "open-cluster-management-agent-addon"}],
+//	}
+//
+// after transformed, the Values will be:
+// map[global:map[agentInstallNamespace:open-cluster-management-agent-addon]]
+//
+// Note: the namespace is only a value for the chart, the install namespace of the addon, e.g. the Release.Namespace
+// and the namespace of the hub kubeconfig secret, is still the spec.installNamespace of the addon.
+func ToAddOnAgentInstallNamespaceValues(config addonapiv1alpha1.AddOnDeploymentConfig) (Values, error) {
+	namespace := customizedVariables(config)[AgentInstallNamespaceVariableName]
+	if len(namespace) == 0 {
+		return nil, nil
+	}
+
+	return Values{"global": map[string]interface{}{"agentInstallNamespace": namespace}}, nil
+}
@@ -0,0 +1,128 @@
+package addonfactory
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+)
+
+func newVariablesConfig(variables ...addonapiv1alpha1.CustomizedVariable) addonapiv1alpha1.AddOnDeploymentConfig {
+	config := addonapiv1alpha1.AddOnDeploymentConfig{}
+	config.Namespace, config.Name = "cluster1", "config"
+	config.Spec.CustomizedVariables = variables
+	return config
+}
+
+func TestToAddOnDeploymentConfigVariableValues(t *testing.T) {
+	proxyConfig := newVariablesConfig(
+		addonapiv1alpha1.CustomizedVariable{Name: HTTPProxyVariableName, Value: "http://proxy:3128"},
+		addonapiv1alpha1.CustomizedVariable{Name: HTTPSProxyVariableName, Value: "https://proxy:3129"},
+		addonapiv1alpha1.CustomizedVariable{Name: NoProxyVariableName, Value: "10.0.0.1,.svc"},
+		addonapiv1alpha1.CustomizedVariable{Name: ProxyCABundleVariableName, Value: "-----BEGIN "},
+		addonapiv1alpha1.CustomizedVariable{Name: ProxyCABundleVariableName + "_1", Value: "CERTIFICATE-----"},
+	)
+	resourcesConfig := newVariablesConfig(
+		addonapiv1alpha1.CustomizedVariable{
+			Name:  ResourceRequirementsVariableName,
+			Value: `{"agent":{"requests":{"cpu":"100m"}},"*":{"limits":{"memory":"1Gi"}}}`,
+		},
+		addonapiv1alpha1.CustomizedVariable{Name: AgentInstallNamespaceVariableName, Value: "agent-ns"},
+	)
+	expectedProxy := map[string]interface{}{
+		"httpProxy":  "http://proxy:3128",
+		"httpsProxy": "https://proxy:3129",
+		"noProxy":    "10.0.0.1,.svc",
+		"caBundle":   "-----BEGIN CERTIFICATE-----",
+	}
+	expectedResources := map[string]interface{}{
+		"agent": map[string]interface{}{"requests": map[string]interface{}{"cpu": "100m"}},
+		"*":     map[string]interface{}{"limits": map[string]interface{}{"memory": "1Gi"}},
+	}
+
+	cases := []struct {
+		name           string
+		toValuesFunc   AddOnDeploymentConfigToValuesFunc
+		config         addonapiv1alpha1.AddOnDeploymentConfig
+		expectedValues Values
+		expectedErr    string
+	}{
+		{
+			name:         "proxy to helm values",
+			toValuesFunc: ToAddOnProxyConfigValues,
+			config:       proxyConfig,
+			expectedValues: Values{
+				"global": map[string]interface{}{"proxyConfig": expectedProxy},
+			},
+		},
+		{
+			name:         "no proxy",
+			toValuesFunc: ToAddOnProxyConfigValues,
+			config:       resourcesConfig,
+		},
+		{
+			name:         "resource requirements to helm values",
+			toValuesFunc: ToAddOnResourceRequirementsValues,
+			config:       resourcesConfig,
+			expectedValues: Values{
+				"global": map[string]interface{}{"resourceRequirements": expectedResources},
+			},
+		},
+		{
+			name:         "invalid resource requirements",
+			toValuesFunc: ToAddOnResourceRequirementsValues,
+			config: newVariablesConfig(addonapiv1alpha1.CustomizedVariable{
+				Name: ResourceRequirementsVariableName, Value: `{"agent":`}),
+			expectedErr: "failed to parse the customized variable ResourceRequirements of AddOnDeploymentConfig cluster1/config",
+		},
+		{
+			name:         "agent install namespace to helm values",
+			toValuesFunc: ToAddOnAgentInstallNamespaceValues,
+			config:       resourcesConfig,
+			expectedValues: Values{
+				"global": map[string]interface{}{"agentInstallNamespace": "agent-ns"},
+			},
+		},
+		{
+			name:         "proxy to template values",
+			toValuesFunc: ToAddOnDeploymentConfigValues,
+			config:       proxyConfig,
+			expectedValues: Values{
+				HTTPProxyVariableName:            "http://proxy:3128",
+				HTTPSProxyVariableName:           "https://proxy:3129",
+				NoProxyVariableName:              "10.0.0.1,.svc",
+				ProxyCABundleVariableName:        "-----BEGIN ",
+				ProxyCABundleVariableName + "_1": "CERTIFICATE-----",
+				"ProxyConfig":                    Values(expectedProxy),
+			},
+		},
+		{
+			name:         "resource requirements to template values",
+			toValuesFunc: ToAddOnDeploymentConfigValues,
+			config:       resourcesConfig,
+			expectedValues: Values{
+				ResourceRequirementsVariableName:  Values(expectedResources),
+				AgentInstallNamespaceVariableName: "agent-ns",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			values, err := c.toValuesFunc(c.config)
+			if len(c.expectedErr) > 0 {
+				if err == nil || !strings.HasPrefix(err.Error(), c.expectedErr) {
+					t.Errorf("expected error %q, but got %v", c.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got err %v", err)
+			}
+			if !equality.Semantic.DeepEqual(values, c.expectedValues) {
+				t.Errorf("expected values %v, but got values %v", c.expectedValues, values)
+			}
+		})
+	}
+}
@@ -1,8 +1,15 @@
 package addonfactory
 
 import (
-	"embed"
 	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"text/template"
+
+	"github.com/xeipuuv/gojsonschema"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
 
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	apiextensionsv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
@@ -26,21 +33,40 @@ type Values map[string]interface{}
 type GetValuesFunc func(cluster *clusterv1.ManagedCluster,
 	addon *addonapiv1alpha1.ManagedClusterAddOn) (Values, error)
 
+// APIVersionsFunc returns the api versions available on the managed cluster, each item is in the format of
+// "group/version" or "group/version/kind", e.g. "route.openshift.io/v1" or "policy/v1beta1/PodSecurityPolicy".
+type APIVersionsFunc func(cluster *clusterv1.ManagedCluster,
+	addon *addonapiv1alpha1.ManagedClusterAddOn) ([]string, error)
+
 // AgentAddonFactory includes the common fields for building different agentAddon instances.
 type AgentAddonFactory struct {
-	scheme            *runtime.Scheme
-	fs                embed.FS
-	dir               string
+	scheme *runtime.Scheme
+	fs     fs.FS
+	dir    string
+	// chart is the chart loaded from a chart archive, the fs is not set if the chart is set.
+	chart             *chart.Chart
 	getValuesFuncs    []GetValuesFunc
 	agentAddonOptions agent.AgentAddonOptions
 	// trimCRDDescription flag is used to trim the description of CRDs in manifestWork. disabled by default.
 	trimCRDDescription bool
-	hostingCluster     *clusterv1.ManagedCluster
+	// helmHooks flag is used to translate the helm hooks to the hooks of the addon framework. disabled by default.
+	helmHooks               bool
+	hostingCluster          *clusterv1.ManagedCluster
+	apiVersionsFunc         APIVersionsFunc
+	kustomizeComponentsFunc KustomizeComponentsFunc
+	templateFuncs           template.FuncMap
+	valuesSchema            []byte
+	postRenderMutators      []PostRenderMutator
+	hubAPIServer            string
+	hubCABundle             []byte
+	typedGetValuesFunc      GetValuesFunc
+	typedValuesCheck        typedValuesCheck
 }
 
 // NewAgentAddonFactory builds an addonAgentFactory instance with addon name and fs.
-// dir is the path prefix based on the fs path.
-func NewAgentAddonFactory(addonName string, fs embed.FS, dir string) *AgentAddonFactory {
+// dir is the path prefix based on the fs path, "." means the root of the fs.
+// The fs can be an embed.FS built in the binary, or any other fs.FS, e.g. os.DirFS of a mounted volume.
+func NewAgentAddonFactory(addonName string, fs fs.FS, dir string) *AgentAddonFactory {
 	s := runtime.NewScheme()
 	_ = scheme.AddToScheme(s)
 	_ = apiextensionsv1.AddToScheme(s)
@@ -61,6 +87,39 @@ func NewAgentAddonFactory(addonName string, fs embed.FS, dir string) *AgentAddon
 	}
 }
 
+// NewAgentAddonFactoryFromDir builds an addonAgentFactory instance with the manifests or the chart in the
+// directory dir on the local disk, e.g. a mounted volume, so the manifests can be changed without rebuilding
+// the binary.
+func NewAgentAddonFactoryFromDir(addonName string, dir string) *AgentAddonFactory {
+	return NewAgentAddonFactory(addonName, os.DirFS(dir), ".")
+}
+
+// NewAgentAddonFactoryFromChartArchive builds an addonAgentFactory instance with the helm chart archive (.tgz)
+// in the path archivePath on the local disk. The archive is loaded into memory, and only BuildHelmAgentAddon
+// can be used to build the agentAddon.
+func NewAgentAddonFactoryFromChartArchive(addonName string, archivePath string) (*AgentAddonFactory, error) {
+	archive, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	files, err := loader.LoadArchiveFiles(archive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chart archive %s: %v", archivePath, err)
+	}
+
+	// the top level directory of the chart is stripped from the name of the files.
+	userChart, err := loader.LoadFiles(files)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chart archive %s: %v", archivePath, err)
+	}
+
+	factory := NewAgentAddonFactory(addonName, nil, ".")
+	factory.chart = userChart
+	return factory, nil
+}
+
 // WithScheme is an optional configuration, only used when the agentAddon has customized resource types.
 func (f *AgentAddonFactory) WithScheme(s *runtime.Scheme) *AgentAddonFactory {
 	f.scheme = s
@@ -77,6 +136,14 @@ func (f *AgentAddonFactory) WithGetValuesFuncs(getValuesFuncs ...GetValuesFunc)
 	return f
 }
 
+// allGetValuesFuncs returns the GetValuesFunc of the typed values followed by the getValuesFuncs.
+func (f *AgentAddonFactory) allGetValuesFuncs() []GetValuesFunc {
+	if f.typedGetValuesFunc == nil {
+		return f.getValuesFuncs
+	}
+	return append([]GetValuesFunc{f.typedGetValuesFunc}, f.getValuesFuncs...)
+}
+
 // WithInstallStrategy defines the installation strategy of the manifests prescribed by Manifests(..).
 func (f *AgentAddonFactory) WithInstallStrategy(strategy *agent.InstallStrategy) *AgentAddonFactory {
 	if strategy.InstallNamespace == "" {
@@ -111,12 +178,100 @@ func (f *AgentAddonFactory) WithTrimCRDDescription() *AgentAddonFactory {
 	return f
 }
 
+// WithHelmHooks is to translate the resources annotated with helm.sh/hook in the helm chart to the hooks of the
+// addon framework, the pre-delete Job and Pod hooks are deployed when the addon is deleted. The install and
+// upgrade hooks are only ordered in the manifestWork by the helm.sh/hook-weight, they are applied together with
+// the other resources without waiting for the hooks to complete. Only used by BuildHelmAgentAddon.
+func (f *AgentAddonFactory) WithHelmHooks() *AgentAddonFactory {
+	f.helmHooks = true
+	return f
+}
+
+// WithAPIVersionsFunc defines how to get the api versions of the managed cluster, which are used as the
+// .Capabilities.APIVersions in rendering the helm chart. Only used by BuildHelmAgentAddon.
+func (f *AgentAddonFactory) WithAPIVersionsFunc(apiVersionsFunc APIVersionsFunc) *AgentAddonFactory {
+	f.apiVersionsFunc = apiVersionsFunc
+	return f
+}
+
+// WithTemplateFuncs adds the custom functions used in the templates, which override the sprig functions and the
+// builtin functions with the same names. Only used by BuildTemplateAgentAddon.
+func (f *AgentAddonFactory) WithTemplateFuncs(funcMap template.FuncMap) *AgentAddonFactory {
+	if f.templateFuncs == nil {
+		f.templateFuncs = template.FuncMap{}
+	}
+	for name, fn := range funcMap {
+		f.templateFuncs[name] = fn
+	}
+	return f
+}
+
+// WithValuesSchema defines the json schema of the values, the values are validated against the schema before
+// rendering the templates, and the invalid values are reported in the ValuesValid condition of the addon. Only
+// used by BuildTemplateAgentAddon, the values of the helm agentAddon are validated against the
+// values.schema.json of the chart.
+func (f *AgentAddonFactory) WithValuesSchema(schema []byte) *AgentAddonFactory {
+	f.valuesSchema = schema
+	return f
+}
+
+// WithKustomizeComponentsFunc defines the kustomize components applied for each cluster, e.g. the overlays for
+// different vendors of the managed clusters. Only used by BuildKustomizeAgentAddon.
+func (f *AgentAddonFactory) WithKustomizeComponentsFunc(componentsFunc KustomizeComponentsFunc) *AgentAddonFactory {
+	f.kustomizeComponentsFunc = componentsFunc
+	return f
+}
+
+// WithPostRenderMutators adds the mutators running on the objects rendered by the agentAddon in order, e.g.
+// AddLabelsMutator and NodePlacementMutator.
+func (f *AgentAddonFactory) WithPostRenderMutators(mutators ...PostRenderMutator) *AgentAddonFactory {
+	f.postRenderMutators = append(f.postRenderMutators, mutators...)
+	return f
+}
+
+// WithManifestPatches supports the ManifestPatches ConfigMap as a config of the addon, the patches in the
+// ConfigMaps referenced by the addon are applied to the rendered manifests, see ManifestPatch for the format of
+// the patches. The ConfigMaps are tracked by the spec hash like the other configs, and must have the label
+// constants.ConfigMapConfigLabelKey: "true".
+func (f *AgentAddonFactory) WithManifestPatches(getter ManifestPatchesGetter) *AgentAddonFactory {
+	f.agentAddonOptions.SupportedConfigGVRs = append(f.agentAddonOptions.SupportedConfigGVRs, ManifestPatchesConfigGVR)
+	f.postRenderMutators = append(f.postRenderMutators, ManifestPatchesMutator(getter))
+	return f
+}
+
 // WithConfigGVRs defines the addon supported configuration GroupVersionResource
 func (f *AgentAddonFactory) WithConfigGVRs(gvrs ...schema.GroupVersionResource) *AgentAddonFactory {
 	f.agentAddonOptions.SupportedConfigGVRs = append(f.agentAddonOptions.SupportedConfigGVRs, gvrs...)
 	return f
 }
 
+// WithConfigValidator defines the validator of the configs of the addon, an invalid config blocks the manifests
+// of the addon from being deployed and is reported in the ConfigurationValid condition.
+func (f *AgentAddonFactory) WithConfigValidator(validator agent.ConfigValidatorFunc) *AgentAddonFactory {
+	f.agentAddonOptions.ConfigValidator = validator
+	return f
+}
+
+// WithConfigHistory keeps the history of the AddOnDeploymentConfigs on the hub, up to the revisionLimit revisions
+// of each config, so a previous revision can be rendered by the getter from NewAddOnDeploymentConfigGetterWithHistory
+// once the config is rolled back on a cluster.
+func (f *AgentAddonFactory) WithConfigHistory(revisionLimit int) *AgentAddonFactory {
+	f.agentAddonOptions.ConfigHistoryRevisionLimit = revisionLimit
+	return f
+}
+
+// WithConfigMergeStrategy defines how the configs of the config type referenced by the addon are merged, the
+// configs each effective spec field came from are recorded on the ManagedClusterAddOn by the addon manager. Use the
+// same strategy in GetAddOnDeploymentConfigValuesWithMergeStrategy to render the AddOnDeploymentConfigs.
+func (f *AgentAddonFactory) WithConfigMergeStrategy(gr addonapiv1alpha1.ConfigGroupResource,
+	strategy ConfigMergeStrategy) *AgentAddonFactory {
+	if f.agentAddonOptions.ConfigMergeStrategies == nil {
+		f.agentAddonOptions.ConfigMergeStrategies = map[addonapiv1alpha1.ConfigGroupResource]agent.ConfigMergeStrategy{}
+	}
+	f.agentAddonOptions.ConfigMergeStrategies[gr] = strategy
+	return f
+}
+
 // WithHostingCluster defines the hosting cluster used in hosted mode. An AgentAddon may use this to provide
 // additional metadata.
 func (f *AgentAddonFactory) WithHostingCluster(cluster *clusterv1.ManagedCluster) *AgentAddonFactory {
@@ -124,28 +279,77 @@ func (f *AgentAddonFactory) WithHostingCluster(cluster *clusterv1.ManagedCluster
 	return f
 }
 
+// WithHubInfo defines the api server url and the CA bundle of the hub cluster, which are used as the built-in
+// values, e.g. to build the hub kubeconfig in the manifests. The host and the CA data of the rest config of the
+// hub cluster can be used.
+func (f *AgentAddonFactory) WithHubInfo(apiServer string, caBundle []byte) *AgentAddonFactory {
+	f.hubAPIServer = apiServer
+	f.hubCABundle = caBundle
+	return f
+}
+
 // BuildHelmAgentAddon builds a helm agentAddon instance.
+// The subcharts vendored in the charts/ directory of the chart are rendered in the same way as helm template,
+// including the dependency condition and tags, the values scoping of subcharts and the global values. Note that
+// the files whose names begin with '.' or '_' are excluded from an embed.FS unless the "all:" prefix is used in
+// the go:embed directive. The helper templates applying the values of the AddOnDeploymentConfig to a pod spec
+// are added to the chart, see ToAddOnNodePlacementValues, ToAddOnRegistriesValues, ToAddOnProxyConfigValues and
+// ToAddOnResourceRequirementsValues.
 func (f *AgentAddonFactory) BuildHelmAgentAddon() (agent.AgentAddon, error) {
 	if err := validateSupportedConfigGVRs(f.agentAddonOptions.SupportedConfigGVRs); err != nil {
 		return nil, err
 	}
 
-	userChart, err := loadChart(f.fs, f.dir)
+	userChart, err := f.loadChart()
 	if err != nil {
 		return nil, err
 	}
+	if err := checkDependencies(userChart); err != nil {
+		return nil, err
+	}
+	addHelperTemplates(userChart)
 
 	agentAddon := newHelmAgentAddon(f, userChart)
 
 	return agentAddon, nil
 }
 
+// loadChart returns a copy of the chart loaded from the chart archive, or loads the chart from the fs.
+func (f *AgentAddonFactory) loadChart() (*chart.Chart, error) {
+	if f.chart == nil {
+		return loadChart(f.fs, f.dir)
+	}
+	userChart := copyChart(f.chart)
+	userChart.Templates = append([]*chart.File{}, f.chart.Templates...)
+	return userChart, nil
+}
+
+// checkFS returns an error if the factory is built from a chart archive, which has no fs for the builders other
+// than BuildHelmAgentAddon.
+func (f *AgentAddonFactory) checkFS() error {
+	if f.fs == nil {
+		return fmt.Errorf("the agentAddon of a chart archive can only be built by BuildHelmAgentAddon")
+	}
+	return nil
+}
+
 // BuildTemplateAgentAddon builds a template agentAddon instance.
+// The templates are rendered with the sprig functions (https://masterminds.github.io/sprig/) except env and
+// expandenv, and the custom functions added by WithTemplateFuncs.
 func (f *AgentAddonFactory) BuildTemplateAgentAddon() (agent.AgentAddon, error) {
+	if err := f.checkFS(); err != nil {
+		return nil, err
+	}
 	if err := validateSupportedConfigGVRs(f.agentAddonOptions.SupportedConfigGVRs); err != nil {
 		return nil, err
 	}
 
+	if len(f.valuesSchema) > 0 {
+		if _, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(f.valuesSchema)); err != nil {
+			return nil, fmt.Errorf("invalid values schema: %v", err)
+		}
+	}
+
 	templateFiles, err := getTemplateFiles(f.fs, f.dir)
 	if err != nil {
 		klog.Errorf("failed to get template files. %v", err)
@@ -158,7 +362,7 @@ func (f *AgentAddonFactory) BuildTemplateAgentAddon() (agent.AgentAddon, error)
 	agentAddon := newTemplateAgentAddon(f)
 
 	for _, file := range templateFiles {
-		template, err := f.fs.ReadFile(file)
+		template, err := fs.ReadFile(f.fs, file)
 		if err != nil {
 			return nil, err
 		}
@@ -167,6 +371,58 @@ func (f *AgentAddonFactory) BuildTemplateAgentAddon() (agent.AgentAddon, error)
 	return agentAddon, nil
 }
 
+// BuildReloadableHelmAgentAddon builds a helm agentAddon instance which is rebuilt when the chart files in the fs of
+// the factory are changed, see ReloadableAgentAddon.
+func (f *AgentAddonFactory) BuildReloadableHelmAgentAddon() (*ReloadableAgentAddon, error) {
+	if err := f.checkFS(); err != nil {
+		return nil, err
+	}
+	return newReloadableAgentAddon(f.fs, f.dir, f.BuildHelmAgentAddon)
+}
+
+// BuildReloadableTemplateAgentAddon builds a template agentAddon instance which is rebuilt when the template files in
+// the fs of the factory are changed, see ReloadableAgentAddon. The templates are parsed when they are built, so a
+// template with a syntax error or an undefined function is rejected.
+func (f *AgentAddonFactory) BuildReloadableTemplateAgentAddon() (*ReloadableAgentAddon, error) {
+	if err := f.checkFS(); err != nil {
+		return nil, err
+	}
+	return newReloadableAgentAddon(f.fs, f.dir, func() (agent.AgentAddon, error) {
+		if err := f.parseTemplateFiles(); err != nil {
+			return nil, err
+		}
+		return f.BuildTemplateAgentAddon()
+	})
+}
+
+// BuildKustomizeAgentAddon builds a kustomize agentAddon instance.
+// The dir of the factory is the kustomization built for the addon, the components returned by the
+// KustomizeComponentsFunc are applied on top of it, and the namespace of the resources is set to the install
+// namespace of the addon. The values are generated as a ConfigMap named addon-values, which can be used as the
+// source of the replacements in the components, and is not deployed to the managed cluster.
+func (f *AgentAddonFactory) BuildKustomizeAgentAddon() (agent.AgentAddon, error) {
+	if err := f.checkFS(); err != nil {
+		return nil, err
+	}
+	if err := validateSupportedConfigGVRs(f.agentAddonOptions.SupportedConfigGVRs); err != nil {
+		return nil, err
+	}
+
+	files, err := loadFiles(f.fs)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := files[path.Join(f.dir, "kustomization.yaml")]; !ok {
+		if _, ok := files[path.Join(f.dir, "kustomization.yml")]; !ok {
+			return nil, fmt.Errorf("there is no kustomization file in %s", f.dir)
+		}
+	}
+
+	agentAddon := newKustomizeAgentAddon(f, files)
+
+	return agentAddon, nil
+}
+
 func validateSupportedConfigGVRs(configGVRs []schema.GroupVersionResource) error {
 	if len(configGVRs) == 0 {
 		// no configs required, ignore
@@ -195,3 +451,54 @@ func validateSupportedConfigGVRs(configGVRs []schema.GroupVersionResource) error
 
 	return nil
 }
+
+// BuildCompositeAgentAddon builds an agentAddon merging the manifests of the components, e.g. a vendored upstream
+// chart and the templates of the addon. The components are built from the dirs based on the dir of the factory
+// with the same options of the factory, so they are rendered with the same values. The post-render mutators and
+// the CRD description trimming of the factory are applied to the merged manifests.
+func (f *AgentAddonFactory) BuildCompositeAgentAddon(components ...AgentAddonComponent) (agent.AgentAddon, error) {
+	if err := f.checkFS(); err != nil {
+		return nil, err
+	}
+	if err := validateSupportedConfigGVRs(f.agentAddonOptions.SupportedConfigGVRs); err != nil {
+		return nil, err
+	}
+	if len(components) == 0 {
+		return nil, fmt.Errorf("there is no components")
+	}
+
+	agentAddon := &CompositeAgentAddon{
+		agentAddonOptions:  f.agentAddonOptions,
+		postRenderMutators: f.postRenderMutators,
+		trimCRDDescription: f.trimCRDDescription,
+	}
+	for _, component := range components {
+		componentFactory := *f
+		componentFactory.dir = path.Join(f.dir, component.Dir)
+		componentFactory.postRenderMutators = nil
+		componentFactory.trimCRDDescription = false
+
+		var componentAgentAddon agent.AgentAddon
+		var err error
+		switch component.Type {
+		case HelmComponent:
+			componentAgentAddon, err = componentFactory.BuildHelmAgentAddon()
+		case TemplateComponent:
+			componentAgentAddon, err = componentFactory.BuildTemplateAgentAddon()
+		case KustomizeComponent:
+			componentAgentAddon, err = componentFactory.BuildKustomizeAgentAddon()
+		default:
+			err = fmt.Errorf("unsupported component type %q", component.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to build the component %s: %v", componentFactory.dir, err)
+		}
+
+		agentAddon.components = append(agentAddon.components, compositeComponent{
+			dir:        componentFactory.dir,
+			agentAddon: componentAgentAddon,
+			excludes:   ExcludeManifestsMutator(component.Excludes...),
+		})
+	}
+	return agentAddon, nil
+}
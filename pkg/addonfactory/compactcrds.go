@@ -0,0 +1,145 @@
+package addonfactory
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+)
+
+var crdGroupKind = schema.GroupKind{Group: "apiextensions.k8s.io", Kind: "CustomResourceDefinition"}
+
+// documentationSchemaFields are the fields of the openAPIV3Schema only used as the documentation, which are not
+// used in the validation, pruning and defaulting of the custom resources. Note that the x-kubernetes-* extensions
+// kept in the schema of a CRD, e.g. x-kubernetes-preserve-unknown-fields and x-kubernetes-list-type, change how
+// the custom resources are validated and stored, so they are not removed.
+var documentationSchemaFields = []string{"description", "title", "example", "externalDocs"}
+
+// CompactCRDsMutator returns a PostRenderMutator compacting the v1 and v1beta1 CRDs to reduce the size of the
+// ManifestWorks, which is beyond WithTrimCRDDescription:
+//   - the description, title, example and externalDocs fields of the schemas are removed.
+//   - the CRDs are minified by removing the status and the empty creationTimestamp, which are added when a typed
+//     object is encoded. The compacted CRDs are returned as unstructured objects.
+//
+// All the versions are kept, see DropUnservedCRDVersionsMutator to remove the versions which are not served.
+func CompactCRDsMutator() PostRenderMutator {
+	return crdsMutator(compactCRD)
+}
+
+// DropUnservedCRDVersionsMutator returns a PostRenderMutator removing the versions of the v1 and v1beta1 CRDs which
+// are neither served nor the storage version. It is only safe once the custom resources stored in the versions are
+// migrated to the storage version, and the versions are removed from the status.storedVersions of the CRDs on the
+// managed clusters, otherwise the CRDs with the versions removed are rejected by the managed clusters.
+func DropUnservedCRDVersionsMutator() PostRenderMutator {
+	return crdsMutator(dropUnservedCRDVersions)
+}
+
+// crdsMutator returns a PostRenderMutator running the mutate on the CRDs, the mutated CRDs are returned as
+// unstructured objects.
+func crdsMutator(mutate func(crd map[string]interface{})) PostRenderMutator {
+	return func(cluster *clusterv1.ManagedCluster, addon *addonapiv1alpha1.ManagedClusterAddOn,
+		objects []runtime.Object) ([]runtime.Object, error) {
+		for i, object := range objects {
+			if object.GetObjectKind().GroupVersionKind().GroupKind() != crdGroupKind {
+				continue
+			}
+
+			content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(object)
+			if err != nil {
+				return nil, err
+			}
+			mutate(content)
+			objects[i] = &unstructured.Unstructured{Object: content}
+		}
+		return objects, nil
+	}
+}
+
+func compactCRD(crd map[string]interface{}) {
+	unstructured.RemoveNestedField(crd, "status")
+	if timestamp, found, _ := unstructured.NestedFieldNoCopy(crd, "metadata", "creationTimestamp"); found &&
+		timestamp == nil {
+		unstructured.RemoveNestedField(crd, "metadata", "creationTimestamp")
+	}
+
+	// the schema of v1beta1 CRD may be in the spec.validation for all the versions.
+	if schema, found, _ := unstructured.NestedMap(crd, "spec", "validation", "openAPIV3Schema"); found {
+		removeDocumentationFields(schema)
+		_ = unstructured.SetNestedMap(crd, schema, "spec", "validation", "openAPIV3Schema")
+	}
+
+	versions, found, _ := unstructured.NestedSlice(crd, "spec", "versions")
+	if !found {
+		return
+	}
+	for _, v := range versions {
+		version, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if schema, found, _ := unstructured.NestedMap(version, "schema", "openAPIV3Schema"); found {
+			removeDocumentationFields(schema)
+			_ = unstructured.SetNestedMap(version, schema, "schema", "openAPIV3Schema")
+		}
+	}
+	_ = unstructured.SetNestedSlice(crd, versions, "spec", "versions")
+}
+
+func dropUnservedCRDVersions(crd map[string]interface{}) {
+	versions, found, _ := unstructured.NestedSlice(crd, "spec", "versions")
+	if !found {
+		return
+	}
+	var servedVersions []interface{}
+	for _, v := range versions {
+		version, ok := v.(map[string]interface{})
+		if !ok {
+			servedVersions = append(servedVersions, v)
+			continue
+		}
+		served, _, _ := unstructured.NestedBool(version, "served")
+		storage, _, _ := unstructured.NestedBool(version, "storage")
+		if !served && !storage {
+			continue
+		}
+		servedVersions = append(servedVersions, version)
+	}
+	_ = unstructured.SetNestedSlice(crd, servedVersions, "spec", "versions")
+}
+
+// removeDocumentationFields removes the documentation fields of the schema and its sub schemas recursively. The
+// fields in the properties are the names of the properties, which are not removed.
+func removeDocumentationFields(schema map[string]interface{}) {
+	for _, field := range documentationSchemaFields {
+		delete(schema, field)
+	}
+
+	// the fields whose values are the maps of the name to the sub schema.
+	for _, field := range []string{"properties", "patternProperties", "definitions", "dependencies"} {
+		subSchemas, ok := schema[field].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, subSchema := range subSchemas {
+			// the value of dependencies can be a string array.
+			if s, ok := subSchema.(map[string]interface{}); ok {
+				removeDocumentationFields(s)
+			}
+		}
+	}
+
+	// the fields whose values are a sub schema, a list of the sub schemas or a bool.
+	for _, field := range []string{"items", "allOf", "oneOf", "anyOf", "not", "additionalProperties", "additionalItems"} {
+		switch subSchema := schema[field].(type) {
+		case map[string]interface{}:
+			removeDocumentationFields(subSchema)
+		case []interface{}:
+			for _, item := range subSchema {
+				if s, ok := item.(map[string]interface{}); ok {
+					removeDocumentationFields(s)
+				}
+			}
+		}
+	}
+}
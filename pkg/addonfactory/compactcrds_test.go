@@ -0,0 +1,201 @@
+package addonfactory
+
+import (
+	"reflect"
+	"testing"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apiextensionsv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func newTestSchemaV1() *apiextensionsv1.JSONSchemaProps {
+	preserveUnknownFields := true
+	example := apiextensionsv1.JSON{Raw: []byte(`{"description":"test"}`)}
+	return &apiextensionsv1.JSONSchemaProps{
+		Description: "Test is a test resource.",
+		Title:       "Test",
+		Type:        "object",
+		Properties: map[string]apiextensionsv1.JSONSchemaProps{
+			"spec": {
+				Description: "spec of the test.",
+				Type:        "object",
+				Example:     &example,
+				Properties: map[string]apiextensionsv1.JSONSchemaProps{
+					"description": {Description: "description of the test.", Type: "string"},
+					"config": {
+						Type:                   "object",
+						XPreserveUnknownFields: &preserveUnknownFields,
+						ExternalDocs:           &apiextensionsv1.ExternalDocumentation{URL: "https://test"},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestCompactCRDsMutator(t *testing.T) {
+	crdV1 := &apiextensionsv1.CustomResourceDefinition{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apiextensions.k8s.io/v1", Kind: "CustomResourceDefinition"},
+		ObjectMeta: metav1.ObjectMeta{Name: "tests.test.io"},
+		Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+			Group: "test.io",
+			Versions: []apiextensionsv1.CustomResourceDefinitionVersion{
+				{
+					Name: "v1alpha1", Served: false, Storage: false,
+					Schema: &apiextensionsv1.CustomResourceValidation{OpenAPIV3Schema: newTestSchemaV1()},
+				},
+				{
+					Name: "v1beta1", Served: false, Storage: true,
+					Schema: &apiextensionsv1.CustomResourceValidation{OpenAPIV3Schema: newTestSchemaV1()},
+				},
+				{
+					Name: "v1", Served: true, Storage: false,
+					Schema: &apiextensionsv1.CustomResourceValidation{OpenAPIV3Schema: newTestSchemaV1()},
+				},
+			},
+		},
+	}
+	crdV1beta1 := &apiextensionsv1beta1.CustomResourceDefinition{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apiextensions.k8s.io/v1beta1", Kind: "CustomResourceDefinition"},
+		ObjectMeta: metav1.ObjectMeta{Name: "olds.test.io"},
+		Spec: apiextensionsv1beta1.CustomResourceDefinitionSpec{
+			Group: "test.io",
+			Validation: &apiextensionsv1beta1.CustomResourceValidation{
+				OpenAPIV3Schema: &apiextensionsv1beta1.JSONSchemaProps{
+					Description: "Old is a test resource.",
+					Type:        "object",
+				},
+			},
+			Versions: []apiextensionsv1beta1.CustomResourceDefinitionVersion{
+				{Name: "v1", Served: true, Storage: true},
+			},
+		},
+	}
+	deploy := newTestDeployment("agent", "quay.io/ocm/agent:v1")
+
+	objects, err := CompactCRDsMutator()(nil, nil, []runtime.Object{crdV1, crdV1beta1, deploy})
+	if err != nil {
+		t.Fatalf("expected no error, got err %v", err)
+	}
+	if objects[2] != deploy {
+		t.Errorf("expected the deployment not changed")
+	}
+
+	compacted := objects[0].(*unstructured.Unstructured)
+	if _, found := compacted.Object["status"]; found {
+		t.Errorf("expected no status, but got %v", compacted.Object["status"])
+	}
+	if _, found, _ := unstructured.NestedFieldNoCopy(compacted.Object, "metadata", "creationTimestamp"); found {
+		t.Errorf("expected no creationTimestamp")
+	}
+
+	// the versions may still be in the status.storedVersions on the managed clusters, so they are all kept.
+	versions, _, _ := unstructured.NestedSlice(compacted.Object, "spec", "versions")
+	if names := crdVersionNames(versions); !reflect.DeepEqual(names, []string{"v1alpha1", "v1beta1", "v1"}) {
+		t.Errorf("expected versions [v1alpha1 v1beta1 v1], but got %v", names)
+	}
+
+	expectedSchema := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"spec": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"description": map[string]interface{}{"type": "string"},
+					"config": map[string]interface{}{
+						"type":                                 "object",
+						"x-kubernetes-preserve-unknown-fields": true,
+					},
+				},
+			},
+		},
+	}
+	for _, v := range versions {
+		schema, _, _ := unstructured.NestedMap(v.(map[string]interface{}), "schema", "openAPIV3Schema")
+		if !reflect.DeepEqual(schema, expectedSchema) {
+			t.Errorf("expected schema %v, but got %v", expectedSchema, schema)
+		}
+	}
+
+	schema, _, _ := unstructured.NestedMap(objects[1].(*unstructured.Unstructured).Object,
+		"spec", "validation", "openAPIV3Schema")
+	if !reflect.DeepEqual(schema, map[string]interface{}{"type": "object"}) {
+		t.Errorf("expected v1beta1 schema without description, but got %v", schema)
+	}
+}
+
+func crdVersionNames(versions []interface{}) []string {
+	var names []string
+	for _, v := range versions {
+		names = append(names, v.(map[string]interface{})["name"].(string))
+	}
+	return names
+}
+
+func TestDropUnservedCRDVersionsMutator(t *testing.T) {
+	crd := &apiextensionsv1.CustomResourceDefinition{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apiextensions.k8s.io/v1", Kind: "CustomResourceDefinition"},
+		ObjectMeta: metav1.ObjectMeta{Name: "tests.test.io"},
+		Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+			Group: "test.io",
+			Versions: []apiextensionsv1.CustomResourceDefinitionVersion{
+				{Name: "v1alpha1", Served: false, Storage: false},
+				{Name: "v1beta1", Served: false, Storage: true},
+				{Name: "v1", Served: true, Storage: false},
+			},
+		},
+	}
+
+	objects, err := DropUnservedCRDVersionsMutator()(nil, nil, []runtime.Object{crd})
+	if err != nil {
+		t.Fatalf("expected no error, got err %v", err)
+	}
+	versions, _, _ := unstructured.NestedSlice(objects[0].(*unstructured.Unstructured).Object, "spec", "versions")
+	if names := crdVersionNames(versions); !reflect.DeepEqual(names, []string{"v1beta1", "v1"}) {
+		t.Errorf("expected versions [v1beta1 v1], but got %v", names)
+	}
+}
+
+func TestManifestSizes(t *testing.T) {
+	crd := &apiextensionsv1.CustomResourceDefinition{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apiextensions.k8s.io/v1", Kind: "CustomResourceDefinition"},
+		ObjectMeta: metav1.ObjectMeta{Name: "tests.test.io"},
+		Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+			Group: "test.io",
+			Versions: []apiextensionsv1.CustomResourceDefinitionVersion{
+				{
+					Name: "v1", Served: true, Storage: true,
+					Schema: &apiextensionsv1.CustomResourceValidation{OpenAPIV3Schema: newTestSchemaV1()},
+				},
+			},
+		},
+	}
+	configMap := newHookConfigMap("config", nil)
+
+	sizes, err := ManifestSizes([]runtime.Object{configMap, crd})
+	if err != nil {
+		t.Fatalf("expected no error, got err %v", err)
+	}
+	if len(sizes) != 2 {
+		t.Fatalf("expected 2 sizes, but got %v", sizes)
+	}
+	if sizes[0].Object != "apiextensions.k8s.io/CustomResourceDefinition tests.test.io" ||
+		sizes[1].Object != "ConfigMap default/config" {
+		t.Errorf("expected the crd is bigger than the configmap, but got %v", sizes)
+	}
+
+	compacted, err := CompactCRDsMutator()(nil, nil, []runtime.Object{crd.DeepCopy()})
+	if err != nil {
+		t.Fatalf("expected no error, got err %v", err)
+	}
+	compactedSizes, err := ManifestSizes(compacted)
+	if err != nil {
+		t.Fatalf("expected no error, got err %v", err)
+	}
+	if compactedSizes[0].Size >= sizes[0].Size {
+		t.Errorf("expected the compacted crd is smaller than %d, but got %d", sizes[0].Size, compactedSizes[0].Size)
+	}
+}
@@ -0,0 +1,181 @@
+package addonfactory
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+
+	"open-cluster-management.io/addon-framework/pkg/agent"
+)
+
+// AgentAddonComponentType is the type of the manifests of a component of the composite agentAddon.
+type AgentAddonComponentType string
+
+const (
+	// HelmComponent is a helm chart, see BuildHelmAgentAddon.
+	HelmComponent AgentAddonComponentType = "Helm"
+	// TemplateComponent is a directory of go templates, see BuildTemplateAgentAddon.
+	TemplateComponent AgentAddonComponentType = "Template"
+	// KustomizeComponent is a kustomization, see BuildKustomizeAgentAddon.
+	KustomizeComponent AgentAddonComponentType = "Kustomize"
+)
+
+// AgentAddonComponent is a part of the manifests of the composite agentAddon, e.g. a vendored upstream chart.
+type AgentAddonComponent struct {
+	Type AgentAddonComponentType
+	// Dir is the path of the component based on the dir of the factory.
+	Dir string
+	// Excludes selects the objects of the component which are not deployed, e.g. the upstream objects replaced
+	// by the other components.
+	Excludes []ManifestSelector
+}
+
+// ManifestSelector selects the objects of the manifests, the empty fields match all the objects.
+type ManifestSelector struct {
+	Group         string
+	Kind          string
+	Namespace     string
+	Name          string
+	LabelSelector *metav1.LabelSelector
+}
+
+// CompositeAgentAddon merges the manifests of several components, which are rendered with the same values.
+type CompositeAgentAddon struct {
+	components         []compositeComponent
+	agentAddonOptions  agent.AgentAddonOptions
+	postRenderMutators []PostRenderMutator
+	trimCRDDescription bool
+}
+
+type compositeComponent struct {
+	dir        string
+	agentAddon agent.AgentAddon
+	excludes   PostRenderMutator
+}
+
+// Manifests returns the objects of the components in order. An error is returned if an object is in more than
+// one component, the objects are identified by the group, kind, namespace and name.
+func (a *CompositeAgentAddon) Manifests(
+	cluster *clusterv1.ManagedCluster,
+	addon *addonapiv1alpha1.ManagedClusterAddOn) ([]runtime.Object, error) {
+	var objects []runtime.Object
+	// owners is the dir of the component of each object.
+	owners := map[string]string{}
+
+	for _, component := range a.components {
+		componentObjects, err := component.agentAddon.Manifests(cluster, addon)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render the component %s: %w", component.dir, err)
+		}
+		componentObjects, err = component.excludes(cluster, addon, componentObjects)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range componentObjects {
+			key, err := objectKey(object)
+			if err != nil {
+				return nil, err
+			}
+			if owner, ok := owners[key]; ok {
+				return nil, fmt.Errorf("duplicate object %s in the components %s and %s", key, owner, component.dir)
+			}
+			owners[key] = component.dir
+			objects = append(objects, object)
+		}
+	}
+
+	objects, err := applyPostRenderMutators(a.postRenderMutators, cluster, addon, objects)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.trimCRDDescription {
+		objects = trimCRDDescription(objects)
+	}
+	return objects, nil
+}
+
+func (a *CompositeAgentAddon) GetAgentAddonOptions() agent.AgentAddonOptions {
+	return a.agentAddonOptions
+}
+
+// ExcludeManifestsMutator returns a PostRenderMutator removing the objects matching any of the selectors.
+func ExcludeManifestsMutator(selectors ...ManifestSelector) PostRenderMutator {
+	return func(cluster *clusterv1.ManagedCluster, addon *addonapiv1alpha1.ManagedClusterAddOn,
+		objects []runtime.Object) ([]runtime.Object, error) {
+		if len(selectors) == 0 {
+			return objects, nil
+		}
+
+		var filtered []runtime.Object
+		for _, object := range objects {
+			excluded := false
+			for _, selector := range selectors {
+				matched, err := selector.matches(object)
+				if err != nil {
+					return nil, err
+				}
+				if matched {
+					excluded = true
+					break
+				}
+			}
+			if !excluded {
+				filtered = append(filtered, object)
+			}
+		}
+		return filtered, nil
+	}
+}
+
+func (s ManifestSelector) matches(object runtime.Object) (bool, error) {
+	gvk := object.GetObjectKind().GroupVersionKind()
+	if len(s.Group) > 0 && gvk.Group != s.Group {
+		return false, nil
+	}
+	if len(s.Kind) > 0 && gvk.Kind != s.Kind {
+		return false, nil
+	}
+
+	accessor, err := meta.Accessor(object)
+	if err != nil {
+		return false, err
+	}
+	if len(s.Namespace) > 0 && accessor.GetNamespace() != s.Namespace {
+		return false, nil
+	}
+	if len(s.Name) > 0 && accessor.GetName() != s.Name {
+		return false, nil
+	}
+	if s.LabelSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(s.LabelSelector)
+		if err != nil {
+			return false, err
+		}
+		if !selector.Matches(labels.Set(accessor.GetLabels())) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// objectKey returns the group, kind, namespace and name of the object, e.g. "apps/Deployment ns/name".
+func objectKey(object runtime.Object) (string, error) {
+	accessor, err := meta.Accessor(object)
+	if err != nil {
+		return "", err
+	}
+	gvk := object.GetObjectKind().GroupVersionKind()
+	name := accessor.GetName()
+	if len(accessor.GetNamespace()) > 0 {
+		name = accessor.GetNamespace() + "/" + name
+	}
+	return strings.TrimPrefix(gvk.Group+"/"+gvk.Kind, "/") + " " + name, nil
+}
@@ -0,0 +1,134 @@
+package addonfactory
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCompositeAgentAddon(t *testing.T) {
+	compositeFS := fstest.MapFS{
+		"upstream/Chart.yaml": &fstest.MapFile{Data: []byte("apiVersion: v2\nname: upstream\nversion: 1.0.0\n")},
+		"upstream/templates/configmaps.yaml": &fstest.MapFile{Data: []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: upstream-config
+  namespace: {{ .Release.Namespace }}
+data:
+  image: {{ .Values.image }}
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: upstream-dashboard
+  namespace: {{ .Release.Namespace }}
+  labels:
+    component: dashboard
+`)},
+		"templates/configmap.yaml": &fstest.MapFile{Data: []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{ .Name }}
+  namespace: {{ .AddonInstallNamespace }}
+data:
+  image: {{ .image }}
+`)},
+	}
+
+	cases := []struct {
+		name          string
+		values        string
+		components    []AgentAddonComponent
+		expectedNames []string
+		expectedErr   string
+	}{
+		{
+			name:   "merge the components with the same values",
+			values: `{"image":"quay.io/ocm/agent:v1","Name":"addon-config"}`,
+			components: []AgentAddonComponent{
+				{Type: HelmComponent, Dir: "upstream"},
+				{Type: TemplateComponent, Dir: "templates"},
+			},
+			expectedNames: []string{"upstream-config", "upstream-dashboard", "addon-config"},
+		},
+		{
+			name:   "exclude the upstream objects",
+			values: `{"image":"quay.io/ocm/agent:v1","Name":"addon-config"}`,
+			components: []AgentAddonComponent{
+				{
+					Type: HelmComponent,
+					Dir:  "upstream",
+					Excludes: []ManifestSelector{
+						{LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"component": "dashboard"}}},
+					},
+				},
+				{Type: TemplateComponent, Dir: "templates"},
+			},
+			expectedNames: []string{"upstream-config", "addon-config"},
+		},
+		{
+			name:   "duplicate objects",
+			values: `{"image":"quay.io/ocm/agent:v1","Name":"upstream-config"}`,
+			components: []AgentAddonComponent{
+				{Type: HelmComponent, Dir: "upstream"},
+				{Type: TemplateComponent, Dir: "templates"},
+			},
+			expectedErr: "duplicate object ConfigMap myNs/upstream-config in the components upstream and templates",
+		},
+		{
+			name:   "replace the duplicate upstream object",
+			values: `{"image":"quay.io/ocm/agent:v1","Name":"upstream-config"}`,
+			components: []AgentAddonComponent{
+				{Type: HelmComponent, Dir: "upstream", Excludes: []ManifestSelector{{Kind: "ConfigMap", Name: "upstream-config"}}},
+				{Type: TemplateComponent, Dir: "templates"},
+			},
+			expectedNames: []string{"upstream-dashboard", "upstream-config"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			agentAddon, err := NewAgentAddonFactory("composite", compositeFS, ".").
+				WithGetValuesFuncs(GetValuesFromAddonAnnotation).
+				BuildCompositeAgentAddon(c.components...)
+			if err != nil {
+				t.Fatalf("expected no error, got err %v", err)
+			}
+
+			objects, err := agentAddon.Manifests(NewFakeManagedCluster("cluster1", "1.10.1"),
+				NewFakeManagedClusterAddon("composite", "cluster1", "myNs", c.values))
+			if len(c.expectedErr) > 0 {
+				if err == nil || !strings.Contains(err.Error(), c.expectedErr) {
+					t.Fatalf("expected error %q, but got %v", c.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got err %v", err)
+			}
+
+			var names []string
+			for _, object := range objects {
+				key, err := objectKey(object)
+				if err != nil {
+					t.Fatal(err)
+				}
+				names = append(names, key[strings.LastIndex(key, "/")+1:])
+			}
+			if !reflect.DeepEqual(names, c.expectedNames) {
+				t.Errorf("expected objects %v, but got %v", c.expectedNames, names)
+			}
+		})
+	}
+}
+
+func TestBuildCompositeAgentAddonWithInvalidComponent(t *testing.T) {
+	_, err := NewAgentAddonFactory("composite", fstest.MapFS{}, ".").
+		BuildCompositeAgentAddon(AgentAddonComponent{Type: "Unknown", Dir: "unknown"})
+	if err == nil || !strings.Contains(err.Error(), `unsupported component type "Unknown"`) {
+		t.Errorf("expected unsupported component type error, but got %v", err)
+	}
+}
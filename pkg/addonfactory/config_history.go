@@ -0,0 +1,136 @@
+package addonfactory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	addonv1alpha1client "open-cluster-management.io/api/client/addon/clientset/versioned"
+
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/constants"
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/controllers/confighistory"
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/controllers/managementaddonconfig"
+)
+
+// AddOnDeploymentConfigRevisionGetter is an AddOnDeploymentConfigGetter which also returns the revisions of the
+// AddOnDeploymentConfigs by the spec hash. GetAddOnDeploymentConfigValues and the post-render mutators use it to
+// get the revision of the desired spec hash of the addon, e.g. the previous revision of a config which is rolled
+// back on a cluster, instead of the latest one.
+type AddOnDeploymentConfigRevisionGetter interface {
+	AddOnDeploymentConfigGetter
+	// GetRevision returns the revision of the AddOnDeploymentConfig with the spec hash, the latest one is returned
+	// if the spec hash is empty. Nil is returned if the revision is neither the latest one nor in the history.
+	GetRevision(ctx context.Context, namespace, name, specHash string) (*addonapiv1alpha1.AddOnDeploymentConfig, error)
+}
+
+type historyAddOnDeploymentConfigGetter struct {
+	AddOnDeploymentConfigGetter
+	kubeClient kubernetes.Interface
+}
+
+// NewAddOnDeploymentConfigGetterWithHistory returns an AddOnDeploymentConfigRevisionGetter reading the previous
+// revisions of the AddOnDeploymentConfigs from the history on the hub. The history is recorded by the addon manager
+// if the addon is built with WithConfigHistory, in the ConfigMap addon-deployment-config-history-<config name>
+// in the namespace of the config. The history is only read when the desired spec hash of the addon is not the
+// spec hash of the latest config, or can not be confirmed to be, see GetRevision.
+func NewAddOnDeploymentConfigGetterWithHistory(addonClient addonv1alpha1client.Interface,
+	kubeClient kubernetes.Interface) AddOnDeploymentConfigRevisionGetter {
+	return &historyAddOnDeploymentConfigGetter{
+		AddOnDeploymentConfigGetter: NewAddOnDeploymentConfigGetter(addonClient),
+		kubeClient:                  kubeClient,
+	}
+}
+
+func (g *historyAddOnDeploymentConfigGetter) GetRevision(ctx context.Context,
+	namespace, name, specHash string) (*addonapiv1alpha1.AddOnDeploymentConfig, error) {
+	config, err := g.Get(ctx, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(specHash) == 0 {
+		return config, nil
+	}
+	// the spec hash of the addon is computed by the hub from the config decoded by the dynamic informer, which
+	// differs from the one of the typed config if the spec has the fields unknown to the typed config or the
+	// explicitly empty fields, e.g. "registries: []". So the typed spec hash is only used to skip the history
+	// when it matches, otherwise the revision is looked up by the spec hash directly, the latest revision is
+	// recorded in the history as well.
+	latestSpecHash, err := addOnDeploymentConfigSpecHash(config)
+	if err != nil {
+		return nil, err
+	}
+	if latestSpecHash == specHash {
+		return config, nil
+	}
+
+	spec, err := confighistory.GetRevisionSpec(ctx, g.kubeClient, namespace, name, specHash)
+	if err != nil || spec == nil {
+		return nil, err
+	}
+	revision := &addonapiv1alpha1.AddOnDeploymentConfig{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+	}
+	if err := json.Unmarshal(spec, &revision.Spec); err != nil {
+		return nil, fmt.Errorf("failed to parse the revision %s of AddOnDeploymentConfig %s/%s: %v",
+			specHash, namespace, name, err)
+	}
+	return revision, nil
+}
+
+// addOnDeploymentConfigSpecHash returns the spec hash of the typed config, which is the same as the spec hash in
+// the config references of the addon unless the config on the hub has the fields dropped by the typed config.
+func addOnDeploymentConfigSpecHash(config *addonapiv1alpha1.AddOnDeploymentConfig) (string, error) {
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(config)
+	if err != nil {
+		return "", err
+	}
+	return managementaddonconfig.GetSpecHash(&unstructured.Unstructured{Object: obj})
+}
+
+// getAddOnDeploymentConfig returns the AddOnDeploymentConfig of the config reference. If the getter keeps the
+// history, the revision of the desired spec hash is returned. Otherwise, or if the revision is not in the history,
+// an error is returned if the config is rolled back on the addon, since the getter can only return the latest
+// revision, which is the failed one.
+func getAddOnDeploymentConfig(getter AddOnDeploymentConfigGetter, addon *addonapiv1alpha1.ManagedClusterAddOn,
+	config addonapiv1alpha1.ConfigReference) (*addonapiv1alpha1.AddOnDeploymentConfig, error) {
+	revisionGetter, ok := getter.(AddOnDeploymentConfigRevisionGetter)
+	if ok && config.DesiredConfig != nil {
+		revision, err := revisionGetter.GetRevision(context.Background(), config.Namespace, config.Name,
+			config.DesiredConfig.SpecHash)
+		if err != nil || revision != nil {
+			return revision, err
+		}
+	}
+	if err := checkConfigRolledBack(addon, config); err != nil {
+		return nil, err
+	}
+	return getter.Get(context.Background(), config.Namespace, config.Name)
+}
+
+// checkConfigRolledBack returns an error if the config is pinned to a previous revision on the addon by the
+// automatic rollback, recorded in the annotation constants.AddonConfigRollbackAnnotationKey.
+func checkConfigRolledBack(addon *addonapiv1alpha1.ManagedClusterAddOn, config addonapiv1alpha1.ConfigReference) error {
+	value, ok := addon.Annotations[constants.AddonConfigRollbackAnnotationKey]
+	if !ok || config.DesiredConfig == nil {
+		return nil
+	}
+	rollbacks := map[string]struct {
+		Pinned addonapiv1alpha1.ConfigSpecHash `json:"pinned"`
+	}{}
+	if err := json.Unmarshal([]byte(value), &rollbacks); err != nil {
+		// the invalid annotation is ignored by the rollback as well
+		return nil
+	}
+	rollback, ok := rollbacks[fmt.Sprintf("%s.%s", config.Resource, config.Group)]
+	if !ok || rollback.Pinned != *config.DesiredConfig {
+		return nil
+	}
+	return fmt.Errorf("AddOnDeploymentConfig %s/%s is rolled back to the revision %s, which can only be rendered "+
+		"from the history by the getter from NewAddOnDeploymentConfigGetterWithHistory", config.Namespace, config.Name,
+		config.DesiredConfig.SpecHash)
+}
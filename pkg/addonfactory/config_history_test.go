@@ -0,0 +1,269 @@
+package addonfactory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakekube "k8s.io/client-go/kubernetes/fake"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	fakeaddon "open-cluster-management.io/api/client/addon/clientset/versioned/fake"
+
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/addontesting"
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/constants"
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/controllers/managementaddonconfig"
+)
+
+func updateTestAddOnDeploymentConfig(t *testing.T, addonClient *fakeaddon.Clientset,
+	config *addonapiv1alpha1.AddOnDeploymentConfig) string {
+	_, err := addonClient.AddonV1alpha1().AddOnDeploymentConfigs(config.Namespace).Update(
+		context.TODO(), config, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	specHash, err := addOnDeploymentConfigSpecHash(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return specHash
+}
+
+func TestAddOnDeploymentConfigSpecHash(t *testing.T) {
+	config := newTestAddOnDeploymentConfig("config1", map[string]string{"os": "linux"},
+		[]corev1.Toleration{{Key: "foo", Operator: corev1.TolerationOpExists}},
+		addonapiv1alpha1.CustomizedVariable{Name: "Image", Value: "a"})
+	specHash, err := addOnDeploymentConfigSpecHash(config)
+	if err != nil {
+		t.Fatalf("expected no error, got err %v", err)
+	}
+
+	// the config references of the addon are hashed from the config decoded from json by the dynamic informer.
+	data, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := &unstructured.Unstructured{}
+	if err := json.Unmarshal(data, &obj.Object); err != nil {
+		t.Fatal(err)
+	}
+	expected, err := managementaddonconfig.GetSpecHash(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if specHash != expected {
+		t.Errorf("expected spec hash %s, but got %s", expected, specHash)
+	}
+}
+
+func TestAddOnDeploymentConfigGetterWithHistory(t *testing.T) {
+	config := newTestAddOnDeploymentConfig("config1", nil, nil,
+		addonapiv1alpha1.CustomizedVariable{Name: "Image", Value: "v1"})
+	addonClient := fakeaddon.NewSimpleClientset(config)
+
+	// the history is recorded by the addon manager, the previous revisions v1 and v2 are in the history.
+	history := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "cluster1", Name: "addon-deployment-config-history-config1"},
+		Data:       map[string]string{},
+	}
+	var specHashes []string
+	for i, image := range []string{"v1", "v2", "v3"} {
+		config.Spec.CustomizedVariables[0].Value = image
+		specHash := updateTestAddOnDeploymentConfig(t, addonClient, config)
+		specHashes = append(specHashes, specHash)
+		if image == "v3" {
+			continue
+		}
+		spec, err := json.Marshal(config.Spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		history.Data[specHash] = fmt.Sprintf(`{"revision": %d, "spec": %s}`, i+1, spec)
+	}
+	kubeClient := fakekube.NewSimpleClientset(history)
+	getter := NewAddOnDeploymentConfigGetterWithHistory(addonClient, kubeClient)
+
+	cases := []struct {
+		name          string
+		specHash      string
+		failed        string
+		expectedImage string
+		readHistory   bool
+		expectedErr   bool
+	}{
+		{
+			name:          "latest revision",
+			specHash:      specHashes[2],
+			expectedImage: "v3",
+		},
+		{
+			name:          "empty spec hash",
+			expectedImage: "v3",
+		},
+		{
+			name:          "previous revision",
+			specHash:      specHashes[1],
+			expectedImage: "v2",
+		},
+		{
+			name:          "oldest revision",
+			specHash:      specHashes[0],
+			expectedImage: "v1",
+		},
+		{
+			name:          "unknown revision",
+			specHash:      "unknown",
+			expectedImage: "v3",
+			readHistory:   true,
+		},
+		{
+			name:        "rolled back to an unknown revision",
+			specHash:    "unknown",
+			failed:      specHashes[2],
+			expectedErr: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			kubeClient.ClearActions()
+			values, err := GetAddOnDeploymentConfigValues(getter, ToAddOnCustomizedVariableValues)(
+				NewFakeManagedCluster("cluster1", "1.10.1"), newTestRolledBackAddon(c.specHash, c.failed))
+			if c.expectedErr {
+				if err == nil {
+					t.Errorf("expected error, but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got err %v", err)
+			}
+			if values["Image"] != c.expectedImage {
+				t.Errorf("expected image %s, but got %v", c.expectedImage, values["Image"])
+			}
+			// the history is only read for the revisions which are not the latest, and never written when rendering
+			for _, action := range kubeClient.Actions() {
+				if action.GetVerb() != "get" || (c.expectedImage == "v3" && !c.readHistory) {
+					t.Errorf("unexpected action %v", action)
+				}
+			}
+		})
+	}
+}
+
+func TestAddOnDeploymentConfigGetterWithHistoryEmptyList(t *testing.T) {
+	config := newTestAddOnDeploymentConfig("config1", nil, nil,
+		addonapiv1alpha1.CustomizedVariable{Name: "Image", Value: "v2"})
+	addonClient := fakeaddon.NewSimpleClientset(config)
+
+	// the latest config on the hub has an explicitly empty list, which is dropped by the typed config, so the spec
+	// hash of the addon computed by the hub differs from the one of the typed config.
+	data, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := &unstructured.Unstructured{}
+	if err := json.Unmarshal(data, &obj.Object); err != nil {
+		t.Fatal(err)
+	}
+	if err := unstructured.SetNestedSlice(obj.Object, []interface{}{}, "spec", "registries"); err != nil {
+		t.Fatal(err)
+	}
+	specHash, err := managementaddonconfig.GetSpecHash(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	typedSpecHash, err := addOnDeploymentConfigSpecHash(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if specHash == typedSpecHash {
+		t.Fatalf("expected the spec hash differs from the typed one")
+	}
+
+	spec, err := json.Marshal(obj.Object["spec"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	history := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "cluster1", Name: "addon-deployment-config-history-config1"},
+		Data:       map[string]string{specHash: fmt.Sprintf(`{"revision": 1, "spec": %s}`, spec)},
+	}
+
+	cases := []struct {
+		name        string
+		kubeObjects []runtime.Object
+	}{
+		{
+			name:        "latest revision in the history",
+			kubeObjects: []runtime.Object{history},
+		},
+		{
+			name: "latest revision not recorded yet",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			getter := NewAddOnDeploymentConfigGetterWithHistory(addonClient, fakekube.NewSimpleClientset(c.kubeObjects...))
+			values, err := GetAddOnDeploymentConfigValues(getter, ToAddOnCustomizedVariableValues)(
+				NewFakeManagedCluster("cluster1", "1.10.1"), newTestRolledBackAddon(specHash, ""))
+			if err != nil {
+				t.Fatalf("expected no error, got err %v", err)
+			}
+			if values["Image"] != "v2" {
+				t.Errorf("expected image v2, but got %v", values["Image"])
+			}
+		})
+	}
+}
+
+// newTestRolledBackAddon returns an addon with the desired spec hash of the AddOnDeploymentConfig cluster1/config1,
+// which is rolled back to the desired spec hash from the failed spec hash if failed is set.
+func newTestRolledBackAddon(specHash, failed string) *addonapiv1alpha1.ManagedClusterAddOn {
+	addon := addontesting.NewAddon("test", "cluster1")
+	addon.Status.ConfigReferences = []addonapiv1alpha1.ConfigReference{
+		{
+			ConfigGroupResource: addonapiv1alpha1.ConfigGroupResource{
+				Group:    AddOnDeploymentConfigGVR.Group,
+				Resource: AddOnDeploymentConfigGVR.Resource,
+			},
+			ConfigReferent: addonapiv1alpha1.ConfigReferent{Namespace: "cluster1", Name: "config1"},
+			DesiredConfig: &addonapiv1alpha1.ConfigSpecHash{
+				ConfigReferent: addonapiv1alpha1.ConfigReferent{Namespace: "cluster1", Name: "config1"},
+				SpecHash:       specHash,
+			},
+		},
+	}
+	if len(failed) > 0 {
+		addon.Annotations = map[string]string{
+			constants.AddonConfigRollbackAnnotationKey: fmt.Sprintf(
+				`{"addondeploymentconfigs.addon.open-cluster-management.io": {"failed": {"namespace": "cluster1", "name": "config1", "specHash": %q}, "pinned": {"namespace": "cluster1", "name": "config1", "specHash": %q}}}`,
+				failed, specHash),
+		}
+	}
+	return addon
+}
+
+func TestRolledBackConfigWithoutHistory(t *testing.T) {
+	config := newTestAddOnDeploymentConfig("config1", nil, nil,
+		addonapiv1alpha1.CustomizedVariable{Name: "Image", Value: "v2"})
+	getter := NewAddOnDeploymentConfigGetter(fakeaddon.NewSimpleClientset(config))
+	getValues := GetAddOnDeploymentConfigValues(getter, ToAddOnCustomizedVariableValues)
+	cluster := NewFakeManagedCluster("cluster1", "1.10.1")
+
+	values, err := getValues(cluster, newTestRolledBackAddon("hash1", ""))
+	if err != nil {
+		t.Fatalf("expected no error, got err %v", err)
+	}
+	if values["Image"] != "v2" {
+		t.Errorf("expected image v2, but got %v", values["Image"])
+	}
+
+	// the latest revision is the failed one, which must not be rendered for the rolled back addon
+	if _, err := getValues(cluster, newTestRolledBackAddon("hash1", "hash2")); err == nil {
+		t.Errorf("expected error for the rolled back config, but got nil")
+	}
+}
@@ -0,0 +1,140 @@
+package addonfactory
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+
+	"open-cluster-management.io/addon-framework/pkg/agent"
+	"open-cluster-management.io/addon-framework/pkg/utils"
+)
+
+// ConfigMergeStrategy defines how the values of multiple configs of the same type are merged, the values of the
+// later config are merged into the values of the former ones.
+type ConfigMergeStrategy = agent.ConfigMergeStrategy
+
+const (
+	// ConfigMergeStrategyReplace replaces each top-level value with the one of the later config.
+	ConfigMergeStrategyReplace = agent.ConfigMergeStrategyReplace
+	// ConfigMergeStrategyDeepMerge merges the maps recursively, the other values are replaced.
+	ConfigMergeStrategyDeepMerge = agent.ConfigMergeStrategyDeepMerge
+	// ConfigMergeStrategyAppendLists merges the maps recursively and appends the lists.
+	ConfigMergeStrategyAppendLists = agent.ConfigMergeStrategyAppendLists
+)
+
+// ValuesSources records where the effective values came from. The key is the key of a value, the keys of the
+// nested values are joined with ".", and the value is the configs the value came from in the format of
+// namespace/name, more than one config only if the lists are appended.
+type ValuesSources map[string][]string
+
+// MergeValuesWithStrategy merges the values b into the values a with the strategy, and returns the merged values.
+// The a and b are not changed.
+func MergeValuesWithStrategy(strategy ConfigMergeStrategy, a, b Values) (Values, error) {
+	merged, err := utils.MergeValuesWithSources(strategy, a, b, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+// GetAddOnDeploymentConfigValuesWithMergeStrategy is the same as GetAddOnDeploymentConfigValues, except that the
+// values of the AddOnDeploymentConfigs are merged in order with the strategy.
+func GetAddOnDeploymentConfigValuesWithMergeStrategy(getter AddOnDeploymentConfigGetter, strategy ConfigMergeStrategy,
+	toValuesFuncs ...AddOnDeploymentConfigToValuesFunc) GetValuesFunc {
+	return func(cluster *clusterv1.ManagedCluster, addon *addonapiv1alpha1.ManagedClusterAddOn) (Values, error) {
+		values, sources, err := mergeAddOnDeploymentConfigValues(getter, strategy, addon, toValuesFuncs)
+		if err != nil {
+			return nil, err
+		}
+		recordValuesSource(addon, sources.source)
+		return values, nil
+	}
+}
+
+// GetAddOnDeploymentConfigValuesSources returns the AddOnDeploymentConfigs each effective value came from, when the
+// values of the AddOnDeploymentConfigs of the addon are merged with the strategy and the toValuesFuncs.
+func GetAddOnDeploymentConfigValuesSources(getter AddOnDeploymentConfigGetter, strategy ConfigMergeStrategy,
+	addon *addonapiv1alpha1.ManagedClusterAddOn,
+	toValuesFuncs ...AddOnDeploymentConfigToValuesFunc) (ValuesSources, error) {
+	_, sources, err := mergeAddOnDeploymentConfigValues(getter, strategy, addon, toValuesFuncs)
+	return sources, err
+}
+
+// source returns the AddOnDeploymentConfigs the field came from. The sources are recorded for the values which are
+// not maps, so the source of a map is the configs of the values in it, and the source of an item of a list is the
+// configs of the list.
+func (s ValuesSources) source(field string) string {
+	configs, ok := s[field]
+	if !ok {
+		for key := field; strings.Contains(key, "."); {
+			key = key[:strings.LastIndex(key, ".")]
+			if configs, ok = s[key]; ok {
+				break
+			}
+		}
+	}
+	if !ok {
+		for key, keyConfigs := range s {
+			if strings.HasPrefix(key, field+".") {
+				for _, config := range keyConfigs {
+					if !containsString(configs, config) {
+						configs = append(configs, config)
+					}
+				}
+			}
+		}
+		sort.Strings(configs)
+	}
+	if len(configs) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("AddOnDeploymentConfig %s", strings.Join(configs, ", "))
+}
+
+// sourcedValues are the values of an AddOnDeploymentConfig.
+type sourcedValues struct {
+	source string
+	values Values
+}
+
+// lastSetterSource returns the source of the values of the AddOnDeploymentConfigs merged in order with MergeValues,
+// which is the last config setting the field.
+func lastSetterSource(valuesOfConfigs []sourcedValues) valuesSource {
+	return func(field string) string {
+		for i := len(valuesOfConfigs) - 1; i >= 0; i-- {
+			if hasValuesField(valuesOfConfigs[i].values, field) {
+				return fmt.Sprintf("AddOnDeploymentConfig %s", valuesOfConfigs[i].source)
+			}
+		}
+		return ""
+	}
+}
+
+func mergeAddOnDeploymentConfigValues(getter AddOnDeploymentConfigGetter, strategy ConfigMergeStrategy,
+	addon *addonapiv1alpha1.ManagedClusterAddOn,
+	toValuesFuncs []AddOnDeploymentConfigToValuesFunc) (Values, ValuesSources, error) {
+	configs, err := getAddOnDeploymentConfigs(getter, addon)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mergedValues := Values{}
+	sources := ValuesSources{}
+	for _, config := range configs {
+		source := fmt.Sprintf("%s/%s", config.Namespace, config.Name)
+		for _, toValuesFunc := range toValuesFuncs {
+			values, err := toValuesFunc(*config)
+			if err != nil {
+				return nil, nil, err
+			}
+			mergedValues, err = utils.MergeValuesWithSources(strategy, mergedValues, values, source, sources)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return mergedValues, sources, nil
+}
@@ -0,0 +1,184 @@
+package addonfactory
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	fakeaddon "open-cluster-management.io/api/client/addon/clientset/versioned/fake"
+
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/addontesting"
+)
+
+func newTestAddOnDeploymentConfig(name string, nodeSelector map[string]string,
+	tolerations []corev1.Toleration, variables ...addonapiv1alpha1.CustomizedVariable) *addonapiv1alpha1.AddOnDeploymentConfig {
+	return &addonapiv1alpha1.AddOnDeploymentConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "cluster1"},
+		Spec: addonapiv1alpha1.AddOnDeploymentConfigSpec{
+			CustomizedVariables: variables,
+			NodePlacement: &addonapiv1alpha1.NodePlacement{
+				NodeSelector: nodeSelector,
+				Tolerations:  tolerations,
+			},
+		},
+	}
+}
+
+func TestGetAddOnDeploymentConfigValuesWithMergeStrategy(t *testing.T) {
+	toleration1 := corev1.Toleration{Key: "foo", Operator: corev1.TolerationOpExists}
+	toleration2 := corev1.Toleration{Key: "bar", Operator: corev1.TolerationOpExists}
+
+	addon := addontesting.NewAddon("test", "cluster1")
+	for _, name := range []string{"config1", "config2"} {
+		addon.Status.ConfigReferences = append(addon.Status.ConfigReferences, addonapiv1alpha1.ConfigReference{
+			ConfigGroupResource: addonapiv1alpha1.ConfigGroupResource{
+				Group:    AddOnDeploymentConfigGVR.Group,
+				Resource: AddOnDeploymentConfigGVR.Resource,
+			},
+			ConfigReferent: addonapiv1alpha1.ConfigReferent{Namespace: "cluster1", Name: name},
+		})
+	}
+	objects := []runtime.Object{
+		addon,
+		newTestAddOnDeploymentConfig("config1", map[string]string{"os": "linux", "disk": "ssd"},
+			[]corev1.Toleration{toleration1}, addonapiv1alpha1.CustomizedVariable{Name: "Image", Value: "a"}),
+		newTestAddOnDeploymentConfig("config2", map[string]string{"disk": "hdd"},
+			[]corev1.Toleration{toleration2}),
+	}
+
+	cases := []struct {
+		name            string
+		strategy        ConfigMergeStrategy
+		expectedValues  Values
+		expectedSources ValuesSources
+		expectedErr     bool
+	}{
+		{
+			name:     "replace",
+			strategy: ConfigMergeStrategyReplace,
+			expectedValues: Values{
+				"Image":        "a",
+				"NodeSelector": map[string]string{"disk": "hdd"},
+				"Tolerations":  []corev1.Toleration{toleration2},
+			},
+			expectedSources: ValuesSources{
+				"Image":        {"cluster1/config1"},
+				"NodeSelector": {"cluster1/config2"},
+				"Tolerations":  {"cluster1/config2"},
+			},
+		},
+		{
+			name:     "deep merge",
+			strategy: ConfigMergeStrategyDeepMerge,
+			expectedValues: Values{
+				"Image":        "a",
+				"NodeSelector": map[string]interface{}{"os": "linux", "disk": "hdd"},
+				"Tolerations":  []corev1.Toleration{toleration2},
+			},
+			expectedSources: ValuesSources{
+				"Image":             {"cluster1/config1"},
+				"NodeSelector.os":   {"cluster1/config1"},
+				"NodeSelector.disk": {"cluster1/config2"},
+				"Tolerations":       {"cluster1/config2"},
+			},
+		},
+		{
+			name:     "append lists",
+			strategy: ConfigMergeStrategyAppendLists,
+			expectedValues: Values{
+				"Image":        "a",
+				"NodeSelector": map[string]interface{}{"os": "linux", "disk": "hdd"},
+				"Tolerations":  []corev1.Toleration{toleration1, toleration2},
+			},
+			expectedSources: ValuesSources{
+				"Image":             {"cluster1/config1"},
+				"NodeSelector.os":   {"cluster1/config1"},
+				"NodeSelector.disk": {"cluster1/config2"},
+				"Tolerations":       {"cluster1/config1", "cluster1/config2"},
+			},
+		},
+		{
+			name:        "unsupported strategy",
+			strategy:    "Unknown",
+			expectedErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			getter := NewAddOnDeploymentConfigGetter(fakeaddon.NewSimpleClientset(objects...))
+
+			values, err := GetAddOnDeploymentConfigValuesWithMergeStrategy(getter, c.strategy,
+				ToAddOnDeploymentConfigValues)(NewFakeManagedCluster("cluster1", "1.10.1"), addon)
+			if c.expectedErr {
+				if err == nil {
+					t.Errorf("expected error, but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got err %v", err)
+			}
+			if !reflect.DeepEqual(values, c.expectedValues) {
+				t.Errorf("expected values %v, but got %v", c.expectedValues, values)
+			}
+
+			sources, err := GetAddOnDeploymentConfigValuesSources(getter, c.strategy, addon,
+				ToAddOnDeploymentConfigValues)
+			if err != nil {
+				t.Fatalf("expected no error, got err %v", err)
+			}
+			if !reflect.DeepEqual(sources, c.expectedSources) {
+				t.Errorf("expected sources %v, but got %v", c.expectedSources, sources)
+			}
+		})
+	}
+}
+
+func TestMergeValuesWithStrategy(t *testing.T) {
+	a := Values{"global": map[string]interface{}{"registries": []interface{}{"a"}, "proxy": "http://a"}}
+	b := Values{"global": map[string]interface{}{"registries": []string{"b"}}}
+
+	merged, err := MergeValuesWithStrategy(ConfigMergeStrategyAppendLists, a, b)
+	if err != nil {
+		t.Fatalf("expected no error, got err %v", err)
+	}
+	expected := Values{"global": map[string]interface{}{"registries": []interface{}{"a", "b"}, "proxy": "http://a"}}
+	if !reflect.DeepEqual(merged, expected) {
+		t.Errorf("expected values %v, but got %v", expected, merged)
+	}
+	if !reflect.DeepEqual(a["global"], map[string]interface{}{"registries": []interface{}{"a"}, "proxy": "http://a"}) {
+		t.Errorf("expected the values not changed, but got %v", a)
+	}
+}
+
+func TestValuesSourcesSource(t *testing.T) {
+	sources := ValuesSources{
+		"Tolerations":      {"cluster1/config1", "cluster1/config2"},
+		"NodeSelector.a":   {"cluster1/config1"},
+		"NodeSelector.b":   {"cluster1/config2"},
+		"global.proxy.url": {"cluster1/config2"},
+	}
+
+	cases := []struct {
+		field    string
+		expected string
+	}{
+		{field: "Tolerations", expected: "AddOnDeploymentConfig cluster1/config1, cluster1/config2"},
+		{field: "Tolerations.0.key", expected: "AddOnDeploymentConfig cluster1/config1, cluster1/config2"},
+		{field: "NodeSelector.b", expected: "AddOnDeploymentConfig cluster1/config2"},
+		{field: "NodeSelector", expected: "AddOnDeploymentConfig cluster1/config1, cluster1/config2"},
+		{field: "global", expected: "AddOnDeploymentConfig cluster1/config2"},
+		{field: "image", expected: ""},
+	}
+	for _, c := range cases {
+		t.Run(c.field, func(t *testing.T) {
+			if source := sources.source(c.field); source != c.expected {
+				t.Errorf("expected source %q, but got %q", c.expected, source)
+			}
+		})
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"helm.sh/helm/v3/pkg/engine"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/version"
 	"k8s.io/apimachinery/pkg/util/yaml"
 	"k8s.io/klog/v2"
 	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
@@ -25,11 +26,12 @@ import (
 // the values in helm chart should begin with a lowercase letter, so we need convert it to Values by JsonStructToValues.
 // the built-in values can not be overrided by getValuesFuncs
 type helmBuiltinValues struct {
-	ClusterName             string `json:"clusterName"`
-	AddonInstallNamespace   string `json:"addonInstallNamespace"`
-	HubKubeConfigSecret     string `json:"hubKubeConfigSecret,omitempty"`
-	ManagedKubeConfigSecret string `json:"managedKubeConfigSecret,omitempty"`
-	InstallMode             string `json:"installMode"`
+	ClusterName             string              `json:"clusterName"`
+	AddonInstallNamespace   string              `json:"addonInstallNamespace"`
+	HubKubeConfigSecret     string              `json:"hubKubeConfigSecret,omitempty"`
+	ManagedKubeConfigSecret string              `json:"managedKubeConfigSecret,omitempty"`
+	InstallMode             string              `json:"installMode"`
+	Global                  globalBuiltinValues `json:"global"`
 }
 
 // helmDefaultValues includes the default values for helm agentAddon.
@@ -45,19 +47,31 @@ type HelmAgentAddon struct {
 	decoder            runtime.Decoder
 	chart              *chart.Chart
 	getValuesFuncs     []GetValuesFunc
+	typedValuesCheck   typedValuesCheck
 	agentAddonOptions  agent.AgentAddonOptions
+	postRenderMutators []PostRenderMutator
 	trimCRDDescription bool
+	helmHooks          bool
 	hostingCluster     *clusterv1.ManagedCluster
+	apiVersionsFunc    APIVersionsFunc
+	hubAPIServer       string
+	hubCABundle        []byte
 }
 
 func newHelmAgentAddon(factory *AgentAddonFactory, chart *chart.Chart) *HelmAgentAddon {
 	return &HelmAgentAddon{
 		decoder:            serializer.NewCodecFactory(factory.scheme).UniversalDeserializer(),
 		chart:              chart,
-		getValuesFuncs:     factory.getValuesFuncs,
+		getValuesFuncs:     factory.allGetValuesFuncs(),
+		typedValuesCheck:   factory.typedValuesCheck,
 		agentAddonOptions:  factory.agentAddonOptions,
 		trimCRDDescription: factory.trimCRDDescription,
+		postRenderMutators: factory.postRenderMutators,
+		helmHooks:          factory.helmHooks,
 		hostingCluster:     factory.hostingCluster,
+		apiVersionsFunc:    factory.apiVersionsFunc,
+		hubAPIServer:       factory.hubAPIServer,
+		hubCABundle:        factory.hubCABundle,
 	}
 }
 
@@ -66,7 +80,11 @@ func (a *HelmAgentAddon) Manifests(
 	addon *addonapiv1alpha1.ManagedClusterAddOn) ([]runtime.Object, error) {
 	var objects []runtime.Object
 
-	values, err := a.getValues(cluster, addon)
+	// the dependencies of the chart are enabled or disabled by the values of each cluster, so the chart
+	// is copied before processing the dependencies.
+	userChart := copyChart(a.chart)
+
+	values, err := a.getValues(userChart, cluster, addon)
 	if err != nil {
 		return objects, err
 	}
@@ -76,7 +94,7 @@ func (a *HelmAgentAddon) Manifests(
 		LintMode: false,
 	}
 
-	crds := a.chart.CRDObjects()
+	crds := userChart.CRDObjects()
 	for _, crd := range crds {
 		klog.V(4).Infof("%v/n", crd.File.Data)
 		object, _, err := a.decoder.Decode(crd.File.Data, nil, nil)
@@ -86,7 +104,7 @@ func (a *HelmAgentAddon) Manifests(
 		objects = append(objects, object)
 	}
 
-	templates, err := helmEngine.Render(a.chart, values)
+	templates, err := helmEngine.Render(userChart, values)
 	if err != nil {
 		return objects, err
 	}
@@ -98,6 +116,7 @@ func (a *HelmAgentAddon) Manifests(
 	}
 	sort.Strings(keys)
 
+	var templateObjects []runtime.Object
 	for _, k := range keys {
 		data := templates[k]
 
@@ -126,12 +145,25 @@ func (a *HelmAgentAddon) Manifests(
 					}
 					return nil, err
 				}
-				objects = append(objects, object)
+				templateObjects = append(templateObjects, object)
 			}
 		}
 
 	}
 
+	if a.helmHooks {
+		templateObjects, err = translateHelmHooks(templateObjects)
+		if err != nil {
+			return nil, err
+		}
+	}
+	objects = append(objects, templateObjects...)
+
+	objects, err = applyPostRenderMutators(a.postRenderMutators, cluster, addon, objects)
+	if err != nil {
+		return nil, err
+	}
+
 	if a.trimCRDDescription {
 		objects = trimCRDDescription(objects)
 	}
@@ -142,11 +174,18 @@ func (a *HelmAgentAddon) GetAgentAddonOptions() agent.AgentAddonOptions {
 	return a.agentAddonOptions
 }
 
+// getValues returns the values to render the chart. The dependencies of the chart are processed with the
+// values in the same way as helm install does, the disabled subcharts per condition and tags are removed
+// from the chart, and the values are imported from the subcharts.
 func (a *HelmAgentAddon) getValues(
+	userChart *chart.Chart,
 	cluster *clusterv1.ManagedCluster,
 	addon *addonapiv1alpha1.ManagedClusterAddOn) (chartutil.Values, error) {
 	overrideValues := map[string]interface{}{}
 
+	customizedValues := newCustomizedValues(addon)
+	defer customizedValues.close()
+
 	defaultValues, err := a.getDefaultValues(cluster, addon)
 	if err != nil {
 		klog.Error("failed to get defaultValue. err:%v", err)
@@ -156,7 +195,7 @@ func (a *HelmAgentAddon) getValues(
 
 	for i := 0; i < len(a.getValuesFuncs); i++ {
 		if a.getValuesFuncs[i] != nil {
-			userValues, err := a.getValuesFuncs[i](cluster, addon)
+			userValues, err := customizedValues.getValues(a.getValuesFuncs[i], cluster)
 			if err != nil {
 				return overrideValues, err
 			}
@@ -167,6 +206,10 @@ func (a *HelmAgentAddon) getValues(
 		}
 	}
 
+	if err := validateTypedValues(a.typedValuesCheck, customizedValues); err != nil {
+		return nil, err
+	}
+
 	builtinValues, err := a.getBuiltinValues(cluster, addon)
 	if err != nil {
 		klog.Error("failed to get builtinValue. err:%v", err)
@@ -175,8 +218,22 @@ func (a *HelmAgentAddon) getValues(
 
 	overrideValues = MergeValues(overrideValues, builtinValues)
 
-	values, err := chartutil.ToRenderValues(a.chart, overrideValues,
-		a.releaseOptions(cluster, addon), a.capabilities(cluster, addon))
+	if err := chartutil.ProcessDependencies(userChart, overrideValues); err != nil {
+		klog.Errorf("failed to process dependencies of helm chart with values %v. err:%v", overrideValues, err)
+		return nil, err
+	}
+
+	if err := validateHelmValues(userChart, overrideValues, customizedValues); err != nil {
+		return nil, err
+	}
+
+	capabilities, err := a.capabilities(cluster, addon)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := chartutil.ToRenderValues(userChart, overrideValues,
+		a.releaseOptions(cluster, addon), capabilities)
 	if err != nil {
 		klog.Errorf("failed to render helm chart with values %v. err:%v", overrideValues, err)
 		return values, err
@@ -185,6 +242,26 @@ func (a *HelmAgentAddon) getValues(
 	return values, nil
 }
 
+// validateHelmValues validates the values coalesced with the default values of the chart against the
+// values.schema.json of the chart and its subcharts before rendering the chart, so the invalid values are reported
+// with the source of them instead of a render error.
+func validateHelmValues(userChart *chart.Chart, values map[string]interface{},
+	customizedValues *customizedValues) error {
+	coalescedValues, err := chartutil.CoalesceValues(userChart, values)
+	if err != nil {
+		return err
+	}
+
+	schemaErrors, err := validateChartValues(userChart, coalescedValues, "")
+	if err != nil {
+		return err
+	}
+	if len(schemaErrors) > 0 {
+		return newInvalidValuesError(schemaErrors, customizedValues)
+	}
+	return nil
+}
+
 func (a *HelmAgentAddon) getBuiltinValues(
 	cluster *clusterv1.ManagedCluster,
 	addon *addonapiv1alpha1.ManagedClusterAddOn) (Values, error) {
@@ -198,6 +275,7 @@ func (a *HelmAgentAddon) getBuiltinValues(
 	builtinValues.AddonInstallNamespace = installNamespace
 
 	builtinValues.InstallMode, _ = constants.GetHostedModeInfo(addon.GetAnnotations())
+	builtinValues.Global = newGlobalBuiltinValues(cluster, addon, a.hubAPIServer, a.hubCABundle)
 
 	helmBuiltinValues, err := JsonStructToValues(builtinValues)
 	if err != nil {
@@ -220,7 +298,11 @@ func (a *HelmAgentAddon) getDefaultValues(
 	defaultValues.ManagedKubeConfigSecret = fmt.Sprintf("%s-managed-kubeconfig", addon.Name)
 
 	if a.hostingCluster != nil {
-		defaultValues.HostingClusterCapabilities = *a.capabilities(a.hostingCluster, addon)
+		hostingCapabilities, err := a.capabilities(a.hostingCluster, addon)
+		if err != nil {
+			return nil, err
+		}
+		defaultValues.HostingClusterCapabilities = *hostingCapabilities
 	}
 
 	helmDefaultValues, err := JsonStructToValues(defaultValues)
@@ -231,13 +313,27 @@ func (a *HelmAgentAddon) getDefaultValues(
 	return helmDefaultValues, nil
 }
 
-// only support Capabilities.KubeVersion
+// only support Capabilities.KubeVersion and Capabilities.APIVersions, the APIVersions is empty unless
+// the apiVersionsFunc is set.
 func (a *HelmAgentAddon) capabilities(
 	cluster *clusterv1.ManagedCluster,
-	addon *addonapiv1alpha1.ManagedClusterAddOn) *chartutil.Capabilities {
-	return &chartutil.Capabilities{
+	addon *addonapiv1alpha1.ManagedClusterAddOn) (*chartutil.Capabilities, error) {
+	capabilities := &chartutil.Capabilities{
 		KubeVersion: chartutil.KubeVersion{Version: cluster.Status.Version.Kubernetes},
 	}
+	if v, err := version.ParseGeneric(cluster.Status.Version.Kubernetes); err == nil {
+		capabilities.KubeVersion.Major = fmt.Sprint(v.Major())
+		capabilities.KubeVersion.Minor = fmt.Sprint(v.Minor())
+	}
+
+	if a.apiVersionsFunc != nil {
+		apiVersions, err := a.apiVersionsFunc(cluster, addon)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get api versions of cluster %s: %v", cluster.Name, err)
+		}
+		capabilities.APIVersions = apiVersions
+	}
+	return capabilities, nil
 }
 
 // only support Release.Name, Release.Namespace
@@ -250,3 +346,83 @@ func (a *HelmAgentAddon) releaseOptions(
 	}
 	return chartutil.ReleaseOptions{Name: a.agentAddonOptions.AddonName, Namespace: installNamespace}
 }
+
+// checkDependencies checks that all the dependencies defined in Chart.yaml of the chart and its subcharts
+// are vendored in the charts/ directory.
+func checkDependencies(c *chart.Chart) error {
+	var missing []string
+	for _, req := range c.Metadata.Dependencies {
+		found := false
+		for _, dep := range c.Dependencies() {
+			if dep.Name() == req.Name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, req.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("found in Chart.yaml of chart %s, but missing in charts/ directory: %s",
+			c.Name(), strings.Join(missing, ", "))
+	}
+
+	for _, dep := range c.Dependencies() {
+		if err := checkDependencies(dep); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyChart copies the chart and its subcharts. Only the fields changed by processing the dependencies are
+// deep copied, the templates and files are shared with the original chart.
+func copyChart(c *chart.Chart) *chart.Chart {
+	out := *c
+	if c.Metadata != nil {
+		metadata := *c.Metadata
+		metadata.Dependencies = make([]*chart.Dependency, 0, len(c.Metadata.Dependencies))
+		for _, dep := range c.Metadata.Dependencies {
+			d := *dep
+			metadata.Dependencies = append(metadata.Dependencies, &d)
+		}
+		out.Metadata = &metadata
+	}
+	out.Values = copyValues(c.Values)
+
+	deps := make([]*chart.Chart, 0, len(c.Dependencies()))
+	for _, dep := range c.Dependencies() {
+		deps = append(deps, copyChart(dep))
+	}
+	out.SetDependencies(deps...)
+	return &out
+}
+
+func copyValues(values map[string]interface{}) map[string]interface{} {
+	if values == nil {
+		return nil
+	}
+	out := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		out[k] = copyValue(v)
+	}
+	return out
+}
+
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return copyValues(v)
+	case chartutil.Values:
+		return copyValues(v)
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i := range v {
+			out[i] = copyValue(v[i])
+		}
+		return out
+	default:
+		return v
+	}
+}
@@ -3,7 +3,13 @@ package addonfactory
 import (
 	"embed"
 	"fmt"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
+	"testing/fstest"
+
+	"helm.sh/helm/v3/pkg/chartutil"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -243,6 +249,231 @@ func TestChartAgentAddon_Manifests(t *testing.T) {
 	}
 }
 
+func TestChartAgentAddon_Sources(t *testing.T) {
+	testScheme := runtime.NewScheme()
+	_ = clusterv1apha1.Install(testScheme)
+	_ = apiextensionsv1.AddToScheme(testScheme)
+	_ = apiextensionsv1beta1.AddToScheme(testScheme)
+	_ = scheme.AddToScheme(testScheme)
+
+	embedChart, err := loadChart(chartFS, "testmanifests/chart")
+	if err != nil {
+		t.Fatalf("failed to load chart: %v", err)
+	}
+	archivePath, err := chartutil.Save(embedChart, t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to save chart archive: %v", err)
+	}
+
+	cases := []struct {
+		name       string
+		newFactory func() (*AgentAddonFactory, error)
+	}{
+		{
+			name: "embed fs",
+			newFactory: func() (*AgentAddonFactory, error) {
+				return NewAgentAddonFactory("helloworld", chartFS, "testmanifests/chart"), nil
+			},
+		},
+		{
+			name: "dir fs",
+			newFactory: func() (*AgentAddonFactory, error) {
+				return NewAgentAddonFactory("helloworld", os.DirFS("testmanifests"), "chart"), nil
+			},
+		},
+		{
+			name: "dir",
+			newFactory: func() (*AgentAddonFactory, error) {
+				return NewAgentAddonFactoryFromDir("helloworld", "testmanifests/chart"), nil
+			},
+		},
+		{
+			name: "chart archive",
+			newFactory: func() (*AgentAddonFactory, error) {
+				return NewAgentAddonFactoryFromChartArchive("helloworld", archivePath)
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			factory, err := c.newFactory()
+			if err != nil {
+				t.Fatalf("expected no error, got err %v", err)
+			}
+			agentAddon, err := factory.
+				WithGetValuesFuncs(getValues, GetValuesFromAddonAnnotation).
+				WithScheme(testScheme).
+				BuildHelmAgentAddon()
+			if err != nil {
+				t.Fatalf("expected no error, got err %v", err)
+			}
+
+			objects, err := agentAddon.Manifests(NewFakeManagedCluster("cluster1", "1.10.1"),
+				NewFakeManagedClusterAddon("helloworld", "cluster1", "myNs", ""))
+			if err != nil {
+				t.Fatalf("expected no error, got err %v", err)
+			}
+			if len(objects) != 4 {
+				t.Errorf("expected 4 objects, but got %v", len(objects))
+			}
+		})
+	}
+
+	factory, err := NewAgentAddonFactoryFromChartArchive("helloworld", archivePath)
+	if err != nil {
+		t.Fatalf("expected no error, got err %v", err)
+	}
+	if _, err := factory.BuildTemplateAgentAddon(); err == nil {
+		t.Errorf("expected error building the template agentAddon of a chart archive")
+	}
+}
+
+func TestChartAgentAddon_Dependencies(t *testing.T) {
+	// the agentAddon is built once to verify that rendering for a cluster does not affect the others.
+	agentAddon, err := NewAgentAddonFactoryFromDir("helloworld", "testmanifests/umbrella").
+		WithGetValuesFuncs(GetValuesFromAddonAnnotation).
+		BuildHelmAgentAddon()
+	if err != nil {
+		t.Fatalf("expected no error, got err %v", err)
+	}
+
+	cases := []struct {
+		name               string
+		annotationValues   string
+		expectedConfigMaps map[string]map[string]string
+	}{
+		{
+			name: "default values",
+			expectedConfigMaps: map[string]map[string]string{
+				"umbrella": {"region": "us-east"},
+				"sub-a":    {"data": "from-parent", "region": "us-east"},
+			},
+		},
+		{
+			name:             "subcharts enabled by condition and tags",
+			annotationValues: `{"sub-a":{"enabled":false},"tags":{"extra":true},"global":{"region":"eu-west"}}`,
+			expectedConfigMaps: map[string]map[string]string{
+				"umbrella": {"region": "eu-west"},
+				"sub-b":    {"data": "from-subchart", "region": "eu-west"},
+			},
+		},
+		{
+			name:             "subchart values overridden",
+			annotationValues: `{"sub-a":{"data":"from-addon"}}`,
+			expectedConfigMaps: map[string]map[string]string{
+				"umbrella": {"region": "us-east"},
+				"sub-a":    {"data": "from-addon", "region": "us-east"},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			objects, err := agentAddon.Manifests(NewFakeManagedCluster("cluster1", "1.10.1"),
+				NewFakeManagedClusterAddon("helloworld", "cluster1", "myNs", c.annotationValues))
+			if err != nil {
+				t.Fatalf("expected no error, got err %v", err)
+			}
+
+			configMaps := map[string]map[string]string{}
+			for _, o := range objects {
+				cm, ok := o.(*corev1.ConfigMap)
+				if !ok {
+					t.Fatalf("expected configmap, but got %T", o)
+				}
+				configMaps[cm.Name] = cm.Data
+			}
+			if !reflect.DeepEqual(configMaps, c.expectedConfigMaps) {
+				t.Errorf("expected configmaps %v, but got %v", c.expectedConfigMaps, configMaps)
+			}
+		})
+	}
+}
+
+func TestChartAgentAddon_MissingDependencies(t *testing.T) {
+	chartFS := fstest.MapFS{
+		"Chart.yaml": &fstest.MapFile{Data: []byte(`apiVersion: v2
+name: umbrella
+version: 1.0.0
+dependencies:
+- name: sub-a
+  version: 1.0.0
+`)},
+	}
+	_, err := NewAgentAddonFactory("helloworld", chartFS, ".").BuildHelmAgentAddon()
+	if err == nil || !strings.Contains(err.Error(), "sub-a") {
+		t.Errorf("expected missing dependency error, got err %v", err)
+	}
+}
+
+func TestChartAgentAddon_Capabilities(t *testing.T) {
+	chartFS := fstest.MapFS{
+		"Chart.yaml": &fstest.MapFile{Data: []byte("apiVersion: v2\nname: capabilities\nversion: 1.0.0\n")},
+		"templates/configmap.yaml": &fstest.MapFile{Data: []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: capabilities
+  namespace: {{ .Release.Namespace }}
+data:
+  minor: "{{ .Capabilities.KubeVersion.Minor }}"
+  route: "{{ .Capabilities.APIVersions.Has "route.openshift.io/v1" }}"
+`)},
+	}
+
+	cases := []struct {
+		name            string
+		apiVersionsFunc APIVersionsFunc
+		claims          []clusterv1.ManagedClusterClaim
+		expectedData    map[string]string
+	}{
+		{
+			name:         "no api versions func",
+			expectedData: map[string]string{"minor": "10", "route": "false"},
+		},
+		{
+			name:            "api versions from cluster claim",
+			apiVersionsFunc: GetAPIVersionsFromClusterClaim("apiversions.test"),
+			claims: []clusterv1.ManagedClusterClaim{
+				{Name: "apiversions.test", Value: "route.openshift.io/v1, policy/v1"},
+			},
+			expectedData: map[string]string{"minor": "10", "route": "true"},
+		},
+		{
+			name:            "no cluster claim",
+			apiVersionsFunc: GetAPIVersionsFromClusterClaim("apiversions.test"),
+			expectedData:    map[string]string{"minor": "10", "route": "false"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			factory := NewAgentAddonFactory("helloworld", chartFS, ".")
+			if c.apiVersionsFunc != nil {
+				factory = factory.WithAPIVersionsFunc(c.apiVersionsFunc)
+			}
+			agentAddon, err := factory.BuildHelmAgentAddon()
+			if err != nil {
+				t.Fatalf("expected no error, got err %v", err)
+			}
+
+			cluster := NewFakeManagedCluster("cluster1", "1.10.1")
+			cluster.Status.ClusterClaims = c.claims
+			objects, err := agentAddon.Manifests(cluster, NewFakeManagedClusterAddon("helloworld", "cluster1", "myNs", ""))
+			if err != nil {
+				t.Fatalf("expected no error, got err %v", err)
+			}
+			if len(objects) != 1 {
+				t.Fatalf("expected 1 object, but got %v", len(objects))
+			}
+			cm := objects[0].(*corev1.ConfigMap)
+			if !reflect.DeepEqual(cm.Data, c.expectedData) {
+				t.Errorf("expected data %v, but got %v", c.expectedData, cm.Data)
+			}
+		})
+	}
+}
+
 func validateTrimCRDv1(crd *apiextensionsv1.CustomResourceDefinition) bool {
 	versions := crd.Spec.Versions
 	for i := range versions {
@@ -476,3 +707,77 @@ func hasDescriptionV1beta1(p *apiextensionsv1beta1.JSONSchemaProps) bool {
 
 	return false
 }
+
+func TestChartAgentAddon_GlobalBuiltinValues(t *testing.T) {
+	chartFS := fstest.MapFS{
+		"Chart.yaml": &fstest.MapFile{Data: []byte("apiVersion: v2\nname: global\nversion: 1.0.0\n")},
+		"templates/configmap.yaml": &fstest.MapFile{Data: []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: global
+  namespace: {{ .Release.Namespace }}
+data:
+  vendor: "{{ dig "clusterLabels" "vendor" "" .Values.global }}"
+  platform: "{{ dig "clusterClaims" "platform.open-cluster-management.io" "" .Values.global }}"
+  hubAPIServer: "{{ dig "hubAPIServer" "" .Values.global }}"
+  hubCABundle: "{{ dig "hubCABundle" "" .Values.global | b64enc }}"
+  hostingClusterName: "{{ dig "hostingClusterName" "" .Values.global }}"
+`)},
+	}
+
+	cases := []struct {
+		name         string
+		hubAPIServer string
+		hubCABundle  []byte
+		annotations  map[string]string
+		expectedData map[string]string
+	}{
+		{
+			name: "no hub info",
+			expectedData: map[string]string{
+				"vendor": "OpenShift", "platform": "AWS", "hubAPIServer": "", "hubCABundle": "", "hostingClusterName": "",
+			},
+		},
+		{
+			name:         "hub info in hosted mode",
+			hubAPIServer: "https://hub:6443",
+			hubCABundle:  []byte("ca"),
+			annotations:  map[string]string{addonapiv1alpha1.HostingClusterNameAnnotationKey: "hosting"},
+			expectedData: map[string]string{
+				"vendor": "OpenShift", "platform": "AWS", "hubAPIServer": "https://hub:6443", "hubCABundle": "Y2E=",
+				"hostingClusterName": "hosting",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			agentAddon, err := NewAgentAddonFactory("global", chartFS, ".").
+				WithHubInfo(c.hubAPIServer, c.hubCABundle).
+				BuildHelmAgentAddon()
+			if err != nil {
+				t.Fatalf("expected no error, got err %v", err)
+			}
+
+			cluster := NewFakeManagedCluster("cluster1", "1.10.1")
+			cluster.Labels = map[string]string{"vendor": "OpenShift"}
+			cluster.Status.ClusterClaims = []clusterv1.ManagedClusterClaim{
+				{Name: "platform.open-cluster-management.io", Value: "AWS"},
+			}
+			addon := NewFakeManagedClusterAddon("global", "cluster1", "myNs", "")
+			addon.Annotations = c.annotations
+
+			objects, err := agentAddon.Manifests(cluster, addon)
+			if err != nil {
+				t.Fatalf("expected no error, got err %v", err)
+			}
+			if len(objects) != 1 {
+				t.Fatalf("expected 1 object, but got %v", len(objects))
+			}
+			cm := objects[0].(*corev1.ConfigMap)
+			if !reflect.DeepEqual(cm.Data, c.expectedData) {
+				t.Errorf("expected data %v, but got %v", c.expectedData, cm.Data)
+			}
+		})
+	}
+}
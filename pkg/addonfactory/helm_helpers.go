@@ -0,0 +1,109 @@
+package addonfactory
+
+import (
+	"helm.sh/helm/v3/pkg/chart"
+)
+
+// helperTemplateName is the name of the template file of the helper templates added to the chart.
+const helperTemplateName = "templates/_addon_framework_helpers.tpl"
+
+// helperTemplate defines the named templates applying the values converted from the AddOnDeploymentConfig by
+// ToAddOnNodePlacementValues, ToAddOnRegistriesValues, ToAddOnProxyConfigValues and
+// ToAddOnResourceRequirementsValues to a pod spec, so the charts do not need to reimplement them:
+//
+//   - "addon-framework.nodePlacement" renders the nodeSelector and tolerations of the pod spec, e.g.
+//     {{- include "addon-framework.nodePlacement" . | nindent 6 }}
+//   - "addon-framework.image" overrides the image with the registries in the same way as OverrideImage, e.g.
+//     image: {{ include "addon-framework.image" (dict "image" .Values.image "context" .) }}
+//   - "addon-framework.proxyEnv" renders the HTTP_PROXY, HTTPS_PROXY and NO_PROXY env items of a container, e.g.
+//     env:
+//     {{- include "addon-framework.proxyEnv" . | nindent 8 }}
+//   - "addon-framework.resources" renders the resources of the container, or of "*" if the container has no
+//     requirements, e.g. {{- include "addon-framework.resources" (dict "container" "agent" "context" .) | nindent 8 }}
+const helperTemplate = `{{- define "addon-framework.nodePlacement" -}}
+{{- with dig "global" "nodeSelector" nil .Values.AsMap }}
+nodeSelector:
+{{- toYaml . | nindent 2 }}
+{{- end }}
+{{- with dig "tolerations" nil .Values.AsMap }}
+tolerations:
+{{- toYaml . | nindent 2 }}
+{{- end }}
+{{- end }}
+
+{{- define "addon-framework.image" -}}
+{{- $nameTag := splitList "@" .image | first -}}
+{{- $digest := trimPrefix $nameTag .image -}}
+{{- $tag := regexFind ":[^/:]*$" $nameTag -}}
+{{- $name := trimSuffix $tag $nameTag -}}
+{{- $first := splitList "/" $name | first -}}
+{{- $path := $name -}}
+{{- $fullName := printf "docker.io/%s" $name -}}
+{{- if and (contains "/" $name) (or (contains "." $first) (contains ":" $first) (eq $first "localhost")) -}}
+{{- $path = trimPrefix (printf "%s/" $first) $name -}}
+{{- $fullName = $name -}}
+{{- else if not (contains "/" $name) -}}
+{{- $fullName = printf "docker.io/library/%s" $name -}}
+{{- end -}}
+{{- $matched := -1 -}}
+{{- $mirrored := $name -}}
+{{- range dig "global" "registries" list .context.Values.AsMap -}}
+{{- $source := trimSuffix "/" (get . "source") -}}
+{{- $mirror := trimSuffix "/" (get . "mirror") -}}
+{{- if $mirror -}}
+{{- if not $source -}}
+{{- if le $matched 0 -}}
+{{- $mirrored = printf "%s/%s" $mirror $path -}}
+{{- $matched = 0 -}}
+{{- end -}}
+{{- else if ge (len $source) $matched -}}
+{{- $found := false -}}
+{{- range list $name $fullName -}}
+{{- if and (not $found) (or (eq . $source) (hasPrefix (printf "%s/" $source) .)) -}}
+{{- $mirrored = printf "%s%s" $mirror (trimPrefix $source .) -}}
+{{- $matched = len $source -}}
+{{- $found = true -}}
+{{- end -}}
+{{- end -}}
+{{- end -}}
+{{- end -}}
+{{- end -}}
+{{- printf "%s%s%s" $mirrored $tag $digest -}}
+{{- end }}
+
+{{- define "addon-framework.proxyEnv" -}}
+{{- with dig "global" "proxyConfig" (dict) .Values.AsMap }}
+{{- with get . "httpProxy" }}
+- name: HTTP_PROXY
+  value: {{ . | quote }}
+{{- end }}
+{{- with get . "httpsProxy" }}
+- name: HTTPS_PROXY
+  value: {{ . | quote }}
+{{- end }}
+{{- with get . "noProxy" }}
+- name: NO_PROXY
+  value: {{ . | quote }}
+{{- end }}
+{{- end }}
+{{- end }}
+
+{{- define "addon-framework.resources" -}}
+{{- $requirements := dig "global" "resourceRequirements" (dict) .context.Values.AsMap }}
+{{- with get $requirements .container | default (get $requirements "*") }}
+resources:
+{{- toYaml . | nindent 2 }}
+{{- end }}
+{{- end }}
+`
+
+// addHelperTemplates adds the helper templates to the chart, the named templates are shared by the chart and its
+// subcharts. The helper templates are not added if the chart has a template file with the same name.
+func addHelperTemplates(c *chart.Chart) {
+	for _, t := range c.Templates {
+		if t.Name == helperTemplateName {
+			return
+		}
+	}
+	c.Templates = append(c.Templates, &chart.File{Name: helperTemplateName, Data: []byte(helperTemplate)})
+}
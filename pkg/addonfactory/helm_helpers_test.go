@@ -0,0 +1,141 @@
+package addonfactory
+
+import (
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestHelmHelperTemplates(t *testing.T) {
+	chartFS := fstest.MapFS{
+		"Chart.yaml":  &fstest.MapFile{Data: []byte("apiVersion: v2\nname: helpers\nversion: 1.0.0\n")},
+		"values.yaml": &fstest.MapFile{Data: []byte("image: quay.io/open-cluster-management/agent:v1\n")},
+		"templates/deployment.yaml": &fstest.MapFile{Data: []byte(`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: agent
+  namespace: {{ .Release.Namespace }}
+spec:
+  template:
+    spec:
+      {{- include "addon-framework.nodePlacement" . | nindent 6 }}
+      containers:
+      - name: agent
+        image: {{ include "addon-framework.image" (dict "image" .Values.image "context" .) }}
+        env:
+        {{- include "addon-framework.proxyEnv" . | nindent 8 }}
+        {{- include "addon-framework.resources" (dict "container" "agent" "context" .) | nindent 8 }}
+`)},
+	}
+
+	cases := []struct {
+		name                 string
+		values               string
+		expectedImage        string
+		expectedNodeSelector map[string]string
+		expectedTolerations  []corev1.Toleration
+		expectedEnv          []corev1.EnvVar
+		expectedResources    corev1.ResourceRequirements
+	}{
+		{
+			name:          "no values",
+			expectedImage: "quay.io/open-cluster-management/agent:v1",
+		},
+		{
+			name: "node placement and registries",
+			values: `{"global":{"nodeSelector":{"kubernetes.io/os":"linux"},` +
+				`"registries":[{"source":"quay.io/open-cluster-management","mirror":"quay.io/ocm"}]},` +
+				`"tolerations":[{"key":"foo","operator":"Exists","effect":"NoExecute"}]}`,
+			expectedImage:        "quay.io/ocm/agent:v1",
+			expectedNodeSelector: map[string]string{"kubernetes.io/os": "linux"},
+			expectedTolerations: []corev1.Toleration{
+				{Key: "foo", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoExecute},
+			},
+		},
+		{
+			name:          "registry without source",
+			values:        `{"global":{"registries":[{"source":"","mirror":"mirror.io/"}]}}`,
+			expectedImage: "mirror.io/open-cluster-management/agent:v1",
+		},
+		{
+			name: "longest source wins",
+			values: `{"global":{"registries":[{"source":"quay.io/open-cluster-management/agent","mirror":"mirror.io/agent"},` +
+				`{"source":"quay.io","mirror":"mirror.io"}]}}`,
+			expectedImage: "mirror.io/agent:v1",
+		},
+		{
+			name: "registry with port and digest",
+			values: `{"image":"localhost:5000/ocm/agent@sha256:abc",` +
+				`"global":{"registries":[{"source":"localhost:5000/ocm","mirror":"mirror.io:8443/ocm"}]}}`,
+			expectedImage: "mirror.io:8443/ocm/agent@sha256:abc",
+		},
+		{
+			name:          "registry not matched",
+			values:        `{"global":{"registries":[{"source":"docker.io","mirror":"quay.io/ocm"}]}}`,
+			expectedImage: "quay.io/open-cluster-management/agent:v1",
+		},
+		{
+			name: "proxy and resource requirements",
+			values: `{"global":{"proxyConfig":{"httpProxy":"http://proxy:3128","noProxy":"10.0.0.1"},` +
+				`"resourceRequirements":{"agent":{"requests":{"cpu":"100m"}},"*":{"limits":{"cpu":"1"}}}}}`,
+			expectedImage: "quay.io/open-cluster-management/agent:v1",
+			expectedEnv: []corev1.EnvVar{
+				{Name: "HTTP_PROXY", Value: "http://proxy:3128"},
+				{Name: "NO_PROXY", Value: "10.0.0.1"},
+			},
+			expectedResources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")},
+			},
+		},
+		{
+			name:          "resource requirements of all containers",
+			values:        `{"global":{"resourceRequirements":{"*":{"limits":{"cpu":"1"}}}}}`,
+			expectedImage: "quay.io/open-cluster-management/agent:v1",
+			expectedResources: corev1.ResourceRequirements{
+				Limits: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			agentAddon, err := NewAgentAddonFactory("helpers", chartFS, ".").
+				WithGetValuesFuncs(GetValuesFromAddonAnnotation).
+				BuildHelmAgentAddon()
+			if err != nil {
+				t.Fatalf("expected no error, got err %v", err)
+			}
+
+			objects, err := agentAddon.Manifests(NewFakeManagedCluster("cluster1", "1.10.1"),
+				NewFakeManagedClusterAddon("helpers", "cluster1", "myNs", c.values))
+			if err != nil {
+				t.Fatalf("expected no error, got err %v", err)
+			}
+			if len(objects) != 1 {
+				t.Fatalf("expected 1 object, but got %v", len(objects))
+			}
+
+			podSpec := objects[0].(*appsv1.Deployment).Spec.Template.Spec
+			if podSpec.Containers[0].Image != c.expectedImage {
+				t.Errorf("expected image %s, but got %s", c.expectedImage, podSpec.Containers[0].Image)
+			}
+			if !reflect.DeepEqual(podSpec.NodeSelector, c.expectedNodeSelector) {
+				t.Errorf("expected nodeSelector %v, but got %v", c.expectedNodeSelector, podSpec.NodeSelector)
+			}
+			if !reflect.DeepEqual(podSpec.Tolerations, c.expectedTolerations) {
+				t.Errorf("expected tolerations %v, but got %v", c.expectedTolerations, podSpec.Tolerations)
+			}
+			if !reflect.DeepEqual(podSpec.Containers[0].Env, c.expectedEnv) {
+				t.Errorf("expected env %v, but got %v", c.expectedEnv, podSpec.Containers[0].Env)
+			}
+			if !equality.Semantic.DeepEqual(podSpec.Containers[0].Resources, c.expectedResources) {
+				t.Errorf("expected resources %v, but got %v", c.expectedResources, podSpec.Containers[0].Resources)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package addonfactory
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+)
+
+const (
+	helmHookAnnotationKey             = "helm.sh/hook"
+	helmHookWeightAnnotationKey       = "helm.sh/hook-weight"
+	helmHookDeletePolicyAnnotationKey = "helm.sh/hook-delete-policy"
+
+	helmHookPreInstall  = "pre-install"
+	helmHookPostInstall = "post-install"
+	helmHookPreUpgrade  = "pre-upgrade"
+	helmHookPostUpgrade = "post-upgrade"
+	helmHookPreDelete   = "pre-delete"
+
+	helmHookDeletePolicyBeforeHookCreation = "before-hook-creation"
+	helmHookDeletePolicyHookSucceeded      = "hook-succeeded"
+	helmHookDeletePolicyHookFailed         = "hook-failed"
+
+	// maxHookNameLength is the max length of the name of a hook Job, the Job name is limited to 63 characters
+	// since it is used as a label value of the pods.
+	maxHookNameLength = 63
+)
+
+type helmHookObject struct {
+	object runtime.Object
+	weight int
+}
+
+// translateHelmHooks translates the helm hook resources to the resources the addon framework can deploy.
+//   - pre-delete Job and Pod hooks are annotated as the addon pre-delete hooks, which are deployed by the
+//     pre-delete hook work when the addon is deleted, and the work is removed once the hooks are completed.
+//     The pre-delete hooks of other kinds are not supported by the framework and are dropped.
+//   - pre-install and pre-upgrade hooks are put before the other resources, and post-install and post-upgrade
+//     hooks are put after the other resources in the manifests of the manifestWork. They are applied together
+//     with the other resources, the work agent does not wait for a hook to complete before applying the next
+//     resource, so the order does not guarantee that a hook is completed before or after the other resources.
+//   - the other hooks, e.g. test, post-delete and rollback hooks, are not applicable and are dropped.
+//
+// The hooks with the same phase are ordered by the helm.sh/hook-weight ascending. A Job or Pod hook with the
+// before-hook-creation delete policy, which is the default policy of helm, is named with the hash of its
+// content, so it is deleted and created again once the content is changed. The hook-succeeded and hook-failed
+// delete policies are not supported for the install and upgrade hooks, the hooks are kept on the managed
+// cluster until they are removed from the manifestWork.
+func translateHelmHooks(objects []runtime.Object) ([]runtime.Object, error) {
+	var preHooks, postHooks, preDeleteHooks []helmHookObject
+	var resources []runtime.Object
+	for _, object := range objects {
+		accessor, err := meta.Accessor(object)
+		if err != nil {
+			return nil, err
+		}
+
+		hooks, ok := accessor.GetAnnotations()[helmHookAnnotationKey]
+		if !ok {
+			resources = append(resources, object)
+			continue
+		}
+
+		hook := helmHookObject{object: object, weight: helmHookWeight(accessor.GetAnnotations())}
+		phases := parseHelmAnnotationList(hooks)
+		switch {
+		case phases.Has(helmHookPreDelete) && !isJobOrPod(object):
+			klog.Warningf("Skipping pre-delete helm hook %s %s/%s, only Job and Pod are supported as the pre-delete hooks",
+				object.GetObjectKind().GroupVersionKind().Kind, accessor.GetNamespace(), accessor.GetName())
+			continue
+		case phases.Has(helmHookPreDelete):
+			annotations := accessor.GetAnnotations()
+			annotations[addonapiv1alpha1.AddonPreDeleteHookAnnotationKey] = ""
+			accessor.SetAnnotations(annotations)
+			preDeleteHooks = append(preDeleteHooks, hook)
+		case phases.Has(helmHookPreInstall) || phases.Has(helmHookPreUpgrade):
+			warnUnsupportedDeletePolicies(object, accessor.GetAnnotations())
+			preHooks = append(preHooks, hook)
+		case phases.Has(helmHookPostInstall) || phases.Has(helmHookPostUpgrade):
+			warnUnsupportedDeletePolicies(object, accessor.GetAnnotations())
+			postHooks = append(postHooks, hook)
+		default:
+			klog.V(4).Infof("Skipping helm hook %s %s/%s with unsupported phases %q",
+				object.GetObjectKind().GroupVersionKind().Kind, accessor.GetNamespace(), accessor.GetName(), hooks)
+			continue
+		}
+
+		if err := nameHookWithHash(object); err != nil {
+			return nil, err
+		}
+	}
+
+	var result []runtime.Object
+	result = append(result, sortHelmHooks(preHooks)...)
+	result = append(result, resources...)
+	result = append(result, sortHelmHooks(postHooks)...)
+	result = append(result, sortHelmHooks(preDeleteHooks)...)
+	return result, nil
+}
+
+// nameHookWithHash appends the hash of the content to the name of the Job or Pod hook whose delete policy is
+// before-hook-creation, since the spec of a Job or Pod cannot be updated.
+func nameHookWithHash(object runtime.Object) error {
+	if !isJobOrPod(object) {
+		return nil
+	}
+
+	accessor, err := meta.Accessor(object)
+	if err != nil {
+		return err
+	}
+	policies, ok := accessor.GetAnnotations()[helmHookDeletePolicyAnnotationKey]
+	if ok && !parseHelmAnnotationList(policies).Has(helmHookDeletePolicyBeforeHookCreation) {
+		return nil
+	}
+
+	data, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+	hash := fmt.Sprintf("%x", sha256.Sum256(data))[:8]
+
+	name := accessor.GetName()
+	if len(name)+len(hash)+1 > maxHookNameLength {
+		name = strings.TrimSuffix(name[:maxHookNameLength-len(hash)-1], "-")
+	}
+	accessor.SetName(fmt.Sprintf("%s-%s", name, hash))
+	return nil
+}
+
+// warnUnsupportedDeletePolicies logs the delete policies of the install and upgrade hook which are not supported,
+// the hook is deployed with the other resources and is not deleted once it is succeeded or failed.
+func warnUnsupportedDeletePolicies(object runtime.Object, annotations map[string]string) {
+	policies := parseHelmAnnotationList(annotations[helmHookDeletePolicyAnnotationKey])
+	if !policies.HasAny(helmHookDeletePolicyHookSucceeded, helmHookDeletePolicyHookFailed) {
+		return
+	}
+	accessor, _ := meta.Accessor(object)
+	klog.Warningf("The delete policies %q of helm hook %s %s/%s are not supported, the hook is kept on the cluster",
+		annotations[helmHookDeletePolicyAnnotationKey], object.GetObjectKind().GroupVersionKind().Kind,
+		accessor.GetNamespace(), accessor.GetName())
+}
+
+func isJobOrPod(object runtime.Object) bool {
+	kind := object.GetObjectKind().GroupVersionKind().Kind
+	return kind == "Job" || kind == "Pod"
+}
+
+func sortHelmHooks(hooks []helmHookObject) []runtime.Object {
+	sort.SliceStable(hooks, func(i, j int) bool {
+		return hooks[i].weight < hooks[j].weight
+	})
+	objects := make([]runtime.Object, 0, len(hooks))
+	for _, hook := range hooks {
+		objects = append(objects, hook.object)
+	}
+	return objects
+}
+
+func helmHookWeight(annotations map[string]string) int {
+	weight, err := strconv.Atoi(strings.TrimSpace(annotations[helmHookWeightAnnotationKey]))
+	if err != nil {
+		return 0
+	}
+	return weight
+}
+
+func parseHelmAnnotationList(value string) sets.String {
+	list := sets.NewString()
+	for _, item := range strings.Split(value, ",") {
+		list.Insert(strings.ToLower(strings.TrimSpace(item)))
+	}
+	return list
+}
@@ -0,0 +1,128 @@
+package addonfactory
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+)
+
+func newHookJob(name string, annotations map[string]string) *batchv1.Job {
+	return &batchv1.Job{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "batch/v1", Kind: "Job"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Annotations: annotations},
+	}
+}
+
+func newHookConfigMap(name string, annotations map[string]string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Annotations: annotations},
+	}
+}
+
+func TestTranslateHelmHooks(t *testing.T) {
+	cases := []struct {
+		name                   string
+		objects                []runtime.Object
+		expectedNames          []string
+		expectedPreDeleteHooks []string
+	}{
+		{
+			name: "no hooks",
+			objects: []runtime.Object{
+				newHookConfigMap("a", nil),
+				newHookConfigMap("b", nil),
+			},
+			expectedNames: []string{"a", "b"},
+		},
+		{
+			name: "install hooks ordered by weight",
+			objects: []runtime.Object{
+				newHookConfigMap("post", map[string]string{helmHookAnnotationKey: "post-install,post-upgrade"}),
+				newHookConfigMap("resource", nil),
+				newHookConfigMap("pre-2", map[string]string{
+					helmHookAnnotationKey: "pre-install", helmHookWeightAnnotationKey: "2"}),
+				newHookConfigMap("pre-1", map[string]string{
+					helmHookAnnotationKey: "pre-upgrade", helmHookWeightAnnotationKey: "-1"}),
+			},
+			expectedNames: []string{"pre-1", "pre-2", "resource", "post"},
+		},
+		{
+			name: "pre-delete hooks other than Job and Pod are dropped",
+			objects: []runtime.Object{
+				newHookJob("cleanup", map[string]string{
+					helmHookAnnotationKey: "pre-delete", helmHookDeletePolicyAnnotationKey: "hook-succeeded"}),
+				newHookConfigMap("cleanup-config", map[string]string{helmHookAnnotationKey: "pre-delete"}),
+				newHookConfigMap("resource", nil),
+			},
+			expectedNames:          []string{"resource", "cleanup"},
+			expectedPreDeleteHooks: []string{"cleanup"},
+		},
+		{
+			name: "unsupported hooks are dropped",
+			objects: []runtime.Object{
+				newHookConfigMap("test", map[string]string{helmHookAnnotationKey: "test"}),
+				newHookConfigMap("rollback", map[string]string{helmHookAnnotationKey: "pre-rollback"}),
+				newHookConfigMap("resource", nil),
+			},
+			expectedNames: []string{"resource"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			objects, err := translateHelmHooks(c.objects)
+			if err != nil {
+				t.Fatalf("expected no error, got err %v", err)
+			}
+
+			var names, preDeleteHooks []string
+			for _, o := range objects {
+				switch object := o.(type) {
+				case *corev1.ConfigMap:
+					names = append(names, object.Name)
+				case *batchv1.Job:
+					names = append(names, object.Name)
+					if _, ok := object.Annotations[addonapiv1alpha1.AddonPreDeleteHookAnnotationKey]; ok {
+						preDeleteHooks = append(preDeleteHooks, object.Name)
+					}
+				}
+			}
+			if !reflect.DeepEqual(names, c.expectedNames) {
+				t.Errorf("expected objects %v, but got %v", c.expectedNames, names)
+			}
+			if !reflect.DeepEqual(preDeleteHooks, c.expectedPreDeleteHooks) {
+				t.Errorf("expected pre-delete hooks %v, but got %v", c.expectedPreDeleteHooks, preDeleteHooks)
+			}
+		})
+	}
+}
+
+func TestTranslateHelmHooksBeforeHookCreation(t *testing.T) {
+	hookName := func(image string) string {
+		job := newHookJob("migrate", map[string]string{helmHookAnnotationKey: "pre-upgrade"})
+		job.Spec.Template.Spec.Containers = []corev1.Container{{Name: "migrate", Image: image}}
+		objects, err := translateHelmHooks([]runtime.Object{job})
+		if err != nil {
+			t.Fatalf("expected no error, got err %v", err)
+		}
+		return objects[0].(*batchv1.Job).Name
+	}
+
+	v1, v1Again, v2 := hookName("migrate:v1"), hookName("migrate:v1"), hookName("migrate:v2")
+	if !strings.HasPrefix(v1, "migrate-") {
+		t.Errorf("expected the hook named with the hash, but got %s", v1)
+	}
+	if v1 != v1Again {
+		t.Errorf("expected the same name for the same hook, but got %s and %s", v1, v1Again)
+	}
+	if v1 == v2 {
+		t.Errorf("expected a new name for the changed hook, but got %s", v2)
+	}
+}
@@ -1,10 +1,12 @@
 package addonfactory
 
 import (
-	"embed"
 	"encoding/json"
+	"fmt"
 	"io/fs"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/fatih/structs"
@@ -16,6 +18,9 @@ import (
 
 	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
 	clusterv1 "open-cluster-management.io/api/cluster/v1"
+
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/constants"
+	"open-cluster-management.io/addon-framework/pkg/agent"
 )
 
 // GetValuesFromAddonAnnotation get the values in the annotation of addon cr.
@@ -32,12 +37,98 @@ func GetValuesFromAddonAnnotation(
 
 	err := json.Unmarshal([]byte(annotations[AnnotationValuesName]), &values)
 	if err != nil {
-		return values, err
+		return values, &agent.InvalidValuesError{
+			Source: fmt.Sprintf("annotation %s", AnnotationValuesName),
+			Err:    err,
+		}
 	}
 
+	recordValuesSource(addon, func(field string) string {
+		return fmt.Sprintf("annotation %s", AnnotationValuesName)
+	})
 	return values, nil
 }
 
+// GetAPIVersionsFromClusterClaim returns an APIVersionsFunc getting the api versions from the cluster claim
+// with the claimName reported by the managed cluster. The value of the claim is a comma separated list of the
+// api versions, e.g. "route.openshift.io/v1,policy/v1". The api versions are empty if there is no such claim.
+func GetAPIVersionsFromClusterClaim(claimName string) APIVersionsFunc {
+	return func(cluster *clusterv1.ManagedCluster, addon *addonapiv1alpha1.ManagedClusterAddOn) ([]string, error) {
+		var apiVersions []string
+		for _, claim := range cluster.Status.ClusterClaims {
+			if claim.Name != claimName {
+				continue
+			}
+			for _, apiVersion := range strings.Split(claim.Value, ",") {
+				apiVersion = strings.TrimSpace(apiVersion)
+				if len(apiVersion) > 0 {
+					apiVersions = append(apiVersions, apiVersion)
+				}
+			}
+		}
+		return apiVersions, nil
+	}
+}
+
+// UnionAPIVersionsFuncs returns an APIVersionsFunc combining the api versions from all the given funcs.
+func UnionAPIVersionsFuncs(funcs ...APIVersionsFunc) APIVersionsFunc {
+	return func(cluster *clusterv1.ManagedCluster, addon *addonapiv1alpha1.ManagedClusterAddOn) ([]string, error) {
+		var apiVersions []string
+		for _, f := range funcs {
+			versions, err := f(cluster, addon)
+			if err != nil {
+				return nil, err
+			}
+			apiVersions = append(apiVersions, versions...)
+		}
+		return apiVersions, nil
+	}
+}
+
+// KustomizeComponentsFromClusterLabel returns a KustomizeComponentsFunc selecting the kustomize component in
+// the dir with the value of the label labelKey of the managed cluster as the name, e.g. the component
+// "overlays/openshift" is selected by the label "vendor=openshift" with labelKey "vendor" and dir "overlays".
+// No component is selected if there is no such label.
+func KustomizeComponentsFromClusterLabel(labelKey, dir string) KustomizeComponentsFunc {
+	return func(cluster *clusterv1.ManagedCluster, addon *addonapiv1alpha1.ManagedClusterAddOn) ([]string, error) {
+		value, ok := cluster.GetLabels()[labelKey]
+		if !ok || len(value) == 0 {
+			return nil, nil
+		}
+		return []string{path.Join(dir, value)}, nil
+	}
+}
+
+// KustomizeComponentsFromClusterClaim returns a KustomizeComponentsFunc selecting the kustomize component in
+// the dir with the value of the cluster claim claimName of the managed cluster as the name. No component is
+// selected if there is no such claim.
+func KustomizeComponentsFromClusterClaim(claimName, dir string) KustomizeComponentsFunc {
+	return func(cluster *clusterv1.ManagedCluster, addon *addonapiv1alpha1.ManagedClusterAddOn) ([]string, error) {
+		for _, claim := range cluster.Status.ClusterClaims {
+			if claim.Name == claimName && len(claim.Value) > 0 {
+				return []string{path.Join(dir, claim.Value)}, nil
+			}
+		}
+		return nil, nil
+	}
+}
+
+// UnionKustomizeComponentsFuncs returns a KustomizeComponentsFunc combining the components from all the given
+// funcs in order.
+func UnionKustomizeComponentsFuncs(funcs ...KustomizeComponentsFunc) KustomizeComponentsFunc {
+	return func(cluster *clusterv1.ManagedCluster, addon *addonapiv1alpha1.ManagedClusterAddOn) ([]string, error) {
+		var components []string
+		for _, f := range funcs {
+			c, err := f(cluster, addon)
+			if err != nil {
+				return nil, err
+			}
+			components = append(components, c...)
+		}
+		return components, nil
+	}
+}
+
 // MergeValues merges the 2 given Values to a Values.
 // the values of b will override that in a for the same fields.
 func MergeValues(a, b Values) Values {
@@ -79,7 +170,7 @@ func JsonStructToValues(a interface{}) (Values, error) {
 	return v, nil
 }
 
-func loadChart(chartFS embed.FS, chartPrefix string) (*chart.Chart, error) {
+func loadChart(chartFS fs.FS, chartPrefix string) (*chart.Chart, error) {
 	files, err := getFiles(chartFS)
 	if err != nil {
 		return nil, err
@@ -92,11 +183,15 @@ func loadChart(chartFS embed.FS, chartPrefix string) (*chart.Chart, error) {
 			klog.Errorf("failed to read file %v. err:%v", fileName, err)
 			return nil, err
 		}
-		if !strings.HasPrefix(fileName, chartPrefix) {
-			continue
+		name := fileName
+		if !isRootDir(chartPrefix) {
+			if !strings.HasPrefix(fileName, chartPrefix) {
+				continue
+			}
+			name = stripPrefix(chartPrefix, fileName)
 		}
 		bf := &loader.BufferedFile{
-			Name: stripPrefix(chartPrefix, fileName),
+			Name: name,
 			Data: b,
 		}
 		bfs = append(bfs, bf)
@@ -110,12 +205,12 @@ func loadChart(chartFS embed.FS, chartPrefix string) (*chart.Chart, error) {
 	return userChart, nil
 }
 
-func getTemplateFiles(templateFS embed.FS, dir string) ([]string, error) {
+func getTemplateFiles(templateFS fs.FS, dir string) ([]string, error) {
 	files, err := getFiles(templateFS)
 	if err != nil {
 		return nil, err
 	}
-	if dir == "." || len(dir) == 0 {
+	if isRootDir(dir) {
 		return files, nil
 	}
 
@@ -129,19 +224,71 @@ func getTemplateFiles(templateFS embed.FS, dir string) ([]string, error) {
 	return templateFiles, nil
 }
 
-func getFiles(manifestFS embed.FS) ([]string, error) {
+// getFiles returns the files in the fs. The entries prefixed with "..", which are the timestamped dirs and the
+// symlinks of the files mounted from a ConfigMap or Secret, are skipped, so the mounted files are only read by
+// their names. The symlinks to dirs, e.g. templates -> ..data/templates of a mounted ConfigMap, are followed.
+func getFiles(manifestFS fs.FS) ([]string, error) {
+	return walkFiles(manifestFS, ".")
+}
+
+func walkFiles(fsys fs.FS, dir string) ([]string, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
 	var res []string
-	err := fs.WalkDir(manifestFS, ".", func(path string, d fs.DirEntry, err error) error {
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), "..") {
+			continue
+		}
+		name := path.Join(dir, entry.Name())
+
+		isDir := entry.IsDir()
+		if entry.Type()&fs.ModeSymlink != 0 {
+			// fs.WalkDir does not follow the symlinks, stat the target to know whether it is a dir.
+			info, err := fs.Stat(fsys, name)
+			if err != nil {
+				return nil, err
+			}
+			isDir = info.IsDir()
+		}
+		if !isDir {
+			res = append(res, name)
+			continue
+		}
+
+		files, err := walkFiles(fsys, name)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		if d.IsDir() {
-			return nil
+		res = append(res, files...)
+	}
+	return res, nil
+}
+
+// loadFiles reads all the files in the fs.
+func loadFiles(fsys fs.FS) (map[string][]byte, error) {
+	names, err := getFiles(fsys)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	files := make(map[string][]byte, len(names))
+	for _, name := range names {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
 		}
-		res = append(res, path)
-		return nil
-	})
-	return res, err
+		files[name] = data
+	}
+	return files, nil
+}
+
+// isRootDir returns true if the dir refers to the root of the fs.
+func isRootDir(dir string) bool {
+	return dir == "." || len(dir) == 0
 }
 
 func stripPrefix(chartPrefix, path string) string {
@@ -172,3 +319,35 @@ func mergeInterfaceMaps(a, b map[string]interface{}) map[string]interface{} {
 
 	return out
 }
+
+// globalBuiltinValues includes the built-in values of the managed cluster and the hub cluster, which are the
+// global values of the helm and kustomize agentAddon. The template agentAddon has the same values in uppercase,
+// e.g. the global value clusterLabels is ClusterLabels of the template agentAddon.
+type globalBuiltinValues struct {
+	ClusterLabels      map[string]string `json:"clusterLabels,omitempty"`
+	ClusterClaims      map[string]string `json:"clusterClaims,omitempty"`
+	HubAPIServer       string            `json:"hubAPIServer,omitempty"`
+	HubCABundle        string            `json:"hubCABundle,omitempty"`
+	HostingClusterName string            `json:"hostingClusterName,omitempty"`
+}
+
+// newGlobalBuiltinValues returns the global built-in values, the cluster claims are a map of the claim name to
+// the claim value, and the hosting cluster name is only set in the hosted mode.
+func newGlobalBuiltinValues(cluster *clusterv1.ManagedCluster, addon *addonapiv1alpha1.ManagedClusterAddOn,
+	hubAPIServer string, hubCABundle []byte) globalBuiltinValues {
+	values := globalBuiltinValues{
+		ClusterLabels: cluster.GetLabels(),
+		HubAPIServer:  hubAPIServer,
+		HubCABundle:   string(hubCABundle),
+	}
+
+	if len(cluster.Status.ClusterClaims) > 0 {
+		values.ClusterClaims = map[string]string{}
+		for _, claim := range cluster.Status.ClusterClaims {
+			values.ClusterClaims[claim.Name] = claim.Value
+		}
+	}
+
+	_, values.HostingClusterName = constants.GetHostedModeInfo(addon.GetAnnotations())
+	return values
+}
@@ -0,0 +1,194 @@
+package addonfactory
+
+import (
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+
+	"open-cluster-management.io/addon-framework/pkg/agent"
+)
+
+const (
+	defaultImageDomain    = "docker.io"
+	officialRepoNamespace = "library"
+)
+
+// imageReference is a parsed image reference in the form of [domain/]path[:tag][@digest].
+type imageReference struct {
+	// domain is the registry of the image, it is empty if the image does not have a registry, e.g. "nginx:1.25".
+	domain string
+	path   string
+	tag    string
+	digest string
+}
+
+// parseImageReference parses the image reference. The first component of the name is the domain only if it
+// contains a "." or a ":" (a port), or is "localhost", which is the same as the docker reference.
+func parseImageReference(image string) imageReference {
+	ref := imageReference{}
+
+	name := image
+	if i := strings.Index(name, "@"); i >= 0 {
+		ref.digest = name[i+1:]
+		name = name[:i]
+	}
+	// the tag is after the last ":" which is not a part of the domain port.
+	if i := strings.LastIndex(name, ":"); i >= 0 && i > strings.LastIndex(name, "/") {
+		ref.tag = name[i+1:]
+		name = name[:i]
+	}
+
+	if i := strings.Index(name, "/"); i >= 0 {
+		first := name[:i]
+		if strings.ContainsAny(first, ".:") || first == "localhost" {
+			ref.domain = first
+			name = name[i+1:]
+		}
+	}
+	ref.path = name
+	return ref
+}
+
+// name returns the name of the image as it is written, without the tag and digest.
+func (r imageReference) name() string {
+	if len(r.domain) == 0 {
+		return r.path
+	}
+	return r.domain + "/" + r.path
+}
+
+// fullName returns the name of the image with the default domain, e.g. "nginx" is "docker.io/library/nginx".
+func (r imageReference) fullName() string {
+	if len(r.domain) > 0 {
+		return r.name()
+	}
+	if !strings.Contains(r.path, "/") {
+		return defaultImageDomain + "/" + officialRepoNamespace + "/" + r.path
+	}
+	return defaultImageDomain + "/" + r.path
+}
+
+// withName returns the image with the name replaced, the tag and digest are kept.
+func (r imageReference) withName(name string) string {
+	image := name
+	if len(r.tag) > 0 {
+		image = image + ":" + r.tag
+	}
+	if len(r.digest) > 0 {
+		image = image + "@" + r.digest
+	}
+	return image
+}
+
+// mirrorImage returns the image mirrored by the registries. The source of a
+// registry matches the image if the source is the name of the image or a prefix of the name ending at a "/",
+// either as the name is written or with the default domain. If more than one source matches, the longest source
+// wins, and the later registry wins for the sources of the same length. A registry without source matches all the
+// images, it replaces the domain of the image and is only used if no source matches. The registries without
+// mirror are ignored.
+func mirrorImage(registries []addonapiv1alpha1.ImageMirror, image string) string {
+	ref := parseImageReference(image)
+
+	matched := -1
+	var mirrored string
+	for _, registry := range registries {
+		source := strings.TrimSuffix(registry.Source, "/")
+		mirror := strings.TrimSuffix(registry.Mirror, "/")
+		if len(mirror) == 0 {
+			continue
+		}
+
+		if len(source) == 0 {
+			if matched <= 0 {
+				mirrored = mirror + "/" + ref.path
+				matched = 0
+			}
+			continue
+		}
+
+		if len(source) < matched {
+			continue
+		}
+		for _, name := range []string{ref.name(), ref.fullName()} {
+			if name == source || strings.HasPrefix(name, source+"/") {
+				mirrored = mirror + strings.TrimPrefix(name, source)
+				matched = len(source)
+				break
+			}
+		}
+	}
+
+	if matched < 0 {
+		return image
+	}
+	return ref.withName(mirrored)
+}
+
+// podSpecFields are the fields of the pod spec in the workloads, the pod spec of a Pod is the spec.
+var podSpecFields = map[string][]string{
+	"Pod":                   {"spec"},
+	"Deployment":            {"spec", "template", "spec"},
+	"DaemonSet":             {"spec", "template", "spec"},
+	"StatefulSet":           {"spec", "template", "spec"},
+	"ReplicaSet":            {"spec", "template", "spec"},
+	"ReplicationController": {"spec", "template", "spec"},
+	"Job":                   {"spec", "template", "spec"},
+	"CronJob":               {"spec", "jobTemplate", "spec", "template", "spec"},
+}
+
+// ListImages returns the sorted images of the init containers, containers and ephemeral containers of all the
+// workloads in the objects, each image is listed once. It can be used to find the images to mirror for the
+// air-gapped environments.
+func ListImages(objects []runtime.Object) ([]string, error) {
+	images := sets.NewString()
+	for _, object := range objects {
+		fields, ok := podSpecFields[object.GetObjectKind().GroupVersionKind().Kind]
+		if !ok {
+			continue
+		}
+
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(object)
+		if err != nil {
+			return nil, err
+		}
+		podSpec, found, err := unstructured.NestedMap(content, fields...)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+
+		for _, containersField := range []string{"initContainers", "containers", "ephemeralContainers"} {
+			containers, _, err := unstructured.NestedSlice(podSpec, containersField)
+			if err != nil {
+				return nil, err
+			}
+			for _, container := range containers {
+				containerMap, ok := container.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				if image, ok := containerMap["image"].(string); ok && len(image) > 0 {
+					images.Insert(image)
+				}
+			}
+		}
+	}
+	return images.List(), nil
+}
+
+// ListAgentAddonImages renders the manifests of the agentAddon for the cluster and the addon, and returns the
+// images in the manifests, see ListImages.
+func ListAgentAddonImages(agentAddon agent.AgentAddon, cluster *clusterv1.ManagedCluster,
+	addon *addonapiv1alpha1.ManagedClusterAddOn) ([]string, error) {
+	objects, err := agentAddon.Manifests(cluster, addon)
+	if err != nil {
+		return nil, err
+	}
+	return ListImages(objects)
+}
@@ -0,0 +1,175 @@
+package addonfactory
+
+import (
+	"reflect"
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	clusterv1apha1 "open-cluster-management.io/api/cluster/v1alpha1"
+)
+
+func TestOverrideImage(t *testing.T) {
+	cases := []struct {
+		name          string
+		registries    []addonapiv1alpha1.ImageMirror
+		image         string
+		expectedImage string
+	}{
+		{
+			name:          "no registries",
+			image:         "quay.io/ocm/agent:v1",
+			expectedImage: "quay.io/ocm/agent:v1",
+		},
+		{
+			name:          "source matched",
+			registries:    []addonapiv1alpha1.ImageMirror{{Source: "quay.io/ocm", Mirror: "mirror.io/ocm"}},
+			image:         "quay.io/ocm/agent:v1",
+			expectedImage: "mirror.io/ocm/agent:v1",
+		},
+		{
+			name:          "source matched only at a path component",
+			registries:    []addonapiv1alpha1.ImageMirror{{Source: "quay.io/ocm", Mirror: "mirror.io/ocm"}},
+			image:         "quay.io/ocm-dev/agent:v1",
+			expectedImage: "quay.io/ocm-dev/agent:v1",
+		},
+		{
+			name:          "source is the image name",
+			registries:    []addonapiv1alpha1.ImageMirror{{Source: "quay.io/ocm/agent", Mirror: "mirror.io/agent"}},
+			image:         "quay.io/ocm/agent:v1",
+			expectedImage: "mirror.io/agent:v1",
+		},
+		{
+			name:          "digest kept",
+			registries:    []addonapiv1alpha1.ImageMirror{{Source: "quay.io/ocm", Mirror: "mirror.io/ocm"}},
+			image:         "quay.io/ocm/agent@sha256:0123abcd",
+			expectedImage: "mirror.io/ocm/agent@sha256:0123abcd",
+		},
+		{
+			name:          "tag and digest kept",
+			registries:    []addonapiv1alpha1.ImageMirror{{Source: "quay.io/ocm", Mirror: "mirror.io/ocm"}},
+			image:         "quay.io/ocm/agent:v1@sha256:0123abcd",
+			expectedImage: "mirror.io/ocm/agent:v1@sha256:0123abcd",
+		},
+		{
+			name:          "registries with ports",
+			registries:    []addonapiv1alpha1.ImageMirror{{Source: "registry:5000/ocm", Mirror: "mirror.io:8443/ocm"}},
+			image:         "registry:5000/ocm/agent",
+			expectedImage: "mirror.io:8443/ocm/agent",
+		},
+		{
+			name: "longest source wins",
+			registries: []addonapiv1alpha1.ImageMirror{
+				{Source: "quay.io/ocm/agent", Mirror: "mirror.io/agent"},
+				{Source: "quay.io", Mirror: "mirror.io"},
+			},
+			image:         "quay.io/ocm/agent:v1",
+			expectedImage: "mirror.io/agent:v1",
+		},
+		{
+			name: "later source of the same length wins",
+			registries: []addonapiv1alpha1.ImageMirror{
+				{Source: "quay.io/ocm", Mirror: "mirror1.io/ocm"},
+				{Source: "quay.io/ocm/", Mirror: "mirror2.io/ocm"},
+			},
+			image:         "quay.io/ocm/agent:v1",
+			expectedImage: "mirror2.io/ocm/agent:v1",
+		},
+		{
+			name:          "source-less mirror keeps the repository path",
+			registries:    []addonapiv1alpha1.ImageMirror{{Mirror: "mirror.io:8443"}},
+			image:         "registry:5000/ocm/agent:v1",
+			expectedImage: "mirror.io:8443/ocm/agent:v1",
+		},
+		{
+			name: "source-less mirror used if no source matched",
+			registries: []addonapiv1alpha1.ImageMirror{
+				{Source: "quay.io/ocm", Mirror: "mirror.io/ocm"},
+				{Mirror: "mirror.io/all"},
+			},
+			image:         "quay.io/ocm/agent:v1",
+			expectedImage: "mirror.io/ocm/agent:v1",
+		},
+		{
+			name:          "source with the default domain",
+			registries:    []addonapiv1alpha1.ImageMirror{{Source: "docker.io/library", Mirror: "mirror.io/library"}},
+			image:         "nginx:1.25",
+			expectedImage: "mirror.io/library/nginx:1.25",
+		},
+		{
+			name:          "registry without mirror ignored",
+			registries:    []addonapiv1alpha1.ImageMirror{{Source: "quay.io/ocm"}},
+			image:         "quay.io/ocm/agent:v1",
+			expectedImage: "quay.io/ocm/agent:v1",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			image := OverrideImage(c.registries, c.image)
+			if image != c.expectedImage {
+				t.Errorf("expected image %s, but got %s", c.expectedImage, image)
+			}
+		})
+	}
+}
+
+func TestListImages(t *testing.T) {
+	cronJob := &batchv1.CronJob{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "batch/v1", Kind: "CronJob"},
+		ObjectMeta: metav1.ObjectMeta{Name: "cleanup", Namespace: "default"},
+		Spec: batchv1.CronJobSpec{
+			JobTemplate: batchv1.JobTemplateSpec{
+				Spec: batchv1.JobSpec{
+					Template: corev1.PodTemplateSpec{
+						Spec: corev1.PodSpec{
+							InitContainers: []corev1.Container{{Name: "init", Image: "quay.io/ocm/init:v1"}},
+							Containers:     []corev1.Container{{Name: "cleanup", Image: "quay.io/ocm/agent:v1"}},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	objects := []runtime.Object{
+		newTestDeployment("agent", "quay.io/ocm/agent:v1"),
+		newTestUnstructuredDaemonSet("proxy", "quay.io/ocm/proxy@sha256:0123abcd"),
+		cronJob,
+		newHookConfigMap("config", nil),
+	}
+
+	images, err := ListImages(objects)
+	if err != nil {
+		t.Fatalf("expected no error, got err %v", err)
+	}
+	expectedImages := []string{"quay.io/ocm/agent:v1", "quay.io/ocm/init:v1", "quay.io/ocm/proxy@sha256:0123abcd"}
+	if !reflect.DeepEqual(images, expectedImages) {
+		t.Errorf("expected images %v, but got %v", expectedImages, images)
+	}
+}
+
+func TestListAgentAddonImages(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clusterv1apha1.Install(scheme)
+
+	agentAddon, err := NewAgentAddonFactory("helloworld", templateFS, "testmanifests/template").
+		WithScheme(scheme).
+		WithGetValuesFuncs(GetValuesFromAddonAnnotation).
+		BuildTemplateAgentAddon()
+	if err != nil {
+		t.Fatalf("expected no error, got err %v", err)
+	}
+
+	images, err := ListAgentAddonImages(agentAddon, NewFakeManagedCluster("cluster1", "1.10.1"),
+		NewFakeManagedClusterAddon("helloworld", "cluster1", "", `{"Image":"quay.io/helloworld:2.4"}`))
+	if err != nil {
+		t.Fatalf("expected no error, got err %v", err)
+	}
+	if !reflect.DeepEqual(images, []string{"quay.io/helloworld:2.4"}) {
+		t.Errorf("expected images [quay.io/helloworld:2.4], but got %v", images)
+	}
+}
@@ -0,0 +1,291 @@
+package addonfactory
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/klog/v2"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+	"sigs.k8s.io/yaml"
+
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/constants"
+	"open-cluster-management.io/addon-framework/pkg/agent"
+)
+
+const (
+	// KustomizeValuesConfigMapName is the name of the ConfigMap generated with the values for the kustomize
+	// agentAddon. It can be used as the source of the replacements in the kustomize components, and is not
+	// deployed to the managed cluster.
+	KustomizeValuesConfigMapName = "addon-values"
+
+	kustomizeValuesAnnotationKey = "addon.open-cluster-management.io/kustomize-values"
+
+	// kustomizeRootDir is the dir of the generated kustomization to build in the in-memory filesystem, and
+	// kustomizeSourceDir is the dir relative to the root holding the files of the agentAddon.
+	kustomizeRootDir   = "/"
+	kustomizeSourceDir = "addon"
+)
+
+// KustomizeComponentsFunc returns the kustomize components applied for the cluster, each component is the
+// path of a dir based on the fs path of the factory.
+type KustomizeComponentsFunc func(cluster *clusterv1.ManagedCluster,
+	addon *addonapiv1alpha1.ManagedClusterAddOn) ([]string, error)
+
+// kustomizeBuiltinValues includes the built-in values for kustomize agentAddon.
+// the built-in values can not be overrided by getValuesFuncs
+type kustomizeBuiltinValues struct {
+	ClusterName           string              `json:"clusterName"`
+	AddonInstallNamespace string              `json:"addonInstallNamespace"`
+	InstallMode           string              `json:"installMode"`
+	Global                globalBuiltinValues `json:"global"`
+}
+
+// kustomizeDefaultValues includes the default values for kustomize agentAddon.
+// the default values can be overrided by getValuesFuncs
+type kustomizeDefaultValues struct {
+	HubKubeConfigSecret     string `json:"hubKubeConfigSecret,omitempty"`
+	ManagedKubeConfigSecret string `json:"managedKubeConfigSecret,omitempty"`
+}
+
+type KustomizeAgentAddon struct {
+	decoder            runtime.Decoder
+	files              map[string][]byte
+	dir                string
+	getValuesFuncs     []GetValuesFunc
+	typedValuesCheck   typedValuesCheck
+	componentsFunc     KustomizeComponentsFunc
+	agentAddonOptions  agent.AgentAddonOptions
+	postRenderMutators []PostRenderMutator
+	trimCRDDescription bool
+	hubAPIServer       string
+	hubCABundle        []byte
+}
+
+func newKustomizeAgentAddon(factory *AgentAddonFactory, files map[string][]byte) *KustomizeAgentAddon {
+	return &KustomizeAgentAddon{
+		decoder:            serializer.NewCodecFactory(factory.scheme).UniversalDeserializer(),
+		files:              files,
+		dir:                factory.dir,
+		getValuesFuncs:     factory.allGetValuesFuncs(),
+		typedValuesCheck:   factory.typedValuesCheck,
+		componentsFunc:     factory.kustomizeComponentsFunc,
+		agentAddonOptions:  factory.agentAddonOptions,
+		trimCRDDescription: factory.trimCRDDescription,
+		postRenderMutators: factory.postRenderMutators,
+		hubAPIServer:       factory.hubAPIServer,
+		hubCABundle:        factory.hubCABundle,
+	}
+}
+
+// Manifests builds a kustomization in memory, which includes the kustomization in the dir of the factory as the
+// resources, the components selected for the cluster and the ConfigMap of the values, and sets the namespace of
+// the resources to the install namespace of the addon.
+func (a *KustomizeAgentAddon) Manifests(
+	cluster *clusterv1.ManagedCluster,
+	addon *addonapiv1alpha1.ManagedClusterAddOn) ([]runtime.Object, error) {
+	values, err := a.getValues(cluster, addon)
+	if err != nil {
+		return nil, err
+	}
+
+	var components []string
+	if a.componentsFunc != nil {
+		components, err = a.componentsFunc(cluster, addon)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fSys, err := a.buildFileSystem(values, components)
+	if err != nil {
+		return nil, err
+	}
+
+	resMap, err := krusty.MakeKustomizer(krusty.MakeDefaultOptions()).Run(fSys, kustomizeRootDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build kustomization for addon %s/%s: %v", addon.Namespace, addon.Name, err)
+	}
+
+	var objects []runtime.Object
+	for _, res := range resMap.Resources() {
+		if _, ok := res.GetAnnotations()[kustomizeValuesAnnotationKey]; ok {
+			continue
+		}
+		raw, err := res.AsYAML()
+		if err != nil {
+			return nil, err
+		}
+		klog.V(4).Infof("rendered resource: %s", string(raw))
+		object, _, err := a.decoder.Decode(raw, nil, nil)
+		if err != nil {
+			if runtime.IsMissingKind(err) {
+				klog.V(4).Infof("Skipping resource %v, reason: %v", res.CurId(), err)
+				continue
+			}
+			return nil, err
+		}
+		objects = append(objects, object)
+	}
+
+	objects, err = applyPostRenderMutators(a.postRenderMutators, cluster, addon, objects)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.trimCRDDescription {
+		objects = trimCRDDescription(objects)
+	}
+	return objects, nil
+}
+
+func (a *KustomizeAgentAddon) GetAgentAddonOptions() agent.AgentAddonOptions {
+	return a.agentAddonOptions
+}
+
+func (a *KustomizeAgentAddon) buildFileSystem(values Values, components []string) (filesys.FileSystem, error) {
+	fSys := filesys.MakeFsInMemory()
+	for name, data := range a.files {
+		if err := fSys.WriteFile(path.Join(kustomizeRootDir, kustomizeSourceDir, name), data); err != nil {
+			return nil, err
+		}
+	}
+
+	valuesConfigMap, err := yaml.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata": map[string]interface{}{
+			"name":        KustomizeValuesConfigMapName,
+			"annotations": map[string]string{kustomizeValuesAnnotationKey: ""},
+		},
+		"data": flattenValues(values),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := fSys.WriteFile(path.Join(kustomizeRootDir, "values.yaml"), valuesConfigMap); err != nil {
+		return nil, err
+	}
+
+	kustomization := map[string]interface{}{
+		"apiVersion": "kustomize.config.k8s.io/v1beta1",
+		"kind":       "Kustomization",
+		"namespace":  values["addonInstallNamespace"],
+		"resources":  []string{path.Join(kustomizeSourceDir, a.dir), "values.yaml"},
+	}
+
+	var componentDirs []string
+	for _, component := range components {
+		componentDir := path.Join(kustomizeSourceDir, component)
+		// the components selected by the cluster labels or claims may not exist, ignore them.
+		if !fSys.IsDir(path.Join(kustomizeRootDir, componentDir)) {
+			klog.V(4).Infof("Skipping kustomize component %s, it does not exist", component)
+			continue
+		}
+		componentDirs = append(componentDirs, componentDir)
+	}
+	if len(componentDirs) > 0 {
+		kustomization["components"] = componentDirs
+	}
+
+	data, err := yaml.Marshal(kustomization)
+	if err != nil {
+		return nil, err
+	}
+	if err := fSys.WriteFile(path.Join(kustomizeRootDir, "kustomization.yaml"), data); err != nil {
+		return nil, err
+	}
+	return fSys, nil
+}
+
+func (a *KustomizeAgentAddon) getValues(
+	cluster *clusterv1.ManagedCluster,
+	addon *addonapiv1alpha1.ManagedClusterAddOn) (Values, error) {
+	overrideValues := map[string]interface{}{}
+
+	defaultValues := kustomizeDefaultValues{}
+	// TODO: hubKubeConfigSecret depends on the signer configuration in registration, and the registration is an array.
+	if a.agentAddonOptions.Registration != nil {
+		defaultValues.HubKubeConfigSecret = fmt.Sprintf("%s-hub-kubeconfig", a.agentAddonOptions.AddonName)
+	}
+	defaultValues.ManagedKubeConfigSecret = fmt.Sprintf("%s-managed-kubeconfig", addon.Name)
+	values, err := JsonStructToValues(defaultValues)
+	if err != nil {
+		return nil, err
+	}
+	overrideValues = MergeValues(overrideValues, values)
+
+	customizedValues := newCustomizedValues(addon)
+	defer customizedValues.close()
+	for i := 0; i < len(a.getValuesFuncs); i++ {
+		if a.getValuesFuncs[i] != nil {
+			userValues, err := customizedValues.getValues(a.getValuesFuncs[i], cluster)
+			if err != nil {
+				return overrideValues, err
+			}
+			overrideValues = MergeValues(overrideValues, userValues)
+		}
+	}
+
+	if err := validateTypedValues(a.typedValuesCheck, customizedValues); err != nil {
+		return overrideValues, err
+	}
+
+	installNamespace := addon.Spec.InstallNamespace
+	if len(installNamespace) == 0 {
+		installNamespace = AddonDefaultInstallNamespace
+	}
+	builtinValues := kustomizeBuiltinValues{
+		ClusterName:           cluster.GetName(),
+		AddonInstallNamespace: installNamespace,
+		Global:                newGlobalBuiltinValues(cluster, addon, a.hubAPIServer, a.hubCABundle),
+	}
+	builtinValues.InstallMode, _ = constants.GetHostedModeInfo(addon.GetAnnotations())
+	values, err = JsonStructToValues(builtinValues)
+	if err != nil {
+		return nil, err
+	}
+	return MergeValues(overrideValues, values), nil
+}
+
+// flattenValues flattens the nested values to the data of a ConfigMap, the keys of nested values are joined
+// with ".", e.g. {"global":{"image":"test"}} is flattened to {"global.image":"test"}. The values which are not
+// string are encoded in json.
+func flattenValues(values Values) map[string]string {
+	data := map[string]string{}
+	var flatten func(prefix string, value interface{})
+	flatten = func(prefix string, value interface{}) {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			for key, value := range v {
+				flatten(joinValuesKey(prefix, key), value)
+			}
+		case Values:
+			flatten(prefix, map[string]interface{}(v))
+		case string:
+			data[prefix] = v
+		case nil:
+			data[prefix] = ""
+		default:
+			raw, err := json.Marshal(v)
+			if err != nil {
+				raw = []byte(fmt.Sprintf("%v", v))
+			}
+			data[prefix] = string(raw)
+		}
+	}
+	flatten("", values)
+	return data
+}
+
+func joinValuesKey(prefix, key string) string {
+	if len(prefix) == 0 {
+		return key
+	}
+	return prefix + "." + key
+}
@@ -0,0 +1,151 @@
+package addonfactory
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/addontesting"
+)
+
+func TestKustomizeAgentAddon(t *testing.T) {
+	cases := []struct {
+		name                  string
+		cluster               *clusterv1.ManagedCluster
+		installNamespace      string
+		getValuesFuncs        []GetValuesFunc
+		componentsFunc        KustomizeComponentsFunc
+		expectedNamespace     string
+		expectedImage         string
+		expectedArgs          []string
+		expectedSecurityCtx   bool
+		expectedObjectsNumber int
+	}{
+		{
+			name:                  "base only",
+			cluster:               addontesting.NewManagedCluster("cluster1"),
+			expectedNamespace:     AddonDefaultInstallNamespace,
+			expectedImage:         "quay.io/open-cluster-management/helloworld:latest",
+			expectedArgs:          []string{"agent", "--cluster-name=CLUSTER_NAME"},
+			expectedObjectsNumber: 2,
+		},
+		{
+			name:             "components selected by cluster label and values replaced",
+			cluster:          newClusterWithLabels("cluster1", map[string]string{"vendor": "openshift"}),
+			installNamespace: "test",
+			getValuesFuncs: []GetValuesFunc{
+				func(cluster *clusterv1.ManagedCluster, addon *addonapiv1alpha1.ManagedClusterAddOn) (Values, error) {
+					return Values{"global": map[string]interface{}{"image": "quay.io/test/helloworld:v1"}}, nil
+				},
+			},
+			componentsFunc: UnionKustomizeComponentsFuncs(
+				KustomizeComponentsFromClusterLabel("vendor", "components"),
+				func(cluster *clusterv1.ManagedCluster, addon *addonapiv1alpha1.ManagedClusterAddOn) ([]string, error) {
+					return []string{"components/image"}, nil
+				},
+			),
+			expectedNamespace:     "test",
+			expectedImage:         "quay.io/test/helloworld:v1",
+			expectedArgs:          []string{"agent", "--cluster-name=cluster1"},
+			expectedSecurityCtx:   true,
+			expectedObjectsNumber: 2,
+		},
+		{
+			name:                  "missing components are skipped",
+			cluster:               newClusterWithLabels("cluster1", map[string]string{"vendor": "unknown"}),
+			componentsFunc:        KustomizeComponentsFromClusterLabel("vendor", "components"),
+			expectedNamespace:     AddonDefaultInstallNamespace,
+			expectedImage:         "quay.io/open-cluster-management/helloworld:latest",
+			expectedArgs:          []string{"agent", "--cluster-name=CLUSTER_NAME"},
+			expectedObjectsNumber: 2,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			factory := NewAgentAddonFactory("helloworld", os.DirFS("testmanifests/kustomize"), "base").
+				WithGetValuesFuncs(c.getValuesFuncs...)
+			if c.componentsFunc != nil {
+				factory = factory.WithKustomizeComponentsFunc(c.componentsFunc)
+			}
+			agentAddon, err := factory.BuildKustomizeAgentAddon()
+			if err != nil {
+				t.Fatalf("failed to build kustomize agentAddon: %v", err)
+			}
+
+			addon := addontesting.NewAddon("helloworld", c.cluster.Name)
+			addon.Spec.InstallNamespace = c.installNamespace
+			objects, err := agentAddon.Manifests(c.cluster, addon)
+			if err != nil {
+				t.Fatalf("failed to get manifests: %v", err)
+			}
+			if len(objects) != c.expectedObjectsNumber {
+				t.Fatalf("expected %d objects, but got %d", c.expectedObjectsNumber, len(objects))
+			}
+
+			for _, o := range objects {
+				switch object := o.(type) {
+				case *appsv1.Deployment:
+					if object.Namespace != c.expectedNamespace {
+						t.Errorf("expected namespace %s, but got %s", c.expectedNamespace, object.Namespace)
+					}
+					container := object.Spec.Template.Spec.Containers[0]
+					if container.Image != c.expectedImage {
+						t.Errorf("expected image %s, but got %s", c.expectedImage, container.Image)
+					}
+					if !reflect.DeepEqual(container.Args, c.expectedArgs) {
+						t.Errorf("expected args %v, but got %v", c.expectedArgs, container.Args)
+					}
+					if (object.Spec.Template.Spec.SecurityContext != nil) != c.expectedSecurityCtx {
+						t.Errorf("expected security context %v, but got %v",
+							c.expectedSecurityCtx, object.Spec.Template.Spec.SecurityContext)
+					}
+				case *corev1.ServiceAccount:
+					if object.Namespace != c.expectedNamespace {
+						t.Errorf("expected namespace %s, but got %s", c.expectedNamespace, object.Namespace)
+					}
+				case *corev1.ConfigMap:
+					t.Errorf("expected the values configmap is not deployed, but got %s", object.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildKustomizeAgentAddonWithoutKustomization(t *testing.T) {
+	_, err := NewAgentAddonFactory("helloworld", os.DirFS("testmanifests/kustomize"), "components").
+		BuildKustomizeAgentAddon()
+	if err == nil {
+		t.Errorf("expected error when there is no kustomization file")
+	}
+}
+
+func TestFlattenValues(t *testing.T) {
+	values := Values{
+		"image":  "test",
+		"global": map[string]interface{}{"replicas": 2, "nodeSelector": map[string]interface{}{"ssd": "true"}},
+		"tags":   []interface{}{"a", "b"},
+		"debug":  true,
+	}
+	expected := map[string]string{
+		"image":                   "test",
+		"global.replicas":         "2",
+		"global.nodeSelector.ssd": "true",
+		"tags":                    `["a","b"]`,
+		"debug":                   "true",
+	}
+	if actual := flattenValues(values); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, but got %v", expected, actual)
+	}
+}
+
+func newClusterWithLabels(name string, labels map[string]string) *clusterv1.ManagedCluster {
+	cluster := addontesting.NewManagedCluster(name)
+	cluster.Labels = labels
+	return cluster
+}
@@ -0,0 +1,233 @@
+package addonfactory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	"sigs.k8s.io/yaml"
+
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/constants"
+)
+
+// ManifestPatchesConfigGVR is the config type of the patches on the rendered manifests, which is a ConfigMap
+// referenced by the ManagedClusterAddOn or the ClusterManagementAddOn. The ConfigMap must have the label
+// constants.ConfigMapConfigLabelKey: "true", the addon manager only watches the ConfigMaps with the label.
+var ManifestPatchesConfigGVR = schema.GroupVersionResource{
+	Group:    "",
+	Version:  "v1",
+	Resource: "configmaps",
+}
+
+// ManifestPatchType is the type of a patch on a rendered manifest.
+type ManifestPatchType string
+
+const (
+	// ManifestPatchTypeStrategicMerge is the strategic merge patch, which is the default patch type. The json
+	// merge patch is used instead for the objects not in the scheme, e.g. the custom resources.
+	ManifestPatchTypeStrategicMerge ManifestPatchType = "StrategicMerge"
+	// ManifestPatchTypeMerge is the json merge patch (RFC 7386).
+	ManifestPatchTypeMerge ManifestPatchType = "Merge"
+	// ManifestPatchTypeJSON is the json patch (RFC 6902).
+	ManifestPatchTypeJSON ManifestPatchType = "JSON"
+)
+
+// ManifestPatch is a patch on a rendered manifest. Each value of the data of the ManifestPatches ConfigMap is a
+// yaml list of ManifestPatch, and the values are applied in the order of the keys. For example:
+//
+//	data:
+//	  resources.yaml: |
+//	    - target:
+//	        apiVersion: apps/v1
+//	        kind: Deployment
+//	        namespace: open-cluster-management-agent-addon
+//	        name: helloworld-agent
+//	      patch: |
+//	        spec:
+//	          template:
+//	            spec:
+//	              containers:
+//	              - name: helloworld-agent
+//	                resources:
+//	                  limits:
+//	                    memory: 2Gi
+type ManifestPatch struct {
+	Target ManifestPatchTarget `json:"target"`
+	// Type is the type of the patch, StrategicMerge by default.
+	Type ManifestPatchType `json:"type,omitempty"`
+	// Patch is the patch in yaml or json.
+	Patch string `json:"patch"`
+}
+
+// ManifestPatchTarget selects the rendered manifest to patch, the namespace is not matched if it is empty.
+type ManifestPatchTarget struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+}
+
+// ManifestPatchesGetter has a method to return the ConfigMap of the patches.
+type ManifestPatchesGetter interface {
+	Get(ctx context.Context, namespace, name string) (*corev1.ConfigMap, error)
+}
+
+type defaultManifestPatchesGetter struct {
+	kubeClient kubernetes.Interface
+}
+
+func (g *defaultManifestPatchesGetter) Get(ctx context.Context, namespace, name string) (*corev1.ConfigMap, error) {
+	return g.kubeClient.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+}
+
+// NewManifestPatchesGetter returns a ManifestPatchesGetter with kube client of the hub cluster.
+func NewManifestPatchesGetter(kubeClient kubernetes.Interface) ManifestPatchesGetter {
+	return &defaultManifestPatchesGetter{kubeClient: kubeClient}
+}
+
+// ManifestPatchesMutator returns a PostRenderMutator applying the patches in the ManifestPatches ConfigMaps
+// referenced by the addon to the rendered manifests, the ConfigMaps are applied in the order of the config
+// references. The patches whose target is not found in the manifests are ignored.
+func ManifestPatchesMutator(getter ManifestPatchesGetter) PostRenderMutator {
+	return func(cluster *clusterv1.ManagedCluster, addon *addonapiv1alpha1.ManagedClusterAddOn,
+		objects []runtime.Object) ([]runtime.Object, error) {
+		for _, config := range addon.Status.ConfigReferences {
+			if config.ConfigGroupResource.Group != ManifestPatchesConfigGVR.Group ||
+				config.ConfigGroupResource.Resource != ManifestPatchesConfigGVR.Resource {
+				continue
+			}
+
+			configMap, err := getter.Get(context.Background(), config.Namespace, config.Name)
+			if err != nil {
+				return nil, err
+			}
+			if configMap.Labels[constants.ConfigMapConfigLabelKey] != "true" {
+				return nil, fmt.Errorf("configmap %s/%s is not a config of the addon, the label %s=true is missing",
+					configMap.Namespace, configMap.Name, constants.ConfigMapConfigLabelKey)
+			}
+
+			patches, err := parseManifestPatches(configMap)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, patch := range patches {
+				objects, err = applyManifestPatch(objects, patch)
+				if err != nil {
+					return nil, fmt.Errorf("failed to apply the patches in configmap %s/%s: %v",
+						configMap.Namespace, configMap.Name, err)
+				}
+			}
+		}
+		return objects, nil
+	}
+}
+
+func parseManifestPatches(configMap *corev1.ConfigMap) ([]ManifestPatch, error) {
+	keys := make([]string, 0, len(configMap.Data))
+	for key := range configMap.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var patches []ManifestPatch
+	for _, key := range keys {
+		var items []ManifestPatch
+		if err := yaml.Unmarshal([]byte(configMap.Data[key]), &items); err != nil {
+			return nil, fmt.Errorf("failed to parse the patches %q in configmap %s/%s: %v",
+				key, configMap.Namespace, configMap.Name, err)
+		}
+		patches = append(patches, items...)
+	}
+	return patches, nil
+}
+
+func applyManifestPatch(objects []runtime.Object, patch ManifestPatch) ([]runtime.Object, error) {
+	patchJSON, err := yaml.YAMLToJSON([]byte(patch.Patch))
+	if err != nil {
+		return nil, err
+	}
+
+	found := false
+	for i, object := range objects {
+		if !matchManifestPatchTarget(object, patch.Target) {
+			continue
+		}
+		found = true
+
+		original, err := json.Marshal(object)
+		if err != nil {
+			return nil, err
+		}
+
+		var patched []byte
+		_, isUnstructured := object.(*unstructured.Unstructured)
+		switch {
+		case patch.Type == ManifestPatchTypeJSON:
+			jsonPatch, err := jsonpatch.DecodePatch(patchJSON)
+			if err != nil {
+				return nil, err
+			}
+			patched, err = jsonPatch.Apply(original)
+			if err != nil {
+				return nil, err
+			}
+		case patch.Type == ManifestPatchTypeMerge,
+			patch.Type == ManifestPatchTypeStrategicMerge && isUnstructured,
+			len(patch.Type) == 0 && isUnstructured:
+			patched, err = jsonpatch.MergePatch(original, patchJSON)
+			if err != nil {
+				return nil, err
+			}
+		case patch.Type == ManifestPatchTypeStrategicMerge, len(patch.Type) == 0:
+			patched, err = strategicpatch.StrategicMergePatch(original, patchJSON, object)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unsupported patch type %q", patch.Type)
+		}
+
+		// decode the patched object to a new object of the same type
+		patchedObject := reflect.New(reflect.TypeOf(object).Elem()).Interface().(runtime.Object)
+		if err := json.Unmarshal(patched, patchedObject); err != nil {
+			return nil, err
+		}
+		objects[i] = patchedObject
+	}
+
+	if !found {
+		klog.V(4).Infof("Skipping the patch, the target %v is not found in the manifests", patch.Target)
+	}
+	return objects, nil
+}
+
+func matchManifestPatchTarget(object runtime.Object, target ManifestPatchTarget) bool {
+	gvk := object.GetObjectKind().GroupVersionKind()
+	if gvk.GroupVersion().String() != target.APIVersion || gvk.Kind != target.Kind {
+		return false
+	}
+
+	accessor, err := meta.Accessor(object)
+	if err != nil {
+		return false
+	}
+	if len(target.Namespace) > 0 && accessor.GetNamespace() != target.Namespace {
+		return false
+	}
+	return accessor.GetName() == target.Name
+}
@@ -0,0 +1,175 @@
+package addonfactory
+
+import (
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakekube "k8s.io/client-go/kubernetes/fake"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/constants"
+)
+
+func TestManifestPatchesMutator(t *testing.T) {
+	cases := []struct {
+		name           string
+		patches        map[string]string
+		unlabeled      bool
+		expectedErr    bool
+		validateObject func(t *testing.T, objects []runtime.Object)
+	}{
+		{
+			name: "strategic merge patch",
+			patches: map[string]string{"limits.yaml": `
+- target:
+    apiVersion: apps/v1
+    kind: Deployment
+    namespace: default
+    name: agent
+  patch: |
+    spec:
+      template:
+        spec:
+          containers:
+          - name: agent
+            resources:
+              limits:
+                memory: 2Gi
+`},
+			validateObject: func(t *testing.T, objects []runtime.Object) {
+				deploy := objects[0].(*appsv1.Deployment)
+				container := deploy.Spec.Template.Spec.Containers[0]
+				if container.Image != "quay.io/open-cluster-management/agent:v1" {
+					t.Errorf("expected the image is not changed, but got %s", container.Image)
+				}
+				if !container.Resources.Limits.Memory().Equal(resource.MustParse("2Gi")) {
+					t.Errorf("expected memory limit 2Gi, but got %v", container.Resources.Limits)
+				}
+			},
+		},
+		{
+			name: "json patch and merge patch in order",
+			patches: map[string]string{
+				"a.yaml": `
+- target: {apiVersion: apps/v1, kind: Deployment, name: agent}
+  type: JSON
+  patch: '[{"op": "replace", "path": "/spec/replicas", "value": 3}]'
+`,
+				"b.yaml": `
+- target: {apiVersion: v1, kind: ConfigMap, name: config}
+  type: Merge
+  patch: '{"data": {"size": "large"}}'
+- target: {apiVersion: apps/v1, kind: Deployment, name: agent}
+  type: Merge
+  patch: '{"spec": {"replicas": 5}}'
+`},
+			validateObject: func(t *testing.T, objects []runtime.Object) {
+				if replicas := *objects[0].(*appsv1.Deployment).Spec.Replicas; replicas != 5 {
+					t.Errorf("expected replicas 5, but got %d", replicas)
+				}
+				if size := objects[1].(*corev1.ConfigMap).Data["size"]; size != "large" {
+					t.Errorf("expected size large, but got %s", size)
+				}
+			},
+		},
+		{
+			name: "patch unstructured object",
+			patches: map[string]string{"cr.yaml": `
+- target: {apiVersion: test.io/v1, kind: Test, name: test}
+  patch: '{"spec": {"size": 2}}'
+`},
+			validateObject: func(t *testing.T, objects []runtime.Object) {
+				u := objects[2].(*unstructured.Unstructured)
+				size, _, _ := unstructured.NestedInt64(u.Object, "spec", "size")
+				if size != 2 {
+					t.Errorf("expected size 2, but got %d", size)
+				}
+			},
+		},
+		{
+			name: "target not found",
+			patches: map[string]string{"missing.yaml": `
+- target: {apiVersion: apps/v1, kind: Deployment, namespace: other, name: agent}
+  patch: '{"spec": {"replicas": 2}}'
+`},
+			validateObject: func(t *testing.T, objects []runtime.Object) {
+				if replicas := *objects[0].(*appsv1.Deployment).Spec.Replicas; replicas != 1 {
+					t.Errorf("expected replicas 1, but got %d", replicas)
+				}
+			},
+		},
+		{
+			name: "configmap without the config label",
+			patches: map[string]string{"limits.yaml": `
+- target: {apiVersion: apps/v1, kind: Deployment, name: agent}
+  patch: '{"spec": {"replicas": 2}}'
+`},
+			unlabeled:   true,
+			expectedErr: true,
+		},
+		{
+			name:        "invalid patches",
+			patches:     map[string]string{"invalid.yaml": "target: test"},
+			expectedErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			configMap := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "patches",
+					Namespace: "cluster1",
+					Labels:    map[string]string{constants.ConfigMapConfigLabelKey: "true"},
+				},
+				Data: c.patches,
+			}
+			if c.unlabeled {
+				configMap.Labels = nil
+			}
+			getter := NewManifestPatchesGetter(fakekube.NewSimpleClientset(configMap))
+
+			addon := NewFakeManagedClusterAddon("helloworld", "cluster1", "", "")
+			addon.Status.ConfigReferences = []addonapiv1alpha1.ConfigReference{
+				{
+					ConfigGroupResource: addonapiv1alpha1.ConfigGroupResource{
+						Group:    ManifestPatchesConfigGVR.Group,
+						Resource: ManifestPatchesConfigGVR.Resource,
+					},
+					ConfigReferent: addonapiv1alpha1.ConfigReferent{Namespace: "cluster1", Name: "patches"},
+				},
+			}
+
+			replicas := int32(1)
+			deploy := newTestDeployment("agent", "quay.io/open-cluster-management/agent:v1")
+			deploy.Spec.Replicas = &replicas
+			objects := []runtime.Object{
+				deploy,
+				newHookConfigMap("config", nil),
+				&unstructured.Unstructured{Object: map[string]interface{}{
+					"apiVersion": "test.io/v1",
+					"kind":       "Test",
+					"metadata":   map[string]interface{}{"name": "test"},
+					"spec":       map[string]interface{}{"size": int64(1)},
+				}},
+			}
+
+			objects, err := ManifestPatchesMutator(getter)(NewFakeManagedCluster("cluster1", "1.10.1"), addon, objects)
+			if c.expectedErr {
+				if err == nil {
+					t.Errorf("expected error, but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got err %v", err)
+			}
+			c.validateObject(t, objects)
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package addonfactory
+
+import (
+	"sort"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+
+	"open-cluster-management.io/addon-framework/pkg/agent"
+)
+
+// ManifestSize is the size of a manifest in the ManifestWork.
+type ManifestSize struct {
+	// Object is the group, kind, namespace and name of the manifest, e.g. "apps/Deployment ns/name".
+	Object string
+	// Size is the size in bytes of the manifest encoded in the ManifestWork.
+	Size int
+}
+
+// ManifestSizes returns the sizes of the objects encoded in the same way as the manifests of the ManifestWork, the
+// biggest object is the first one. A manifest bigger than the manifests limit of the ManifestWork can not be
+// deployed, and the big manifests can be compacted, e.g. by WithTrimCRDDescription or CompactCRDsMutator.
+func ManifestSizes(objects []runtime.Object) ([]ManifestSize, error) {
+	var sizes []ManifestSize
+	for _, object := range objects {
+		key, err := objectKey(object)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := runtime.Encode(unstructured.UnstructuredJSONScheme, object)
+		if err != nil {
+			return nil, err
+		}
+		sizes = append(sizes, ManifestSize{Object: key, Size: len(raw)})
+	}
+
+	sort.SliceStable(sizes, func(i, j int) bool {
+		return sizes[i].Size > sizes[j].Size
+	})
+	return sizes, nil
+}
+
+// AgentAddonManifestSizes renders the manifests of the agentAddon for the cluster and the addon, and returns the
+// sizes of the manifests, see ManifestSizes.
+func AgentAddonManifestSizes(agentAddon agent.AgentAddon, cluster *clusterv1.ManagedCluster,
+	addon *addonapiv1alpha1.ManagedClusterAddOn) ([]ManifestSize, error) {
+	objects, err := agentAddon.Manifests(cluster, addon)
+	if err != nil {
+		return nil, err
+	}
+	return ManifestSizes(objects)
+}
@@ -0,0 +1,225 @@
+package addonfactory
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+)
+
+// PostRenderMutator mutates the objects rendered by the agentAddon before they are returned by Manifests, it is
+// used for the cross-cutting changes to all the manifests, e.g. the common labels or the node placement.
+type PostRenderMutator func(cluster *clusterv1.ManagedCluster, addon *addonapiv1alpha1.ManagedClusterAddOn,
+	objects []runtime.Object) ([]runtime.Object, error)
+
+// applyPostRenderMutators runs the mutators on the objects in order.
+func applyPostRenderMutators(mutators []PostRenderMutator, cluster *clusterv1.ManagedCluster,
+	addon *addonapiv1alpha1.ManagedClusterAddOn, objects []runtime.Object) ([]runtime.Object, error) {
+	var err error
+	for _, mutator := range mutators {
+		objects, err = mutator(cluster, addon, objects)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return objects, nil
+}
+
+// AddLabelsMutator returns a PostRenderMutator adding the labels to all the objects, the existing labels with the
+// same keys are overridden.
+func AddLabelsMutator(labels map[string]string) PostRenderMutator {
+	return func(cluster *clusterv1.ManagedCluster, addon *addonapiv1alpha1.ManagedClusterAddOn,
+		objects []runtime.Object) ([]runtime.Object, error) {
+		for _, object := range objects {
+			accessor, err := meta.Accessor(object)
+			if err != nil {
+				return nil, err
+			}
+			accessor.SetLabels(mergeStringMap(accessor.GetLabels(), labels))
+		}
+		return objects, nil
+	}
+}
+
+// AddAnnotationsMutator returns a PostRenderMutator adding the annotations to all the objects, the existing
+// annotations with the same keys are overridden.
+func AddAnnotationsMutator(annotations map[string]string) PostRenderMutator {
+	return func(cluster *clusterv1.ManagedCluster, addon *addonapiv1alpha1.ManagedClusterAddOn,
+		objects []runtime.Object) ([]runtime.Object, error) {
+		for _, object := range objects {
+			accessor, err := meta.Accessor(object)
+			if err != nil {
+				return nil, err
+			}
+			accessor.SetAnnotations(mergeStringMap(accessor.GetAnnotations(), annotations))
+		}
+		return objects, nil
+	}
+}
+
+// NodePlacementMutator returns a PostRenderMutator setting the nodeSelector and tolerations of the pods of every
+// Deployment, DaemonSet and Job to the node placement of the AddOnDeploymentConfigs of the addon. If there are
+// multiple AddOnDeploymentConfigs with node placement, the big index one overrides the small index one. The
+// objects are not changed if there is no node placement.
+func NodePlacementMutator(getter AddOnDeploymentConfigGetter) PostRenderMutator {
+	return func(cluster *clusterv1.ManagedCluster, addon *addonapiv1alpha1.ManagedClusterAddOn,
+		objects []runtime.Object) ([]runtime.Object, error) {
+		configs, err := getAddOnDeploymentConfigs(getter, addon)
+		if err != nil {
+			return nil, err
+		}
+
+		var nodePlacement *addonapiv1alpha1.NodePlacement
+		for _, config := range configs {
+			if config.Spec.NodePlacement != nil {
+				nodePlacement = config.Spec.NodePlacement
+			}
+		}
+		if nodePlacement == nil {
+			return objects, nil
+		}
+
+		return mutatePodSpecs(objects, func(podSpec *corev1.PodSpec) {
+			podSpec.NodeSelector = nodePlacement.NodeSelector
+			podSpec.Tolerations = nodePlacement.Tolerations
+		})
+	}
+}
+
+// ImagePullSecretMutator returns a PostRenderMutator adding the secret to the imagePullSecrets of the pods of every
+// Deployment, DaemonSet and Job.
+func ImagePullSecretMutator(secretName string) PostRenderMutator {
+	return func(cluster *clusterv1.ManagedCluster, addon *addonapiv1alpha1.ManagedClusterAddOn,
+		objects []runtime.Object) ([]runtime.Object, error) {
+		return mutatePodSpecs(objects, func(podSpec *corev1.PodSpec) {
+			for _, secret := range podSpec.ImagePullSecrets {
+				if secret.Name == secretName {
+					return
+				}
+			}
+			podSpec.ImagePullSecrets = append(podSpec.ImagePullSecrets, corev1.LocalObjectReference{Name: secretName})
+		})
+	}
+}
+
+// ImageMirrorMutator returns a PostRenderMutator overriding the image of every container and init container of
+// the pods of every Deployment, DaemonSet and Job with the registries of the AddOnDeploymentConfigs of the addon.
+// See OverrideImage for how the image is overridden.
+func ImageMirrorMutator(getter AddOnDeploymentConfigGetter) PostRenderMutator {
+	return func(cluster *clusterv1.ManagedCluster, addon *addonapiv1alpha1.ManagedClusterAddOn,
+		objects []runtime.Object) ([]runtime.Object, error) {
+		configs, err := getAddOnDeploymentConfigs(getter, addon)
+		if err != nil {
+			return nil, err
+		}
+
+		var registries []addonapiv1alpha1.ImageMirror
+		for _, config := range configs {
+			registries = append(registries, config.Spec.Registries...)
+		}
+		if len(registries) == 0 {
+			return objects, nil
+		}
+
+		return mutatePodSpecs(objects, func(podSpec *corev1.PodSpec) {
+			for i := range podSpec.InitContainers {
+				podSpec.InitContainers[i].Image = OverrideImage(registries, podSpec.InitContainers[i].Image)
+			}
+			for i := range podSpec.Containers {
+				podSpec.Containers[i].Image = OverrideImage(registries, podSpec.Containers[i].Image)
+			}
+		})
+	}
+}
+
+// getAddOnDeploymentConfigs returns the AddOnDeploymentConfigs in the config references of the addon in order.
+func getAddOnDeploymentConfigs(getter AddOnDeploymentConfigGetter,
+	addon *addonapiv1alpha1.ManagedClusterAddOn) ([]*addonapiv1alpha1.AddOnDeploymentConfig, error) {
+	var configs []*addonapiv1alpha1.AddOnDeploymentConfig
+	for _, config := range addon.Status.ConfigReferences {
+		if config.ConfigGroupResource.Group != AddOnDeploymentConfigGVR.Group ||
+			config.ConfigGroupResource.Resource != AddOnDeploymentConfigGVR.Resource {
+			continue
+		}
+
+		addOnDeploymentConfig, err := getAddOnDeploymentConfig(getter, addon, config)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, addOnDeploymentConfig)
+	}
+	return configs, nil
+}
+
+// mutatePodSpecs runs the mutate func on the pod spec of every Deployment, DaemonSet and Job in the objects. The
+// unstructured objects are converted to the typed objects to be mutated.
+func mutatePodSpecs(objects []runtime.Object, mutate func(podSpec *corev1.PodSpec)) ([]runtime.Object, error) {
+	for i, object := range objects {
+		u, isUnstructured := object.(*unstructured.Unstructured)
+		if isUnstructured {
+			typed, err := toTypedWorkload(u)
+			if err != nil {
+				return nil, err
+			}
+			if typed == nil {
+				continue
+			}
+			object = typed
+		}
+
+		switch workload := object.(type) {
+		case *appsv1.Deployment:
+			mutate(&workload.Spec.Template.Spec)
+		case *appsv1.DaemonSet:
+			mutate(&workload.Spec.Template.Spec)
+		case *batchv1.Job:
+			mutate(&workload.Spec.Template.Spec)
+		default:
+			continue
+		}
+
+		if isUnstructured {
+			content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(object)
+			if err != nil {
+				return nil, err
+			}
+			objects[i] = &unstructured.Unstructured{Object: content}
+		}
+	}
+	return objects, nil
+}
+
+// toTypedWorkload converts the unstructured Deployment, DaemonSet or Job to the typed object, nil is returned for
+// the other kinds.
+func toTypedWorkload(u *unstructured.Unstructured) (runtime.Object, error) {
+	var typed runtime.Object
+	switch u.GroupVersionKind() {
+	case appsv1.SchemeGroupVersion.WithKind("Deployment"):
+		typed = &appsv1.Deployment{}
+	case appsv1.SchemeGroupVersion.WithKind("DaemonSet"):
+		typed = &appsv1.DaemonSet{}
+	case batchv1.SchemeGroupVersion.WithKind("Job"):
+		typed = &batchv1.Job{}
+	default:
+		return nil, nil
+	}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, typed); err != nil {
+		return nil, err
+	}
+	return typed, nil
+}
+
+func mergeStringMap(a, b map[string]string) map[string]string {
+	out := make(map[string]string, len(a)+len(b))
+	for k, v := range a {
+		out[k] = v
+	}
+	for k, v := range b {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,152 @@
+package addonfactory
+
+import (
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	fakeaddon "open-cluster-management.io/api/client/addon/clientset/versioned/fake"
+)
+
+func newTestDeployment(name, image string) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: name, Image: image}}},
+			},
+		},
+	}
+}
+
+func newTestUnstructuredDaemonSet(name, image string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "DaemonSet",
+		"metadata":   map[string]interface{}{"name": name, "namespace": "default"},
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": []interface{}{map[string]interface{}{"name": name, "image": image}},
+				},
+			},
+		},
+	}}
+}
+
+func podSpecOf(t *testing.T, object runtime.Object) corev1.PodSpec {
+	switch o := object.(type) {
+	case *appsv1.Deployment:
+		return o.Spec.Template.Spec
+	case *unstructured.Unstructured:
+		ds := &appsv1.DaemonSet{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(o.Object, ds); err != nil {
+			t.Fatal(err)
+		}
+		return ds.Spec.Template.Spec
+	}
+	t.Fatalf("unexpected object %T", object)
+	return corev1.PodSpec{}
+}
+
+func TestPostRenderMutators(t *testing.T) {
+	config := &addonapiv1alpha1.AddOnDeploymentConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "cluster1"},
+		Spec: addonapiv1alpha1.AddOnDeploymentConfigSpec{
+			NodePlacement: &addonapiv1alpha1.NodePlacement{
+				NodeSelector: map[string]string{"host": "ssd"},
+				Tolerations:  []corev1.Toleration{{Key: "foo", Operator: corev1.TolerationOpExists}},
+			},
+			Registries: []addonapiv1alpha1.ImageMirror{
+				{Source: "quay.io/open-cluster-management", Mirror: "quay.io/ocm"},
+			},
+		},
+	}
+	getter := NewAddOnDeploymentConfigGetter(fakeaddon.NewSimpleClientset(config))
+
+	addon := NewFakeManagedClusterAddon("helloworld", "cluster1", "", "")
+	addon.Status.ConfigReferences = []addonapiv1alpha1.ConfigReference{
+		{
+			ConfigGroupResource: addonapiv1alpha1.ConfigGroupResource{
+				Group:    AddOnDeploymentConfigGVR.Group,
+				Resource: AddOnDeploymentConfigGVR.Resource,
+			},
+			ConfigReferent: addonapiv1alpha1.ConfigReferent{Namespace: "cluster1", Name: "config"},
+		},
+	}
+
+	objects := []runtime.Object{
+		newTestDeployment("agent", "quay.io/open-cluster-management/agent:v1"),
+		newTestUnstructuredDaemonSet("daemon", "quay.io/open-cluster-management/daemon:v1"),
+		newHookConfigMap("config", nil),
+	}
+	objects, err := applyPostRenderMutators([]PostRenderMutator{
+		AddLabelsMutator(map[string]string{"app": "helloworld"}),
+		AddAnnotationsMutator(map[string]string{"owner": "test"}),
+		NodePlacementMutator(getter),
+		ImagePullSecretMutator("pull-secret"),
+		ImageMirrorMutator(getter),
+	}, NewFakeManagedCluster("cluster1", "1.10.1"), addon, objects)
+	if err != nil {
+		t.Fatalf("expected no error, got err %v", err)
+	}
+
+	expectedImages := []string{"quay.io/ocm/agent:v1", "quay.io/ocm/daemon:v1"}
+	for i, object := range objects[:2] {
+		podSpec := podSpecOf(t, object)
+		if !reflect.DeepEqual(podSpec.NodeSelector, config.Spec.NodePlacement.NodeSelector) {
+			t.Errorf("expected nodeSelector %v, but got %v", config.Spec.NodePlacement.NodeSelector, podSpec.NodeSelector)
+		}
+		if !reflect.DeepEqual(podSpec.Tolerations, config.Spec.NodePlacement.Tolerations) {
+			t.Errorf("expected tolerations %v, but got %v", config.Spec.NodePlacement.Tolerations, podSpec.Tolerations)
+		}
+		if !reflect.DeepEqual(podSpec.ImagePullSecrets, []corev1.LocalObjectReference{{Name: "pull-secret"}}) {
+			t.Errorf("expected imagePullSecrets pull-secret, but got %v", podSpec.ImagePullSecrets)
+		}
+		if podSpec.Containers[0].Image != expectedImages[i] {
+			t.Errorf("expected image %s, but got %s", expectedImages[i], podSpec.Containers[0].Image)
+		}
+	}
+
+	cm := objects[2].(*corev1.ConfigMap)
+	if cm.Labels["app"] != "helloworld" || cm.Annotations["owner"] != "test" {
+		t.Errorf("expected labels and annotations added, but got %v, %v", cm.Labels, cm.Annotations)
+	}
+}
+
+func TestTemplateAddon_PostRenderMutators(t *testing.T) {
+	templateFS := fstest.MapFS{
+		"manifests/configmap.yaml": &fstest.MapFile{Data: []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: test
+  namespace: {{ .AddonInstallNamespace }}
+  labels:
+    app: test
+`)},
+	}
+
+	agentAddon, err := NewAgentAddonFactory("helloworld", templateFS, "manifests").
+		WithPostRenderMutators(AddLabelsMutator(map[string]string{"addon": "helloworld"})).
+		BuildTemplateAgentAddon()
+	if err != nil {
+		t.Fatalf("expected no error, got err %v", err)
+	}
+
+	objects, err := agentAddon.Manifests(NewFakeManagedCluster("cluster1", "1.10.1"),
+		NewFakeManagedClusterAddon("helloworld", "cluster1", "", ""))
+	if err != nil {
+		t.Fatalf("expected no error, got err %v", err)
+	}
+	expectedLabels := map[string]string{"app": "test", "addon": "helloworld"}
+	if labels := objects[0].(*corev1.ConfigMap).Labels; !reflect.DeepEqual(labels, expectedLabels) {
+		t.Errorf("expected labels %v, but got %v", expectedLabels, labels)
+	}
+}
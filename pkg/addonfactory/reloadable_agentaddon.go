@@ -0,0 +1,183 @@
+package addonfactory
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+
+	"open-cluster-management.io/addon-framework/pkg/agent"
+	"open-cluster-management.io/addon-framework/pkg/assets"
+)
+
+// TriggerFunc triggers the reconcile of the addon on the cluster, an empty clusterName means all the clusters.
+// AddonManager.Trigger is a TriggerFunc.
+type TriggerFunc func(clusterName, addonName string)
+
+// ReloadableAgentAddon is an agentAddon rebuilt from the files of the factory when they are changed, so the chart
+// or the templates of a factory backed by a mounted directory, e.g. NewAgentAddonFactoryFromDir of a ConfigMap
+// volume, can be updated without restarting the manager. The files are checked by the checksum of their contents,
+// in the same way as utils.NewConfigChecker.
+//
+// A change is applied atomically: the manifests are rendered by either the previous or the rebuilt agentAddon, never
+// a mix of them. A change which fails to build, e.g. an invalid Chart.yaml or a template with a syntax error, is
+// rejected and the previous agentAddon is kept, until the files are changed again.
+type ReloadableAgentAddon struct {
+	fs    fs.FS
+	dir   string
+	build func() (agent.AgentAddon, error)
+
+	lock       sync.RWMutex
+	agentAddon agent.AgentAddon
+	checksum   [32]byte
+}
+
+var _ agent.AgentAddon = &ReloadableAgentAddon{}
+
+func newReloadableAgentAddon(fsys fs.FS, dir string,
+	build func() (agent.AgentAddon, error)) (*ReloadableAgentAddon, error) {
+	checksum, err := filesChecksum(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	agentAddon, err := build()
+	if err != nil {
+		return nil, err
+	}
+	return &ReloadableAgentAddon{
+		fs:         fsys,
+		dir:        dir,
+		build:      build,
+		agentAddon: agentAddon,
+		checksum:   checksum,
+	}, nil
+}
+
+func (a *ReloadableAgentAddon) current() agent.AgentAddon {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	return a.agentAddon
+}
+
+func (a *ReloadableAgentAddon) Manifests(cluster *clusterv1.ManagedCluster,
+	addon *addonapiv1alpha1.ManagedClusterAddOn) ([]runtime.Object, error) {
+	return a.current().Manifests(cluster, addon)
+}
+
+func (a *ReloadableAgentAddon) GetAgentAddonOptions() agent.AgentAddonOptions {
+	return a.current().GetAgentAddonOptions()
+}
+
+// Reload rebuilds the agentAddon if the files are changed since the last build, and returns true if the agentAddon
+// is rebuilt. An error is returned if the files can not be read or the rebuilt agentAddon is invalid, and the
+// previous agentAddon is kept in this case.
+func (a *ReloadableAgentAddon) Reload() (bool, error) {
+	checksum, err := filesChecksum(a.fs, a.dir)
+	if err != nil {
+		return false, err
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if checksum == a.checksum {
+		return false, nil
+	}
+
+	agentAddon, err := a.build()
+	if err != nil {
+		return false, err
+	}
+	// the manager registers the agentAddon by its name, which can not be changed after it is added.
+	previousName := a.agentAddon.GetAgentAddonOptions().AddonName
+	if name := agentAddon.GetAgentAddonOptions().AddonName; name != previousName {
+		return false, fmt.Errorf("the addon name can not be changed from %s to %s", previousName, name)
+	}
+	a.agentAddon = agentAddon
+	a.checksum = checksum
+	return true, nil
+}
+
+// Start checks the files every period until the ctx is done. Once the agentAddon is rebuilt, the trigger is called
+// with an empty clusterName, so the addon is redeployed on all the clusters with the rebuilt agentAddon, e.g.
+//
+//	agentAddon, err := addonfactory.NewAgentAddonFactoryFromDir(addonName, "/etc/addon/chart").
+//		BuildReloadableHelmAgentAddon()
+//	...
+//	err = mgr.AddAgent(agentAddon)
+//	...
+//	go agentAddon.Start(ctx, 30*time.Second, mgr.Trigger)
+func (a *ReloadableAgentAddon) Start(ctx context.Context, period time.Duration, trigger TriggerFunc) {
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		reloaded, err := a.Reload()
+		if err != nil {
+			klog.Errorf("failed to reload the addon %s from %s, keep the previous version: %v",
+				a.GetAgentAddonOptions().AddonName, a.dir, err)
+			return
+		}
+		if !reloaded {
+			return
+		}
+
+		addonName := a.GetAgentAddonOptions().AddonName
+		klog.Infof("the addon %s is reloaded from %s", addonName, a.dir)
+		if trigger != nil {
+			trigger("", addonName)
+		}
+	}, period)
+}
+
+// parseTemplateFiles parses the template files of the factory with the functions used to render them.
+func (f *AgentAddonFactory) parseTemplateFiles() error {
+	templateFiles, err := getTemplateFiles(f.fs, f.dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range templateFiles {
+		template, err := fs.ReadFile(f.fs, file)
+		if err != nil {
+			return err
+		}
+		if err := assets.ParseTemplate(file, template, f.templateFuncs); err != nil {
+			return fmt.Errorf("invalid template %s: %v", file, err)
+		}
+	}
+	return nil
+}
+
+// filesChecksum returns the checksum of the names and the contents of the files in the dir of the fs.
+func filesChecksum(fsys fs.FS, dir string) ([32]byte, error) {
+	if isRootDir(dir) {
+		dir = "."
+	}
+	// only walk the dir, the sibling dirs sharing the prefix of the dir, e.g. chart-backup of chart, are not read.
+	files, err := walkFiles(fsys, path.Clean(dir))
+	if err != nil {
+		return [32]byte{}, err
+	}
+	sort.Strings(files)
+
+	hash := sha256.New()
+	for _, file := range files {
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return [32]byte{}, err
+		}
+		// the length of the content is written so the boundary of the files is not ambiguous.
+		fmt.Fprintf(hash, "%s\n%d\n", file, len(data))
+		hash.Write(data)
+	}
+
+	var checksum [32]byte
+	copy(checksum[:], hash.Sum(nil))
+	return checksum, nil
+}
@@ -0,0 +1,291 @@
+package addonfactory
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func newReloadTemplate(data string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: reload
+  namespace: {{ .AddonInstallNamespace }}
+data:
+  value: ` + data + "\n")}
+}
+
+func reloadedConfigMapValue(t *testing.T, objects []runtime.Object) string {
+	if len(objects) != 1 {
+		t.Fatalf("expected 1 object, but got %v", objects)
+	}
+	return objects[0].(*corev1.ConfigMap).Data["value"]
+}
+
+func TestReloadableTemplateAgentAddon(t *testing.T) {
+	templates := fstest.MapFS{
+		"manifests/configmap.yaml": newReloadTemplate("v1"),
+		"other/readme.md":          &fstest.MapFile{Data: []byte("readme")},
+	}
+	agentAddon, err := NewAgentAddonFactory("reload", templates, "manifests").BuildReloadableTemplateAgentAddon()
+	if err != nil {
+		t.Fatalf("expected no error, got err %v", err)
+	}
+	cluster := NewFakeManagedCluster("cluster1", "1.10.1")
+	addon := NewFakeManagedClusterAddon("reload", "cluster1", "", "")
+
+	cases := []struct {
+		name             string
+		update           func()
+		expectedReloaded bool
+		expectedErr      bool
+		expectedValue    string
+	}{
+		{
+			name:          "no change",
+			update:        func() {},
+			expectedValue: "v1",
+		},
+		{
+			name: "change out of the dir",
+			update: func() {
+				templates["other/readme.md"] = &fstest.MapFile{Data: []byte("changed")}
+			},
+			expectedValue: "v1",
+		},
+		{
+			name: "template changed",
+			update: func() {
+				templates["manifests/configmap.yaml"] = newReloadTemplate("v2")
+			},
+			expectedReloaded: true,
+			expectedValue:    "v2",
+		},
+		{
+			name: "invalid template is rejected",
+			update: func() {
+				templates["manifests/configmap.yaml"] = newReloadTemplate("{{ .Value ")
+			},
+			expectedErr:   true,
+			expectedValue: "v2",
+		},
+		{
+			name: "fixed template",
+			update: func() {
+				templates["manifests/configmap.yaml"] = newReloadTemplate("v3")
+			},
+			expectedReloaded: true,
+			expectedValue:    "v3",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			c.update()
+			reloaded, err := agentAddon.Reload()
+			if c.expectedErr && err == nil {
+				t.Errorf("expected error, but got nil")
+			}
+			if !c.expectedErr && err != nil {
+				t.Errorf("expected no error, got err %v", err)
+			}
+			if reloaded != c.expectedReloaded {
+				t.Errorf("expected reloaded %v, but got %v", c.expectedReloaded, reloaded)
+			}
+
+			objects, err := agentAddon.Manifests(cluster, addon)
+			if err != nil {
+				t.Fatalf("expected no error, got err %v", err)
+			}
+			if value := reloadedConfigMapValue(t, objects); value != c.expectedValue {
+				t.Errorf("expected value %s, but got %s", c.expectedValue, value)
+			}
+		})
+	}
+}
+
+func TestReloadableHelmAgentAddonFromDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name, data string) {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("Chart.yaml", "apiVersion: v2\nname: reload\nversion: 1.0.0\n")
+	writeFile("values.yaml", "value: v1\n")
+	writeFile("templates/configmap.yaml", `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: reload
+  namespace: {{ .Release.Namespace }}
+data:
+  value: {{ .Values.value }}
+`)
+
+	agentAddon, err := NewAgentAddonFactoryFromDir("reload", dir).BuildReloadableHelmAgentAddon()
+	if err != nil {
+		t.Fatalf("expected no error, got err %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	triggered := make(chan string, 10)
+	go agentAddon.Start(ctx, 10*time.Millisecond, func(clusterName, addonName string) {
+		triggered <- clusterName + "/" + addonName
+	})
+
+	// the invalid chart is rejected and the previous chart is kept.
+	writeFile("Chart.yaml", "name: reload\n")
+	if _, err := agentAddon.Reload(); err == nil {
+		t.Errorf("expected error of the invalid chart, but got nil")
+	}
+	objects, err := agentAddon.Manifests(NewFakeManagedCluster("cluster1", "1.10.1"),
+		NewFakeManagedClusterAddon("reload", "cluster1", "", ""))
+	if err != nil {
+		t.Fatalf("expected no error, got err %v", err)
+	}
+	if value := reloadedConfigMapValue(t, objects); value != "v1" {
+		t.Errorf("expected value v1, but got %s", value)
+	}
+
+	writeFile("values.yaml", "value: v2\n")
+	writeFile("Chart.yaml", "apiVersion: v2\nname: reload\nversion: 1.0.1\n")
+	select {
+	case key := <-triggered:
+		if key != "/reload" {
+			t.Errorf("expected the addon triggered on all the clusters, but got %s", key)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the addon reloaded and triggered")
+	}
+	objects, err = agentAddon.Manifests(NewFakeManagedCluster("cluster1", "1.10.1"),
+		NewFakeManagedClusterAddon("reload", "cluster1", "", ""))
+	if err != nil {
+		t.Fatalf("expected no error, got err %v", err)
+	}
+	if value := reloadedConfigMapValue(t, objects); value != "v2" {
+		t.Errorf("expected value v2, but got %s", value)
+	}
+}
+
+func TestFilesChecksum(t *testing.T) {
+	cases := []struct {
+		name            string
+		dir             string
+		changed         string
+		expectedChanged bool
+	}{
+		{
+			name:            "file in the dir changed",
+			dir:             "chart",
+			changed:         "chart/values.yaml",
+			expectedChanged: true,
+		},
+		{
+			name:    "file in a sibling dir sharing the prefix changed",
+			dir:     "chart",
+			changed: "chart-backup/values.yaml",
+		},
+		{
+			name:    "file in a sibling dir changed with a trailing slash of the dir",
+			dir:     "chart/",
+			changed: "chart-backup/values.yaml",
+		},
+		{
+			name:            "file changed in the root dir",
+			dir:             ".",
+			changed:         "chart-backup/values.yaml",
+			expectedChanged: true,
+		},
+		{
+			name:            "file changed in the empty dir",
+			changed:         "chart-backup/values.yaml",
+			expectedChanged: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fsys := fstest.MapFS{
+				"chart/values.yaml":        &fstest.MapFile{Data: []byte("a")},
+				"chart-backup/values.yaml": &fstest.MapFile{Data: []byte("a")},
+			}
+			checksum, err := filesChecksum(fsys, c.dir)
+			if err != nil {
+				t.Fatalf("expected no error, got err %v", err)
+			}
+			fsys[c.changed] = &fstest.MapFile{Data: []byte("b")}
+			newChecksum, err := filesChecksum(fsys, c.dir)
+			if err != nil {
+				t.Fatalf("expected no error, got err %v", err)
+			}
+			if changed := checksum != newChecksum; changed != c.expectedChanged {
+				t.Errorf("expected checksum changed %v, but got %v", c.expectedChanged, changed)
+			}
+		})
+	}
+}
+
+func TestGetFilesSkipsMountedDataDirs(t *testing.T) {
+	files, err := getFiles(fstest.MapFS{
+		"configmap.yaml":                &fstest.MapFile{Data: []byte("a")},
+		"..2023_01_01/configmap.yaml":   &fstest.MapFile{Data: []byte("a")},
+		"..data":                        &fstest.MapFile{Data: []byte("a")},
+		"templates/..2023_01_01/a.yaml": &fstest.MapFile{Data: []byte("a")},
+		"templates/deployment.yaml":     &fstest.MapFile{Data: []byte("a")},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got err %v", err)
+	}
+	if len(files) != 2 || files[0] != "configmap.yaml" || files[1] != "templates/deployment.yaml" {
+		t.Errorf("expected the mounted data dirs skipped, but got %v", files)
+	}
+}
+
+func TestGetFilesFollowsMountedSymlinks(t *testing.T) {
+	// the layout of a ConfigMap mounted with the items templates/deployment.yaml and Chart.yaml.
+	dir := t.TempDir()
+	dataDir := filepath.Join(dir, "..2023_01_01")
+	if err := os.MkdirAll(filepath.Join(dataDir, "templates"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string]string{
+		"Chart.yaml":                "apiVersion: v2",
+		"templates/deployment.yaml": "kind: Deployment",
+	} {
+		if err := os.WriteFile(filepath.Join(dataDir, name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{
+		"..data":     "..2023_01_01",
+		"Chart.yaml": "..data/Chart.yaml",
+		"templates":  "..data/templates",
+	} {
+		if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := loadFiles(os.DirFS(dir))
+	if err != nil {
+		t.Fatalf("expected no error, got err %v", err)
+	}
+	expected := map[string][]byte{
+		"Chart.yaml":                []byte("apiVersion: v2"),
+		"templates/deployment.yaml": []byte("kind: Deployment"),
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("expected files %v, but got %v", expected, files)
+	}
+}
@@ -2,6 +2,7 @@ package addonfactory
 
 import (
 	"fmt"
+	"text/template"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
@@ -21,6 +22,11 @@ type templateBuiltinValues struct {
 	ClusterName           string
 	AddonInstallNamespace string
 	InstallMode           string
+	ClusterLabels         map[string]string
+	ClusterClaims         map[string]string
+	HubAPIServer          string
+	HubCABundle           string
+	HostingClusterName    string
 }
 
 // templateDefaultValues includes the default values for template agentAddon.
@@ -40,16 +46,28 @@ type TemplateAgentAddon struct {
 	decoder            runtime.Decoder
 	templateFiles      []templateFile
 	getValuesFuncs     []GetValuesFunc
+	typedValuesCheck   typedValuesCheck
+	templateFuncs      template.FuncMap
+	valuesSchema       []byte
 	agentAddonOptions  agent.AgentAddonOptions
+	postRenderMutators []PostRenderMutator
 	trimCRDDescription bool
+	hubAPIServer       string
+	hubCABundle        []byte
 }
 
 func newTemplateAgentAddon(factory *AgentAddonFactory) *TemplateAgentAddon {
 	return &TemplateAgentAddon{
 		decoder:            serializer.NewCodecFactory(factory.scheme).UniversalDeserializer(),
-		getValuesFuncs:     factory.getValuesFuncs,
+		getValuesFuncs:     factory.allGetValuesFuncs(),
+		typedValuesCheck:   factory.typedValuesCheck,
+		templateFuncs:      factory.templateFuncs,
+		valuesSchema:       factory.valuesSchema,
 		agentAddonOptions:  factory.agentAddonOptions,
 		trimCRDDescription: factory.trimCRDDescription,
+		postRenderMutators: factory.postRenderMutators,
+		hubAPIServer:       factory.hubAPIServer,
+		hubCABundle:        factory.hubCABundle,
 	}
 }
 
@@ -68,8 +86,11 @@ func (a *TemplateAgentAddon) Manifests(
 			continue
 		}
 		klog.V(4).Infof("rendered template: %v", file.content)
-		raw := assets.MustCreateAssetFromTemplate(file.name, file.content, configValues).Data
-		object, _, err := a.decoder.Decode(raw, nil, nil)
+		asset, err := assets.CreateAssetFromTemplate(file.name, file.content, configValues, a.templateFuncs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render template %s: %v", file.name, err)
+		}
+		object, _, err := a.decoder.Decode(asset.Data, nil, nil)
 		if err != nil {
 			if runtime.IsMissingKind(err) {
 				klog.V(4).Infof("Skipping template %v, reason: %v", file.name, err)
@@ -80,6 +101,11 @@ func (a *TemplateAgentAddon) Manifests(
 		objects = append(objects, object)
 	}
 
+	objects, err = applyPostRenderMutators(a.postRenderMutators, cluster, addon, objects)
+	if err != nil {
+		return nil, err
+	}
+
 	if a.trimCRDDescription {
 		objects = trimCRDDescription(objects)
 	}
@@ -95,21 +121,38 @@ func (a *TemplateAgentAddon) getValues(
 	addon *addonapiv1alpha1.ManagedClusterAddOn) (Values, error) {
 	overrideValues := map[string]interface{}{}
 
+	customizedValues := newCustomizedValues(addon)
+	defer customizedValues.close()
+
 	defaultValues := a.getDefaultValues(cluster, addon)
 	overrideValues = MergeValues(overrideValues, defaultValues)
 
 	for i := 0; i < len(a.getValuesFuncs); i++ {
 		if a.getValuesFuncs[i] != nil {
-			userValues, err := a.getValuesFuncs[i](cluster, addon)
+			userValues, err := customizedValues.getValues(a.getValuesFuncs[i], cluster)
 			if err != nil {
 				return overrideValues, err
 			}
 			overrideValues = MergeValues(overrideValues, userValues)
 		}
 	}
+
+	if err := validateTypedValues(a.typedValuesCheck, customizedValues); err != nil {
+		return overrideValues, err
+	}
 	builtinValues := a.getBuiltinValues(cluster, addon)
 	overrideValues = MergeValues(overrideValues, builtinValues)
 
+	if len(a.valuesSchema) > 0 {
+		schemaErrors, err := validateValuesSchema(a.valuesSchema, overrideValues)
+		if err != nil {
+			return overrideValues, err
+		}
+		if len(schemaErrors) > 0 {
+			return overrideValues, newInvalidValuesError(schemaErrors, customizedValues)
+		}
+	}
+
 	return overrideValues, nil
 }
 
@@ -125,7 +168,13 @@ func (a *TemplateAgentAddon) getBuiltinValues(
 	}
 	builtinValues.AddonInstallNamespace = installNamespace
 
-	builtinValues.InstallMode, _ = constants.GetHostedModeInfo(addon.GetAnnotations())
+	builtinValues.InstallMode, builtinValues.HostingClusterName = constants.GetHostedModeInfo(addon.GetAnnotations())
+
+	global := newGlobalBuiltinValues(cluster, addon, a.hubAPIServer, a.hubCABundle)
+	builtinValues.ClusterLabels = global.ClusterLabels
+	builtinValues.ClusterClaims = global.ClusterClaims
+	builtinValues.HubAPIServer = global.HubAPIServer
+	builtinValues.HubCABundle = global.HubCABundle
 
 	return StructToValues(builtinValues)
 }
@@ -3,9 +3,14 @@ package addonfactory
 import (
 	"embed"
 	"fmt"
+	"reflect"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"text/template"
 
 	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"open-cluster-management.io/addon-framework/pkg/agent"
 	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
@@ -210,3 +215,133 @@ func TestTemplateAddon_Manifests(t *testing.T) {
 		})
 	}
 }
+
+func TestTemplateAddon_TemplateFuncs(t *testing.T) {
+	configMap := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{ .ClusterName | trunc 4 }}-config
+  namespace: {{ .AddonInstallNamespace }}
+data:
+  cluster: {{ .ClusterName | greeting | quote }}
+  image: {{ .Image | default "quay.io/helloworld:latest" }}
+`
+
+	cases := []struct {
+		name          string
+		template      string
+		expectedData  map[string]string
+		expectedName  string
+		expectedError string
+	}{
+		{
+			name:         "sprig and custom functions",
+			template:     configMap,
+			expectedName: "clus-config",
+			expectedData: map[string]string{"cluster": "hello cluster1", "image": "quay.io/helloworld:latest"},
+		},
+		{
+			name:          "unknown function",
+			template:      "name: {{ .ClusterName | unknown }}",
+			expectedError: "failed to render template manifests/configmap.yaml",
+		},
+		{
+			name:          "environment functions are removed",
+			template:      "name: {{ env \"HOME\" }}",
+			expectedError: "failed to render template manifests/configmap.yaml",
+		},
+		{
+			name:          "failed to execute template",
+			template:      "name: {{ fail \"invalid\" }}",
+			expectedError: "invalid",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			templateFS := fstest.MapFS{"manifests/configmap.yaml": &fstest.MapFile{Data: []byte(c.template)}}
+			agentAddon, err := NewAgentAddonFactory("helloworld", templateFS, "manifests").
+				WithTemplateFuncs(template.FuncMap{
+					"greeting": func(name string) string { return "hello " + name },
+				}).
+				BuildTemplateAgentAddon()
+			if err != nil {
+				t.Fatalf("expected no error, got err %v", err)
+			}
+
+			objects, err := agentAddon.Manifests(NewFakeManagedCluster("cluster1", "1.10.1"),
+				NewFakeManagedClusterAddon("helloworld", "cluster1", "", ""))
+			if len(c.expectedError) > 0 {
+				if err == nil || !strings.Contains(err.Error(), c.expectedError) {
+					t.Fatalf("expected error %q, got err %v", c.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got err %v", err)
+			}
+			if len(objects) != 1 {
+				t.Fatalf("expected 1 object, but got %d", len(objects))
+			}
+			object, ok := objects[0].(*corev1.ConfigMap)
+			if !ok {
+				t.Fatalf("expected configmap, but got %T", objects[0])
+			}
+			if object.Name != c.expectedName {
+				t.Errorf("expected name %s, but got %s", c.expectedName, object.Name)
+			}
+			for k, v := range c.expectedData {
+				if object.Data[k] != v {
+					t.Errorf("expected data %s=%s, but got %s", k, v, object.Data[k])
+				}
+			}
+		})
+	}
+}
+
+func TestTemplateAddon_BuiltinClusterValues(t *testing.T) {
+	templates := fstest.MapFS{
+		"configmap.yaml": &fstest.MapFile{Data: []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: builtin
+  namespace: {{ .AddonInstallNamespace }}
+data:
+  vendor: "{{ .ClusterLabels.vendor }}"
+  platform: "{{ index .ClusterClaims "platform.open-cluster-management.io" }}"
+  hubAPIServer: "{{ .HubAPIServer }}"
+  hubCABundle: "{{ .HubCABundle | b64enc }}"
+  hostingClusterName: "{{ .HostingClusterName }}"
+`)},
+	}
+
+	agentAddon, err := NewAgentAddonFactory("builtin", templates, ".").
+		WithHubInfo("https://hub:6443", []byte("ca")).
+		BuildTemplateAgentAddon()
+	if err != nil {
+		t.Fatalf("expected no error, got err %v", err)
+	}
+
+	cluster := NewFakeManagedCluster("cluster1", "1.10.1")
+	cluster.Labels = map[string]string{"vendor": "OpenShift"}
+	cluster.Status.ClusterClaims = []clusterv1.ManagedClusterClaim{
+		{Name: "platform.open-cluster-management.io", Value: "AWS"},
+	}
+	addon := NewFakeManagedClusterAddon("builtin", "cluster1", "myNs", "")
+	addon.Annotations = map[string]string{addonapiv1alpha1.HostingClusterNameAnnotationKey: "hosting"}
+
+	objects, err := agentAddon.Manifests(cluster, addon)
+	if err != nil {
+		t.Fatalf("expected no error, got err %v", err)
+	}
+	if len(objects) != 1 {
+		t.Fatalf("expected 1 object, but got %v", len(objects))
+	}
+	expectedData := map[string]string{
+		"vendor": "OpenShift", "platform": "AWS", "hubAPIServer": "https://hub:6443", "hubCABundle": "Y2E=",
+		"hostingClusterName": "hosting",
+	}
+	cm := objects[0].(*corev1.ConfigMap)
+	if !reflect.DeepEqual(cm.Data, expectedData) {
+		t.Errorf("expected data %v, but got %v", expectedData, cm.Data)
+	}
+}
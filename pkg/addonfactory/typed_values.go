@@ -0,0 +1,215 @@
+package addonfactory
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+)
+
+// TypedGetValuesFunc returns the values of the type T, which is a struct converted to the values by the json tags
+// of the fields. A nil T means no values.
+type TypedGetValuesFunc[T any] func(cluster *clusterv1.ManagedCluster,
+	addon *addonapiv1alpha1.ManagedClusterAddOn) (*T, error)
+
+// typedValuesCheck returns the invalid fields of the values against the type of the typed values.
+type typedValuesCheck func(values map[string]interface{}) ([]valuesSchemaError, error)
+
+// WithTypedValues defines the type T of the values. The defaults and the values of the getValuesFuncs are merged
+// in order before the values of the GetValuesFuncs of the factory, see GetTypedValuesFunc. The values of all the
+// GetValuesFuncs, e.g. the values from the annotation or the AddOnDeploymentConfig, are validated against T: a
+// key which is not the json name of a field of T or a value which can not be decoded to the field is rejected, and
+// reported in the ValuesValid condition of the addon. The keys are matched case-sensitively, since the templates
+// refer the values by the exact keys.
+//
+// WithTypedValues is a func instead of a method of the factory since a method can not have a type parameter:
+//
+//	factory := addonfactory.NewAgentAddonFactory(addonName, FS, "manifests/templates")
+//	agentAddon, err := addonfactory.WithTypedValues(factory, &Values{Image: defaultImage}, getValues).
+//		WithGetValuesFuncs(addonfactory.GetValuesFromAddonAnnotation).
+//		BuildTemplateAgentAddon()
+func WithTypedValues[T any](f *AgentAddonFactory, defaults *T,
+	getValuesFuncs ...TypedGetValuesFunc[T]) *AgentAddonFactory {
+	f.typedGetValuesFunc = GetTypedValuesFunc(defaults, getValuesFuncs...)
+	f.typedValuesCheck = func(values map[string]interface{}) ([]valuesSchemaError, error) {
+		return checkTypedValues(reflect.TypeOf((*T)(nil)).Elem(), values)
+	}
+	return f
+}
+
+// GetTypedValuesFunc returns a GetValuesFunc merging the defaults and the values of the getValuesFuncs in order,
+// the values of the big index func override the small index one. Each T is converted to the values by the json
+// tags, so a field with the omitempty tag is not set if it is empty, and the other fields are always set. Use the
+// pointer or omitempty fields in T so a func only overrides the fields it sets.
+func GetTypedValuesFunc[T any](defaults *T, getValuesFuncs ...TypedGetValuesFunc[T]) GetValuesFunc {
+	return func(cluster *clusterv1.ManagedCluster, addon *addonapiv1alpha1.ManagedClusterAddOn) (Values, error) {
+		values := Values{}
+		if defaults != nil {
+			defaultValues, err := JsonStructToValues(defaults)
+			if err != nil {
+				return nil, err
+			}
+			values = MergeValues(values, defaultValues)
+		}
+
+		for _, getValuesFunc := range getValuesFuncs {
+			typedValues, err := getValuesFunc(cluster, addon)
+			if err != nil {
+				return nil, err
+			}
+			if typedValues == nil {
+				continue
+			}
+			userValues, err := JsonStructToValues(typedValues)
+			if err != nil {
+				return nil, err
+			}
+			values = MergeValues(values, userValues)
+		}
+		return values, nil
+	}
+}
+
+// validateTypedValues validates the values of the getValuesFuncs with the typedValuesCheck.
+func validateTypedValues(check typedValuesCheck, customizedValues *customizedValues) error {
+	if check == nil {
+		return nil
+	}
+	schemaErrors, err := check(customizedValues.merged)
+	if err != nil {
+		return err
+	}
+	if len(schemaErrors) > 0 {
+		return newInvalidValuesError(schemaErrors, customizedValues)
+	}
+	return nil
+}
+
+// checkTypedValues returns the unknown fields of the values against the type t, and the field which can not be
+// decoded to t.
+func checkTypedValues(t reflect.Type, values map[string]interface{}) ([]valuesSchemaError, error) {
+	// the values are normalized by json, e.g. the structs in the values are converted to maps.
+	raw, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	normalized := map[string]interface{}{}
+	if err := json.Unmarshal(raw, &normalized); err != nil {
+		return nil, err
+	}
+
+	var schemaErrors []valuesSchemaError
+	for _, field := range unknownValuesFields(t, normalized, "") {
+		schemaErrors = append(schemaErrors, valuesSchemaError{field: field, message: "unknown field"})
+	}
+	if len(schemaErrors) > 0 {
+		return schemaErrors, nil
+	}
+
+	typed := reflect.New(t).Interface()
+	if err := json.Unmarshal(raw, typed); err != nil {
+		var typeError *json.UnmarshalTypeError
+		if !errors.As(err, &typeError) {
+			return nil, err
+		}
+		schemaErrors = append(schemaErrors, valuesSchemaError{
+			field:   typeError.Field,
+			message: fmt.Sprintf("cannot use %s as %s", typeError.Value, typeError.Type),
+		})
+	}
+	return schemaErrors, nil
+}
+
+// unknownValuesFields returns the keys of the value which are not the fields of the type t, the keys of the
+// nested values are joined with ".".
+func unknownValuesFields(t reflect.Type, value interface{}, prefix string) []string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	var unknown []string
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			// the mismatched type is reported by decoding the values.
+			return nil
+		}
+		fields := jsonFields(t)
+		for _, key := range sortedKeys(m) {
+			fieldType, ok := fields[key]
+			if !ok {
+				unknown = append(unknown, joinValuesKey(prefix, key))
+				continue
+			}
+			unknown = append(unknown, unknownValuesFields(fieldType, m[key], joinValuesKey(prefix, key))...)
+		}
+	case reflect.Map:
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		for _, key := range sortedKeys(m) {
+			unknown = append(unknown, unknownValuesFields(t.Elem(), m[key], joinValuesKey(prefix, key))...)
+		}
+	case reflect.Slice, reflect.Array:
+		items, ok := value.([]interface{})
+		if !ok {
+			return nil
+		}
+		for i, item := range items {
+			unknown = append(unknown, unknownValuesFields(t.Elem(), item, joinValuesKey(prefix, strconv.Itoa(i)))...)
+		}
+	}
+	return unknown
+}
+
+// jsonFields returns the json names of the fields of the struct type t, the fields of the embedded structs without
+// json names are promoted in the same way as encoding/json.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && len(name) == 0 && fieldType.Kind() == reflect.Struct {
+			for embeddedName, embeddedType := range jsonFields(fieldType) {
+				if _, ok := fields[embeddedName]; !ok {
+					fields[embeddedName] = embeddedType
+				}
+			}
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if len(name) == 0 {
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,169 @@
+package addonfactory
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	corev1 "k8s.io/api/core/v1"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+
+	"open-cluster-management.io/addon-framework/pkg/agent"
+)
+
+type testTypedConfig struct {
+	Name  string `json:"name,omitempty"`
+	Value string `json:"value,omitempty"`
+}
+
+type testTypedCommon struct {
+	Image string `json:"Image,omitempty"`
+}
+
+type testTypedValues struct {
+	testTypedCommon
+	Replicas *int                       `json:"Replicas,omitempty"`
+	Labels   map[string]string          `json:"Labels,omitempty"`
+	Configs  []testTypedConfig          `json:"Configs,omitempty"`
+	Extra    map[string]interface{}     `json:"Extra,omitempty"`
+	Global   map[string]testTypedConfig `json:"Global,omitempty"`
+}
+
+func TestTypedValues(t *testing.T) {
+	templates := fstest.MapFS{
+		"configmap.yaml": &fstest.MapFile{Data: []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: typed
+  namespace: {{ .AddonInstallNamespace }}
+data:
+  image: "{{ .Image }}"
+  replicas: "{{ .Replicas }}"
+`)},
+	}
+
+	replicas := 1
+	defaults := &testTypedValues{testTypedCommon: testTypedCommon{Image: "quay.io/ocm/agent:v1"}, Replicas: &replicas}
+
+	cases := []struct {
+		name           string
+		getValuesFuncs []TypedGetValuesFunc[testTypedValues]
+		annotation     string
+		expectedData   map[string]string
+		expectedErr    string
+	}{
+		{
+			name:         "defaults",
+			expectedData: map[string]string{"image": "quay.io/ocm/agent:v1", "replicas": "1"},
+		},
+		{
+			name: "typed values override the defaults",
+			getValuesFuncs: []TypedGetValuesFunc[testTypedValues]{
+				func(cluster *clusterv1.ManagedCluster,
+					addon *addonapiv1alpha1.ManagedClusterAddOn) (*testTypedValues, error) {
+					return &testTypedValues{testTypedCommon: testTypedCommon{Image: "quay.io/ocm/agent:v2"}}, nil
+				},
+				func(cluster *clusterv1.ManagedCluster,
+					addon *addonapiv1alpha1.ManagedClusterAddOn) (*testTypedValues, error) {
+					return nil, nil
+				},
+			},
+			expectedData: map[string]string{"image": "quay.io/ocm/agent:v2", "replicas": "1"},
+		},
+		{
+			name:         "annotation values override the typed values",
+			annotation:   `{"Replicas":3}`,
+			expectedData: map[string]string{"image": "quay.io/ocm/agent:v1", "replicas": "3"},
+		},
+		{
+			name:        "unknown keys",
+			annotation:  `{"image":"quay.io/ocm/agent:v3","Configs":[{"name":"a","valeu":"b"}]}`,
+			expectedErr: "invalid values from annotation addon.open-cluster-management.io/values: [Configs.0.valeu: unknown field, image: unknown field]",
+		},
+		{
+			name:        "mismatched type",
+			annotation:  `{"Replicas":"3"}`,
+			expectedErr: "invalid values from annotation addon.open-cluster-management.io/values: Replicas: cannot use string as int",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			factory := NewAgentAddonFactory("typed", templates, ".")
+			agentAddon, err := WithTypedValues(factory, defaults, c.getValuesFuncs...).
+				WithGetValuesFuncs(GetValuesFromAddonAnnotation).
+				BuildTemplateAgentAddon()
+			if err != nil {
+				t.Fatalf("expected no error, got err %v", err)
+			}
+
+			objects, err := agentAddon.Manifests(NewFakeManagedCluster("cluster1", "1.10.1"),
+				NewFakeManagedClusterAddon("typed", "cluster1", "", c.annotation))
+			if len(c.expectedErr) > 0 {
+				var invalidValuesErr *agent.InvalidValuesError
+				if !errors.As(err, &invalidValuesErr) {
+					t.Fatalf("expected InvalidValuesError, but got %v", err)
+				}
+				if err.Error() != c.expectedErr {
+					t.Errorf("expected error %q, but got %q", c.expectedErr, err.Error())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got err %v", err)
+			}
+			cm := objects[0].(*corev1.ConfigMap)
+			if !reflect.DeepEqual(cm.Data, c.expectedData) {
+				t.Errorf("expected data %v, but got %v", c.expectedData, cm.Data)
+			}
+		})
+	}
+}
+
+func TestUnknownValuesFields(t *testing.T) {
+	values := map[string]interface{}{
+		"Image":   "test",
+		"Labels":  map[string]interface{}{"any": "label"},
+		"Extra":   map[string]interface{}{"any": map[string]interface{}{"nested": true}},
+		"Global":  map[string]interface{}{"a": map[string]interface{}{"name": "a", "vaule": "b"}},
+		"Configs": []interface{}{map[string]interface{}{"name": "a"}, map[string]interface{}{"Name": "b"}},
+		"Unknown": "test",
+	}
+
+	unknown := unknownValuesFields(reflect.TypeOf(testTypedValues{}), values, "")
+	expected := []string{"Configs.1.Name", "Global.a.vaule", "Unknown"}
+	if !reflect.DeepEqual(unknown, expected) {
+		t.Errorf("expected unknown fields %v, but got %v", expected, unknown)
+	}
+}
+
+func TestWithTypedValuesInHelmAgentAddon(t *testing.T) {
+	chartFS := fstest.MapFS{
+		"Chart.yaml": &fstest.MapFile{Data: []byte("apiVersion: v2\nname: typed\nversion: 1.0.0\n")},
+		"templates/configmap.yaml": &fstest.MapFile{Data: []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: typed
+  namespace: {{ .Release.Namespace }}
+data:
+  image: "{{ .Values.Image }}"
+`)},
+	}
+
+	factory := NewAgentAddonFactory("typed", chartFS, ".")
+	agentAddon, err := WithTypedValues(factory, &testTypedValues{testTypedCommon: testTypedCommon{Image: "test"}}).
+		WithGetValuesFuncs(GetValuesFromAddonAnnotation).
+		BuildHelmAgentAddon()
+	if err != nil {
+		t.Fatalf("expected no error, got err %v", err)
+	}
+
+	_, err = agentAddon.Manifests(NewFakeManagedCluster("cluster1", "1.10.1"),
+		NewFakeManagedClusterAddon("typed", "cluster1", "", `{"Imag":"typo"}`))
+	if err == nil || !strings.Contains(err.Error(), "Imag: unknown field") {
+		t.Errorf("expected unknown field error, but got %v", err)
+	}
+}
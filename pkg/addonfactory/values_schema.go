@@ -0,0 +1,225 @@
+package addonfactory
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/xeipuuv/gojsonschema"
+	"helm.sh/helm/v3/pkg/chart"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+
+	"open-cluster-management.io/addon-framework/pkg/agent"
+)
+
+// valuesSchemaError is an invalid field of the values against the schema.
+type valuesSchemaError struct {
+	field   string
+	message string
+}
+
+// validateValuesSchema validates the values against the json schema, and returns the invalid fields.
+func validateValuesSchema(schema []byte, values map[string]interface{}) ([]valuesSchemaError, error) {
+	result, err := gojsonschema.Validate(gojsonschema.NewBytesLoader(schema), gojsonschema.NewGoLoader(values))
+	if err != nil {
+		return nil, fmt.Errorf("failed to validate values against the schema: %v", err)
+	}
+
+	var schemaErrors []valuesSchemaError
+	for _, e := range result.Errors() {
+		field := e.Field()
+		if field == gojsonschema.STRING_CONTEXT_ROOT {
+			field = ""
+		}
+		// the field of the required error is the parent of the missing property.
+		if property, ok := e.Details()["property"].(string); ok && e.Type() == "required" {
+			field = joinValuesKey(field, property)
+		}
+		schemaErrors = append(schemaErrors, valuesSchemaError{field: field, message: e.Description()})
+	}
+	return schemaErrors, nil
+}
+
+// validateChartValues validates the values against the values.schema.json of the chart and its subcharts, the
+// values of a subchart are scoped by the name of the subchart.
+func validateChartValues(c *chart.Chart, values map[string]interface{}, prefix string) ([]valuesSchemaError, error) {
+	var schemaErrors []valuesSchemaError
+	if len(c.Schema) > 0 {
+		errs, err := validateValuesSchema(c.Schema, values)
+		if err != nil {
+			return nil, fmt.Errorf("chart %s: %v", c.Name(), err)
+		}
+		for _, e := range errs {
+			e.field = joinValuesKey(prefix, e.field)
+			schemaErrors = append(schemaErrors, e)
+		}
+	}
+
+	for _, sub := range c.Dependencies() {
+		subValues, ok := values[sub.Name()].(map[string]interface{})
+		if !ok {
+			subValues = map[string]interface{}{}
+		}
+		errs, err := validateChartValues(sub, subValues, joinValuesKey(prefix, sub.Name()))
+		if err != nil {
+			return nil, err
+		}
+		schemaErrors = append(schemaErrors, errs...)
+	}
+	return schemaErrors, nil
+}
+
+// valuesSource returns the source of a field of the values returned by a getValuesFunc, e.g. the
+// AddOnDeploymentConfig setting the field. It is empty if the source is unknown.
+type valuesSource func(field string) string
+
+// valuesSourceRecorders keeps the recorder of each rendering of the addon, keyed by the copy of the addon passed to
+// the getValuesFuncs of the rendering. The getValuesFuncs of this package record the source of their values with
+// recordValuesSource, the source of the values of the other getValuesFuncs is unknown.
+var valuesSourceRecorders sync.Map
+
+type valuesSourceRecorder struct {
+	source valuesSource
+}
+
+// recordValuesSource records the source of the values returned by the getValuesFunc called with the addon, if the
+// addon is rendered by the factory.
+func recordValuesSource(addon *addonapiv1alpha1.ManagedClusterAddOn, source valuesSource) {
+	if recorder, ok := valuesSourceRecorders.Load(addon); ok {
+		recorder.(*valuesSourceRecorder).source = source
+	}
+}
+
+// customizedValues are the values from the getValuesFuncs, which are used to find the source of the invalid
+// values. The values of each getValuesFunc are kept in order with their source besides the merged values.
+type customizedValues struct {
+	merged     map[string]interface{}
+	funcValues []getValuesFuncValues
+	addon      *addonapiv1alpha1.ManagedClusterAddOn
+	recorder   *valuesSourceRecorder
+}
+
+// getValuesFuncValues are the values returned by a getValuesFunc.
+type getValuesFuncValues struct {
+	values Values
+	source valuesSource
+}
+
+// newCustomizedValues returns the customizedValues of a rendering of the addon, close must be called once the
+// values are rendered.
+func newCustomizedValues(addon *addonapiv1alpha1.ManagedClusterAddOn) *customizedValues {
+	c := &customizedValues{
+		merged: map[string]interface{}{},
+		// the addon is copied so that the recorder is only found by the getValuesFuncs of this rendering.
+		addon:    addon.DeepCopy(),
+		recorder: &valuesSourceRecorder{},
+	}
+	valuesSourceRecorders.Store(c.addon, c.recorder)
+	return c
+}
+
+func (c *customizedValues) close() {
+	valuesSourceRecorders.Delete(c.addon)
+}
+
+// getValues calls the getValuesFunc, and merges the values returned with the source recorded by the getValuesFunc.
+func (c *customizedValues) getValues(getValuesFunc GetValuesFunc, cluster *clusterv1.ManagedCluster) (Values, error) {
+	c.recorder.source = nil
+	values, err := getValuesFunc(cluster, c.addon)
+	if err != nil {
+		return nil, err
+	}
+	c.merged = MergeValues(c.merged, values)
+	c.funcValues = append(c.funcValues, getValuesFuncValues{values: values, source: c.recorder.source})
+	return values, nil
+}
+
+// source returns the source of the field, which is the source of the last getValuesFunc setting the field. It is
+// empty if the field is not set by any getValuesFunc, or the source of the getValuesFunc is unknown.
+func (c *customizedValues) source(field string) string {
+	for i := len(c.funcValues) - 1; i >= 0; i-- {
+		if hasValuesField(c.funcValues[i].values, field) {
+			if c.funcValues[i].source == nil {
+				return ""
+			}
+			return c.funcValues[i].source(field)
+		}
+	}
+	return ""
+}
+
+// newInvalidValuesError returns an InvalidValuesError naming the source of the invalid fields, which is recorded by
+// the getValuesFunc setting the field, e.g. the annotation of the addon or the AddOnDeploymentConfig setting the
+// field. The source is unknown for the fields not set by the getValuesFuncs, e.g. the default values of the chart.
+func newInvalidValuesError(schemaErrors []valuesSchemaError, customized *customizedValues) error {
+	var sources []string
+	var errs []error
+	for _, e := range schemaErrors {
+		errs = append(errs, fmt.Errorf("%s: %s", fieldName(e.field), e.message))
+
+		source := customized.source(e.field)
+		if len(source) > 0 && !containsString(sources, source) {
+			sources = append(sources, source)
+		}
+	}
+
+	return &agent.InvalidValuesError{
+		Source: strings.Join(sources, ", "),
+		Err:    utilerrors.NewAggregate(errs),
+	}
+}
+
+// hasValuesField returns true if the field is set in the values, the field is the keys joined with ".", the
+// item of a list is referred by the index, e.g. "tolerations.0.key".
+func hasValuesField(values map[string]interface{}, field string) bool {
+	if len(field) == 0 {
+		return false
+	}
+
+	var current interface{} = values
+	for _, key := range strings.Split(field, ".") {
+		switch v := current.(type) {
+		case map[string]interface{}:
+			value, ok := v[key]
+			if !ok {
+				return false
+			}
+			current = value
+		case Values:
+			value, ok := v[key]
+			if !ok {
+				return false
+			}
+			current = value
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(v) {
+				return false
+			}
+			current = v[index]
+		default:
+			// the values which are not maps or lists, e.g. a struct, are taken as set as a whole.
+			return true
+		}
+	}
+	return true
+}
+
+func fieldName(field string) string {
+	if len(field) == 0 {
+		return "values"
+	}
+	return field
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,223 @@
+package addonfactory
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	fakeaddon "open-cluster-management.io/api/client/addon/clientset/versioned/fake"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+
+	"open-cluster-management.io/addon-framework/pkg/agent"
+)
+
+const testValuesSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "type": "object",
+  "required": ["image"],
+  "properties": {
+    "image": {"type": "string"},
+    "replicas": {"type": "integer", "minimum": 1}
+  }
+}`
+
+func TestValuesSchema(t *testing.T) {
+	chartFS := fstest.MapFS{
+		"Chart.yaml":         &fstest.MapFile{Data: []byte("apiVersion: v2\nname: schema\nversion: 1.0.0\n")},
+		"values.yaml":        &fstest.MapFile{Data: []byte("image: quay.io/helloworld:latest\nreplicas: 1\n")},
+		"values.schema.json": &fstest.MapFile{Data: []byte(testValuesSchema)},
+		"templates/configmap.yaml": &fstest.MapFile{Data: []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: schema
+  namespace: {{ .Release.Namespace }}
+data:
+  replicas: "{{ .Values.replicas }}"
+`)},
+	}
+	templateFS := fstest.MapFS{
+		"manifests/configmap.yaml": &fstest.MapFile{Data: []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: schema
+  namespace: {{ .AddonInstallNamespace }}
+data:
+  replicas: "{{ .replicas }}"
+`)},
+	}
+
+	invalidReplicas := func(cluster *clusterv1.ManagedCluster,
+		addon *addonapiv1alpha1.ManagedClusterAddOn) (Values, error) {
+		return Values{"image": "quay.io/helloworld:latest", "replicas": 0}, nil
+	}
+	deploymentConfigValues := GetAddOnDeploymentConfigValues(
+		NewAddOnDeploymentConfigGetter(fakeaddon.NewSimpleClientset(&addonapiv1alpha1.AddOnDeploymentConfig{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "open-cluster-management", Name: "config"},
+		})),
+		func(config addonapiv1alpha1.AddOnDeploymentConfig) (Values, error) {
+			return Values{"image": "quay.io/helloworld:latest", "replicas": 0}, nil
+		})
+	// the config "image" sets the image only, and the config "replicas" sets the invalid replicas.
+	deploymentConfigGetter := NewAddOnDeploymentConfigGetter(fakeaddon.NewSimpleClientset(
+		&addonapiv1alpha1.AddOnDeploymentConfig{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "open-cluster-management", Name: "image"},
+		},
+		&addonapiv1alpha1.AddOnDeploymentConfig{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "open-cluster-management", Name: "replicas"},
+		},
+	))
+	toValues := func(config addonapiv1alpha1.AddOnDeploymentConfig) (Values, error) {
+		if config.Name == "image" {
+			return Values{"image": "quay.io/helloworld:latest"}, nil
+		}
+		return Values{"replicas": 0}, nil
+	}
+	builders := map[string]func(getValuesFuncs ...GetValuesFunc) (agent.AgentAddon, error){
+		"helm": func(getValuesFuncs ...GetValuesFunc) (agent.AgentAddon, error) {
+			return NewAgentAddonFactory("helloworld", chartFS, ".").
+				WithGetValuesFuncs(getValuesFuncs...).
+				BuildHelmAgentAddon()
+		},
+		"template": func(getValuesFuncs ...GetValuesFunc) (agent.AgentAddon, error) {
+			return NewAgentAddonFactory("helloworld", templateFS, "manifests").
+				WithGetValuesFuncs(getValuesFuncs...).
+				WithValuesSchema([]byte(testValuesSchema)).
+				BuildTemplateAgentAddon()
+		},
+	}
+
+	cases := []struct {
+		name            string
+		builders        []string
+		annotation      string
+		configs         []string
+		getValuesFuncs  []GetValuesFunc
+		expectedSource  string
+		expectedMessage string
+	}{
+		{
+			name:       "valid values",
+			builders:   []string{"helm"},
+			annotation: `{"replicas":2}`,
+		},
+		{
+			name:       "valid values with template",
+			builders:   []string{"template"},
+			annotation: `{"image":"quay.io/helloworld:latest","replicas":2}`,
+		},
+		{
+			name:            "invalid values from annotation",
+			builders:        []string{"helm", "template"},
+			annotation:      `{"image":"quay.io/helloworld:latest","replicas":"two"}`,
+			expectedSource:  "annotation addon.open-cluster-management.io/values",
+			expectedMessage: "replicas: Invalid type",
+		},
+		{
+			name:            "malformed annotation",
+			builders:        []string{"helm", "template"},
+			annotation:      `{"replicas":`,
+			expectedSource:  "annotation addon.open-cluster-management.io/values",
+			expectedMessage: "unexpected end of JSON input",
+		},
+		{
+			name:            "invalid values from addon deployment config",
+			builders:        []string{"helm", "template"},
+			getValuesFuncs:  []GetValuesFunc{deploymentConfigValues},
+			expectedSource:  "AddOnDeploymentConfig open-cluster-management/config",
+			expectedMessage: "replicas: Must be greater than or equal to 1",
+		},
+		{
+			name:            "invalid values from one of the addon deployment configs",
+			builders:        []string{"helm", "template"},
+			configs:         []string{"replicas", "image"},
+			getValuesFuncs:  []GetValuesFunc{GetAddOnDeploymentConfigValues(deploymentConfigGetter, toValues)},
+			expectedSource:  "AddOnDeploymentConfig open-cluster-management/replicas",
+			expectedMessage: "replicas: Must be greater than or equal to 1",
+		},
+		{
+			name:     "invalid values from one of the addon deployment configs merged with strategy",
+			builders: []string{"helm", "template"},
+			configs:  []string{"replicas", "image"},
+			getValuesFuncs: []GetValuesFunc{GetAddOnDeploymentConfigValuesWithMergeStrategy(
+				deploymentConfigGetter, ConfigMergeStrategyDeepMerge, toValues)},
+			expectedSource:  "AddOnDeploymentConfig open-cluster-management/replicas",
+			expectedMessage: "replicas: Must be greater than or equal to 1",
+		},
+		{
+			name:            "invalid values from a getValuesFunc of unknown source",
+			builders:        []string{"helm", "template"},
+			getValuesFuncs:  []GetValuesFunc{deploymentConfigValues, invalidReplicas},
+			expectedMessage: "replicas: Must be greater than or equal to 1",
+		},
+		{
+			name:            "invalid values from addon deployment config overridden by annotation",
+			builders:        []string{"helm", "template"},
+			annotation:      `{"replicas":"two"}`,
+			getValuesFuncs:  []GetValuesFunc{deploymentConfigValues},
+			expectedSource:  "annotation addon.open-cluster-management.io/values",
+			expectedMessage: "replicas: Invalid type",
+		},
+		{
+			name:            "required values missing",
+			builders:        []string{"template"},
+			expectedMessage: "image: image is required",
+		},
+	}
+
+	for _, c := range cases {
+		for _, builder := range c.builders {
+			t.Run(c.name+"/"+builder, func(t *testing.T) {
+				agentAddon, err := builders[builder](append(c.getValuesFuncs, GetValuesFromAddonAnnotation)...)
+				if err != nil {
+					t.Fatalf("expected no error, got err %v", err)
+				}
+
+				addon := NewFakeManagedClusterAddon("helloworld", "cluster1", "", c.annotation)
+				configs := c.configs
+				if len(configs) == 0 {
+					configs = []string{"config"}
+				}
+				for _, config := range configs {
+					addon.Status.ConfigReferences = append(addon.Status.ConfigReferences, addonapiv1alpha1.ConfigReference{
+						ConfigGroupResource: addonapiv1alpha1.ConfigGroupResource{
+							Group:    AddOnDeploymentConfigGVR.Group,
+							Resource: AddOnDeploymentConfigGVR.Resource,
+						},
+						ConfigReferent: addonapiv1alpha1.ConfigReferent{Namespace: "open-cluster-management", Name: config},
+					})
+				}
+				_, err = agentAddon.Manifests(NewFakeManagedCluster("cluster1", "1.10.1"), addon)
+				if len(c.expectedMessage) == 0 {
+					if err != nil {
+						t.Fatalf("expected no error, got err %v", err)
+					}
+					return
+				}
+
+				var invalidValuesErr *agent.InvalidValuesError
+				if !errors.As(err, &invalidValuesErr) {
+					t.Fatalf("expected invalid values error, got err %v", err)
+				}
+				if invalidValuesErr.Source != c.expectedSource {
+					t.Errorf("expected source %q, but got %q", c.expectedSource, invalidValuesErr.Source)
+				}
+				if !strings.Contains(invalidValuesErr.Error(), c.expectedMessage) {
+					t.Errorf("expected error %q, but got %v", c.expectedMessage, invalidValuesErr)
+				}
+			})
+		}
+	}
+}
+
+func TestBuildTemplateAgentAddonWithInvalidSchema(t *testing.T) {
+	templateFS := fstest.MapFS{"manifests/configmap.yaml": &fstest.MapFile{Data: []byte("kind: ConfigMap")}}
+	_, err := NewAgentAddonFactory("helloworld", templateFS, "manifests").
+		WithValuesSchema([]byte(`{"type": 1}`)).
+		BuildTemplateAgentAddon()
+	if err == nil {
+		t.Errorf("expected error for the invalid values schema")
+	}
+}
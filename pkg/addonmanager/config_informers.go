@@ -0,0 +1,55 @@
+package addonmanager
+
+import (
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/constants"
+)
+
+var configMapGVR = schema.GroupVersionResource{Group: "", Version: "v1", Resource: "configmaps"}
+
+// configInformerFactory is the informer factory of the configs of the addons. The ConfigMaps are only watched with
+// the label constants.ConfigMapConfigLabelKey, so the manager does not cache every ConfigMap on the hub.
+type configInformerFactory struct {
+	dynamicinformer.DynamicSharedInformerFactory
+	configMapInformers dynamicinformer.DynamicSharedInformerFactory
+}
+
+func newConfigInformerFactory(dynamicClient dynamic.Interface,
+	defaultResync time.Duration) dynamicinformer.DynamicSharedInformerFactory {
+	return &configInformerFactory{
+		DynamicSharedInformerFactory: dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, defaultResync),
+		configMapInformers: dynamicinformer.NewFilteredDynamicSharedInformerFactory(dynamicClient, defaultResync,
+			metav1.NamespaceAll, func(listOptions *metav1.ListOptions) {
+				listOptions.LabelSelector = metav1.FormatLabelSelector(&metav1.LabelSelector{
+					MatchLabels: map[string]string{constants.ConfigMapConfigLabelKey: "true"},
+				})
+			}),
+	}
+}
+
+func (f *configInformerFactory) ForResource(gvr schema.GroupVersionResource) informers.GenericInformer {
+	if gvr == configMapGVR {
+		return f.configMapInformers.ForResource(gvr)
+	}
+	return f.DynamicSharedInformerFactory.ForResource(gvr)
+}
+
+func (f *configInformerFactory) Start(stopCh <-chan struct{}) {
+	f.DynamicSharedInformerFactory.Start(stopCh)
+	f.configMapInformers.Start(stopCh)
+}
+
+func (f *configInformerFactory) WaitForCacheSync(stopCh <-chan struct{}) map[schema.GroupVersionResource]bool {
+	synced := f.DynamicSharedInformerFactory.WaitForCacheSync(stopCh)
+	for gvr, ok := range f.configMapInformers.WaitForCacheSync(stopCh) {
+		synced[gvr] = ok
+	}
+	return synced
+}
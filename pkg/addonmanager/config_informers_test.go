@@ -0,0 +1,65 @@
+package addonmanager
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/constants"
+)
+
+func TestConfigInformerFactory(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = addonapiv1alpha1.Install(scheme)
+
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(scheme,
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "cluster1",
+				Name:      "patches",
+				Labels:    map[string]string{constants.ConfigMapConfigLabelKey: "true"},
+			},
+		},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "cluster1", Name: "other"}},
+		&addonapiv1alpha1.AddOnDeploymentConfig{ObjectMeta: metav1.ObjectMeta{Namespace: "cluster1", Name: "config"}},
+	)
+
+	factory := newConfigInformerFactory(dynamicClient, 0)
+	configMapLister := factory.ForResource(configMapGVR).Lister()
+	configLister := factory.ForResource(addonapiv1alpha1.GroupVersion.WithResource("addondeploymentconfigs")).Lister()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	factory.Start(ctx.Done())
+	for gvr, synced := range factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			t.Fatalf("expected the informer of %v synced", gvr)
+		}
+	}
+
+	configMaps, err := configMapLister.List(labels.Everything())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configMaps) != 1 {
+		t.Errorf("expected only the configmap with the config label, but got %d configmaps", len(configMaps))
+	}
+	if _, err := configMapLister.ByNamespace("cluster1").Get("patches"); err != nil {
+		t.Errorf("expected the configmap with the config label, but got err %v", err)
+	}
+
+	configs, err := configLister.List(labels.Everything())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 1 {
+		t.Errorf("expected the configs of the other types not filtered, but got %d configs", len(configs))
+	}
+}
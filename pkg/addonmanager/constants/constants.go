@@ -14,6 +14,113 @@ const (
 	InstallModeDefault         = "Default"
 )
 
+const (
+	// AddonCSRSignedConditionType is the condition type of ManagedClusterAddOn reflecting whether the csr of the
+	// addon agent is signed by the customized signer of the addon.
+	AddonCSRSignedConditionType = "CSRSigned"
+	// AddonCSRSignedReasonSigned is the reason of the CSRSigned condition when the csr is signed.
+	AddonCSRSignedReasonSigned = "CSRSigned"
+	// AddonCSRSignedReasonSignFailed is the reason of the CSRSigned condition when the signer failed to
+	// sign the csr.
+	AddonCSRSignedReasonSignFailed = "CSRSignFailed"
+)
+
+const (
+	// RegistrationCertificateExpiryAnnotationKey is the annotation key of ManagedClusterAddOn recording the validity
+	// of the last issued client certificate of the addon agent per signer, the value is a json map from the signer
+	// name to the notBefore and notAfter of the certificate.
+	RegistrationCertificateExpiryAnnotationKey = "addon.open-cluster-management.io/registration-certificate-expiry"
+
+	// AddonCertificateExpiringConditionType is the condition type of ManagedClusterAddOn reflecting whether a client
+	// certificate of the addon agent is close to expiry while no renewal csr is created.
+	AddonCertificateExpiringConditionType = "RegistrationCertificateExpiring"
+	// AddonCertificateExpiringReasonExpiring is the reason of the RegistrationCertificateExpiring condition when a
+	// certificate is close to expiry and not renewed.
+	AddonCertificateExpiringReasonExpiring = "CertificateExpiring"
+	// AddonCertificateExpiringReasonValid is the reason of the RegistrationCertificateExpiring condition when all
+	// the certificates are valid or being renewed.
+	AddonCertificateExpiringReasonValid = "CertificateValid"
+)
+
+const (
+	// AddonServiceAccountTokenRequestableConditionType is the condition type of ManagedClusterAddOn reflecting
+	// whether the ServiceAccount of the addon agent registered with ServiceAccount token is created, and the token
+	// requesters are allowed to request its token.
+	AddonServiceAccountTokenRequestableConditionType = "ServiceAccountTokenRequestable"
+	// AddonServiceAccountTokenRequestableReasonRequestable is the reason of the ServiceAccountTokenRequestable
+	// condition when the token can be requested.
+	AddonServiceAccountTokenRequestableReasonRequestable = "TokenRequestable"
+	// AddonServiceAccountTokenRequestableReasonFailed is the reason of the ServiceAccountTokenRequestable
+	// condition when the hub failed to create the ServiceAccount or the permission to request its token.
+	AddonServiceAccountTokenRequestableReasonFailed = "TokenRequestSetupFailed"
+)
+
+const (
+	// AddonValuesValidConditionType is the condition type of ManagedClusterAddOn reflecting whether the values
+	// to render the manifests of the addon are valid, e.g. against the values schema of the addon.
+	AddonValuesValidConditionType = "ValuesValid"
+	// AddonValuesValidReasonValid is the reason of the ValuesValid condition when the values are valid.
+	AddonValuesValidReasonValid = "ValuesValid"
+	// AddonValuesValidReasonInvalid is the reason of the ValuesValid condition when the values are invalid,
+	// the message names the config or the annotation providing the invalid values.
+	AddonValuesValidReasonInvalid = "ValuesInvalid"
+)
+
+const (
+	// AddonConfigurationValidConditionType is the condition type of ManagedClusterAddOn and the install progressions
+	// of ClusterManagementAddOn reflecting whether the configs of the addon are valid, checked by the ConfigValidator
+	// of the addon. The manifests of the addon are not deployed while the configs are invalid.
+	AddonConfigurationValidConditionType = "ConfigurationValid"
+	// AddonConfigurationValidReasonValid is the reason of the ConfigurationValid condition when all the configs are
+	// valid.
+	AddonConfigurationValidReasonValid = "ConfigurationValid"
+	// AddonConfigurationValidReasonInvalid is the reason of the ConfigurationValid condition when any config is
+	// invalid, the message names each invalid config and its validation error. It is also the reason of the
+	// Progressing condition of an install progression whose rollout is blocked by the invalid configs.
+	AddonConfigurationValidReasonInvalid = "ConfigurationInvalid"
+)
+
+const (
+	// AddonConfigValuesSourcesAnnotationKey is the annotation key of ManagedClusterAddOn recording the configs each
+	// effective spec field came from, for the config types with a merge strategy of the addon. The value is a json
+	// map from the config type in the format of <resource>.<group> to the map from the spec field, the keys of the
+	// nested fields joined with ".", to the configs in the format of namespace/name, e.g.
+	// {"addondeploymentconfigs.addon.open-cluster-management.io": {"nodePlacement.nodeSelector.disk": ["cluster1/config2"]}}.
+	AddonConfigValuesSourcesAnnotationKey = "addon.open-cluster-management.io/config-values-sources"
+)
+
+const (
+	// ConfigMapConfigLabelKey is the label key of the ConfigMaps used as the configs of the addons, e.g. the
+	// ManifestPatches ConfigMaps, the value is "true". The addon manager only watches the ConfigMaps with the
+	// label, so the ConfigMaps without it are never treated as the configs.
+	ConfigMapConfigLabelKey = "addon.open-cluster-management.io/config"
+)
+
+const (
+	// AddonRolloutGatesAnnotationKey is the annotation key of ClusterManagementAddOn defining the gates of the
+	// rollout of the configs on each placement of the install strategy, the value is a json object with the
+	// optional fields minAvailableSeconds, maxFailures, progressDeadline and autoRollback, e.g.
+	// {"minAvailableSeconds": 60, "maxFailures": "10%", "progressDeadline": "10m", "autoRollback": true}.
+	AddonRolloutGatesAnnotationKey = "addon.open-cluster-management.io/rollout-gates"
+
+	// AddonConfigRollbackAnnotationKey is the annotation key of ManagedClusterAddOn recording the configs rolled back
+	// automatically on the failed rollout, the value is a json map from the config group resource to the failed
+	// config and the pinned previous config. The addon keeps the pinned config until the desired config of its
+	// placement is changed from the failed one, or the annotation is removed.
+	AddonConfigRollbackAnnotationKey = "addon.open-cluster-management.io/config-rollback"
+
+	// AddonRolloutPausedConditionType is the condition type of the install progressions of ClusterManagementAddOn
+	// reflecting whether the rollout on the placement is paused by the rollout gates. It is only set when the
+	// rollout gates are defined.
+	AddonRolloutPausedConditionType = "RolloutPaused"
+	// AddonRolloutPausedReasonFailureThresholdExceeded is the reason of the RolloutPaused condition when more addons
+	// failed than the maxFailures of the rollout gates, the message names each failed cluster and its failure.
+	AddonRolloutPausedReasonFailureThresholdExceeded = "FailureThresholdExceeded"
+	// AddonRolloutPausedReasonWithinFailureThreshold is the reason of the RolloutPaused condition when the failed
+	// addons do not exceed the maxFailures of the rollout gates.
+	AddonRolloutPausedReasonWithinFailureThreshold = "WithinFailureThreshold"
+)
+
 // DeployWorkNamePrefix returns the prefix of the work name for the addon
 func DeployWorkNamePrefix(addonName string) string {
 	return fmt.Sprintf("addon-%s-deploy", addonName)
@@ -34,6 +141,12 @@ func PreDeleteHookHostingWorkName(addonNamespace, addonName string) string {
 	return fmt.Sprintf("%s-hosting-%s", PreDeleteHookWorkName(addonName), addonNamespace)
 }
 
+// TokenWorkName return the name of the work which delivered the ServiceAccount token to the addon agent in the
+// previous versions, the work is deleted by the token controller since the agent requests the token itself.
+func TokenWorkName(addonName string) string {
+	return fmt.Sprintf("addon-%s-token", addonName)
+}
+
 // GetHostedModeInfo returns addon installation mode and hosting cluster name.
 func GetHostedModeInfo(annotations map[string]string) (string, string) {
 	hostingClusterName, ok := annotations[addonv1alpha1.HostingClusterNameAnnotationKey]
@@ -8,6 +8,7 @@ import (
 	jsonpatch "github.com/evanphx/json-patch"
 	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -19,13 +20,17 @@ import (
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
+	"k8s.io/utils/pointer"
 	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
 	addonv1alpha1client "open-cluster-management.io/api/client/addon/clientset/versioned"
 	addoninformerv1alpha1 "open-cluster-management.io/api/client/addon/informers/externalversions/addon/v1alpha1"
 	addonlisterv1alpha1 "open-cluster-management.io/api/client/addon/listers/addon/v1alpha1"
 
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/constants"
 	"open-cluster-management.io/addon-framework/pkg/addonmanager/controllers/managementaddonconfig"
+	"open-cluster-management.io/addon-framework/pkg/agent"
 	"open-cluster-management.io/addon-framework/pkg/basecontroller/factory"
+	"open-cluster-management.io/addon-framework/pkg/utils"
 )
 
 const (
@@ -42,6 +47,7 @@ type addonConfigController struct {
 	addonIndexer  cache.Indexer
 	configListers map[schema.GroupResource]dynamiclister.Lister
 	queue         workqueue.RateLimitingInterface
+	agentAddons   map[string]agent.AgentAddon
 }
 
 func NewAddonConfigController(
@@ -49,6 +55,7 @@ func NewAddonConfigController(
 	addonInformers addoninformerv1alpha1.ManagedClusterAddOnInformer,
 	configInformerFactory dynamicinformer.DynamicSharedInformerFactory,
 	configGVRs map[schema.GroupVersionResource]bool,
+	agentAddons map[string]agent.AgentAddon,
 ) factory.Controller {
 	syncCtx := factory.NewSyncContext(controllerName)
 
@@ -58,6 +65,7 @@ func NewAddonConfigController(
 		addonIndexer:  addonInformers.Informer().GetIndexer(),
 		configListers: map[schema.GroupResource]dynamiclister.Lister{},
 		queue:         syncCtx.Queue(),
+		agentAddons:   agentAddons,
 	}
 
 	configInformers := c.buildConfigInformers(configInformerFactory, configGVRs)
@@ -167,7 +175,19 @@ func (c *addonConfigController) sync(ctx context.Context, syncCtx factory.SyncCo
 		return err
 	}
 
-	return c.patchConfigReferences(ctx, addon, addonCopy)
+	if err := c.updateConfigurationValidCondition(addonCopy); err != nil {
+		return err
+	}
+
+	if err := c.patchConfigReferences(ctx, addon, addonCopy); err != nil {
+		return err
+	}
+
+	sources, err := c.configValuesSources(addonCopy)
+	if err != nil {
+		return err
+	}
+	return c.patchConfigValuesSources(ctx, addon, sources)
 }
 
 func (c *addonConfigController) updateConfigSpecHashAndGenerations(addon *addonapiv1alpha1.ManagedClusterAddOn) error {
@@ -176,26 +196,13 @@ func (c *addonConfigController) updateConfigSpecHashAndGenerations(addon *addona
 		supportedConfigSet[config] = true
 	}
 	for index, configReference := range addon.Status.ConfigReferences {
-		lister, ok := c.configListers[schema.GroupResource{Group: configReference.ConfigGroupResource.Group, Resource: configReference.ConfigGroupResource.Resource}]
-		if !ok {
-			continue
-		}
-
-		var config *unstructured.Unstructured
-		var err error
-		if configReference.Namespace == "" {
-			config, err = lister.Get(configReference.Name)
-		} else {
-			config, err = lister.Namespace(configReference.Namespace).Get(configReference.Name)
-		}
-
-		if errors.IsNotFound(err) {
-			continue
-		}
-
+		config, err := c.getConfig(configReference)
 		if err != nil {
 			return err
 		}
+		if config == nil {
+			continue
+		}
 
 		// update LastObservedGeneration for all the configs in status
 		addon.Status.ConfigReferences[index].LastObservedGeneration = config.GetGeneration()
@@ -222,14 +229,160 @@ func (c *addonConfigController) updateConfigSpecHashAndGenerations(addon *addona
 	return nil
 }
 
+// updateConfigurationValidCondition validates the configs of the addon with the ConfigValidator of the addon, and
+// sets the ConfigurationValid condition of the addon.
+func (c *addonConfigController) updateConfigurationValidCondition(addon *addonapiv1alpha1.ManagedClusterAddOn) error {
+	agentAddon, ok := c.agentAddons[addon.Name]
+	if !ok {
+		return nil
+	}
+	validator := agentAddon.GetAgentAddonOptions().ConfigValidator
+	if validator == nil {
+		return nil
+	}
+
+	var invalidConfigs []string
+	for _, configReference := range addon.Status.ConfigReferences {
+		config, err := c.getConfig(configReference)
+		if err != nil {
+			return err
+		}
+		if config == nil {
+			continue
+		}
+		if message := managementaddonconfig.ValidateConfig(
+			validator, configReference.ConfigGroupResource, config); len(message) > 0 {
+			invalidConfigs = append(invalidConfigs, message)
+		}
+	}
+	meta.SetStatusCondition(&addon.Status.Conditions, managementaddonconfig.NewConfigurationValidCondition(invalidConfigs))
+	return nil
+}
+
+// configValuesSources merges the specs of the configs of each config type with a merge strategy of the addon, in
+// the order of the config references in the status, and returns the configs each effective spec field came from,
+// keyed by the config type in the format of <resource>.<group>.
+func (c *addonConfigController) configValuesSources(
+	addon *addonapiv1alpha1.ManagedClusterAddOn) (map[string]map[string][]string, error) {
+	agentAddon, ok := c.agentAddons[addon.Name]
+	if !ok {
+		return nil, nil
+	}
+	strategies := agentAddon.GetAgentAddonOptions().ConfigMergeStrategies
+	if len(strategies) == 0 {
+		return nil, nil
+	}
+
+	merged := map[string]map[string]interface{}{}
+	sources := map[string]map[string][]string{}
+	for _, configReference := range addon.Status.ConfigReferences {
+		strategy, ok := strategies[configReference.ConfigGroupResource]
+		if !ok {
+			continue
+		}
+		config, err := c.getConfig(configReference)
+		if err != nil {
+			return nil, err
+		}
+		if config == nil {
+			continue
+		}
+		spec, _, err := unstructured.NestedMap(config.Object, "spec")
+		if err != nil {
+			return nil, err
+		}
+
+		configType := fmt.Sprintf("%s.%s", configReference.Resource, configReference.Group)
+		if _, ok := sources[configType]; !ok {
+			sources[configType] = map[string][]string{}
+		}
+		source := config.GetName()
+		if len(config.GetNamespace()) > 0 {
+			source = config.GetNamespace() + "/" + source
+		}
+		merged[configType], err = utils.MergeValuesWithSources(
+			strategy, merged[configType], spec, source, sources[configType])
+		if err != nil {
+			return nil, fmt.Errorf("failed to merge the configs %s of addon %s/%s: %v",
+				configType, addon.Namespace, addon.Name, err)
+		}
+	}
+	return sources, nil
+}
+
+// patchConfigValuesSources records the sources in the annotation of the addon, the annotation is removed if there
+// are no sources.
+func (c *addonConfigController) patchConfigValuesSources(ctx context.Context,
+	addon *addonapiv1alpha1.ManagedClusterAddOn, sources map[string]map[string][]string) error {
+	existing, ok := addon.Annotations[constants.AddonConfigValuesSourcesAnnotationKey]
+
+	var value *string
+	if len(sources) > 0 {
+		data, err := json.Marshal(sources)
+		if err != nil {
+			return err
+		}
+		if existing == string(data) {
+			return nil
+		}
+		value = pointer.String(string(data))
+	} else if !ok {
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"uid": addon.UID,
+			"annotations": map[string]*string{
+				constants.AddonConfigValuesSourcesAnnotationKey: value,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	klog.V(4).Infof("Patching addon %s/%s config values sources with %s", addon.Namespace, addon.Name, string(patch))
+	_, err = c.addonClient.AddonV1alpha1().ManagedClusterAddOns(addon.Namespace).Patch(
+		ctx, addon.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+// getConfig returns the config of the config reference from the lister, nil is returned if the config type is not
+// watched or the config is not found.
+func (c *addonConfigController) getConfig(
+	configReference addonapiv1alpha1.ConfigReference) (*unstructured.Unstructured, error) {
+	lister, ok := c.configListers[schema.GroupResource{Group: configReference.ConfigGroupResource.Group, Resource: configReference.ConfigGroupResource.Resource}]
+	if !ok {
+		return nil, nil
+	}
+
+	var config *unstructured.Unstructured
+	var err error
+	if configReference.Namespace == "" {
+		config, err = lister.Get(configReference.Name)
+	} else {
+		config, err = lister.Namespace(configReference.Namespace).Get(configReference.Name)
+	}
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
 func (c *addonConfigController) patchConfigReferences(ctx context.Context, old, new *addonapiv1alpha1.ManagedClusterAddOn) error {
-	if equality.Semantic.DeepEqual(new.Status.ConfigReferences, old.Status.ConfigReferences) {
+	if equality.Semantic.DeepEqual(new.Status.ConfigReferences, old.Status.ConfigReferences) &&
+		equality.Semantic.DeepEqual(new.Status.Conditions, old.Status.Conditions) {
 		return nil
 	}
 
 	oldData, err := json.Marshal(&addonapiv1alpha1.ManagedClusterAddOn{
 		Status: addonapiv1alpha1.ManagedClusterAddOnStatus{
 			ConfigReferences: old.Status.ConfigReferences,
+			Conditions:       old.Status.Conditions,
 		},
 	})
 	if err != nil {
@@ -243,6 +396,7 @@ func (c *addonConfigController) patchConfigReferences(ctx context.Context, old,
 		},
 		Status: addonapiv1alpha1.ManagedClusterAddOnStatus{
 			ConfigReferences: new.Status.ConfigReferences,
+			Conditions:       new.Status.Conditions,
 		},
 	})
 	if err != nil {
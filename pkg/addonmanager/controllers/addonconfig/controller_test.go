@@ -3,9 +3,13 @@ package addonconfig
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"reflect"
 	"testing"
 	"time"
 
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -15,9 +19,12 @@ import (
 	clienttesting "k8s.io/client-go/testing"
 	"k8s.io/client-go/tools/cache"
 	"open-cluster-management.io/addon-framework/pkg/addonmanager/addontesting"
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/constants"
+	"open-cluster-management.io/addon-framework/pkg/agent"
 	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
 	fakeaddon "open-cluster-management.io/api/client/addon/clientset/versioned/fake"
 	addoninformers "open-cluster-management.io/api/client/addon/informers/externalversions"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
 )
 
 var fakeGVR = schema.GroupVersionResource{
@@ -601,3 +608,229 @@ func newTestConfing(name, namespace string, generation int64) *unstructured.Unst
 		},
 	}
 }
+
+type testAgent struct {
+	name       string
+	validator  agent.ConfigValidatorFunc
+	strategies map[addonapiv1alpha1.ConfigGroupResource]agent.ConfigMergeStrategy
+}
+
+func (t *testAgent) Manifests(cluster *clusterv1.ManagedCluster,
+	addon *addonapiv1alpha1.ManagedClusterAddOn) ([]runtime.Object, error) {
+	return nil, nil
+}
+
+func (t *testAgent) GetAgentAddonOptions() agent.AgentAddonOptions {
+	return agent.AgentAddonOptions{AddonName: t.name, ConfigValidator: t.validator, ConfigMergeStrategies: t.strategies}
+}
+
+func TestSyncConfigurationValid(t *testing.T) {
+	validator := func(config *unstructured.Unstructured) error {
+		value, _, _ := unstructured.NestedString(config.Object, "spec", "test")
+		if value != "valid" {
+			return fmt.Errorf("spec.test should be valid")
+		}
+		return nil
+	}
+
+	cases := []struct {
+		name            string
+		configValue     string
+		expectedStatus  metav1.ConditionStatus
+		expectedReason  string
+		expectedMessage string
+	}{
+		{
+			name:            "valid config",
+			configValue:     "valid",
+			expectedStatus:  metav1.ConditionTrue,
+			expectedReason:  constants.AddonConfigurationValidReasonValid,
+			expectedMessage: "the configs of addon are valid",
+		},
+		{
+			name:            "invalid config",
+			configValue:     "test",
+			expectedStatus:  metav1.ConditionFalse,
+			expectedReason:  constants.AddonConfigurationValidReasonInvalid,
+			expectedMessage: "invalid configs: configs.configs.test cluster1/test: spec.test should be valid",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			addon := addontesting.NewAddon("test", "cluster1")
+			addon.Status.ConfigReferences = []addonapiv1alpha1.ConfigReference{
+				{
+					ConfigGroupResource: addonapiv1alpha1.ConfigGroupResource{Group: fakeGVR.Group, Resource: fakeGVR.Resource},
+					ConfigReferent:      addonapiv1alpha1.ConfigReferent{Namespace: "cluster1", Name: "test"},
+				},
+			}
+			config := newTestConfing("test", "cluster1", 0)
+			if err := unstructured.SetNestedField(config.Object, c.configValue, "spec", "test"); err != nil {
+				t.Fatal(err)
+			}
+
+			fakeAddonClient := fakeaddon.NewSimpleClientset(addon)
+			addonInformers := addoninformers.NewSharedInformerFactory(fakeAddonClient, 10*time.Minute)
+			if err := addonInformers.Addon().V1alpha1().ManagedClusterAddOns().Informer().GetStore().Add(addon); err != nil {
+				t.Fatal(err)
+			}
+			configInformerFactory := dynamicinformer.NewDynamicSharedInformerFactory(
+				dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()), 0)
+			if err := configInformerFactory.ForResource(fakeGVR).Informer().GetStore().Add(config); err != nil {
+				t.Fatal(err)
+			}
+
+			ctrl := &addonConfigController{
+				addonClient:   fakeAddonClient,
+				addonLister:   addonInformers.Addon().V1alpha1().ManagedClusterAddOns().Lister(),
+				configListers: map[schema.GroupResource]dynamiclister.Lister{},
+				agentAddons:   map[string]agent.AgentAddon{"test": &testAgent{name: "test", validator: validator}},
+			}
+			ctrl.buildConfigInformers(configInformerFactory, map[schema.GroupVersionResource]bool{fakeGVR: true})
+
+			if err := ctrl.sync(context.TODO(), addontesting.NewFakeSyncContext(t), "cluster1/test"); err != nil {
+				t.Fatalf("expected no error when sync: %v", err)
+			}
+
+			actions := fakeAddonClient.Actions()
+			addontesting.AssertActions(t, actions, "patch")
+			patchedAddon := &addonapiv1alpha1.ManagedClusterAddOn{}
+			if err := json.Unmarshal(actions[0].(clienttesting.PatchActionImpl).Patch, patchedAddon); err != nil {
+				t.Fatal(err)
+			}
+			cond := meta.FindStatusCondition(patchedAddon.Status.Conditions, constants.AddonConfigurationValidConditionType)
+			if cond == nil {
+				t.Fatalf("expected ConfigurationValid condition, but got %v", patchedAddon.Status.Conditions)
+			}
+			if cond.Status != c.expectedStatus || cond.Reason != c.expectedReason || cond.Message != c.expectedMessage {
+				t.Errorf("expected condition %s %s %q, but got %v", c.expectedStatus, c.expectedReason, c.expectedMessage, cond)
+			}
+		})
+	}
+}
+
+func TestSyncConfigValuesSources(t *testing.T) {
+	configGR := addonapiv1alpha1.ConfigGroupResource{Group: fakeGVR.Group, Resource: fakeGVR.Resource}
+	newConfig := func(name string, spec map[string]interface{}) *unstructured.Unstructured {
+		config := newTestConfing(name, "cluster1", 1)
+		config.Object["spec"] = spec
+		return config
+	}
+	configs := []runtime.Object{
+		newConfig("config1", map[string]interface{}{
+			"test":         "a",
+			"nodeSelector": map[string]interface{}{"os": "linux", "disk": "ssd"},
+			"tolerations":  []interface{}{"foo"},
+		}),
+		newConfig("config2", map[string]interface{}{
+			"nodeSelector": map[string]interface{}{"disk": "hdd"},
+			"tolerations":  []interface{}{"bar"},
+		}),
+	}
+
+	cases := []struct {
+		name            string
+		strategies      map[addonapiv1alpha1.ConfigGroupResource]agent.ConfigMergeStrategy
+		annotations     map[string]string
+		expectedSources map[string]map[string][]string
+		expectedRemoved bool
+	}{
+		{
+			name:       "no merge strategy",
+			strategies: nil,
+		},
+		{
+			name:       "replace",
+			strategies: map[addonapiv1alpha1.ConfigGroupResource]agent.ConfigMergeStrategy{configGR: agent.ConfigMergeStrategyReplace},
+			expectedSources: map[string]map[string][]string{"configs.configs.test": {
+				"test":         {"cluster1/config1"},
+				"nodeSelector": {"cluster1/config2"},
+				"tolerations":  {"cluster1/config2"},
+			}},
+		},
+		{
+			name:       "append lists",
+			strategies: map[addonapiv1alpha1.ConfigGroupResource]agent.ConfigMergeStrategy{configGR: agent.ConfigMergeStrategyAppendLists},
+			expectedSources: map[string]map[string][]string{"configs.configs.test": {
+				"test":              {"cluster1/config1"},
+				"nodeSelector.os":   {"cluster1/config1"},
+				"nodeSelector.disk": {"cluster1/config2"},
+				"tolerations":       {"cluster1/config1", "cluster1/config2"},
+			}},
+		},
+		{
+			name:            "merge strategy removed",
+			annotations:     map[string]string{constants.AddonConfigValuesSourcesAnnotationKey: "{}"},
+			expectedRemoved: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			addon := addontesting.NewAddon("test", "cluster1")
+			addon.Annotations = c.annotations
+			for _, name := range []string{"config1", "config2"} {
+				addon.Status.ConfigReferences = append(addon.Status.ConfigReferences, addonapiv1alpha1.ConfigReference{
+					ConfigGroupResource:    configGR,
+					ConfigReferent:         addonapiv1alpha1.ConfigReferent{Namespace: "cluster1", Name: name},
+					LastObservedGeneration: 1,
+				})
+			}
+
+			fakeAddonClient := fakeaddon.NewSimpleClientset(addon)
+			addonInformers := addoninformers.NewSharedInformerFactory(fakeAddonClient, 10*time.Minute)
+			if err := addonInformers.Addon().V1alpha1().ManagedClusterAddOns().Informer().GetStore().Add(addon); err != nil {
+				t.Fatal(err)
+			}
+			configInformerFactory := dynamicinformer.NewDynamicSharedInformerFactory(
+				dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()), 0)
+			for _, config := range configs {
+				if err := configInformerFactory.ForResource(fakeGVR).Informer().GetStore().Add(config); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			ctrl := &addonConfigController{
+				addonClient:   fakeAddonClient,
+				addonLister:   addonInformers.Addon().V1alpha1().ManagedClusterAddOns().Lister(),
+				configListers: map[schema.GroupResource]dynamiclister.Lister{},
+				agentAddons:   map[string]agent.AgentAddon{"test": &testAgent{name: "test", strategies: c.strategies}},
+			}
+			ctrl.buildConfigInformers(configInformerFactory, map[schema.GroupVersionResource]bool{fakeGVR: true})
+
+			if err := ctrl.sync(context.TODO(), addontesting.NewFakeSyncContext(t), "cluster1/test"); err != nil {
+				t.Fatalf("expected no error when sync: %v", err)
+			}
+
+			actions := fakeAddonClient.Actions()
+			if c.expectedSources == nil && !c.expectedRemoved {
+				addontesting.AssertNoActions(t, actions)
+				return
+			}
+			addontesting.AssertActions(t, actions, "patch")
+			patch := struct {
+				Metadata struct {
+					Annotations map[string]*string `json:"annotations"`
+				} `json:"metadata"`
+			}{}
+			if err := json.Unmarshal(actions[0].(clienttesting.PatchActionImpl).Patch, &patch); err != nil {
+				t.Fatal(err)
+			}
+			value := patch.Metadata.Annotations[constants.AddonConfigValuesSourcesAnnotationKey]
+			if c.expectedRemoved {
+				if value != nil {
+					t.Errorf("expected the annotation removed, but got %s", *value)
+				}
+				return
+			}
+			sources := map[string]map[string][]string{}
+			if err := json.Unmarshal([]byte(*value), &sources); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(sources, c.expectedSources) {
+				t.Errorf("expected sources %v, but got %v", c.expectedSources, sources)
+			}
+		})
+	}
+}
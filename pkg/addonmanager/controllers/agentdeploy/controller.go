@@ -3,6 +3,7 @@ package agentdeploy
 import (
 	"context"
 	"encoding/json"
+	goerrors "errors"
 	"fmt"
 	"strings"
 
@@ -11,6 +12,7 @@ import (
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	errorsutil "k8s.io/apimachinery/pkg/util/errors"
@@ -151,6 +153,20 @@ func (c *addonDeployController) getWorksByAddonFn(index string) func(addonName,
 	}
 }
 
+func (c *addonDeployController) enqueueAddonOnAllClusters(syncCtx factory.SyncContext, addonName string) error {
+	addons, err := c.managedClusterAddonLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	for _, addon := range addons {
+		if addon.Name != addonName {
+			continue
+		}
+		syncCtx.Queue().Add(fmt.Sprintf("%s/%s", addon.Namespace, addon.Name))
+	}
+	return nil
+}
+
 func (c *addonDeployController) sync(ctx context.Context, syncCtx factory.SyncContext, key string) error {
 	clusterName, addonName, err := cache.SplitMetaNamespaceKey(key)
 	if err != nil {
@@ -163,6 +179,11 @@ func (c *addonDeployController) sync(ctx context.Context, syncCtx factory.SyncCo
 		return nil
 	}
 
+	// the key without the cluster name is to trigger the addon on all the clusters, e.g. the agentAddon is reloaded.
+	if len(clusterName) == 0 {
+		return c.enqueueAddonOnAllClusters(syncCtx, addonName)
+	}
+
 	addon, err := c.managedClusterAddonLister.ManagedClusterAddOns(clusterName).Get(addonName)
 	if errors.IsNotFound(err) {
 		// need to find a way to clean up cache by addon
@@ -344,7 +365,15 @@ func (c *addonDeployController) buildDeployManifestWorks(installMode, workNamesp
 		return nil, nil, fmt.Errorf("invalid install mode %v", installMode)
 	}
 
+	// the manifests rendered with the invalid configs are not deployed, and the deployed works are kept until the
+	// configs are fixed.
+	if meta.IsStatusConditionFalse(addon.Status.Conditions, constants.AddonConfigurationValidConditionType) {
+		klog.V(4).Infof("skip deploying the addon %s/%s since its configs are invalid", addon.Namespace, addon.Name)
+		return nil, nil, nil
+	}
+
 	objects, err := agentAddon.Manifests(cluster, addon)
+	setValuesValidCondition(addon, err)
 	if err != nil {
 		meta.SetStatusCondition(&addon.Status.Conditions, metav1.Condition{
 			Type:    appliedType,
@@ -397,6 +426,7 @@ func (c *addonDeployController) buildHookManifestWork(installMode, workNamespace
 	}
 
 	objects, err := agentAddon.Manifests(cluster, addon)
+	setValuesValidCondition(addon, err)
 	if err != nil {
 		meta.SetStatusCondition(&addon.Status.Conditions, metav1.Condition{
 			Type:    appliedType,
@@ -422,3 +452,26 @@ func (c *addonDeployController) buildHookManifestWork(installMode, workNamespace
 	}
 	return hookWork, nil
 }
+
+// setValuesValidCondition sets the ValuesValid condition of the addon to false if the values of the addon are
+// invalid, and back to true once the manifests are rendered. The condition is not added for the other errors.
+func setValuesValidCondition(addon *addonapiv1alpha1.ManagedClusterAddOn, manifestsErr error) {
+	var invalidValuesErr *agent.InvalidValuesError
+	switch {
+	case goerrors.As(manifestsErr, &invalidValuesErr):
+		meta.SetStatusCondition(&addon.Status.Conditions, metav1.Condition{
+			Type:    constants.AddonValuesValidConditionType,
+			Status:  metav1.ConditionFalse,
+			Reason:  constants.AddonValuesValidReasonInvalid,
+			Message: invalidValuesErr.Error(),
+		})
+	case manifestsErr == nil &&
+		meta.FindStatusCondition(addon.Status.Conditions, constants.AddonValuesValidConditionType) != nil:
+		meta.SetStatusCondition(&addon.Status.Conditions, metav1.Condition{
+			Type:    constants.AddonValuesValidConditionType,
+			Status:  metav1.ConditionTrue,
+			Reason:  constants.AddonValuesValidReasonValid,
+			Message: "the values of addon are valid",
+		})
+	}
+}
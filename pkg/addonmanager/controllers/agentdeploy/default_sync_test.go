@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
@@ -25,6 +27,7 @@ import (
 	workapiv1 "open-cluster-management.io/api/work/v1"
 
 	"open-cluster-management.io/addon-framework/pkg/addonmanager/addontesting"
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/constants"
 	"open-cluster-management.io/addon-framework/pkg/agent"
 )
 
@@ -105,6 +108,22 @@ func TestDefaultReconcile(t *testing.T) {
 				addontesting.AssertActions(t, actions, "create")
 			},
 		},
+		{
+			name: "do not deploy manifests for an addon with invalid configs",
+			key:  "cluster1/test",
+			addon: []runtime.Object{addontesting.NewAddonWithConditions("test", "cluster1", registrationAppliedCondition,
+				metav1.Condition{
+					Type:   constants.AddonConfigurationValidConditionType,
+					Status: metav1.ConditionFalse,
+					Reason: constants.AddonConfigurationValidReasonInvalid,
+				})},
+			cluster: []runtime.Object{addontesting.NewManagedCluster("cluster1")},
+			testaddon: &testAgent{name: "test", objects: []runtime.Object{
+				addontesting.NewUnstructured("v1", "ConfigMap", "default", "test"),
+			}},
+			validateAddonActions: addontesting.AssertNoActions,
+			validateWorkActions:  addontesting.AssertNoActions,
+		},
 		{
 			name:    "update manifest for an addon",
 			key:     "cluster1/test",
@@ -241,6 +260,64 @@ func TestDefaultReconcile(t *testing.T) {
 				}
 			},
 		},
+		{
+			name:    "get invalid values error when run manifest from agent",
+			key:     "cluster1/test",
+			addon:   []runtime.Object{addontesting.NewAddonWithConditions("test", "cluster1", registrationAppliedCondition)},
+			cluster: []runtime.Object{addontesting.NewManagedCluster("cluster1")},
+			testaddon: &testAgent{
+				name: "test",
+				err: &agent.InvalidValuesError{
+					Source: "annotation addon.open-cluster-management.io/values",
+					Err:    fmt.Errorf("replicas: Invalid type. Expected: integer, given: string"),
+				},
+			},
+			validateWorkActions: addontesting.AssertNoActions,
+			validateAddonActions: func(t *testing.T, actions []clienttesting.Action) {
+				addontesting.AssertActions(t, actions, "patch")
+				patch := actions[0].(clienttesting.PatchActionImpl).Patch
+				addOn := &addonapiv1alpha1.ManagedClusterAddOn{}
+				err := json.Unmarshal(patch, addOn)
+				if err != nil {
+					t.Fatal(err)
+				}
+				cond := meta.FindStatusCondition(addOn.Status.Conditions, constants.AddonValuesValidConditionType)
+				if cond == nil || cond.Status != metav1.ConditionFalse ||
+					cond.Reason != constants.AddonValuesValidReasonInvalid ||
+					!strings.Contains(cond.Message, "annotation addon.open-cluster-management.io/values") {
+					t.Errorf("ValuesValid condition is not correct: %v", addOn.Status.Conditions)
+				}
+			},
+		},
+		{
+			name: "values valid again",
+			key:  "cluster1/test",
+			addon: []runtime.Object{addontesting.NewAddonWithConditions("test", "cluster1", registrationAppliedCondition,
+				metav1.Condition{
+					Type:   constants.AddonValuesValidConditionType,
+					Status: metav1.ConditionFalse,
+					Reason: constants.AddonValuesValidReasonInvalid,
+				})},
+			cluster: []runtime.Object{addontesting.NewManagedCluster("cluster1")},
+			testaddon: &testAgent{name: "test", objects: []runtime.Object{
+				addontesting.NewUnstructured("v1", "ConfigMap", "default", "test"),
+			}},
+			validateWorkActions: func(t *testing.T, actions []clienttesting.Action) {
+				addontesting.AssertActions(t, actions, "create")
+			},
+			validateAddonActions: func(t *testing.T, actions []clienttesting.Action) {
+				addontesting.AssertActions(t, actions, "patch")
+				patch := actions[0].(clienttesting.PatchActionImpl).Patch
+				addOn := &addonapiv1alpha1.ManagedClusterAddOn{}
+				err := json.Unmarshal(patch, addOn)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !meta.IsStatusConditionTrue(addOn.Status.Conditions, constants.AddonValuesValidConditionType) {
+					t.Errorf("ValuesValid condition is not correct: %v", addOn.Status.Conditions)
+				}
+			},
+		},
 	}
 
 	for _, c := range cases {
@@ -304,3 +381,42 @@ func TestDefaultReconcile(t *testing.T) {
 		})
 	}
 }
+
+func TestSyncAddonOnAllClusters(t *testing.T) {
+	fakeAddonClient := fakeaddon.NewSimpleClientset()
+	addonInformers := addoninformers.NewSharedInformerFactory(fakeAddonClient, 10*time.Minute)
+	for _, addon := range []*addonapiv1alpha1.ManagedClusterAddOn{
+		addontesting.NewAddon("test", "cluster1"),
+		addontesting.NewAddon("test", "cluster2"),
+		addontesting.NewAddon("other", "cluster1"),
+	} {
+		if err := addonInformers.Addon().V1alpha1().ManagedClusterAddOns().Informer().GetStore().Add(addon); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	controller := addonDeployController{
+		addonClient:               fakeAddonClient,
+		managedClusterAddonLister: addonInformers.Addon().V1alpha1().ManagedClusterAddOns().Lister(),
+		agentAddons:               map[string]agent.AgentAddon{"test": &testAgent{name: "test"}},
+	}
+
+	syncContext := addontesting.NewFakeSyncContext(t)
+	if err := controller.sync(context.TODO(), syncContext, "/test"); err != nil {
+		t.Fatalf("expected no error, got err %v", err)
+	}
+
+	var keys []string
+	for syncContext.Queue().Len() > 0 {
+		key, _ := syncContext.Queue().Get()
+		keys = append(keys, key.(string))
+		syncContext.Queue().Done(key)
+	}
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != "cluster1/test,cluster2/test" {
+		t.Errorf("expected the addon enqueued on all the clusters, but got %v", keys)
+	}
+	if len(fakeAddonClient.Actions()) != 0 {
+		t.Errorf("expected no actions, but got %v", fakeAddonClient.Actions())
+	}
+}
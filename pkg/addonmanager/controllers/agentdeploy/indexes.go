@@ -71,6 +71,11 @@ func extractAddonFromWork(work *workapiv1.ManifestWork) (string, string, bool) {
 		return "", "", false
 	}
 
+	// the work delivering the ServiceAccount token of the addon agent is not managed by this controller.
+	if work.Name == constants.TokenWorkName(addonName) {
+		return "", "", false
+	}
+
 	addonNamespace := work.Labels[addonapiv1alpha1.AddonNamespaceLabelKey]
 
 	isHook := false
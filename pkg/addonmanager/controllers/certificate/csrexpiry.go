@@ -0,0 +1,335 @@
+package certificate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	certificatesv1 "k8s.io/api/certificates/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	certificatesinformers "k8s.io/client-go/informers/certificates/v1"
+	certificateslisters "k8s.io/client-go/listers/certificates/v1"
+	"k8s.io/client-go/tools/cache"
+	certutil "k8s.io/client-go/util/cert"
+	"k8s.io/klog/v2"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	addonv1alpha1client "open-cluster-management.io/api/client/addon/clientset/versioned"
+	addoninformerv1alpha1 "open-cluster-management.io/api/client/addon/informers/externalversions/addon/v1alpha1"
+	addonlisterv1alpha1 "open-cluster-management.io/api/client/addon/listers/addon/v1alpha1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/constants"
+	"open-cluster-management.io/addon-framework/pkg/agent"
+	"open-cluster-management.io/addon-framework/pkg/basecontroller/factory"
+	"open-cluster-management.io/addon-framework/pkg/utils"
+)
+
+const defaultCertificateExpiryWarningPercentage = 20
+
+// CertificateValidity is the validity of a client certificate issued to the addon agent.
+type CertificateValidity struct {
+	NotBefore metav1.Time `json:"notBefore"`
+	NotAfter  metav1.Time `json:"notAfter"`
+}
+
+// GetRegistrationCertificateExpiry returns the validity of the last issued client certificates of the addon agent
+// recorded on the ManagedClusterAddOn, keyed by the signer name.
+func GetRegistrationCertificateExpiry(addon *addonapiv1alpha1.ManagedClusterAddOn) (map[string]CertificateValidity, error) {
+	validities := map[string]CertificateValidity{}
+	value, ok := addon.Annotations[constants.RegistrationCertificateExpiryAnnotationKey]
+	if !ok || len(value) == 0 {
+		return validities, nil
+	}
+	if err := json.Unmarshal([]byte(value), &validities); err != nil {
+		return nil, err
+	}
+	return validities, nil
+}
+
+// csrExpiryController records the expiry of the client certificates issued to the addon agents, and warns
+// when a certificate is close to expiry while no renewal csr is created. The issued csrs are garbage collected
+// by the kube-controller-manager, so the last seen validity is persisted in the annotation of the addon.
+type csrExpiryController struct {
+	addonClient               addonv1alpha1client.Interface
+	agentAddons               map[string]agent.AgentAddon
+	managedClusterAddonLister addonlisterv1alpha1.ManagedClusterAddOnLister
+	csrLister                 certificateslisters.CertificateSigningRequestLister
+
+	// signers records the signers reported in metrics per addon, so the metrics can be cleaned up
+	// when the addon is deleted.
+	signers     map[string]sets.String
+	signersLock sync.Mutex
+}
+
+// NewCSRExpiryController creates a new csr expiry controller
+func NewCSRExpiryController(
+	addonClient addonv1alpha1client.Interface,
+	csrInformer certificatesinformers.CertificateSigningRequestInformer,
+	addonInformers addoninformerv1alpha1.ManagedClusterAddOnInformer,
+	agentAddons map[string]agent.AgentAddon,
+) factory.Controller {
+	c := &csrExpiryController{
+		addonClient:               addonClient,
+		agentAddons:               agentAddons,
+		managedClusterAddonLister: addonInformers.Lister(),
+		csrLister:                 csrInformer.Lister(),
+		signers:                   map[string]sets.String{},
+	}
+	return factory.New().
+		WithFilteredEventsInformersQueueKeysFunc(
+			func(obj runtime.Object) []string {
+				accessor, _ := meta.Accessor(obj)
+				return []string{fmt.Sprintf("%s/%s",
+					accessor.GetLabels()[clusterv1.ClusterNameLabelKey], accessor.GetLabels()[addonapiv1alpha1.AddonLabelKey])}
+			},
+			func(obj interface{}) bool {
+				accessor, _ := meta.Accessor(obj)
+				if !strings.HasPrefix(accessor.GetName(), "addon") {
+					return false
+				}
+				if len(accessor.GetLabels()[clusterv1.ClusterNameLabelKey]) == 0 {
+					return false
+				}
+				addonName := accessor.GetLabels()[addonapiv1alpha1.AddonLabelKey]
+				if _, ok := agentAddons[addonName]; !ok {
+					return false
+				}
+				return true
+			},
+			csrInformer.Informer()).
+		WithFilteredEventsInformersQueueKeysFunc(
+			func(obj runtime.Object) []string {
+				key, _ := cache.MetaNamespaceKeyFunc(obj)
+				return []string{key}
+			},
+			func(obj interface{}) bool {
+				accessor, _ := meta.Accessor(obj)
+				if _, ok := agentAddons[accessor.GetName()]; !ok {
+					return false
+				}
+				return true
+			},
+			addonInformers.Informer()).
+		WithSync(c.sync).
+		ToController("CSRExpiryController")
+}
+
+func (c *csrExpiryController) sync(ctx context.Context, syncCtx factory.SyncContext, key string) error {
+	klog.V(4).Infof("Reconciling certificate expiry of addon %q", key)
+
+	clusterName, addonName, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		// ignore addon whose key is not in format: namespace/name
+		return nil
+	}
+
+	agentAddon, ok := c.agentAddons[addonName]
+	if !ok {
+		return nil
+	}
+
+	addon, err := c.managedClusterAddonLister.ManagedClusterAddOns(clusterName).Get(addonName)
+	if errors.IsNotFound(err) {
+		c.cleanupMetrics(clusterName, addonName)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	registrationOption := agentAddon.GetAgentAddonOptions().Registration
+	if registrationOption == nil || registrationOption.ServiceAccountToken != nil {
+		return nil
+	}
+	if !addon.DeletionTimestamp.IsZero() {
+		c.cleanupMetrics(clusterName, addonName)
+		return nil
+	}
+
+	validities, err := GetRegistrationCertificateExpiry(addon)
+	if err != nil {
+		klog.Warningf("Ignore the invalid certificate expiry annotation of addon %q: %v", key, err)
+		validities = map[string]CertificateValidity{}
+	}
+
+	csrs, err := c.csrLister.List(labels.SelectorFromSet(labels.Set{
+		addonapiv1alpha1.AddonLabelKey: addonName,
+		clusterv1.ClusterNameLabelKey:  clusterName,
+	}))
+	if err != nil {
+		return err
+	}
+
+	// update the validity with the latest issued certificate of each signer.
+	for _, csr := range csrs {
+		if len(csr.Status.Certificate) == 0 {
+			continue
+		}
+		certs, err := certutil.ParseCertsPEM(csr.Status.Certificate)
+		if err != nil || len(certs) == 0 {
+			klog.V(4).Infof("Ignore the invalid certificate of csr %q: %v", csr.Name, err)
+			continue
+		}
+		validity, ok := validities[csr.Spec.SignerName]
+		if ok && !certs[0].NotAfter.After(validity.NotAfter.Time) {
+			continue
+		}
+		validities[csr.Spec.SignerName] = CertificateValidity{
+			NotBefore: metav1.NewTime(certs[0].NotBefore),
+			NotAfter:  metav1.NewTime(certs[0].NotAfter),
+		}
+	}
+
+	if len(validities) == 0 {
+		return nil
+	}
+
+	percentage := registrationOption.CertificateExpiryWarningPercentage
+	if percentage <= 0 {
+		percentage = defaultCertificateExpiryWarningPercentage
+	}
+
+	now := time.Now()
+	var expiringSigners []string
+	var nextCheck time.Duration
+	for signer, validity := range validities {
+		lifetime := validity.NotAfter.Sub(validity.NotBefore.Time)
+		warnAt := validity.NotAfter.Add(-lifetime * time.Duration(percentage) / 100)
+
+		expiring := !now.Before(warnAt) && !renewalRequested(csrs, signer, validity.NotBefore.Time)
+		certificateExpirationTimestamp.WithLabelValues(clusterName, addonName, signer).Set(float64(validity.NotAfter.Unix()))
+		if expiring {
+			expiringSigners = append(expiringSigners, signer)
+			certificateExpiring.WithLabelValues(clusterName, addonName, signer).Set(1)
+			continue
+		}
+		certificateExpiring.WithLabelValues(clusterName, addonName, signer).Set(0)
+
+		if wait := warnAt.Sub(now); wait > 0 && (nextCheck == 0 || wait < nextCheck) {
+			nextCheck = wait
+		}
+	}
+	c.recordSigners(clusterName, addonName, validities)
+
+	// check the certificate again when it turns to expiring, since no csr event might happen until then.
+	if nextCheck > 0 {
+		syncCtx.Queue().AddAfter(key, nextCheck)
+	}
+
+	addon, err = c.patchCertificateExpiry(ctx, addon, validities)
+	if err != nil {
+		return err
+	}
+
+	cond := metav1.Condition{
+		Type:    constants.AddonCertificateExpiringConditionType,
+		Status:  metav1.ConditionFalse,
+		Reason:  constants.AddonCertificateExpiringReasonValid,
+		Message: "The client certificates of the addon agent are valid or being renewed",
+	}
+	if len(expiringSigners) > 0 {
+		sort.Strings(expiringSigners)
+		cond.Status = metav1.ConditionTrue
+		cond.Reason = constants.AddonCertificateExpiringReasonExpiring
+		cond.Message = fmt.Sprintf("The client certificates of signers %s have less than %d%% of lifetime left "+
+			"and no renewal csr is created", strings.Join(expiringSigners, ","), percentage)
+		if !meta.IsStatusConditionTrue(addon.Status.Conditions, constants.AddonCertificateExpiringConditionType) {
+			klog.Warningf("The client certificates of addon %q are close to expiry: %s", key, cond.Message)
+		}
+	}
+
+	addonCopy := addon.DeepCopy()
+	meta.SetStatusCondition(&addonCopy.Status.Conditions, cond)
+	return utils.PatchAddonCondition(ctx, c.addonClient, addonCopy, addon)
+}
+
+// patchCertificateExpiry records the validities in the annotation of the addon and returns the latest addon.
+func (c *csrExpiryController) patchCertificateExpiry(ctx context.Context,
+	addon *addonapiv1alpha1.ManagedClusterAddOn, validities map[string]CertificateValidity,
+) (*addonapiv1alpha1.ManagedClusterAddOn, error) {
+	data, err := json.Marshal(validities)
+	if err != nil {
+		return nil, err
+	}
+	if addon.Annotations[constants.RegistrationCertificateExpiryAnnotationKey] == string(data) {
+		return addon, nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"uid":             addon.UID,
+			"resourceVersion": addon.ResourceVersion,
+			"annotations": map[string]string{
+				constants.RegistrationCertificateExpiryAnnotationKey: string(data),
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	klog.V(2).Infof("Patching certificate expiry of addon %s/%s with %s", addon.Namespace, addon.Name, string(data))
+	return c.addonClient.AddonV1alpha1().ManagedClusterAddOns(addon.Namespace).Patch(
+		ctx, addon.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+}
+
+func (c *csrExpiryController) recordSigners(clusterName, addonName string, validities map[string]CertificateValidity) {
+	c.signersLock.Lock()
+	defer c.signersLock.Unlock()
+	key := clusterName + "/" + addonName
+	if _, ok := c.signers[key]; !ok {
+		c.signers[key] = sets.NewString()
+	}
+	for signer := range validities {
+		c.signers[key].Insert(signer)
+	}
+}
+
+func (c *csrExpiryController) cleanupMetrics(clusterName, addonName string) {
+	c.signersLock.Lock()
+	defer c.signersLock.Unlock()
+	key := clusterName + "/" + addonName
+	for signer := range c.signers[key] {
+		metricLabels := map[string]string{"cluster": clusterName, "addon": addonName, "signer": signer}
+		certificateExpirationTimestamp.Delete(metricLabels)
+		certificateExpiring.Delete(metricLabels)
+	}
+	delete(c.signers, key)
+}
+
+// renewalRequested checks whether a csr of the signer is created after the certificate is issued and is still
+// waiting to be approved or signed.
+func renewalRequested(csrs []*certificatesv1.CertificateSigningRequest, signer string, issuedAt time.Time) bool {
+	for _, csr := range csrs {
+		if csr.Spec.SignerName != signer || len(csr.Status.Certificate) > 0 {
+			continue
+		}
+		if !csr.CreationTimestamp.After(issuedAt) {
+			continue
+		}
+		if isCSRDeniedOrFailed(csr) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func isCSRDeniedOrFailed(csr *certificatesv1.CertificateSigningRequest) bool {
+	for _, condition := range csr.Status.Conditions {
+		if condition.Type == certificatesv1.CertificateDenied || condition.Type == certificatesv1.CertificateFailed {
+			return true
+		}
+	}
+	return false
+}
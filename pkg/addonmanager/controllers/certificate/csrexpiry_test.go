@@ -0,0 +1,191 @@
+package certificate
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	certv1 "k8s.io/api/certificates/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubeinformers "k8s.io/client-go/informers"
+	fakekube "k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	fakeaddon "open-cluster-management.io/api/client/addon/clientset/versioned/fake"
+	addoninformers "open-cluster-management.io/api/client/addon/informers/externalversions"
+
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/addontesting"
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/constants"
+	"open-cluster-management.io/addon-framework/pkg/agent"
+)
+
+func newCertificate(t *testing.T, notBefore, notAfter time.Time) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func newIssuedCSR(t *testing.T, name string, notBefore, notAfter time.Time) *certv1.CertificateSigningRequest {
+	csr := addontesting.NewApprovedCSR("test", "cluster1")
+	csr.Name = name
+	csr.Spec.SignerName = certv1.KubeAPIServerClientSignerName
+	csr.CreationTimestamp = metav1.NewTime(notBefore)
+	csr.Status.Certificate = newCertificate(t, notBefore, notAfter)
+	return csr
+}
+
+func newPendingCSR(name string, created time.Time) *certv1.CertificateSigningRequest {
+	csr := addontesting.NewCSR("test", "cluster1")
+	csr.Name = name
+	csr.Spec.SignerName = certv1.KubeAPIServerClientSignerName
+	csr.CreationTimestamp = metav1.NewTime(created)
+	return csr
+}
+
+func assertExpiringCondition(t *testing.T, action clienttesting.Action, status metav1.ConditionStatus) {
+	patch := action.(clienttesting.PatchActionImpl).Patch
+	addon := &addonapiv1alpha1.ManagedClusterAddOn{}
+	if err := json.Unmarshal(patch, addon); err != nil {
+		t.Fatal(err)
+	}
+	cond := meta.FindStatusCondition(addon.Status.Conditions, constants.AddonCertificateExpiringConditionType)
+	if cond == nil {
+		t.Fatalf("expected %s condition in patch %s", constants.AddonCertificateExpiringConditionType, string(patch))
+	}
+	if cond.Status != status {
+		t.Errorf("expected condition status %s, but got %v", status, cond)
+	}
+}
+
+func TestExpiryReconcile(t *testing.T) {
+	now := time.Now()
+
+	cases := []struct {
+		name                 string
+		addon                *addonapiv1alpha1.ManagedClusterAddOn
+		csrs                 []runtime.Object
+		validateAddonActions func(t *testing.T, actions []clienttesting.Action)
+	}{
+		{
+			name:                 "no certificate issued",
+			addon:                addontesting.NewAddon("test", "cluster1"),
+			csrs:                 []runtime.Object{newPendingCSR("addon-test-1", now)},
+			validateAddonActions: addontesting.AssertNoActions,
+		},
+		{
+			name:  "certificate is valid",
+			addon: addontesting.NewAddon("test", "cluster1"),
+			csrs:  []runtime.Object{newIssuedCSR(t, "addon-test-1", now.Add(-time.Hour), now.Add(9*time.Hour))},
+			validateAddonActions: func(t *testing.T, actions []clienttesting.Action) {
+				addontesting.AssertActions(t, actions, "patch", "patch")
+				addon := &addonapiv1alpha1.ManagedClusterAddOn{}
+				if err := json.Unmarshal(actions[0].(clienttesting.PatchActionImpl).Patch, addon); err != nil {
+					t.Fatal(err)
+				}
+				validities, err := GetRegistrationCertificateExpiry(addon)
+				if err != nil {
+					t.Fatal(err)
+				}
+				validity, ok := validities[certv1.KubeAPIServerClientSignerName]
+				if !ok || validity.NotAfter.Unix() != now.Add(9*time.Hour).Unix() {
+					t.Errorf("unexpected validities %v", validities)
+				}
+				assertExpiringCondition(t, actions[1], metav1.ConditionFalse)
+			},
+		},
+		{
+			name:  "certificate is expiring",
+			addon: addontesting.NewAddon("test", "cluster1"),
+			csrs:  []runtime.Object{newIssuedCSR(t, "addon-test-1", now.Add(-9*time.Hour), now.Add(time.Hour))},
+			validateAddonActions: func(t *testing.T, actions []clienttesting.Action) {
+				addontesting.AssertActions(t, actions, "patch", "patch")
+				assertExpiringCondition(t, actions[1], metav1.ConditionTrue)
+			},
+		},
+		{
+			name:  "certificate is expiring and being renewed",
+			addon: addontesting.NewAddon("test", "cluster1"),
+			csrs: []runtime.Object{
+				newIssuedCSR(t, "addon-test-1", now.Add(-9*time.Hour), now.Add(time.Hour)),
+				newPendingCSR("addon-test-2", now),
+			},
+			validateAddonActions: func(t *testing.T, actions []clienttesting.Action) {
+				addontesting.AssertActions(t, actions, "patch", "patch")
+				assertExpiringCondition(t, actions[1], metav1.ConditionFalse)
+			},
+		},
+		{
+			name: "issued csr is garbage collected",
+			addon: func() *addonapiv1alpha1.ManagedClusterAddOn {
+				addon := addontesting.NewAddon("test", "cluster1")
+				data, _ := json.Marshal(map[string]CertificateValidity{
+					certv1.KubeAPIServerClientSignerName: {
+						NotBefore: metav1.NewTime(now.Add(-9 * time.Hour)),
+						NotAfter:  metav1.NewTime(now.Add(time.Hour)),
+					},
+				})
+				addon.Annotations = map[string]string{constants.RegistrationCertificateExpiryAnnotationKey: string(data)}
+				return addon
+			}(),
+			validateAddonActions: func(t *testing.T, actions []clienttesting.Action) {
+				addontesting.AssertActions(t, actions, "patch")
+				assertExpiringCondition(t, actions[0], metav1.ConditionTrue)
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fakeAddonClient := fakeaddon.NewSimpleClientset(c.addon)
+			fakeKubeClient := fakekube.NewSimpleClientset(c.csrs...)
+
+			addonInformers := addoninformers.NewSharedInformerFactory(fakeAddonClient, 10*time.Minute)
+			kubeInfomers := kubeinformers.NewSharedInformerFactory(fakeKubeClient, 10*time.Minute)
+
+			if err := addonInformers.Addon().V1alpha1().ManagedClusterAddOns().Informer().GetStore().Add(c.addon); err != nil {
+				t.Fatal(err)
+			}
+			for _, csr := range c.csrs {
+				if err := kubeInfomers.Certificates().V1().CertificateSigningRequests().Informer().GetStore().Add(csr); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			testaddon := &testSignAgent{name: "test"}
+			controller := NewCSRExpiryController(
+				fakeAddonClient,
+				kubeInfomers.Certificates().V1().CertificateSigningRequests(),
+				addonInformers.Addon().V1alpha1().ManagedClusterAddOns(),
+				map[string]agent.AgentAddon{testaddon.name: testaddon},
+			)
+
+			err := controller.Sync(context.TODO(), addontesting.NewFakeSyncContext(t), "cluster1/test")
+			if err != nil {
+				t.Errorf("expected no error when sync: %v", err)
+			}
+			c.validateAddonActions(t, fakeAddonClient.Actions())
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	certificatesv1 "k8s.io/api/certificates/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
@@ -15,19 +16,30 @@ import (
 	certificateslisters "k8s.io/client-go/listers/certificates/v1"
 	"k8s.io/klog/v2"
 	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	addonv1alpha1client "open-cluster-management.io/api/client/addon/clientset/versioned"
 	addoninformerv1alpha1 "open-cluster-management.io/api/client/addon/informers/externalversions/addon/v1alpha1"
 	addonlisterv1alpha1 "open-cluster-management.io/api/client/addon/listers/addon/v1alpha1"
 	clusterinformers "open-cluster-management.io/api/client/cluster/informers/externalversions/cluster/v1"
 	clusterlister "open-cluster-management.io/api/client/cluster/listers/cluster/v1"
 	clusterv1 "open-cluster-management.io/api/cluster/v1"
 
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/constants"
 	"open-cluster-management.io/addon-framework/pkg/agent"
 	"open-cluster-management.io/addon-framework/pkg/basecontroller/factory"
+	"open-cluster-management.io/addon-framework/pkg/utils"
+)
+
+const (
+	// signTimeout is the timeout of a single call to the signer of the addon.
+	signTimeout = 30 * time.Second
+	// signPendingRequeueInterval is the interval to check the csr again if the signing is in progress.
+	signPendingRequeueInterval = 10 * time.Second
 )
 
 // csrApprovingController auto approve the renewal CertificateSigningRequests for an accepted spoke cluster on the hub.
 type csrSignController struct {
 	kubeClient                kubernetes.Interface
+	addonClient               addonv1alpha1client.Interface
 	agentAddons               map[string]agent.AgentAddon
 	managedClusterLister      clusterlister.ManagedClusterLister
 	managedClusterAddonLister addonlisterv1alpha1.ManagedClusterAddOnLister
@@ -37,6 +49,7 @@ type csrSignController struct {
 // NewCSRApprovingController creates a new csr approving controller
 func NewCSRSignController(
 	kubeClient kubernetes.Interface,
+	addonClient addonv1alpha1client.Interface,
 	clusterInformers clusterinformers.ManagedClusterInformer,
 	csrInformer certificatesinformers.CertificateSigningRequestInformer,
 	addonInformers addoninformerv1alpha1.ManagedClusterAddOnInformer,
@@ -44,6 +57,7 @@ func NewCSRSignController(
 ) factory.Controller {
 	c := &csrSignController{
 		kubeClient:                kubeClient,
+		addonClient:               addonClient,
 		agentAddons:               agentAddons,
 		managedClusterLister:      clusterInformers.Lister(),
 		managedClusterAddonLister: addonInformers.Lister(),
@@ -122,7 +136,7 @@ func (c *csrSignController) sync(ctx context.Context, syncCtx factory.SyncContex
 		return err
 	}
 
-	_, err = c.managedClusterAddonLister.ManagedClusterAddOns(clusterName).Get(addonName)
+	addon, err := c.managedClusterAddonLister.ManagedClusterAddOns(clusterName).Get(addonName)
 	if errors.IsNotFound(err) {
 		return nil
 	}
@@ -130,18 +144,54 @@ func (c *csrSignController) sync(ctx context.Context, syncCtx factory.SyncContex
 		return err
 	}
 
-	if registrationOption.CSRSign == nil {
+	var signer agent.CSRSigner
+	switch {
+	case registrationOption.CSRSigner != nil:
+		signer = registrationOption.CSRSigner
+	case registrationOption.CSRSign != nil:
+		signer = registrationOption.CSRSign
+	default:
 		return nil
 	}
 
-	csr.Status.Certificate = registrationOption.CSRSign(csr)
-	if len(csr.Status.Certificate) == 0 {
-		return fmt.Errorf("invalid client certificate generated for addon csr %q", csr.Name)
+	signCtx, cancel := context.WithTimeout(ctx, signTimeout)
+	defer cancel()
+	certificate, err := signer.Sign(signCtx, csr)
+	if err != nil {
+		if updateErr := c.updateCSRSignedCondition(ctx, addon, metav1.Condition{
+			Type:    constants.AddonCSRSignedConditionType,
+			Status:  metav1.ConditionFalse,
+			Reason:  constants.AddonCSRSignedReasonSignFailed,
+			Message: fmt.Sprintf("Failed to sign csr %q: %v", csr.Name, err),
+		}); updateErr != nil {
+			return updateErr
+		}
+		// return the error so the csr is requeued with backoff.
+		return fmt.Errorf("failed to sign csr %q: %w", csr.Name, err)
+	}
+	if len(certificate) == 0 {
+		klog.V(4).Infof("The signing of csr %q is in progress", csr.Name)
+		syncCtx.Queue().AddAfter(csrName, signPendingRequeueInterval)
+		return nil
 	}
 
+	csr.Status.Certificate = certificate
 	_, err = c.kubeClient.CertificatesV1().CertificateSigningRequests().UpdateStatus(ctx, csr, metav1.UpdateOptions{})
 	if err != nil {
 		return err
 	}
-	return nil
+
+	return c.updateCSRSignedCondition(ctx, addon, metav1.Condition{
+		Type:    constants.AddonCSRSignedConditionType,
+		Status:  metav1.ConditionTrue,
+		Reason:  constants.AddonCSRSignedReasonSigned,
+		Message: fmt.Sprintf("The csr %q is signed", csr.Name),
+	})
+}
+
+func (c *csrSignController) updateCSRSignedCondition(ctx context.Context,
+	addon *addonapiv1alpha1.ManagedClusterAddOn, cond metav1.Condition) error {
+	addonCopy := addon.DeepCopy()
+	meta.SetStatusCondition(&addonCopy.Status.Conditions, cond)
+	return utils.PatchAddonCondition(ctx, c.addonClient, addonCopy, addon)
 }
@@ -2,16 +2,21 @@ package certificate
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"reflect"
 	"testing"
 	"time"
 
 	certv1 "k8s.io/api/certificates/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	kubeinformers "k8s.io/client-go/informers"
 	fakekube "k8s.io/client-go/kubernetes/fake"
 	clienttesting "k8s.io/client-go/testing"
 	"open-cluster-management.io/addon-framework/pkg/addonmanager/addontesting"
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/constants"
 	"open-cluster-management.io/addon-framework/pkg/agent"
 	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
 	fakeaddon "open-cluster-management.io/api/client/addon/clientset/versioned/fake"
@@ -22,8 +27,9 @@ import (
 )
 
 type testSignAgent struct {
-	name string
-	cert []byte
+	name   string
+	cert   []byte
+	signer agent.CSRSigner
 }
 
 func (t *testSignAgent) Manifests(cluster *clusterv1.ManagedCluster, addon *addonapiv1alpha1.ManagedClusterAddOn) ([]runtime.Object, error) {
@@ -37,18 +43,45 @@ func (t *testSignAgent) GetAgentAddonOptions() agent.AgentAddonOptions {
 			CSRSign: func(csr *certv1.CertificateSigningRequest) []byte {
 				return t.cert
 			},
+			CSRSigner: t.signer,
 		},
 	}
 }
 
+type testSigner struct {
+	cert []byte
+	err  error
+}
+
+func (s *testSigner) Sign(ctx context.Context, csr *certv1.CertificateSigningRequest) ([]byte, error) {
+	return s.cert, s.err
+}
+
+func assertCSRSignedCondition(t *testing.T, action clienttesting.Action, status metav1.ConditionStatus, reason string) {
+	patch := action.(clienttesting.PatchActionImpl).Patch
+	addon := &addonapiv1alpha1.ManagedClusterAddOn{}
+	if err := json.Unmarshal(patch, addon); err != nil {
+		t.Fatal(err)
+	}
+	cond := meta.FindStatusCondition(addon.Status.Conditions, constants.AddonCSRSignedConditionType)
+	if cond == nil {
+		t.Fatalf("expected CSRSigned condition in patch %s", string(patch))
+	}
+	if cond.Status != status || cond.Reason != reason {
+		t.Errorf("unexpected CSRSigned condition %v", cond)
+	}
+}
+
 func TestSignReconcile(t *testing.T) {
 	cases := []struct {
-		name               string
-		addon              []runtime.Object
-		cluster            []runtime.Object
-		csr                []runtime.Object
-		testaddon          *testSignAgent
-		validateCSRActions func(t *testing.T, actions []clienttesting.Action)
+		name                 string
+		addon                []runtime.Object
+		cluster              []runtime.Object
+		csr                  []runtime.Object
+		testaddon            *testSignAgent
+		expectErr            bool
+		validateCSRActions   func(t *testing.T, actions []clienttesting.Action)
+		validateAddonActions func(t *testing.T, actions []clienttesting.Action)
 	}{
 		{
 			name:               "no cluster",
@@ -95,8 +128,48 @@ func TestSignReconcile(t *testing.T) {
 					t.Errorf("Expect certificate to be updated, actual %v", csr.Status.Certificate)
 				}
 			},
+			validateAddonActions: func(t *testing.T, actions []clienttesting.Action) {
+				addontesting.AssertActions(t, actions, "patch")
+				assertCSRSignedCondition(t, actions[0], metav1.ConditionTrue, constants.AddonCSRSignedReasonSigned)
+			},
 			testaddon: &testSignAgent{name: "test", cert: []byte("test")},
 		},
+		{
+			name:    "sign with signer",
+			cluster: []runtime.Object{addontesting.NewManagedCluster("cluster1")},
+			addon:   []runtime.Object{addontesting.NewAddon("test", "cluster1")},
+			csr:     []runtime.Object{addontesting.NewApprovedCSR("test", "cluster1")},
+			validateCSRActions: func(t *testing.T, actions []clienttesting.Action) {
+				addontesting.AssertActions(t, actions, "update")
+				actual := actions[0].(clienttesting.UpdateActionImpl).Object
+				csr := actual.(*certv1.CertificateSigningRequest)
+				if !reflect.DeepEqual(csr.Status.Certificate, []byte("signer")) {
+					t.Errorf("Expect certificate to be updated, actual %v", csr.Status.Certificate)
+				}
+			},
+			testaddon: &testSignAgent{name: "test", cert: []byte("test"), signer: &testSigner{cert: []byte("signer")}},
+		},
+		{
+			name:               "sign failed",
+			cluster:            []runtime.Object{addontesting.NewManagedCluster("cluster1")},
+			addon:              []runtime.Object{addontesting.NewAddon("test", "cluster1")},
+			csr:                []runtime.Object{addontesting.NewApprovedCSR("test", "cluster1")},
+			expectErr:          true,
+			validateCSRActions: addontesting.AssertNoActions,
+			validateAddonActions: func(t *testing.T, actions []clienttesting.Action) {
+				addontesting.AssertActions(t, actions, "patch")
+				assertCSRSignedCondition(t, actions[0], metav1.ConditionFalse, constants.AddonCSRSignedReasonSignFailed)
+			},
+			testaddon: &testSignAgent{name: "test", signer: &testSigner{err: fmt.Errorf("pki is unavailable")}},
+		},
+		{
+			name:               "sign pending",
+			cluster:            []runtime.Object{addontesting.NewManagedCluster("cluster1")},
+			addon:              []runtime.Object{addontesting.NewAddon("test", "cluster1")},
+			csr:                []runtime.Object{addontesting.NewApprovedCSR("test", "cluster1")},
+			validateCSRActions: addontesting.AssertNoActions,
+			testaddon:          &testSignAgent{name: "test", signer: &testSigner{}},
+		},
 	}
 
 	for _, c := range cases {
@@ -127,6 +200,7 @@ func TestSignReconcile(t *testing.T) {
 
 			controller := &csrSignController{
 				kubeClient:                fakeKubeClient,
+				addonClient:               fakeAddonClient,
 				agentAddons:               map[string]agent.AgentAddon{c.testaddon.name: c.testaddon},
 				managedClusterLister:      clusterInformers.Cluster().V1().ManagedClusters().Lister(),
 				managedClusterAddonLister: addonInformers.Addon().V1alpha1().ManagedClusterAddOns().Lister(),
@@ -137,10 +211,16 @@ func TestSignReconcile(t *testing.T) {
 				csr := obj.(*certv1.CertificateSigningRequest)
 				syncContext := addontesting.NewFakeSyncContext(t)
 				err := controller.sync(context.TODO(), syncContext, csr.Name)
-				if err != nil {
+				if c.expectErr && err == nil {
+					t.Errorf("expected error when sync")
+				}
+				if !c.expectErr && err != nil {
 					t.Errorf("expected no error when sync: %v", err)
 				}
 				c.validateCSRActions(t, fakeKubeClient.Actions())
+				if c.validateAddonActions != nil {
+					c.validateAddonActions(t, fakeAddonClient.Actions())
+				}
 			}
 		})
 	}
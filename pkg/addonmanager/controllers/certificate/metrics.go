@@ -0,0 +1,28 @@
+package certificate
+
+import (
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+var (
+	certificateExpirationTimestamp = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Name: "addon_registration_certificate_expiration_timestamp_seconds",
+			Help: "The expiration timestamp of the last issued client certificate of the addon agent in unix seconds.",
+		},
+		[]string{"cluster", "addon", "signer"},
+	)
+
+	certificateExpiring = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Name: "addon_registration_certificate_expiring",
+			Help: "Whether the client certificate of the addon agent is close to expiry and not renewed, 1 is expiring and 0 is not.",
+		},
+		[]string{"cluster", "addon", "signer"},
+	)
+)
+
+func init() {
+	legacyregistry.MustRegister(certificateExpirationTimestamp, certificateExpiring)
+}
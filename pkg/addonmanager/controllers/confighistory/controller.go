@@ -0,0 +1,99 @@
+package confighistory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/dynamic/dynamiclister"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/controllers/managementaddonconfig"
+	"open-cluster-management.io/addon-framework/pkg/basecontroller/factory"
+)
+
+const controllerName = "config-history-controller"
+
+// AddOnDeploymentConfigGVR is the resource of the configs whose history is kept.
+var AddOnDeploymentConfigGVR = schema.GroupVersionResource{
+	Group:    "addon.open-cluster-management.io",
+	Version:  "v1alpha1",
+	Resource: "addondeploymentconfigs",
+}
+
+// configHistoryController records each revision of the AddOnDeploymentConfigs on the hub into the history, so the
+// addons can render a previous revision of a config after it is changed, e.g. when the config is rolled back on a
+// cluster.
+type configHistoryController struct {
+	kubeClient    kubernetes.Interface
+	configLister  dynamiclister.Lister
+	revisionLimit int
+}
+
+func NewConfigHistoryController(
+	kubeClient kubernetes.Interface,
+	configInformerFactory dynamicinformer.DynamicSharedInformerFactory,
+	revisionLimit int,
+) factory.Controller {
+	if revisionLimit <= 0 {
+		revisionLimit = DefaultRevisionLimit
+	}
+	configInformer := configInformerFactory.ForResource(AddOnDeploymentConfigGVR).Informer()
+
+	c := &configHistoryController{
+		kubeClient:    kubeClient,
+		configLister:  dynamiclister.New(configInformer.GetIndexer(), AddOnDeploymentConfigGVR),
+		revisionLimit: revisionLimit,
+	}
+
+	return factory.New().
+		WithInformersQueueKeysFunc(func(obj runtime.Object) []string {
+			key, _ := cache.MetaNamespaceKeyFunc(obj)
+			return []string{key}
+		}, configInformer).
+		WithSync(c.sync).ToController(controllerName)
+}
+
+func (c *configHistoryController) sync(ctx context.Context, syncCtx factory.SyncContext, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		// ignore config whose key is invalid
+		return nil
+	}
+
+	config, err := c.configLister.Namespace(namespace).Get(name)
+	switch {
+	case errors.IsNotFound(err):
+		// the history is deleted with the config by its owner reference
+		return nil
+	case err != nil:
+		return err
+	}
+
+	specHash, err := managementaddonconfig.GetSpecHash(config)
+	if err != nil {
+		return err
+	}
+	spec, err := json.Marshal(config.Object["spec"])
+	if err != nil {
+		return err
+	}
+
+	klog.V(4).Infof("Recording the revision %s of config %s/%s", specHash, namespace, name)
+	if err := record(ctx, c.kubeClient, metav1.OwnerReference{
+		APIVersion: config.GetAPIVersion(),
+		Kind:       config.GetKind(),
+		Name:       config.GetName(),
+		UID:        config.GetUID(),
+	}, namespace, name, specHash, spec, c.revisionLimit); err != nil {
+		return fmt.Errorf("failed to record the revision %s of config %s/%s: %v", specHash, namespace, name, err)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package confighistory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/dynamic/dynamiclister"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	fakekube "k8s.io/client-go/kubernetes/fake"
+
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/addontesting"
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/controllers/managementaddonconfig"
+)
+
+func newTestConfig(image string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "addon.open-cluster-management.io/v1alpha1",
+		"kind":       "AddOnDeploymentConfig",
+		"metadata": map[string]interface{}{
+			"namespace": "cluster1",
+			"name":      "config1",
+			"uid":       "config1-uid",
+		},
+		"spec": map[string]interface{}{
+			"customizedVariables": []interface{}{
+				map[string]interface{}{"name": "Image", "value": image},
+			},
+		},
+	}}
+}
+
+func TestConfigHistoryController(t *testing.T) {
+	kubeClient := fakekube.NewSimpleClientset()
+	configInformer := dynamicinformer.NewDynamicSharedInformerFactory(
+		dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()), 0).ForResource(AddOnDeploymentConfigGVR).Informer()
+	ctrl := &configHistoryController{
+		kubeClient:    kubeClient,
+		configLister:  dynamiclister.New(configInformer.GetIndexer(), AddOnDeploymentConfigGVR),
+		revisionLimit: 2,
+	}
+
+	// the deleted config is ignored
+	if err := ctrl.sync(context.TODO(), addontesting.NewFakeSyncContext(t), "cluster1/config1"); err != nil {
+		t.Fatalf("expected no error, got err %v", err)
+	}
+	addontesting.AssertNoActions(t, kubeClient.Actions())
+
+	var specHashes []string
+	for _, image := range []string{"v1", "v2", "v3", "v3"} {
+		config := newTestConfig(image)
+		if err := configInformer.GetStore().Update(config); err != nil {
+			t.Fatal(err)
+		}
+		specHash, err := managementaddonconfig.GetSpecHash(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		specHashes = append(specHashes, specHash)
+		if err := ctrl.sync(context.TODO(), addontesting.NewFakeSyncContext(t), "cluster1/config1"); err != nil {
+			t.Fatalf("expected no error, got err %v", err)
+		}
+	}
+
+	history, err := kubeClient.CoreV1().ConfigMaps("cluster1").Get(context.TODO(), HistoryName("config1"), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected the history, got err %v", err)
+	}
+	if len(history.OwnerReferences) != 1 || history.OwnerReferences[0].UID != "config1-uid" {
+		t.Errorf("expected the history owned by the config, but got %v", history.OwnerReferences)
+	}
+	if len(history.Data) != 2 {
+		t.Errorf("expected 2 revisions in the history, but got %v", history.Data)
+	}
+
+	cases := []struct {
+		name          string
+		specHash      string
+		expectedImage string
+	}{
+		{
+			name:          "latest revision",
+			specHash:      specHashes[2],
+			expectedImage: "v3",
+		},
+		{
+			name:          "previous revision",
+			specHash:      specHashes[1],
+			expectedImage: "v2",
+		},
+		{
+			name:     "pruned revision",
+			specHash: specHashes[0],
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			spec, err := GetRevisionSpec(context.TODO(), kubeClient, "cluster1", "config1", c.specHash)
+			if err != nil {
+				t.Fatalf("expected no error, got err %v", err)
+			}
+			if len(c.expectedImage) == 0 {
+				if spec != nil {
+					t.Errorf("expected the revision not found, but got spec %s", spec)
+				}
+				return
+			}
+			expected, err := json.Marshal(newTestConfig(c.expectedImage).Object["spec"])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(spec) != string(expected) {
+				t.Errorf("expected spec %s, but got %s", expected, spec)
+			}
+		})
+	}
+}
+
+func TestPruneHistory(t *testing.T) {
+	history := &corev1.ConfigMap{Data: map[string]string{"invalid": "invalid"}}
+	for i := 2; i >= 0; i-- {
+		data, err := json.Marshal(&revision{Revision: int64(i + 1), Spec: json.RawMessage("{}")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		history.Data[fmt.Sprintf("hash%d", i)] = string(data)
+	}
+
+	pruneHistory(history, 2)
+	keys := []string{}
+	for key := range history.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if !reflect.DeepEqual(keys, []string{"hash1", "hash2"}) {
+		t.Errorf("expected the latest 2 revisions kept, but got %v", keys)
+	}
+}
@@ -0,0 +1,142 @@
+package confighistory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// LabelKey is the label key of the ConfigMaps keeping the history of the AddOnDeploymentConfigs, the value is
+	// the name of the config.
+	LabelKey = "addon.open-cluster-management.io/config-history"
+
+	// DefaultRevisionLimit is the default number of the revisions kept for each AddOnDeploymentConfig.
+	DefaultRevisionLimit = 10
+
+	historyNamePrefix = "addon-deployment-config-history-"
+)
+
+// revision is a revision of a config in the history, the revision number increases each time a revision is
+// recorded.
+type revision struct {
+	Revision int64           `json:"revision"`
+	Spec     json.RawMessage `json:"spec"`
+}
+
+// HistoryName returns the name of the ConfigMap keeping the history of the config, in the namespace of the config.
+func HistoryName(configName string) string {
+	return historyNamePrefix + configName
+}
+
+// GetRevisionSpec returns the spec of the revision of the config with the spec hash from the history, nil is
+// returned if the revision is not in the history.
+func GetRevisionSpec(ctx context.Context, kubeClient kubernetes.Interface,
+	namespace, name, specHash string) (json.RawMessage, error) {
+	history, err := kubeClient.CoreV1().ConfigMaps(namespace).Get(ctx, HistoryName(name), metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+	if errors.IsNotFound(err) || len(history.Data[specHash]) == 0 {
+		return nil, nil
+	}
+
+	r := &revision{}
+	if err := json.Unmarshal([]byte(history.Data[specHash]), r); err != nil {
+		return nil, fmt.Errorf("failed to parse the revision %s of config %s/%s: %v", specHash, namespace, name, err)
+	}
+	return r.Spec, nil
+}
+
+// record adds the revision of the config to the history if it is not recorded yet, the oldest revisions are removed
+// once the history has more revisions than the revisionLimit. The history is owned by the config, so it is deleted
+// together with the config.
+func record(ctx context.Context, kubeClient kubernetes.Interface, owner metav1.OwnerReference,
+	namespace, name, specHash string, spec json.RawMessage, revisionLimit int) error {
+	historyName := HistoryName(name)
+	history, err := kubeClient.CoreV1().ConfigMaps(namespace).Get(ctx, historyName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		data, err := json.Marshal(&revision{Revision: 1, Spec: spec})
+		if err != nil {
+			return err
+		}
+		_, err = kubeClient.CoreV1().ConfigMaps(namespace).Create(ctx, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:       namespace,
+				Name:            historyName,
+				Labels:          map[string]string{LabelKey: name},
+				OwnerReferences: []metav1.OwnerReference{owner},
+			},
+			Data: map[string]string{specHash: string(data)},
+		}, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if _, ok := history.Data[specHash]; ok {
+		return nil
+	}
+
+	var latest int64
+	for _, r := range parseHistory(history) {
+		if r > latest {
+			latest = r
+		}
+	}
+	data, err := json.Marshal(&revision{Revision: latest + 1, Spec: spec})
+	if err != nil {
+		return err
+	}
+
+	history = history.DeepCopy()
+	if history.Data == nil {
+		history.Data = map[string]string{}
+	}
+	history.Data[specHash] = string(data)
+	pruneHistory(history, revisionLimit)
+	_, err = kubeClient.CoreV1().ConfigMaps(namespace).Update(ctx, history, metav1.UpdateOptions{})
+	return err
+}
+
+// pruneHistory removes the oldest revisions from the history until at most revisionLimit revisions are left, the
+// revisions which can not be parsed are removed first.
+func pruneHistory(history *corev1.ConfigMap, revisionLimit int) {
+	if len(history.Data) <= revisionLimit {
+		return
+	}
+
+	revisions := parseHistory(history)
+	specHashes := make([]string, 0, len(history.Data))
+	for specHash := range history.Data {
+		specHashes = append(specHashes, specHash)
+	}
+	sort.Slice(specHashes, func(i, j int) bool {
+		if revisions[specHashes[i]] == revisions[specHashes[j]] {
+			return specHashes[i] < specHashes[j]
+		}
+		return revisions[specHashes[i]] < revisions[specHashes[j]]
+	})
+	for _, specHash := range specHashes[:len(specHashes)-revisionLimit] {
+		delete(history.Data, specHash)
+	}
+}
+
+// parseHistory returns the revision numbers of the spec hashes in the history, the revisions which can not be
+// parsed are not returned.
+func parseHistory(history *corev1.ConfigMap) map[string]int64 {
+	revisions := map[string]int64{}
+	for specHash, data := range history.Data {
+		r := &revision{}
+		if err := json.Unmarshal([]byte(data), r); err == nil {
+			revisions[specHash] = r.Revision
+		}
+	}
+	return revisions
+}
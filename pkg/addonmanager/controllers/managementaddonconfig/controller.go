@@ -9,6 +9,7 @@ import (
 	jsonpatch "github.com/evanphx/json-patch"
 	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -26,6 +27,7 @@ import (
 	addoninformerv1alpha1 "open-cluster-management.io/api/client/addon/informers/externalversions/addon/v1alpha1"
 	addonlisterv1alpha1 "open-cluster-management.io/api/client/addon/listers/addon/v1alpha1"
 
+	"open-cluster-management.io/addon-framework/pkg/agent"
 	"open-cluster-management.io/addon-framework/pkg/basecontroller/factory"
 )
 
@@ -43,6 +45,7 @@ type clusterManagementAddonConfigController struct {
 	clusterManagementAddonIndexer cache.Indexer
 	configListers                 map[schema.GroupResource]dynamiclister.Lister
 	queue                         workqueue.RateLimitingInterface
+	agentAddons                   map[string]agent.AgentAddon
 }
 
 func NewManagementAddonConfigController(
@@ -50,6 +53,7 @@ func NewManagementAddonConfigController(
 	clusterManagementAddonInformers addoninformerv1alpha1.ClusterManagementAddOnInformer,
 	configInformerFactory dynamicinformer.DynamicSharedInformerFactory,
 	configGVRs map[schema.GroupVersionResource]bool,
+	agentAddons map[string]agent.AgentAddon,
 ) factory.Controller {
 	syncCtx := factory.NewSyncContext(controllerName)
 
@@ -59,6 +63,7 @@ func NewManagementAddonConfigController(
 		clusterManagementAddonIndexer: clusterManagementAddonInformers.Informer().GetIndexer(),
 		configListers:                 map[schema.GroupResource]dynamiclister.Lister{},
 		queue:                         syncCtx.Queue(),
+		agentAddons:                   agentAddons,
 	}
 
 	configInformers := c.buildConfigInformers(configInformerFactory, configGVRs)
@@ -178,6 +183,10 @@ func (c *clusterManagementAddonConfigController) sync(ctx context.Context, syncC
 		return err
 	}
 
+	if err := c.updateConfigurationValidConditions(cmaCopy); err != nil {
+		return err
+	}
+
 	return c.patchConfigReferences(ctx, cma, cmaCopy)
 }
 
@@ -212,6 +221,42 @@ func (c *clusterManagementAddonConfigController) updateConfigSpecHash(cma *addon
 	return nil
 }
 
+// updateConfigurationValidConditions validates the desired configs of each install progression with the
+// ConfigValidator of the addon, and sets the ConfigurationValid condition of the install progression.
+func (c *clusterManagementAddonConfigController) updateConfigurationValidConditions(
+	cma *addonapiv1alpha1.ClusterManagementAddOn) error {
+	agentAddon, ok := c.agentAddons[cma.Name]
+	if !ok {
+		return nil
+	}
+	validator := agentAddon.GetAgentAddonOptions().ConfigValidator
+	if validator == nil {
+		return nil
+	}
+
+	for i, installProgression := range cma.Status.InstallProgressions {
+		var invalidConfigs []string
+		for _, configReference := range installProgression.ConfigReferences {
+			if configReference.DesiredConfig == nil || configReference.DesiredConfig.Name == "" {
+				continue
+			}
+
+			config, err := c.getConfig(configReference.ConfigGroupResource, configReference.DesiredConfig.ConfigReferent)
+			if err != nil {
+				return err
+			}
+			if config == nil {
+				continue
+			}
+			if message := ValidateConfig(validator, configReference.ConfigGroupResource, config); len(message) > 0 {
+				invalidConfigs = append(invalidConfigs, message)
+			}
+		}
+		meta.SetStatusCondition(&cma.Status.InstallProgressions[i].Conditions, NewConfigurationValidCondition(invalidConfigs))
+	}
+	return nil
+}
+
 func (c *clusterManagementAddonConfigController) patchConfigReferences(ctx context.Context, old, new *addonapiv1alpha1.ClusterManagementAddOn) error {
 	if equality.Semantic.DeepEqual(new.Status.DefaultConfigReferences, old.Status.DefaultConfigReferences) &&
 		equality.Semantic.DeepEqual(new.Status.InstallProgressions, old.Status.InstallProgressions) {
@@ -261,9 +306,24 @@ func (c *clusterManagementAddonConfigController) patchConfigReferences(ctx conte
 
 func (c *clusterManagementAddonConfigController) getConfigSpecHash(gr addonapiv1alpha1.ConfigGroupResource,
 	cr addonapiv1alpha1.ConfigReferent) (string, error) {
+	config, err := c.getConfig(gr, cr)
+	if err != nil {
+		return "", err
+	}
+	if config == nil {
+		return "", nil
+	}
+
+	return GetSpecHash(config)
+}
+
+// getConfig returns the config from the lister, nil is returned if the config type is not watched or the config
+// is not found.
+func (c *clusterManagementAddonConfigController) getConfig(gr addonapiv1alpha1.ConfigGroupResource,
+	cr addonapiv1alpha1.ConfigReferent) (*unstructured.Unstructured, error) {
 	lister, ok := c.configListers[schema.GroupResource{Group: gr.Group, Resource: gr.Resource}]
 	if !ok {
-		return "", nil
+		return nil, nil
 	}
 
 	var config *unstructured.Unstructured
@@ -274,13 +334,12 @@ func (c *clusterManagementAddonConfigController) getConfigSpecHash(gr addonapiv1
 		config, err = lister.Namespace(cr.Namespace).Get(cr.Name)
 	}
 	if errors.IsNotFound(err) {
-		return "", nil
+		return nil, nil
 	}
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-
-	return GetSpecHash(config)
+	return config, nil
 }
 
 func getIndex(configGroupResource addonapiv1alpha1.ConfigGroupResource, configSpecHash addonapiv1alpha1.ConfigSpecHash) string {
@@ -291,10 +350,17 @@ func getIndex(configGroupResource addonapiv1alpha1.ConfigGroupResource, configSp
 	return fmt.Sprintf("%s/%s/%s", configGroupResource.Group, configGroupResource.Resource, configSpecHash.Name)
 }
 
+// GetSpecHash returns the hash of the spec of the config. The configs without spec, e.g. ConfigMap, are hashed by
+// the data and binaryData.
 func GetSpecHash(obj *unstructured.Unstructured) (string, error) {
 	spec, ok := obj.Object["spec"]
 	if !ok {
-		return "", fmt.Errorf("object has no spec field")
+		data, hasData := obj.Object["data"]
+		binaryData, hasBinaryData := obj.Object["binaryData"]
+		if !hasData && !hasBinaryData {
+			return "", fmt.Errorf("object has no spec field")
+		}
+		spec = map[string]interface{}{"data": data, "binaryData": binaryData}
 	}
 
 	specBytes, err := json.Marshal(spec)
@@ -3,9 +3,12 @@ package managementaddonconfig
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"testing"
 	"time"
 
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -15,9 +18,12 @@ import (
 	clienttesting "k8s.io/client-go/testing"
 	"k8s.io/client-go/tools/cache"
 	"open-cluster-management.io/addon-framework/pkg/addonmanager/addontesting"
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/constants"
+	"open-cluster-management.io/addon-framework/pkg/agent"
 	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
 	fakeaddon "open-cluster-management.io/api/client/addon/clientset/versioned/fake"
 	addoninformers "open-cluster-management.io/api/client/addon/informers/externalversions"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
 )
 
 var fakeGVR = schema.GroupVersionResource{
@@ -396,3 +402,149 @@ func newTestConfing(name, namespace string, generation int64) *unstructured.Unst
 		},
 	}
 }
+
+func TestGetSpecHash(t *testing.T) {
+	cases := []struct {
+		name        string
+		obj         *unstructured.Unstructured
+		expectedErr bool
+	}{
+		{
+			name: "config with spec",
+			obj: &unstructured.Unstructured{Object: map[string]interface{}{
+				"spec": map[string]interface{}{"test": "value"},
+			}},
+		},
+		{
+			name: "configmap",
+			obj: &unstructured.Unstructured{Object: map[string]interface{}{
+				"data": map[string]interface{}{"test": "value"},
+			}},
+		},
+		{
+			name:        "no spec",
+			obj:         &unstructured.Unstructured{Object: map[string]interface{}{}},
+			expectedErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			hash, err := GetSpecHash(c.obj)
+			if c.expectedErr {
+				if err == nil {
+					t.Errorf("expected error, but got hash %s", hash)
+				}
+				return
+			}
+			if err != nil || len(hash) == 0 {
+				t.Errorf("expected hash, but got %q, err %v", hash, err)
+			}
+
+			changed := c.obj.DeepCopy()
+			for _, field := range []string{"spec", "data"} {
+				if _, ok := changed.Object[field]; ok {
+					changed.Object[field] = map[string]interface{}{"test": "changed"}
+				}
+			}
+			changedHash, _ := GetSpecHash(changed)
+			if changedHash == hash {
+				t.Errorf("expected the hash is changed with the config")
+			}
+		})
+	}
+}
+
+type testAgent struct {
+	name      string
+	validator agent.ConfigValidatorFunc
+}
+
+func (t *testAgent) Manifests(cluster *clusterv1.ManagedCluster,
+	addon *addonapiv1alpha1.ManagedClusterAddOn) ([]runtime.Object, error) {
+	return nil, nil
+}
+
+func (t *testAgent) GetAgentAddonOptions() agent.AgentAddonOptions {
+	return agent.AgentAddonOptions{AddonName: t.name, ConfigValidator: t.validator}
+}
+
+func TestSyncConfigurationValid(t *testing.T) {
+	cma := addontesting.NewClusterManagementAddon("test", "", "").Build()
+	cma.Status.InstallProgressions = []addonapiv1alpha1.InstallProgression{
+		{
+			PlacementRef: addonapiv1alpha1.PlacementRef{Namespace: "default", Name: "valid"},
+			ConfigReferences: []addonapiv1alpha1.InstallConfigReference{
+				{
+					ConfigGroupResource: addonapiv1alpha1.ConfigGroupResource{Group: fakeGVR.Group, Resource: fakeGVR.Resource},
+					DesiredConfig: &addonapiv1alpha1.ConfigSpecHash{
+						ConfigReferent: addonapiv1alpha1.ConfigReferent{Namespace: "default", Name: "valid"},
+					},
+				},
+			},
+		},
+		{
+			PlacementRef: addonapiv1alpha1.PlacementRef{Namespace: "default", Name: "invalid"},
+			ConfigReferences: []addonapiv1alpha1.InstallConfigReference{
+				{
+					ConfigGroupResource: addonapiv1alpha1.ConfigGroupResource{Group: fakeGVR.Group, Resource: fakeGVR.Resource},
+					DesiredConfig: &addonapiv1alpha1.ConfigSpecHash{
+						ConfigReferent: addonapiv1alpha1.ConfigReferent{Namespace: "default", Name: "invalid"},
+					},
+				},
+			},
+		},
+	}
+
+	fakeAddonClient := fakeaddon.NewSimpleClientset(cma)
+	addonInformers := addoninformers.NewSharedInformerFactory(fakeAddonClient, 10*time.Minute)
+	if err := addonInformers.Addon().V1alpha1().ClusterManagementAddOns().Informer().GetStore().Add(cma); err != nil {
+		t.Fatal(err)
+	}
+	configInformerFactory := dynamicinformer.NewDynamicSharedInformerFactory(
+		dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()), 0)
+	for _, name := range []string{"valid", "invalid"} {
+		if err := configInformerFactory.ForResource(fakeGVR).Informer().GetStore().Add(
+			newTestConfing(name, "default", 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	validator := func(config *unstructured.Unstructured) error {
+		if config.GetName() == "invalid" {
+			return fmt.Errorf("invalid config")
+		}
+		return nil
+	}
+	ctrl := &clusterManagementAddonConfigController{
+		addonClient:                  fakeAddonClient,
+		clusterManagementAddonLister: addonInformers.Addon().V1alpha1().ClusterManagementAddOns().Lister(),
+		configListers:                map[schema.GroupResource]dynamiclister.Lister{},
+		agentAddons:                  map[string]agent.AgentAddon{"test": &testAgent{name: "test", validator: validator}},
+	}
+	ctrl.buildConfigInformers(configInformerFactory, map[schema.GroupVersionResource]bool{fakeGVR: true})
+
+	if err := ctrl.sync(context.TODO(), addontesting.NewFakeSyncContext(t), "test"); err != nil {
+		t.Fatalf("expected no error when sync: %v", err)
+	}
+
+	actions := fakeAddonClient.Actions()
+	addontesting.AssertActions(t, actions, "patch")
+	patchedCMA := &addonapiv1alpha1.ClusterManagementAddOn{}
+	if err := json.Unmarshal(actions[0].(clienttesting.PatchActionImpl).Patch, patchedCMA); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]metav1.ConditionStatus{"valid": metav1.ConditionTrue, "invalid": metav1.ConditionFalse}
+	for _, installProgression := range patchedCMA.Status.InstallProgressions {
+		cond := meta.FindStatusCondition(installProgression.Conditions, constants.AddonConfigurationValidConditionType)
+		if cond == nil || cond.Status != expected[installProgression.Name] {
+			t.Errorf("expected ConfigurationValid %s of %s, but got %v",
+				expected[installProgression.Name], installProgression.Name, cond)
+		}
+		if installProgression.Name == "invalid" &&
+			cond.Message != "invalid configs: configs.configs.test default/invalid: invalid config" {
+			t.Errorf("unexpected message %q", cond.Message)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package managementaddonconfig
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/constants"
+	"open-cluster-management.io/addon-framework/pkg/agent"
+)
+
+// ValidateConfig validates the config with the validator, and returns the message of the config if it is invalid,
+// or an empty message if it is valid.
+func ValidateConfig(validator agent.ConfigValidatorFunc, gr addonapiv1alpha1.ConfigGroupResource,
+	config *unstructured.Unstructured) string {
+	err := validator(config)
+	if err == nil {
+		return ""
+	}
+
+	name := config.GetName()
+	if len(config.GetNamespace()) > 0 {
+		name = config.GetNamespace() + "/" + name
+	}
+	return fmt.Sprintf("%s %s: %v", schema.GroupResource{Group: gr.Group, Resource: gr.Resource}, name, err)
+}
+
+// NewConfigurationValidCondition returns the ConfigurationValid condition with the messages of the invalid
+// configs. A single condition is reported for all the configs rather than one for each config, since the configs
+// of an addon or an install progression are rolled out together, any invalid one blocks the rollout of all of
+// them, and a condition type can not be derived from the config without growing the conditions unboundedly; the
+// message names every invalid config instead.
+func NewConfigurationValidCondition(invalidConfigs []string) metav1.Condition {
+	if len(invalidConfigs) == 0 {
+		return metav1.Condition{
+			Type:    constants.AddonConfigurationValidConditionType,
+			Status:  metav1.ConditionTrue,
+			Reason:  constants.AddonConfigurationValidReasonValid,
+			Message: "the configs of addon are valid",
+		}
+	}
+
+	sort.Strings(invalidConfigs)
+	return metav1.Condition{
+		Type:    constants.AddonConfigurationValidConditionType,
+		Status:  metav1.ConditionFalse,
+		Reason:  constants.AddonConfigurationValidReasonInvalid,
+		Message: fmt.Sprintf("invalid configs: %s", strings.Join(invalidConfigs, "; ")),
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sync"
 
 	"open-cluster-management.io/addon-framework/pkg/utils"
 
@@ -12,8 +13,10 @@ import (
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/klog/v2"
 	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
@@ -29,19 +32,25 @@ import (
 
 // addonRegistrationController reconciles instances of ManagedClusterAddon on the hub.
 type addonRegistrationController struct {
+	kubeClient                kubernetes.Interface
 	addonClient               addonv1alpha1client.Interface
 	managedClusterLister      clusterlister.ManagedClusterLister
 	managedClusterAddonLister addonlisterv1alpha1.ManagedClusterAddOnLister
 	agentAddons               map[string]agent.AgentAddon
+	// boundServiceAccountChecked records the addons whose ServiceAccount bindings are checked, the check is done
+	// once for each addon in the lifetime of the manager.
+	boundServiceAccountChecked sync.Map
 }
 
 func NewAddonRegistrationController(
+	kubeClient kubernetes.Interface,
 	addonClient addonv1alpha1client.Interface,
 	clusterInformers clusterinformers.ManagedClusterInformer,
 	addonInformers addoninformerv1alpha1.ManagedClusterAddOnInformer,
 	agentAddons map[string]agent.AgentAddon,
 ) factory.Controller {
 	c := &addonRegistrationController{
+		kubeClient:                kubeClient,
 		addonClient:               addonClient,
 		managedClusterLister:      clusterInformers.Lister(),
 		managedClusterAddonLister: addonInformers.Lister(),
@@ -79,16 +88,17 @@ func (c *addonRegistrationController) sync(ctx context.Context, syncCtx factory.
 		return nil
 	}
 
-	// Get ManagedCluster
-	managedCluster, err := c.managedClusterLister.Get(clusterName)
+	managedClusterAddon, err := c.managedClusterAddonLister.ManagedClusterAddOns(clusterName).Get(addonName)
 	if errors.IsNotFound(err) {
-		return nil
+		// the addon is deleted, prune the hub permissions of the addon agent.
+		return c.prunePermissions(ctx, clusterName, agentAddon)
 	}
 	if err != nil {
 		return err
 	}
 
-	managedClusterAddon, err := c.managedClusterAddonLister.ManagedClusterAddOns(clusterName).Get(addonName)
+	// Get ManagedCluster
+	managedCluster, err := c.managedClusterLister.Get(clusterName)
 	if errors.IsNotFound(err) {
 		return nil
 	}
@@ -113,6 +123,22 @@ func (c *addonRegistrationController) sync(ctx context.Context, syncCtx factory.
 	managedClusterAddonCopy.Status.SupportedConfigs = supportedConfigs
 
 	registrationOption := agentAddon.GetAgentAddonOptions().Registration
+
+	// track the ServiceAccount of the agent in the related objects before setting the permission, so that the
+	// RBACPermissionBuilder binds the permission to the ServiceAccount only if the agent is registered with it.
+	serviceAccountRef := addonapiv1alpha1.ObjectReference{
+		Resource:  "serviceaccounts",
+		Namespace: clusterName,
+		Name:      agent.DefaultServiceAccountName(addonName),
+	}
+	tokenRegistration := registrationOption != nil && registrationOption.ServiceAccountToken != nil
+	if tokenRegistration {
+		modified := false
+		utils.MergeRelatedObjects(&modified, &managedClusterAddonCopy.Status.RelatedObjects, serviceAccountRef)
+	} else {
+		removeRelatedObject(&managedClusterAddonCopy.Status.RelatedObjects, serviceAccountRef)
+	}
+
 	if registrationOption == nil {
 		meta.SetStatusCondition(&managedClusterAddonCopy.Status.Conditions, metav1.Condition{
 			Type:    addonapiv1alpha1.ManagedClusterAddOnRegistrationApplied,
@@ -124,7 +150,7 @@ func (c *addonRegistrationController) sync(ctx context.Context, syncCtx factory.
 	}
 
 	if registrationOption.PermissionConfig != nil {
-		err = registrationOption.PermissionConfig(managedCluster, managedClusterAddon)
+		err = registrationOption.PermissionConfig(managedCluster, managedClusterAddonCopy)
 		if err != nil {
 			meta.SetStatusCondition(&managedClusterAddonCopy.Status.Conditions, metav1.Condition{
 				Type:    addonapiv1alpha1.ManagedClusterAddOnRegistrationApplied,
@@ -139,6 +165,29 @@ func (c *addonRegistrationController) sync(ctx context.Context, syncCtx factory.
 		}
 	}
 
+	installNamespace := registrationOption.Namespace
+	if len(managedClusterAddonCopy.Spec.InstallNamespace) > 0 {
+		installNamespace = managedClusterAddonCopy.Spec.InstallNamespace
+	}
+
+	if tokenRegistration {
+		// the agent is registered with the ServiceAccount token requested by the agent side, the registrations
+		// are cleaned so that no csr is created for the agent.
+		managedClusterAddonCopy.Status.Registrations = nil
+		managedClusterAddonCopy.Status.Namespace = installNamespace
+		if registrationOption.PermissionConfig != nil {
+			c.checkServiceAccountBound(ctx, clusterName, addonName)
+		}
+
+		meta.SetStatusCondition(&managedClusterAddonCopy.Status.Conditions, metav1.Condition{
+			Type:    addonapiv1alpha1.ManagedClusterAddOnRegistrationApplied,
+			Status:  metav1.ConditionTrue,
+			Reason:  addonapiv1alpha1.RegistrationAppliedSetPermissionApplied,
+			Message: "Registration of the addon agent is configured with ServiceAccount token",
+		})
+		return c.patchAddonStatus(ctx, managedClusterAddonCopy, managedClusterAddon)
+	}
+
 	if registrationOption.CSRConfigurations == nil {
 		meta.SetStatusCondition(&managedClusterAddonCopy.Status.Conditions, metav1.Condition{
 			Type:    addonapiv1alpha1.ManagedClusterAddOnRegistrationApplied,
@@ -152,10 +201,7 @@ func (c *addonRegistrationController) sync(ctx context.Context, syncCtx factory.
 
 	managedClusterAddonCopy.Status.Registrations = configs
 
-	managedClusterAddonCopy.Status.Namespace = registrationOption.Namespace
-	if len(managedClusterAddonCopy.Spec.InstallNamespace) > 0 {
-		managedClusterAddonCopy.Status.Namespace = managedClusterAddonCopy.Spec.InstallNamespace
-	}
+	managedClusterAddonCopy.Status.Namespace = installNamespace
 
 	meta.SetStatusCondition(&managedClusterAddonCopy.Status.Conditions, metav1.Condition{
 		Type:    addonapiv1alpha1.ManagedClusterAddOnRegistrationApplied,
@@ -167,10 +213,93 @@ func (c *addonRegistrationController) sync(ctx context.Context, syncCtx factory.
 	return c.patchAddonStatus(ctx, managedClusterAddonCopy, managedClusterAddon)
 }
 
+// checkServiceAccountBound logs a warning if no role binding in the managed cluster namespace and no cluster
+// role binding binds the ServiceAccount of the agent registered with ServiceAccount token, the PermissionConfig
+// binding the permissions to the groups of the agent grants the ServiceAccount nothing in this case.
+func (c *addonRegistrationController) checkServiceAccountBound(ctx context.Context, clusterName, addonName string) {
+	key := clusterName + "/" + addonName
+	if _, checked := c.boundServiceAccountChecked.Load(key); checked {
+		return
+	}
+
+	serviceAccountName := agent.DefaultServiceAccountName(addonName)
+	roleBindings, err := c.kubeClient.RbacV1().RoleBindings(clusterName).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		klog.V(4).Infof("Failed to list the rolebindings of addon %s: %v", key, err)
+		return
+	}
+	for _, binding := range roleBindings.Items {
+		if utils.IsServiceAccountBound(binding.Subjects, clusterName, serviceAccountName) {
+			c.boundServiceAccountChecked.Store(key, true)
+			return
+		}
+	}
+
+	clusterRoleBindings, err := c.kubeClient.RbacV1().ClusterRoleBindings().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		klog.V(4).Infof("Failed to list the clusterrolebindings of addon %s: %v", key, err)
+		return
+	}
+	for _, binding := range clusterRoleBindings.Items {
+		if utils.IsServiceAccountBound(binding.Subjects, clusterName, serviceAccountName) {
+			c.boundServiceAccountChecked.Store(key, true)
+			return
+		}
+	}
+
+	c.boundServiceAccountChecked.Store(key, true)
+	klog.Warningf("The addon %s is registered with ServiceAccount token, but no permission is bound to the "+
+		"serviceaccount %s/%s, bind the permissions with BindClusterRoleToAgentGroup or "+
+		"BindRoleTemplateToAgentGroup of the RBACPermissionBuilder", key, clusterName, serviceAccountName)
+}
+
+func removeRelatedObject(objs *[]addonapiv1alpha1.ObjectReference, obj addonapiv1alpha1.ObjectReference) {
+	var kept []addonapiv1alpha1.ObjectReference
+	for _, o := range *objs {
+		if o.Group == obj.Group && o.Resource == obj.Resource && o.Name == obj.Name && o.Namespace == obj.Namespace {
+			continue
+		}
+		kept = append(kept, o)
+	}
+	if len(kept) != len(*objs) {
+		*objs = kept
+	}
+}
+
+// prunePermissions deletes the hub RBAC resources created by the utils.RBACPermissionBuilder for the addon
+// agent in the cluster namespace. The cluster scoped ones are deleted only if the addon is removed from all
+// the managed clusters.
+func (c *addonRegistrationController) prunePermissions(ctx context.Context, clusterName string, agentAddon agent.AgentAddon) error {
+	registrationOption := agentAddon.GetAgentAddonOptions().Registration
+	if registrationOption == nil || registrationOption.PermissionConfig == nil {
+		return nil
+	}
+
+	addonName := agentAddon.GetAgentAddonOptions().AddonName
+	if err := utils.PruneRBACPermissions(ctx, c.kubeClient.RbacV1(), clusterName, addonName); err != nil {
+		return err
+	}
+
+	addons, err := c.managedClusterAddonLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	for _, addon := range addons {
+		// the addon being deleted on other clusters may still need the permissions, e.g. to run the pre-delete
+		// hook, the cluster scoped permissions are pruned once the last one is gone.
+		if addon.Name == addonName && addon.Namespace != clusterName {
+			return nil
+		}
+	}
+
+	return utils.PruneClusterScopedRBACPermissions(ctx, c.kubeClient.RbacV1(), addonName)
+}
+
 func (c *addonRegistrationController) patchAddonStatus(ctx context.Context, new, old *addonapiv1alpha1.ManagedClusterAddOn) error {
 	if equality.Semantic.DeepEqual(new.Status.Registrations, old.Status.Registrations) &&
 		equality.Semantic.DeepEqual(new.Status.Conditions, old.Status.Conditions) &&
 		equality.Semantic.DeepEqual(new.Status.SupportedConfigs, old.Status.SupportedConfigs) &&
+		equality.Semantic.DeepEqual(new.Status.RelatedObjects, old.Status.RelatedObjects) &&
 		new.Status.Namespace == old.Status.Namespace {
 		return nil
 	}
@@ -180,6 +309,7 @@ func (c *addonRegistrationController) patchAddonStatus(ctx context.Context, new,
 			Registrations:    old.Status.Registrations,
 			Namespace:        old.Status.Namespace,
 			SupportedConfigs: old.Status.SupportedConfigs,
+			RelatedObjects:   old.Status.RelatedObjects,
 			Conditions:       old.Status.Conditions,
 		},
 	})
@@ -196,6 +326,7 @@ func (c *addonRegistrationController) patchAddonStatus(ctx context.Context, new,
 			Registrations:    new.Status.Registrations,
 			Namespace:        new.Status.Namespace,
 			SupportedConfigs: new.Status.SupportedConfigs,
+			RelatedObjects:   new.Status.RelatedObjects,
 			Conditions:       new.Status.Conditions,
 		},
 	})
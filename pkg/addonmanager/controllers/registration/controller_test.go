@@ -4,16 +4,21 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
+	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	fakekube "k8s.io/client-go/kubernetes/fake"
 	clienttesting "k8s.io/client-go/testing"
 	"open-cluster-management.io/addon-framework/pkg/addonmanager/addontesting"
 	"open-cluster-management.io/addon-framework/pkg/agent"
+	"open-cluster-management.io/addon-framework/pkg/utils"
 	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
 	fakeaddon "open-cluster-management.io/api/client/addon/clientset/versioned/fake"
 	addoninformers "open-cluster-management.io/api/client/addon/informers/externalversions"
@@ -26,6 +31,9 @@ type testAgent struct {
 	name          string
 	namespace     string
 	registrations []addonapiv1alpha1.RegistrationConfig
+	tokenOption   *agent.ServiceAccountTokenOption
+	// tokenRegistered records if the addon passed to the PermissionConfig is registered with ServiceAccount token.
+	tokenRegistered bool
 }
 
 func (t *testAgent) Manifests(cluster *clusterv1.ManagedCluster, addon *addonapiv1alpha1.ManagedClusterAddOn) ([]runtime.Object, error) {
@@ -45,20 +53,23 @@ func (t *testAgent) GetAgentAddonOptions() agent.AgentAddonOptions {
 				return t.registrations
 			},
 			PermissionConfig: func(cluster *clusterv1.ManagedCluster, addon *addonapiv1alpha1.ManagedClusterAddOn) error {
+				t.tokenRegistered = utils.IsServiceAccountTokenRegistered(addon)
 				return nil
 			},
-			Namespace: t.namespace,
+			Namespace:           t.namespace,
+			ServiceAccountToken: t.tokenOption,
 		},
 	}
 }
 
 func TestReconcile(t *testing.T) {
 	cases := []struct {
-		name                 string
-		addon                []runtime.Object
-		cluster              []runtime.Object
-		testaddon            *testAgent
-		validateAddonActions func(t *testing.T, actions []clienttesting.Action)
+		name                  string
+		addon                 []runtime.Object
+		cluster               []runtime.Object
+		testaddon             *testAgent
+		expectTokenRegistered bool
+		validateAddonActions  func(t *testing.T, actions []clienttesting.Action)
 	}{
 		{
 			name:                 "no cluster",
@@ -176,6 +187,84 @@ func TestReconcile(t *testing.T) {
 				},
 			}},
 		},
+		{
+			name:    "with serviceaccount token",
+			cluster: []runtime.Object{addontesting.NewManagedCluster("cluster1")},
+			addon: []runtime.Object{
+				func() *addonapiv1alpha1.ManagedClusterAddOn {
+					addon := addontesting.NewAddon("test", "cluster1", metav1.OwnerReference{
+						Kind: "ClusterManagementAddOn",
+						Name: "test",
+					})
+					return addon
+				}(),
+			},
+			validateAddonActions: func(t *testing.T, actions []clienttesting.Action) {
+				addontesting.AssertActions(t, actions, "patch")
+				actual := actions[0].(clienttesting.PatchActionImpl).Patch
+				addOn := &addonapiv1alpha1.ManagedClusterAddOn{}
+				err := json.Unmarshal(actual, addOn)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(addOn.Status.Registrations) != 0 {
+					t.Errorf("Registration config should not be set")
+				}
+				if addOn.Status.Namespace != "default" {
+					t.Errorf("Namespace in status is not correct")
+				}
+				if len(addOn.Status.RelatedObjects) != 1 ||
+					addOn.Status.RelatedObjects[0].Name != agent.DefaultServiceAccountName("test") {
+					t.Errorf("ServiceAccount is not tracked in related objects")
+				}
+			},
+			testaddon: &testAgent{name: "test", namespace: "default", registrations: []addonapiv1alpha1.RegistrationConfig{
+				{
+					SignerName: "test",
+				},
+			}, tokenOption: &agent.ServiceAccountTokenOption{}},
+			expectTokenRegistered: true,
+		},
+		{
+			name:    "switch from serviceaccount token to csr",
+			cluster: []runtime.Object{addontesting.NewManagedCluster("cluster1")},
+			addon: []runtime.Object{
+				func() *addonapiv1alpha1.ManagedClusterAddOn {
+					addon := addontesting.NewAddon("test", "cluster1", metav1.OwnerReference{
+						Kind: "ClusterManagementAddOn",
+						Name: "test",
+					})
+					addon.Status.RelatedObjects = []addonapiv1alpha1.ObjectReference{
+						{
+							Resource:  "serviceaccounts",
+							Namespace: "cluster1",
+							Name:      agent.DefaultServiceAccountName("test"),
+						},
+					}
+					return addon
+				}(),
+			},
+			validateAddonActions: func(t *testing.T, actions []clienttesting.Action) {
+				addontesting.AssertActions(t, actions, "patch")
+				actual := actions[0].(clienttesting.PatchActionImpl).Patch
+				addOn := &addonapiv1alpha1.ManagedClusterAddOn{}
+				err := json.Unmarshal(actual, addOn)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if addOn.Status.Registrations[0].SignerName != "test" {
+					t.Errorf("Registration config is not updated")
+				}
+				if !strings.Contains(string(actual), `"relatedObjects":null`) {
+					t.Errorf("ServiceAccount should be removed from related objects, got %s", string(actual))
+				}
+			},
+			testaddon: &testAgent{name: "test", namespace: "default", registrations: []addonapiv1alpha1.RegistrationConfig{
+				{
+					SignerName: "test",
+				},
+			}},
+		},
 	}
 
 	for _, c := range cases {
@@ -198,6 +287,7 @@ func TestReconcile(t *testing.T) {
 			}
 
 			controller := addonRegistrationController{
+				kubeClient:                fakekube.NewSimpleClientset(),
 				addonClient:               fakeAddonClient,
 				managedClusterLister:      clusterInformers.Cluster().V1().ManagedClusters().Lister(),
 				managedClusterAddonLister: addonInformers.Addon().V1alpha1().ManagedClusterAddOns().Lister(),
@@ -214,7 +304,131 @@ func TestReconcile(t *testing.T) {
 				}
 				c.validateAddonActions(t, fakeAddonClient.Actions())
 			}
+			if c.testaddon.tokenRegistered != c.expectTokenRegistered {
+				t.Errorf("expected the addon passed to PermissionConfig registered with token %t, but got %t",
+					c.expectTokenRegistered, c.testaddon.tokenRegistered)
+			}
+
+		})
+	}
+}
+
+func TestPrunePermissions(t *testing.T) {
+	newRole := func(namespace, name, addonName string) *rbacv1.Role {
+		return &rbacv1.Role{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: namespace,
+				Name:      name,
+				Labels:    map[string]string{utils.PermissionConfigLabelKey: addonName},
+			},
+		}
+	}
+	newClusterRole := func(name, addonName string) *rbacv1.ClusterRole {
+		return &rbacv1.ClusterRole{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   name,
+				Labels: map[string]string{utils.PermissionConfigLabelKey: addonName},
+			},
+		}
+	}
+
+	cases := []struct {
+		name              string
+		addon             []runtime.Object
+		kubeObjs          []runtime.Object
+		expectedDeletions []string
+	}{
+		{
+			name: "addon deleted from the last cluster",
+			kubeObjs: []runtime.Object{
+				newRole("cluster1", "role1", "test"),
+				newRole("cluster1", "role2", "other"),
+				newRole("cluster2", "role1", "test"),
+				newClusterRole("clusterrole1", "test"),
+			},
+			expectedDeletions: []string{"cluster1/role1", "/clusterrole1"},
+		},
+		{
+			name: "addon deleted while installed on other clusters",
+			addon: []runtime.Object{
+				addontesting.NewAddon("test", "cluster2"),
+			},
+			kubeObjs: []runtime.Object{
+				newRole("cluster1", "role1", "test"),
+				newRole("cluster2", "role1", "test"),
+				newClusterRole("clusterrole1", "test"),
+			},
+			expectedDeletions: []string{"cluster1/role1"},
+		},
+		{
+			name: "addon is deleting",
+			addon: []runtime.Object{
+				func() *addonapiv1alpha1.ManagedClusterAddOn {
+					addon := addontesting.NewAddon("test", "cluster1")
+					addon.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+					return addon
+				}(),
+			},
+			kubeObjs: []runtime.Object{
+				newRole("cluster1", "role1", "test"),
+				newClusterRole("clusterrole1", "test"),
+			},
+		},
+		{
+			name: "addon deleted while deleting on other clusters",
+			addon: []runtime.Object{
+				func() *addonapiv1alpha1.ManagedClusterAddOn {
+					addon := addontesting.NewAddon("test", "cluster2")
+					addon.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+					return addon
+				}(),
+			},
+			kubeObjs: []runtime.Object{
+				newRole("cluster1", "role1", "test"),
+				newClusterRole("clusterrole1", "test"),
+			},
+			expectedDeletions: []string{"cluster1/role1"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fakeKubeClient := fakekube.NewSimpleClientset(c.kubeObjs...)
+			fakeClusterClient := fakecluster.NewSimpleClientset()
+			fakeAddonClient := fakeaddon.NewSimpleClientset(c.addon...)
+
+			addonInformers := addoninformers.NewSharedInformerFactory(fakeAddonClient, 10*time.Minute)
+			clusterInformers := clusterv1informers.NewSharedInformerFactory(fakeClusterClient, 10*time.Minute)
+			for _, obj := range c.addon {
+				if err := addonInformers.Addon().V1alpha1().ManagedClusterAddOns().Informer().GetStore().Add(obj); err != nil {
+					t.Fatal(err)
+				}
+			}
 
+			testaddon := &testAgent{name: "test", registrations: []addonapiv1alpha1.RegistrationConfig{{SignerName: "test"}}}
+			controller := addonRegistrationController{
+				kubeClient:                fakeKubeClient,
+				addonClient:               fakeAddonClient,
+				managedClusterLister:      clusterInformers.Cluster().V1().ManagedClusters().Lister(),
+				managedClusterAddonLister: addonInformers.Addon().V1alpha1().ManagedClusterAddOns().Lister(),
+				agentAddons:               map[string]agent.AgentAddon{testaddon.name: testaddon},
+			}
+
+			err := controller.sync(context.TODO(), addontesting.NewFakeSyncContext(t), "cluster1/test")
+			if err != nil {
+				t.Errorf("expected no error when sync: %v", err)
+			}
+
+			var actualDeletions []string
+			for _, action := range fakeKubeClient.Actions() {
+				if deleteAction, ok := action.(clienttesting.DeleteActionImpl); ok {
+					actualDeletions = append(actualDeletions, fmt.Sprintf("%s/%s", deleteAction.Namespace, deleteAction.Name))
+				}
+			}
+			if !equality.Semantic.DeepEqual(actualDeletions, c.expectedDeletions) {
+				t.Errorf("expected deletions %v, but got %v", c.expectedDeletions, actualDeletions)
+			}
+			addontesting.AssertNoActions(t, fakeAddonClient.Actions())
 		})
 	}
 }
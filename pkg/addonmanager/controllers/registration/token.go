@@ -0,0 +1,231 @@
+package registration
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	addonv1alpha1client "open-cluster-management.io/api/client/addon/clientset/versioned"
+	addoninformerv1alpha1 "open-cluster-management.io/api/client/addon/informers/externalversions/addon/v1alpha1"
+	addonlisterv1alpha1 "open-cluster-management.io/api/client/addon/listers/addon/v1alpha1"
+	workv1client "open-cluster-management.io/api/client/work/clientset/versioned"
+
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/constants"
+	"open-cluster-management.io/addon-framework/pkg/agent"
+	"open-cluster-management.io/addon-framework/pkg/basecontroller/factory"
+	"open-cluster-management.io/addon-framework/pkg/utils"
+)
+
+// addonTokenController prepares the ServiceAccount token registration of the addon agents on the hub. It creates
+// the ServiceAccount of the agent and allows the token requesters to create the token of the ServiceAccount, the
+// token itself is requested by the agent side and never written to the hub.
+type addonTokenController struct {
+	kubeClient                kubernetes.Interface
+	addonClient               addonv1alpha1client.Interface
+	workClient                workv1client.Interface
+	managedClusterAddonLister addonlisterv1alpha1.ManagedClusterAddOnLister
+	agentAddons               map[string]agent.AgentAddon
+}
+
+func NewAddonTokenController(
+	kubeClient kubernetes.Interface,
+	addonClient addonv1alpha1client.Interface,
+	workClient workv1client.Interface,
+	addonInformers addoninformerv1alpha1.ManagedClusterAddOnInformer,
+	agentAddons map[string]agent.AgentAddon,
+) factory.Controller {
+	c := &addonTokenController{
+		kubeClient:                kubeClient,
+		addonClient:               addonClient,
+		workClient:                workClient,
+		managedClusterAddonLister: addonInformers.Lister(),
+		agentAddons:               agentAddons,
+	}
+
+	return factory.New().WithFilteredEventsInformersQueueKeysFunc(
+		func(obj runtime.Object) []string {
+			key, _ := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+			return []string{key}
+		},
+		func(obj interface{}) bool {
+			accessor, _ := meta.Accessor(obj)
+			return c.tokenOption(accessor.GetName()) != nil
+		},
+		addonInformers.Informer()).
+		WithSync(c.sync).ToController("addon-token-controller")
+}
+
+func (c *addonTokenController) tokenOption(addonName string) *agent.ServiceAccountTokenOption {
+	agentAddon, ok := c.agentAddons[addonName]
+	if !ok {
+		return nil
+	}
+	registrationOption := agentAddon.GetAgentAddonOptions().Registration
+	if registrationOption == nil {
+		return nil
+	}
+	return registrationOption.ServiceAccountToken
+}
+
+func (c *addonTokenController) sync(ctx context.Context, syncCtx factory.SyncContext, key string) error {
+	klog.V(4).Infof("Reconciling addon token %q", key)
+
+	clusterName, addonName, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		// ignore addon whose key is not in format: namespace/name
+		return nil
+	}
+
+	tokenOption := c.tokenOption(addonName)
+	if tokenOption == nil {
+		return nil
+	}
+
+	addon, err := c.managedClusterAddonLister.ManagedClusterAddOns(clusterName).Get(addonName)
+	if errors.IsNotFound(err) {
+		// the serviceaccount and the token requester role are deleted with the addon by the ownerRef.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if !addon.DeletionTimestamp.IsZero() {
+		return nil
+	}
+
+	installMode, _ := constants.GetHostedModeInfo(addon.GetAnnotations())
+	if installMode != constants.InstallModeDefault {
+		klog.Warningf("ServiceAccount token registration of addon %s is not supported in %s mode", key, installMode)
+		return nil
+	}
+
+	// the token was delivered by a manifestWork in the previous versions, remove it so the token is not kept
+	// on the hub.
+	err = c.workClient.WorkV1().ManifestWorks(clusterName).Delete(ctx, constants.TokenWorkName(addonName),
+		metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	serviceAccountName := agent.DefaultServiceAccountName(addonName)
+	requesters := agent.DefaultTokenRequesters
+	if tokenOption.TokenRequesters != nil {
+		requesters = tokenOption.TokenRequesters
+	}
+	subjects := requesters(clusterName, addonName)
+
+	err = c.allowTokenRequest(ctx, clusterName, serviceAccountName, addon, subjects)
+	cond := metav1.Condition{
+		Type:   constants.AddonServiceAccountTokenRequestableConditionType,
+		Status: metav1.ConditionTrue,
+		Reason: constants.AddonServiceAccountTokenRequestableReasonRequestable,
+		Message: fmt.Sprintf("The token of serviceaccount %s/%s can be requested by %s",
+			clusterName, serviceAccountName, subjectNames(subjects)),
+	}
+	if err != nil {
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = constants.AddonServiceAccountTokenRequestableReasonFailed
+		cond.Message = err.Error()
+	}
+
+	addonCopy := addon.DeepCopy()
+	meta.SetStatusCondition(&addonCopy.Status.Conditions, cond)
+	if patchErr := utils.PatchAddonCondition(ctx, c.addonClient, addonCopy, addon); patchErr != nil {
+		return patchErr
+	}
+	return err
+}
+
+// allowTokenRequest ensures the ServiceAccount of the addon agent, and a role binding allowing the subjects to
+// create the token of the ServiceAccount only.
+func (c *addonTokenController) allowTokenRequest(ctx context.Context, clusterName, serviceAccountName string,
+	addon *addonapiv1alpha1.ManagedClusterAddOn, subjects []rbacv1.Subject) error {
+	owner := metav1.NewControllerRef(addon, addonapiv1alpha1.GroupVersion.WithKind("ManagedClusterAddOn"))
+	if err := c.ensureServiceAccount(ctx, clusterName, serviceAccountName, addon.Name, owner); err != nil {
+		return fmt.Errorf("failed to create serviceaccount %s/%s: %w", clusterName, serviceAccountName, err)
+	}
+	if len(subjects) == 0 {
+		return fmt.Errorf("no token requester of serviceaccount %s/%s", clusterName, serviceAccountName)
+	}
+
+	objectMeta := metav1.ObjectMeta{
+		Name:            fmt.Sprintf("open-cluster-management:%s:token-requester", addon.Name),
+		Namespace:       clusterName,
+		Labels:          map[string]string{addonapiv1alpha1.AddonLabelKey: addon.Name},
+		OwnerReferences: []metav1.OwnerReference{*owner},
+	}
+	_, _, err := utils.ApplyRole(ctx, c.kubeClient.RbacV1(), &rbacv1.Role{
+		ObjectMeta: objectMeta,
+		Rules: []rbacv1.PolicyRule{
+			{
+				APIGroups:     []string{""},
+				Resources:     []string{"serviceaccounts/token"},
+				ResourceNames: []string{serviceAccountName},
+				Verbs:         []string{"create"},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply the token requester role of serviceaccount %s/%s: %w",
+			clusterName, serviceAccountName, err)
+	}
+
+	_, _, err = utils.ApplyRoleBinding(ctx, c.kubeClient.RbacV1(), &rbacv1.RoleBinding{
+		ObjectMeta: objectMeta,
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "Role",
+			Name:     objectMeta.Name,
+		},
+		Subjects: subjects,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply the token requester rolebinding of serviceaccount %s/%s: %w",
+			clusterName, serviceAccountName, err)
+	}
+	return nil
+}
+
+func (c *addonTokenController) ensureServiceAccount(ctx context.Context,
+	namespace, name, addonName string, owner *metav1.OwnerReference) error {
+	_, err := c.kubeClient.CoreV1().ServiceAccounts(namespace).Get(ctx, name, metav1.GetOptions{})
+	if !errors.IsNotFound(err) {
+		return err
+	}
+
+	_, err = c.kubeClient.CoreV1().ServiceAccounts(namespace).Create(ctx, &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       namespace,
+			Labels:          map[string]string{addonapiv1alpha1.AddonLabelKey: addonName},
+			OwnerReferences: []metav1.OwnerReference{*owner},
+		},
+	}, metav1.CreateOptions{})
+	if errors.IsAlreadyExists(err) {
+		return nil
+	}
+	return err
+}
+
+func subjectNames(subjects []rbacv1.Subject) string {
+	var names []string
+	for _, subject := range subjects {
+		name := subject.Name
+		if len(subject.Namespace) > 0 {
+			name = fmt.Sprintf("%s/%s", subject.Namespace, subject.Name)
+		}
+		names = append(names, fmt.Sprintf("%s %s", strings.ToLower(subject.Kind), name))
+	}
+	return strings.Join(names, ", ")
+}
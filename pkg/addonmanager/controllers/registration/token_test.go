@@ -0,0 +1,224 @@
+package registration
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakekube "k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	addonapiv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	fakeaddon "open-cluster-management.io/api/client/addon/clientset/versioned/fake"
+	addoninformers "open-cluster-management.io/api/client/addon/informers/externalversions"
+	fakework "open-cluster-management.io/api/client/work/clientset/versioned/fake"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	workapiv1 "open-cluster-management.io/api/work/v1"
+
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/addontesting"
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/constants"
+	"open-cluster-management.io/addon-framework/pkg/agent"
+)
+
+type testTokenAgent struct {
+	name        string
+	tokenOption *agent.ServiceAccountTokenOption
+}
+
+func (t *testTokenAgent) Manifests(cluster *clusterv1.ManagedCluster, addon *addonapiv1alpha1.ManagedClusterAddOn) ([]runtime.Object, error) {
+	return []runtime.Object{}, nil
+}
+
+func (t *testTokenAgent) GetAgentAddonOptions() agent.AgentAddonOptions {
+	return agent.AgentAddonOptions{
+		AddonName: t.name,
+		Registration: &agent.RegistrationOption{
+			ServiceAccountToken: t.tokenOption,
+		},
+	}
+}
+
+func TestTokenReconcile(t *testing.T) {
+	hostedAddon := addontesting.NewHostedModeAddon("test", "cluster1", "hosting")
+
+	cases := []struct {
+		name                 string
+		addon                *addonapiv1alpha1.ManagedClusterAddOn
+		tokenOption          *agent.ServiceAccountTokenOption
+		kubeObjects          []runtime.Object
+		works                []runtime.Object
+		rbacErr              error
+		validateKubeActions  func(t *testing.T, actions []clienttesting.Action)
+		validateWorkActions  func(t *testing.T, actions []clienttesting.Action)
+		validateAddonActions func(t *testing.T, actions []clienttesting.Action)
+	}{
+		{
+			name:                 "not supported in hosted mode",
+			addon:                hostedAddon,
+			tokenOption:          &agent.ServiceAccountTokenOption{},
+			validateKubeActions:  addontesting.AssertNoActions,
+			validateWorkActions:  addontesting.AssertNoActions,
+			validateAddonActions: addontesting.AssertNoActions,
+		},
+		{
+			name:        "allow the default token requesters",
+			addon:       addontesting.NewAddon("test", "cluster1"),
+			tokenOption: &agent.ServiceAccountTokenOption{},
+			validateKubeActions: func(t *testing.T, actions []clienttesting.Action) {
+				addontesting.AssertActions(t, actions, "get", "create", "get", "create", "get", "create")
+				sa := actions[1].(clienttesting.CreateActionImpl).Object.(*corev1.ServiceAccount)
+				if sa.Name != agent.DefaultServiceAccountName("test") || sa.Namespace != "cluster1" {
+					t.Errorf("unexpected serviceaccount %s/%s", sa.Namespace, sa.Name)
+				}
+				role := actions[3].(clienttesting.CreateActionImpl).Object.(*rbacv1.Role)
+				if len(role.Rules) != 1 || role.Rules[0].Resources[0] != "serviceaccounts/token" ||
+					role.Rules[0].ResourceNames[0] != agent.DefaultServiceAccountName("test") ||
+					role.Rules[0].Verbs[0] != "create" {
+					t.Errorf("expected the role only allows to create the token, but got %v", role.Rules)
+				}
+				binding := actions[5].(clienttesting.CreateActionImpl).Object.(*rbacv1.RoleBinding)
+				if len(binding.Subjects) != 1 || binding.Subjects[0].Kind != rbacv1.GroupKind ||
+					binding.Subjects[0].Name != "system:open-cluster-management:cluster1" {
+					t.Errorf("unexpected token requesters %v", binding.Subjects)
+				}
+			},
+			validateWorkActions: func(t *testing.T, actions []clienttesting.Action) {
+				addontesting.AssertActions(t, actions, "delete")
+			},
+			validateAddonActions: func(t *testing.T, actions []clienttesting.Action) {
+				assertTokenRequestableCondition(t, actions, metav1.ConditionTrue,
+					constants.AddonServiceAccountTokenRequestableReasonRequestable)
+			},
+		},
+		{
+			name:  "allow customized token requesters",
+			addon: addontesting.NewAddon("test", "cluster1"),
+			tokenOption: &agent.ServiceAccountTokenOption{
+				TokenRequesters: func(clusterName, addonName string) []rbacv1.Subject {
+					return []rbacv1.Subject{{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: "requester"}}
+				},
+			},
+			kubeObjects: []runtime.Object{
+				&corev1.ServiceAccount{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "cluster1", Name: agent.DefaultServiceAccountName("test")},
+				},
+			},
+			validateKubeActions: func(t *testing.T, actions []clienttesting.Action) {
+				addontesting.AssertActions(t, actions, "get", "get", "create", "get", "create")
+				binding := actions[4].(clienttesting.CreateActionImpl).Object.(*rbacv1.RoleBinding)
+				if len(binding.Subjects) != 1 || binding.Subjects[0].Name != "requester" {
+					t.Errorf("unexpected token requesters %v", binding.Subjects)
+				}
+			},
+			validateWorkActions: func(t *testing.T, actions []clienttesting.Action) {
+				addontesting.AssertActions(t, actions, "delete")
+			},
+			validateAddonActions: func(t *testing.T, actions []clienttesting.Action) {
+				assertTokenRequestableCondition(t, actions, metav1.ConditionTrue,
+					constants.AddonServiceAccountTokenRequestableReasonRequestable)
+			},
+		},
+		{
+			name:        "remove the token work of previous versions",
+			addon:       addontesting.NewAddon("test", "cluster1"),
+			tokenOption: &agent.ServiceAccountTokenOption{},
+			works: []runtime.Object{
+				&workapiv1.ManifestWork{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "cluster1", Name: constants.TokenWorkName("test")},
+				},
+			},
+			validateKubeActions: func(t *testing.T, actions []clienttesting.Action) {
+				addontesting.AssertActions(t, actions, "get", "create", "get", "create", "get", "create")
+			},
+			validateWorkActions: func(t *testing.T, actions []clienttesting.Action) {
+				addontesting.AssertActions(t, actions, "delete")
+				if actions[0].(clienttesting.DeleteActionImpl).Name != constants.TokenWorkName("test") {
+					t.Errorf("unexpected deleted work %v", actions[0])
+				}
+			},
+			validateAddonActions: func(t *testing.T, actions []clienttesting.Action) {
+				assertTokenRequestableCondition(t, actions, metav1.ConditionTrue,
+					constants.AddonServiceAccountTokenRequestableReasonRequestable)
+			},
+		},
+		{
+			name:        "failed to apply the token requester role",
+			addon:       addontesting.NewAddon("test", "cluster1"),
+			tokenOption: &agent.ServiceAccountTokenOption{},
+			rbacErr:     fmt.Errorf("forbidden"),
+			validateKubeActions: func(t *testing.T, actions []clienttesting.Action) {
+				addontesting.AssertActions(t, actions, "get", "create", "get", "create")
+			},
+			validateWorkActions: func(t *testing.T, actions []clienttesting.Action) {
+				addontesting.AssertActions(t, actions, "delete")
+			},
+			validateAddonActions: func(t *testing.T, actions []clienttesting.Action) {
+				assertTokenRequestableCondition(t, actions, metav1.ConditionFalse,
+					constants.AddonServiceAccountTokenRequestableReasonFailed)
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fakeKubeClient := fakekube.NewSimpleClientset(c.kubeObjects...)
+			if c.rbacErr != nil {
+				fakeKubeClient.PrependReactor("create", "roles",
+					func(action clienttesting.Action) (handled bool, ret runtime.Object, err error) {
+						return true, nil, c.rbacErr
+					})
+			}
+			fakeAddonClient := fakeaddon.NewSimpleClientset(c.addon)
+			fakeWorkClient := fakework.NewSimpleClientset(c.works...)
+
+			addonInformers := addoninformers.NewSharedInformerFactory(fakeAddonClient, 10*time.Minute)
+			if err := addonInformers.Addon().V1alpha1().ManagedClusterAddOns().Informer().GetStore().Add(c.addon); err != nil {
+				t.Fatal(err)
+			}
+
+			testaddon := &testTokenAgent{name: "test", tokenOption: c.tokenOption}
+			controller := NewAddonTokenController(
+				fakeKubeClient,
+				fakeAddonClient,
+				fakeWorkClient,
+				addonInformers.Addon().V1alpha1().ManagedClusterAddOns(),
+				map[string]agent.AgentAddon{testaddon.name: testaddon},
+			)
+			fakeKubeClient.ClearActions()
+			fakeAddonClient.ClearActions()
+			fakeWorkClient.ClearActions()
+
+			err := controller.Sync(context.TODO(), addontesting.NewFakeSyncContext(t), "cluster1/test")
+			if c.rbacErr == nil && err != nil {
+				t.Errorf("expected no error when sync: %v", err)
+			}
+			if c.rbacErr != nil && err == nil {
+				t.Errorf("expected error when sync, but got nil")
+			}
+			c.validateKubeActions(t, fakeKubeClient.Actions())
+			c.validateWorkActions(t, fakeWorkClient.Actions())
+			c.validateAddonActions(t, fakeAddonClient.Actions())
+		})
+	}
+}
+
+func assertTokenRequestableCondition(t *testing.T, actions []clienttesting.Action,
+	status metav1.ConditionStatus, reason string) {
+	addontesting.AssertActions(t, actions, "patch")
+	addon := &addonapiv1alpha1.ManagedClusterAddOn{}
+	if err := json.Unmarshal(actions[0].(clienttesting.PatchActionImpl).Patch, addon); err != nil {
+		t.Fatal(err)
+	}
+	cond := meta.FindStatusCondition(addon.Status.Conditions,
+		constants.AddonServiceAccountTokenRequestableConditionType)
+	if cond == nil || cond.Status != status || cond.Reason != reason {
+		t.Errorf("expected condition %s with status %s and reason %s, but got %v",
+			constants.AddonServiceAccountTokenRequestableConditionType, status, reason, cond)
+	}
+}
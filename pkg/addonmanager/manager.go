@@ -14,7 +14,6 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/dynamic"
-	"k8s.io/client-go/dynamic/dynamicinformer"
 	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -30,6 +29,7 @@ import (
 	"open-cluster-management.io/addon-framework/pkg/addonmanager/controllers/addoninstall"
 	"open-cluster-management.io/addon-framework/pkg/addonmanager/controllers/agentdeploy"
 	"open-cluster-management.io/addon-framework/pkg/addonmanager/controllers/certificate"
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/controllers/confighistory"
 	"open-cluster-management.io/addon-framework/pkg/addonmanager/controllers/managementaddonconfig"
 	"open-cluster-management.io/addon-framework/pkg/addonmanager/controllers/registration"
 	"open-cluster-management.io/addon-framework/pkg/agent"
@@ -44,7 +44,8 @@ type AddonManager interface {
 	AddAgent(addon agent.AgentAddon) error
 
 	// Trigger triggers a reconcile loop in the manager. Currently it
-	// only trigger the deploy controller.
+	// only trigger the deploy controller. An empty clusterName triggers
+	// the addon on all the clusters.
 	Trigger(clusterName, addonName string)
 
 	// Start starts all registered addon agent.
@@ -144,7 +145,7 @@ func (a *addonManager) Start(ctx context.Context) error {
 			listOptions.LabelSelector = metav1.FormatLabelSelector(selector)
 		}),
 	)
-	dynamicInformers := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, 10*time.Minute)
+	dynamicInformers := newConfigInformerFactory(dynamicClient, 10*time.Minute)
 
 	deployController := agentdeploy.NewAddonDeployController(
 		workClient,
@@ -156,12 +157,21 @@ func (a *addonManager) Start(ctx context.Context) error {
 	)
 
 	registrationController := registration.NewAddonRegistrationController(
+		kubeClient,
 		addonClient,
 		clusterInformers.Cluster().V1().ManagedClusters(),
 		addonInformers.Addon().V1alpha1().ManagedClusterAddOns(),
 		a.addonAgents,
 	)
 
+	tokenController := registration.NewAddonTokenController(
+		kubeClient,
+		addonClient,
+		workClient,
+		addonInformers.Addon().V1alpha1().ManagedClusterAddOns(),
+		a.addonAgents,
+	)
+
 	addonInstallController := addoninstall.NewAddonInstallController(
 		addonClient,
 		clusterInformers.Cluster().V1().ManagedClusters(),
@@ -185,12 +195,14 @@ func (a *addonManager) Start(ctx context.Context) error {
 			addonInformers.Addon().V1alpha1().ManagedClusterAddOns(),
 			dynamicInformers,
 			a.addonConfigs,
+			a.addonAgents,
 		)
 		managementAddonConfigController = managementaddonconfig.NewManagementAddonConfigController(
 			addonClient,
 			addonInformers.Addon().V1alpha1().ClusterManagementAddOns(),
 			dynamicInformers,
 			a.addonConfigs,
+			a.addonAgents,
 		)
 
 		// start addonConfiguration controller, note this is to handle the case when the general addon-manager
@@ -220,8 +232,32 @@ func (a *addonManager) Start(ctx context.Context) error {
 		)
 	}
 
+	// keep the history of the AddOnDeploymentConfigs for the addons rendering the previous revisions, up to the
+	// largest revision limit of them.
+	var configHistoryController factory.Controller
+	var configHistoryRevisionLimit int
+	for _, agentImpl := range a.addonAgents {
+		options := agentImpl.GetAgentAddonOptions()
+		if options.ConfigHistoryRevisionLimit <= configHistoryRevisionLimit {
+			continue
+		}
+		for _, configGVR := range options.SupportedConfigGVRs {
+			if configGVR == confighistory.AddOnDeploymentConfigGVR {
+				configHistoryRevisionLimit = options.ConfigHistoryRevisionLimit
+			}
+		}
+	}
+	if configHistoryRevisionLimit > 0 {
+		configHistoryController = confighistory.NewConfigHistoryController(
+			kubeClient,
+			dynamicInformers,
+			configHistoryRevisionLimit,
+		)
+	}
+
 	var csrApproveController factory.Controller
 	var csrSignController factory.Controller
+	var csrExpiryController factory.Controller
 	// Spawn the following controllers only if v1 CSR api is supported in the
 	// hub cluster. Under v1beta1 CSR api, all the CSR objects will be signed
 	// by the kube-controller-manager so custom CSR controller should be
@@ -237,11 +273,18 @@ func (a *addonManager) Start(ctx context.Context) error {
 		)
 		csrSignController = certificate.NewCSRSignController(
 			kubeClient,
+			addonClient,
 			clusterInformers.Cluster().V1().ManagedClusters(),
 			kubeInfomers.Certificates().V1().CertificateSigningRequests(),
 			addonInformers.Addon().V1alpha1().ManagedClusterAddOns(),
 			a.addonAgents,
 		)
+		csrExpiryController = certificate.NewCSRExpiryController(
+			addonClient,
+			kubeInfomers.Certificates().V1().CertificateSigningRequests(),
+			addonInformers.Addon().V1alpha1().ManagedClusterAddOns(),
+			a.addonAgents,
+		)
 	} else if v1beta1Supported {
 		csrApproveController = certificate.NewCSRApprovingController(
 			kubeClient,
@@ -263,6 +306,7 @@ func (a *addonManager) Start(ctx context.Context) error {
 
 	go deployController.Run(ctx, 1)
 	go registrationController.Run(ctx, 1)
+	go tokenController.Run(ctx, 1)
 	go addonInstallController.Run(ctx, 1)
 
 	go addonOwnerController.Run(ctx, 1)
@@ -275,12 +319,18 @@ func (a *addonManager) Start(ctx context.Context) error {
 	if addonConfigurationController != nil {
 		go addonConfigurationController.Run(ctx, 1)
 	}
+	if configHistoryController != nil {
+		go configHistoryController.Run(ctx, 1)
+	}
 	if csrApproveController != nil {
 		go csrApproveController.Run(ctx, 1)
 	}
 	if csrSignController != nil {
 		go csrSignController.Run(ctx, 1)
 	}
+	if csrExpiryController != nil {
+		go csrExpiryController.Run(ctx, 1)
+	}
 	return nil
 }
 
@@ -1,10 +1,13 @@
 package agent
 
 import (
+	"context"
 	"fmt"
 
 	certificatesv1 "k8s.io/api/certificates/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -38,6 +41,27 @@ type AgentAddon interface {
 	GetAgentAddonOptions() AgentAddonOptions
 }
 
+// InvalidValuesError is returned by Manifests when the values to render the manifests of the addon are invalid,
+// e.g. the values do not match the values schema. The addon manager reflects it in the ValuesValid condition of
+// the ManagedClusterAddOn.
+type InvalidValuesError struct {
+	// Source names the config or the annotation of the ManagedClusterAddOn providing the invalid values, e.g.
+	// AddOnDeploymentConfig open-cluster-management/default. It is empty if the source is unknown.
+	Source string
+	Err    error
+}
+
+func (e *InvalidValuesError) Error() string {
+	if len(e.Source) == 0 {
+		return fmt.Sprintf("invalid values: %v", e.Err)
+	}
+	return fmt.Sprintf("invalid values from %s: %v", e.Source, e.Err)
+}
+
+func (e *InvalidValuesError) Unwrap() error {
+	return e.Err
+}
+
 // AgentAddonOptions prescribes the future customization for the addon.
 type AgentAddonOptions struct {
 	// AddonName is the name of the addon.
@@ -75,10 +99,68 @@ type AgentAddonOptions struct {
 	// SupportedConfigGVRs is a list of addon supported configuration GroupVersionResource
 	// each configuration GroupVersionResource should be unique
 	SupportedConfigGVRs []schema.GroupVersionResource
+
+	// ConfigValidator validates each config referenced by the ManagedClusterAddOn and the install progressions of
+	// the ClusterManagementAddOn. The result is the ConfigurationValid condition of them, and the manifests of the
+	// addon are not deployed on the cluster while any config of the ManagedClusterAddOn is invalid.
+	// +optional
+	ConfigValidator ConfigValidatorFunc
+
+	// ConfigHistoryRevisionLimit keeps the history of the AddOnDeploymentConfigs on the hub if it is positive, up
+	// to the limit revisions of each config. The addon can then render a previous revision of a config, e.g. rolled
+	// back on a cluster, with the getter from addonfactory.NewAddOnDeploymentConfigGetterWithHistory.
+	// +optional
+	ConfigHistoryRevisionLimit int
+
+	// ConfigMergeStrategies defines how the configs of the same type referenced by the ManagedClusterAddOn are
+	// merged, keyed by the config type. For each config type with a strategy, the specs of its configs are merged
+	// in the order of the config references in the status of the ManagedClusterAddOn, and the configs each
+	// effective spec field came from are recorded in the annotation
+	// addon.open-cluster-management.io/config-values-sources of the ManagedClusterAddOn.
+	// The values of the AddOnDeploymentConfigs are merged with the strategy by
+	// addonfactory.GetAddOnDeploymentConfigValuesWithMergeStrategy.
+	// +optional
+	ConfigMergeStrategies map[addonapiv1alpha1.ConfigGroupResource]ConfigMergeStrategy
 }
 
+// ConfigValidatorFunc returns an error if the config, which is an object of the SupportedConfigGVRs, is invalid.
+type ConfigValidatorFunc func(config *unstructured.Unstructured) error
+
+// ConfigMergeStrategy defines how the values of multiple configs of the same type are merged, the values of the
+// later config are merged into the values of the former ones.
+type ConfigMergeStrategy string
+
+const (
+	// ConfigMergeStrategyReplace replaces each top-level value with the one of the later config, e.g. the
+	// NodeSelector of the later config replaces the whole NodeSelector of the former config.
+	ConfigMergeStrategyReplace ConfigMergeStrategy = "Replace"
+	// ConfigMergeStrategyDeepMerge merges the maps recursively, the other values, including the lists, are replaced
+	// by the ones of the later config.
+	ConfigMergeStrategyDeepMerge ConfigMergeStrategy = "DeepMerge"
+	// ConfigMergeStrategyAppendLists merges the maps recursively in the same way as ConfigMergeStrategyDeepMerge,
+	// and appends the lists of the later config to the lists of the former config, e.g. the Tolerations.
+	ConfigMergeStrategyAppendLists ConfigMergeStrategy = "AppendLists"
+)
+
 type CSRSignerFunc func(csr *certificatesv1.CertificateSigningRequest) []byte
 
+// Sign implements CSRSigner, an empty certificate returned by the CSRSignerFunc is taken as a failure.
+func (f CSRSignerFunc) Sign(ctx context.Context, csr *certificatesv1.CertificateSigningRequest) ([]byte, error) {
+	cert := f(csr)
+	if len(cert) == 0 {
+		return nil, fmt.Errorf("invalid client certificate generated for addon csr %q", csr.Name)
+	}
+	return cert, nil
+}
+
+// CSRSigner signs a csr with a signer that can be slow or fail, e.g. an external PKI.
+type CSRSigner interface {
+	// Sign returns a PEM encoded x509 certificate for the csr. The csr is retried with backoff if an error
+	// is returned. A nil certificate with a nil error means the signing is still in progress, the csr will
+	// be checked again later.
+	Sign(ctx context.Context, csr *certificatesv1.CertificateSigningRequest) ([]byte, error)
+}
+
 type CSRApproveFunc func(cluster *clusterv1.ManagedCluster, addon *addonapiv1alpha1.ManagedClusterAddOn, csr *certificatesv1.CertificateSigningRequest) bool
 
 type PermissionConfigFunc func(cluster *clusterv1.ManagedCluster, addon *addonapiv1alpha1.ManagedClusterAddOn) error
@@ -123,6 +205,59 @@ type RegistrationOption struct {
 	// The returned byte array shall be a valid non-nil PEM encoded x509 certificate.
 	// +optional
 	CSRSign CSRSignerFunc
+
+	// CSRSigner signs a csr with a signer that may fail or sign asynchronously, e.g. an external PKI. It is
+	// used when the addon has its own customized signer and takes precedence over CSRSign. The failure of
+	// signing is reflected in the CSRSigned condition of the ManagedClusterAddOn.
+	// +optional
+	CSRSigner CSRSigner
+
+	// CertificateExpiryWarningPercentage is the percentage of the lifetime of the agent client certificate. The
+	// RegistrationCertificateExpiring condition of the ManagedClusterAddOn turns true when the remaining lifetime
+	// of a certificate is less than this percentage and no renewal csr is created. Defaults to 20.
+	// +optional
+	CertificateExpiryWarningPercentage int32
+
+	// ServiceAccountToken switches the registration of the addon agent from csr to ServiceAccount token if set.
+	// The hub creates a ServiceAccount named DefaultServiceAccountName(addonName) in the managed cluster namespace,
+	// and allows the token requesters of the option to create the token of this ServiceAccount only. The agent
+	// side requests the token with TokenRequest using the identity of a token requester, and keeps it in the secret
+	// "<addon name>-hub-kubeconfig" of the addon install namespace on the managed cluster, see the package
+	// pkg/token. Whether the token can be requested is recorded in the ServiceAccountTokenRequestable
+	// condition of the ManagedClusterAddOn. CSRConfigurations, CSRApproveCheck and CSRSign are not used in this
+	// mode, and the permissions of the agent on the hub are bound to the ServiceAccount instead of the groups of
+	// the agent, e.g. with BindClusterRoleToAgentGroup of the RBACPermissionBuilder. A warning is logged if no
+	// role binding or cluster role binding binds the ServiceAccount after the PermissionConfig.
+	//
+	// The token is never written to an object on the hub, so the users able to read the manifestWorks or the
+	// secrets of the managed cluster namespace can not read it. The token can be requested by the token requesters
+	// and by the users allowed to create serviceaccounts/token in the managed cluster namespace. The default token
+	// requester is the klusterlet agent of the managed cluster, which is trusted to register the addon agents with
+	// csr as well.
+	// NB this mode only supports the Default install mode for now, and the addon manager requires the RBAC
+	// permission to create serviceaccounts, roles and rolebindings on the hub.
+	// +optional
+	ServiceAccountToken *ServiceAccountTokenOption
+}
+
+// ServiceAccountTokenOption defines who can request the ServiceAccount token of the addon agent.
+type ServiceAccountTokenOption struct {
+	// TokenRequesters returns the hub identities allowed to request the token of the ServiceAccount of the addon
+	// agent in the managed cluster. It defaults to DefaultTokenRequesters.
+	// +optional
+	TokenRequesters func(clusterName, addonName string) []rbacv1.Subject
+}
+
+// DefaultTokenRequesters returns the group of the klusterlet agent of the managed cluster, whose hub kubeconfig
+// is on the managed cluster already.
+func DefaultTokenRequesters(clusterName, addonName string) []rbacv1.Subject {
+	return []rbacv1.Subject{
+		{
+			APIGroup: rbacv1.GroupName,
+			Kind:     rbacv1.GroupKind,
+			Name:     fmt.Sprintf("system:open-cluster-management:%s", clusterName),
+		},
+	}
 }
 
 // InstallStrategy is the installation strategy of the manifests prescribed by Manifests(..).
@@ -213,6 +348,12 @@ func DefaultUser(clusterName, addonName, agentName string) string {
 	return fmt.Sprintf("system:open-cluster-management:cluster:%s:addon:%s:agent:%s", clusterName, addonName, agentName)
 }
 
+// DefaultServiceAccountName returns the name of the ServiceAccount of the addon agent in the managed cluster
+// namespace when the agent is registered with ServiceAccount token.
+func DefaultServiceAccountName(addonName string) string {
+	return fmt.Sprintf("addon-%s-agent", addonName)
+}
+
 // DefaultGroups returns the default groups
 func DefaultGroups(clusterName, addonName string) []string {
 	return []string{
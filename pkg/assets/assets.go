@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"text/template"
 
 	"k8s.io/apimachinery/pkg/util/errors"
 )
@@ -85,16 +86,27 @@ func (a Asset) WriteFile(path string) error {
 }
 
 // MustCreateAssetFromTemplate process the given template using and return an asset.
+// It panics if the template fails to be rendered, use CreateAssetFromTemplate to get the error instead.
 func MustCreateAssetFromTemplate(name string, template []byte, config interface{}) Asset {
-	asset, err := assetFromTemplate(name, template, config)
+	asset, err := CreateAssetFromTemplate(name, template, config)
 	if err != nil {
 		panic(err)
 	}
-	return *asset
+	return asset
 }
 
-func assetFromTemplate(name string, tb []byte, data interface{}) (*Asset, error) {
-	bs, err := renderFile(name, tb, data)
+// CreateAssetFromTemplate process the given template with the sprig functions, the builtin functions and the
+// given funcMaps, and return an asset, or an error if the template fails to be parsed or executed.
+func CreateAssetFromTemplate(name string, tb []byte, config interface{}, funcMaps ...template.FuncMap) (Asset, error) {
+	asset, err := assetFromTemplate(name, tb, config, funcMaps...)
+	if err != nil {
+		return Asset{}, err
+	}
+	return *asset, nil
+}
+
+func assetFromTemplate(name string, tb []byte, data interface{}, funcMaps ...template.FuncMap) (*Asset, error) {
+	bs, err := renderFile(name, tb, data, funcMaps...)
 	if err != nil {
 		return nil, err
 	}
@@ -7,10 +7,13 @@ import (
 	"text/template"
 	"time"
 
+	"github.com/Masterminds/sprig/v3"
 	"k8s.io/client-go/util/cert"
 )
 
-var templateFuncs = map[string]interface{}{
+// templateFuncs are the builtin functions of the templates, which override the sprig functions with the
+// same names, e.g. indent.
+var templateFuncs = template.FuncMap{
 	"notAfter":  notAfter,
 	"notBefore": notBefore,
 	"issuer":    issuer,
@@ -28,45 +31,71 @@ func base64encode(v []byte) string {
 	return base64.StdEncoding.EncodeToString(v)
 }
 
-func notAfter(certBytes []byte) string {
+func notAfter(certBytes []byte) (string, error) {
 	if len(certBytes) == 0 {
-		return ""
+		return "", nil
 	}
 	certs, err := cert.ParseCertsPEM(certBytes)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
-	return certs[0].NotAfter.Format(time.RFC3339)
+	return certs[0].NotAfter.Format(time.RFC3339), nil
 }
 
-func notBefore(certBytes []byte) string {
+func notBefore(certBytes []byte) (string, error) {
 	if len(certBytes) == 0 {
-		return ""
+		return "", nil
 	}
 	certs, err := cert.ParseCertsPEM(certBytes)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
-	return certs[0].NotBefore.Format(time.RFC3339)
+	return certs[0].NotBefore.Format(time.RFC3339), nil
 }
 
-func issuer(certBytes []byte) string {
+func issuer(certBytes []byte) (string, error) {
 	if len(certBytes) == 0 {
-		return ""
+		return "", nil
 	}
 	certs, err := cert.ParseCertsPEM(certBytes)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
-	return certs[0].Issuer.CommonName
+	return certs[0].Issuer.CommonName, nil
 }
 
 func load(n string, assets map[string][]byte) []byte {
 	return assets[n]
 }
 
-func renderFile(name string, tb []byte, data interface{}) ([]byte, error) {
-	tmpl, err := template.New(name).Funcs(templateFuncs).Parse(string(tb))
+// ParseTemplate parses the template with the same functions as CreateAssetFromTemplate, and returns the error
+// if the template is invalid, e.g. it has a syntax error or uses an undefined function.
+func ParseTemplate(name string, tb []byte, funcMaps ...template.FuncMap) error {
+	_, err := newTemplate(name, funcMaps...).Parse(string(tb))
+	return err
+}
+
+// sprigFuncs returns the sprig functions without env and expandenv, the same as helm does, so the templates can not
+// read the environment variables of the addon manager.
+func sprigFuncs() template.FuncMap {
+	funcs := sprig.TxtFuncMap()
+	delete(funcs, "env")
+	delete(funcs, "expandenv")
+	return funcs
+}
+
+func newTemplate(name string, funcMaps ...template.FuncMap) *template.Template {
+	tmpl := template.New(name).Funcs(sprigFuncs()).Funcs(templateFuncs)
+	for _, funcMap := range funcMaps {
+		tmpl = tmpl.Funcs(funcMap)
+	}
+	return tmpl
+}
+
+// renderFile renders the template with the sprig functions, the builtin functions and the given funcMaps,
+// the functions in the latter funcMaps override the ones with the same names.
+func renderFile(name string, tb []byte, data interface{}, funcMaps ...template.FuncMap) ([]byte, error) {
+	tmpl, err := newTemplate(name, funcMaps...).Parse(string(tb))
 	if err != nil {
 		return nil, err
 	}
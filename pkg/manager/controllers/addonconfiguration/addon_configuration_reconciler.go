@@ -4,9 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	jsonpatch "github.com/evanphx/json-patch"
 	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
@@ -26,6 +28,9 @@ func (d *managedClusterAddonConfigurationReconciler) reconcile(
 
 	for _, addon := range graph.addonToUpdate() {
 		mca := d.mergeAddonConfig(addon.mca, addon.desiredConfigs)
+		if !equality.Semantic.DeepEqual(mca.Status.ConfigReferences, addon.mca.Status.ConfigReferences) {
+			setProgressingOnConfigsPushed(mca, graph.now)
+		}
 		err := d.patchAddonStatus(ctx, mca, addon.mca)
 		if err != nil {
 			errs = append(errs, err)
@@ -73,6 +78,39 @@ func (d *managedClusterAddonConfigurationReconciler) mergeAddonConfig(
 	return mcaCopy
 }
 
+// setProgressingOnConfigsPushed restarts the Progressing condition of the addon at the time the new desired configs
+// are pushed, so the condition left by the previous configs is not taken as the progress of the new ones. The
+// condition is not changed if the addon already applied the desired configs.
+func setProgressingOnConfigsPushed(mca *addonv1alpha1.ManagedClusterAddOn, now time.Time) {
+	applied, isUpgrade := true, false
+	for _, configReference := range mca.Status.ConfigReferences {
+		if !equality.Semantic.DeepEqual(configReference.LastAppliedConfig, configReference.DesiredConfig) {
+			applied = false
+		}
+		if configReference.LastAppliedConfig != nil && configReference.LastAppliedConfig.SpecHash != "" {
+			isUpgrade = true
+		}
+	}
+	if applied {
+		return
+	}
+
+	condition := metav1.Condition{
+		Type:               addonv1alpha1.ManagedClusterAddOnConditionProgressing,
+		Status:             metav1.ConditionTrue,
+		Reason:             addonv1alpha1.ProgressingReasonInstalling,
+		Message:            "installing... desired configs are updated",
+		LastTransitionTime: metav1.NewTime(now),
+	}
+	if isUpgrade {
+		condition.Reason = addonv1alpha1.ProgressingReasonUpgrading
+		condition.Message = "upgrading... desired configs are updated"
+	}
+	// remove the condition at first so the last transition time is reset even if the addon was progressing
+	meta.RemoveStatusCondition(&mca.Status.Conditions, addonv1alpha1.ManagedClusterAddOnConditionProgressing)
+	meta.SetStatusCondition(&mca.Status.Conditions, condition)
+}
+
 func (d *managedClusterAddonConfigurationReconciler) patchAddonStatus(ctx context.Context, new, old *addonv1alpha1.ManagedClusterAddOn) error {
 	if equality.Semantic.DeepEqual(new.Status, old.Status) {
 		return nil
@@ -82,6 +120,7 @@ func (d *managedClusterAddonConfigurationReconciler) patchAddonStatus(ctx contex
 		Status: addonv1alpha1.ManagedClusterAddOnStatus{
 			Namespace:        old.Status.Namespace,
 			ConfigReferences: old.Status.ConfigReferences,
+			Conditions:       old.Status.Conditions,
 		},
 	})
 	if err != nil {
@@ -96,6 +135,7 @@ func (d *managedClusterAddonConfigurationReconciler) patchAddonStatus(ctx contex
 		Status: addonv1alpha1.ManagedClusterAddOnStatus{
 			Namespace:        new.Status.Namespace,
 			ConfigReferences: new.Status.ConfigReferences,
+			Conditions:       new.Status.Conditions,
 		},
 	})
 	if err != nil {
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/intstr"
@@ -628,3 +629,82 @@ func expectPatchConfigurationAction(t *testing.T, action clienttesting.Action, e
 		t.Errorf("Configuration not correctly patched, expected %v, actual %v", expected, mca.Status.ConfigReferences)
 	}
 }
+
+func TestSetProgressingOnConfigsPushed(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	upgradeSucceed := metav1.Condition{
+		Type:               addonv1alpha1.ManagedClusterAddOnConditionProgressing,
+		Status:             metav1.ConditionFalse,
+		Reason:             addonv1alpha1.ProgressingReasonUpgradeSucceed,
+		LastTransitionTime: metav1.NewTime(now.Add(-24 * time.Hour)),
+	}
+	upgrading := metav1.Condition{
+		Type:               addonv1alpha1.ManagedClusterAddOnConditionProgressing,
+		Status:             metav1.ConditionTrue,
+		Reason:             addonv1alpha1.ProgressingReasonUpgrading,
+		LastTransitionTime: metav1.NewTime(now.Add(-time.Hour)),
+	}
+	configReference := func(desired, applied string) addonv1alpha1.ConfigReference {
+		ref := addonv1alpha1.ConfigReference{
+			ConfigGroupResource: addonv1alpha1.ConfigGroupResource{Group: "core", Resource: "Foo"},
+			DesiredConfig:       &addonv1alpha1.ConfigSpecHash{SpecHash: desired},
+		}
+		if len(applied) > 0 {
+			ref.LastAppliedConfig = &addonv1alpha1.ConfigSpecHash{SpecHash: applied}
+		}
+		return ref
+	}
+
+	cases := []struct {
+		name               string
+		condition          metav1.Condition
+		configReference    addonv1alpha1.ConfigReference
+		expectedReason     string
+		expectedTransition time.Time
+	}{
+		{
+			name:               "install",
+			condition:          upgradeSucceed,
+			configReference:    configReference("hash1", ""),
+			expectedReason:     addonv1alpha1.ProgressingReasonInstalling,
+			expectedTransition: now,
+		},
+		{
+			name:               "upgrade after the previous configs succeeded",
+			condition:          upgradeSucceed,
+			configReference:    configReference("hash1", "hash0"),
+			expectedReason:     addonv1alpha1.ProgressingReasonUpgrading,
+			expectedTransition: now,
+		},
+		{
+			name:               "upgrade while upgrading to the previous configs",
+			condition:          upgrading,
+			configReference:    configReference("hash2", "hash0"),
+			expectedReason:     addonv1alpha1.ProgressingReasonUpgrading,
+			expectedTransition: now,
+		},
+		{
+			name:               "configs already applied",
+			condition:          upgradeSucceed,
+			configReference:    configReference("hash0", "hash0"),
+			expectedReason:     addonv1alpha1.ProgressingReasonUpgradeSucceed,
+			expectedTransition: upgradeSucceed.LastTransitionTime.Time,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mca := addontesting.NewAddonWithConditions("test", "cluster1", c.condition)
+			mca.Status.ConfigReferences = []addonv1alpha1.ConfigReference{c.configReference}
+			setProgressingOnConfigsPushed(mca, now)
+
+			progressing := meta.FindStatusCondition(mca.Status.Conditions, addonv1alpha1.ManagedClusterAddOnConditionProgressing)
+			if progressing.Reason != c.expectedReason {
+				t.Errorf("expected reason %s, but got %s", c.expectedReason, progressing.Reason)
+			}
+			if !progressing.LastTransitionTime.Time.Equal(c.expectedTransition) {
+				t.Errorf("expected last transition time %v, but got %v", c.expectedTransition, progressing.LastTransitionTime)
+			}
+		})
+	}
+}
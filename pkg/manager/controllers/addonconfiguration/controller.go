@@ -69,6 +69,9 @@ func NewAddonConfigurationController(
 		&managedClusterAddonConfigurationReconciler{
 			addonClient: addonClient,
 		},
+		&managedClusterAddonRollbackReconciler{
+			addonClient: addonClient,
+		},
 		&clusterManagementAddonProgressingReconciler{
 			addonClient: addonClient,
 		},
@@ -139,11 +142,23 @@ func (c *addonConfigurationController) sync(ctx context.Context, syncCtx factory
 		}
 	}
 
+	// check the rollout gates again once the addons waiting for the min available seconds or the progress deadline
+	// are expected to change the rollout status.
+	if requeueAfter := graph.requeueAfter(); requeueAfter > 0 {
+		syncCtx.Queue().AddAfter(key, requeueAfter)
+	}
+
 	return utilerrors.NewAggregate(errs)
 }
 
 func (c *addonConfigurationController) buildConfigurationGraph(cma *addonv1alpha1.ClusterManagementAddOn) (*configurationGraph, error) {
 	graph := newGraph(cma.Spec.SupportedConfigs, cma.Status.DefaultConfigReferences)
+	gates, err := getRolloutGates(cma)
+	if err != nil {
+		return graph, err
+	}
+	graph.gates = gates
+
 	addons, err := c.managedClusterAddonIndexer.ByIndex(index.ManagedClusterAddonByName, cma.Name)
 	if err != nil {
 		return graph, err
@@ -5,11 +5,16 @@ import (
 	"math"
 	"sort"
 	"strconv"
+	"time"
 
 	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/apimachinery/pkg/util/sets"
 	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/constants"
 )
 
 var (
@@ -25,6 +30,10 @@ type configurationGraph struct {
 	nodes []*installStrategyNode
 	// defaults is the nodes with no install strategy
 	defaults *installStrategyNode
+	// gates is the rollout gates of the install strategy nodes, nil if the rollout is not gated
+	gates *rolloutGates
+	// now is the time to check the rollout gates
+	now time.Time
 }
 
 // installStrategyNode is a node in configurationGraph defined by a install strategy
@@ -35,6 +44,13 @@ type installStrategyNode struct {
 	// children keeps a map of addons node as the children of this node
 	children map[string]*addonNode
 	clusters sets.Set[string]
+	gates    *rolloutGates
+	now      time.Time
+	// lastKnownGoodConfigs is the configs last rolled out successfully on the placement
+	lastKnownGoodConfigs map[addonv1alpha1.ConfigGroupResource]addonv1alpha1.ConfigSpecHash
+	// invalidConfigs is the message of the ConfigurationValid condition of the install progression if its desired
+	// configs are invalid, no addon is updated to the desired configs until they are valid.
+	invalidConfigs string
 }
 
 // addonNode is node as a child of installStrategy node represting a mca
@@ -44,6 +60,13 @@ type addonNode struct {
 	mca            *addonv1alpha1.ManagedClusterAddOn
 	// record mca upgrade status
 	mcaUpgradeStatus upgradeStatus
+	// record mca rollout status checked by the rollout gates
+	rolloutStatus  rolloutStatus
+	rolloutMessage string
+	// the duration before the rollout status changes over time
+	requeueAfter time.Duration
+	// record whether mca is pinned to the previous configs rolled back on the failed rollout
+	rolledBack bool
 }
 
 type upgradeStatus int
@@ -57,6 +80,17 @@ const (
 	upgraded
 )
 
+type rolloutStatus int
+
+const (
+	// mca is not upgraded, or upgraded but not passed the rollout gates yet
+	rolloutWaiting rolloutStatus = iota
+	// mca is upgraded and passed the rollout gates
+	rolloutSucceeded
+	// mca failed to upgrade, or not passed the rollout gates within the progress deadline
+	rolloutFailed
+)
+
 type addonConfigMap map[addonv1alpha1.ConfigGroupResource]addonv1alpha1.ConfigReference
 
 // set addon upgrade status
@@ -100,6 +134,7 @@ func newGraph(supportedConfigs []addonv1alpha1.ConfigMeta, defaultConfigReferenc
 			desiredConfigs: map[addonv1alpha1.ConfigGroupResource]addonv1alpha1.ConfigReference{},
 			children:       map[string]*addonNode{},
 		},
+		now: time.Now(),
 	}
 
 	// init graph.defaults.desiredConfigs with supportedConfigs
@@ -155,6 +190,10 @@ func (g *configurationGraph) addPlacementNode(
 		desiredConfigs: g.defaults.desiredConfigs,
 		children:       map[string]*addonNode{},
 		clusters:       sets.New[string](clusters...),
+		gates:          g.gates,
+		now:            g.now,
+
+		lastKnownGoodConfigs: map[addonv1alpha1.ConfigGroupResource]addonv1alpha1.ConfigSpecHash{},
 	}
 
 	// set max concurrency
@@ -166,6 +205,17 @@ func (g *configurationGraph) addPlacementNode(
 		}
 	}
 
+	if cond := meta.FindStatusCondition(installProgression.Conditions,
+		constants.AddonConfigurationValidConditionType); cond != nil && cond.Status == metav1.ConditionFalse {
+		node.invalidConfigs = cond.Message
+	}
+
+	for _, configRef := range installConfigReference {
+		if configRef.LastKnownGoodConfig != nil {
+			node.lastKnownGoodConfigs[configRef.ConfigGroupResource] = *configRef.LastKnownGoodConfig
+		}
+	}
+
 	// overrides configuration by install strategy
 	if len(installConfigReference) > 0 {
 		node.desiredConfigs = node.desiredConfigs.copy()
@@ -248,14 +298,18 @@ func (n *installStrategyNode) addNode(addon *addonv1alpha1.ManagedClusterAddOn)
 		}
 	}
 
-	// set addon node upgrade status
+	// pin the configs rolled back on the failed rollout
+	n.children[addon.Namespace].pinRolledBackConfigs()
+
+	// set addon node upgrade status and rollout status
 	n.children[addon.Namespace].setUpgradeStatus()
+	n.children[addon.Namespace].setRolloutStatus(n.gates, n.now)
 }
 
 func (n *installStrategyNode) addonUpgraded() int {
 	count := 0
 	for _, addon := range n.children {
-		if desiredConfigsEqual(addon.desiredConfigs, n.desiredConfigs) && addon.mcaUpgradeStatus == upgraded {
+		if desiredConfigsEqual(addon.desiredConfigs, n.desiredConfigs) && addon.rolloutStatus == rolloutSucceeded {
 			count += 1
 		}
 	}
@@ -265,7 +319,8 @@ func (n *installStrategyNode) addonUpgraded() int {
 func (n *installStrategyNode) addonUpgrading() int {
 	count := 0
 	for _, addon := range n.children {
-		if desiredConfigsEqual(addon.desiredConfigs, n.desiredConfigs) && addon.mcaUpgradeStatus == upgrading {
+		if desiredConfigsEqual(addon.desiredConfigs, n.desiredConfigs) && addon.mcaUpgradeStatus != toupgrade &&
+			addon.rolloutStatus == rolloutWaiting {
 			count += 1
 		}
 	}
@@ -274,7 +329,7 @@ func (n *installStrategyNode) addonUpgrading() int {
 
 // addonToUpdate finds the addons to be updated by placement
 func (n *installStrategyNode) addonToUpdate() []*addonNode {
-	var addons []*addonNode
+	var addons, rolledBackAddons []*addonNode
 
 	// sort the children by key
 	keys := make([]string, 0, len(n.children))
@@ -283,28 +338,36 @@ func (n *installStrategyNode) addonToUpdate() []*addonNode {
 	}
 	sort.Strings(keys)
 
-	total := len(n.clusters)
-	if total == 0 {
-		total = len(n.children)
+	// the rolled back addons are updated to the pinned configs at once, even if the rollout is paused
+	for _, k := range keys {
+		if addon := n.children[k]; addon.rolledBack && addon.mcaUpgradeStatus == toupgrade {
+			rolledBackAddons = append(rolledBackAddons, addon)
+		}
+	}
+
+	// no addon is updated while the rollout is paused by the rollout gates, or the desired configs are invalid
+	if n.rolloutPaused() || len(n.invalidConfigs) > 0 {
+		return rolledBackAddons
 	}
 
-	length, _ := parseMaxConcurrency(n.maxConcurrency, total)
+	length, _ := parseMaxConcurrency(n.maxConcurrency, n.total())
 	if length == 0 {
-		return addons
+		return rolledBackAddons
 	}
 
 	for i, k := range keys {
 		if (i%length == 0) && len(addons) > 0 {
-			return addons
+			break
 		}
 
+		// the failed addons within the max failures and the rolled back addons are skipped
 		addon := n.children[k]
-		if addon.mcaUpgradeStatus != upgraded {
+		if addon.rolloutStatus == rolloutWaiting && !addon.rolledBack {
 			addons = append(addons, addon)
 		}
 	}
 
-	return addons
+	return append(rolledBackAddons, addons...)
 }
 
 func parseMaxConcurrency(maxConcurrency intstr.IntOrString, total int) (int, error) {
@@ -15,6 +15,8 @@ import (
 
 	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
 	addonv1alpha1client "open-cluster-management.io/api/client/addon/clientset/versioned"
+
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/constants"
 )
 
 type clusterManagementAddonProgressingReconciler struct {
@@ -43,12 +45,25 @@ func (d *clusterManagementAddonProgressingReconciler) reconcile(
 			}
 		}
 
+		// the rollout is blocked until the desired configs are valid, the last applied configs are kept.
+		if len(placementNode.invalidConfigs) > 0 {
+			meta.SetStatusCondition(&cmaCopy.Status.InstallProgressions[i].Conditions, metav1.Condition{
+				Type:    addonv1alpha1.ManagedClusterAddOnConditionProgressing,
+				Status:  metav1.ConditionFalse,
+				Reason:  constants.AddonConfigurationValidReasonInvalid,
+				Message: fmt.Sprintf("the rollout is blocked by %s", placementNode.invalidConfigs),
+			})
+			setRolloutPausedCondition(&cmaCopy.Status.InstallProgressions[i], placementNode)
+			continue
+		}
+
 		setAddOnInstallProgressionsAndLastApplied(&cmaCopy.Status.InstallProgressions[i],
 			isUpgrade,
 			placementNode.addonUpgrading(),
 			placementNode.addonUpgraded(),
 			len(placementNode.clusters),
 		)
+		setRolloutPausedCondition(&cmaCopy.Status.InstallProgressions[i], placementNode)
 	}
 
 	err := d.patchMgmtAddonStatus(ctx, cmaCopy, cma)
@@ -0,0 +1,212 @@
+package addonconfiguration
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/klog/v2"
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	addonv1alpha1client "open-cluster-management.io/api/client/addon/clientset/versioned"
+
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/constants"
+)
+
+// configRollback is a config rolled back on the addon, the addon is pinned to the previous config while the desired
+// config of its placement is the failed one.
+type configRollback struct {
+	Failed addonv1alpha1.ConfigSpecHash `json:"failed"`
+	Pinned addonv1alpha1.ConfigSpecHash `json:"pinned"`
+}
+
+// configRollbacks is the configs rolled back on the addon, recorded in the annotation
+// constants.AddonConfigRollbackAnnotationKey, the key is the config group resource in the format of resource.group.
+type configRollbacks map[string]configRollback
+
+// addOnDeploymentConfigGroupResource is the only config type which can be rolled back, the addon renders its
+// previous revisions from the history on the hub.
+var addOnDeploymentConfigGroupResource = addonv1alpha1.ConfigGroupResource{
+	Group:    "addon.open-cluster-management.io",
+	Resource: "addondeploymentconfigs",
+}
+
+func configRollbackKey(gr addonv1alpha1.ConfigGroupResource) string {
+	return fmt.Sprintf("%s.%s", gr.Resource, gr.Group)
+}
+
+// getConfigRollbacks returns the configs rolled back on the addon, the invalid annotation is ignored.
+func getConfigRollbacks(addon *addonv1alpha1.ManagedClusterAddOn) configRollbacks {
+	value, ok := addon.Annotations[constants.AddonConfigRollbackAnnotationKey]
+	if !ok {
+		return nil
+	}
+	rollbacks := configRollbacks{}
+	if err := json.Unmarshal([]byte(value), &rollbacks); err != nil {
+		klog.Warningf("ignore the invalid annotation %s of addon %s/%s: %v",
+			constants.AddonConfigRollbackAnnotationKey, addon.Namespace, addon.Name, err)
+		return nil
+	}
+	return rollbacks
+}
+
+// pinRolledBackConfigs replaces the desired configs of the addon which are rolled back with the pinned configs. The
+// configs in the mca spec are not pinned since they are not rolled out by the placement.
+func (n *addonNode) pinRolledBackConfigs() {
+	rollbacks := getConfigRollbacks(n.mca)
+	if len(rollbacks) == 0 {
+		return
+	}
+
+	desiredConfigs := n.desiredConfigs
+	for gr, desired := range desiredConfigs {
+		rollback, ok := rollbacks[configRollbackKey(gr)]
+		if !ok || desired.DesiredConfig == nil || *desired.DesiredConfig != rollback.Failed || inSpecConfigs(n.mca, gr) {
+			continue
+		}
+		if !n.rolledBack {
+			n.desiredConfigs = desiredConfigs.copy()
+			n.rolledBack = true
+		}
+		n.desiredConfigs[gr] = addonv1alpha1.ConfigReference{
+			ConfigGroupResource: gr,
+			ConfigReferent:      rollback.Pinned.ConfigReferent,
+			DesiredConfig:       rollback.Pinned.DeepCopy(),
+		}
+	}
+}
+
+// configRollbacks returns the configs to roll back on the addon and true if they are changed from the annotation
+// of the addon. The rollbacks whose failed config is no longer desired by the placement are removed, and the addon
+// failed with the desired configs of the placement is rolled back if the rollout gates enable autoRollback.
+func (n *installStrategyNode) configRollbacks(addon *addonNode) (configRollbacks, bool) {
+	existing := getConfigRollbacks(addon.mca)
+	rollbacks := configRollbacks{}
+	for gr, desired := range n.desiredConfigs {
+		key := configRollbackKey(gr)
+		if rollback, ok := existing[key]; ok && desired.DesiredConfig != nil && *desired.DesiredConfig == rollback.Failed {
+			rollbacks[key] = rollback
+		}
+	}
+
+	if n.gates != nil && n.gates.AutoRollback && addon.failedWithDesiredConfigs() &&
+		desiredConfigsEqual(addon.desiredConfigs, n.desiredConfigs) {
+		for key, rollback := range n.rollbacksOf(addon) {
+			rollbacks[key] = rollback
+		}
+	}
+
+	if len(rollbacks) == 0 && len(existing) == 0 {
+		return rollbacks, false
+	}
+	return rollbacks, !equality.Semantic.DeepEqual(rollbacks, existing)
+}
+
+// failedWithDesiredConfigs returns true if the addon failed the rollout of its desired configs. The failure is only
+// attributed to the desired configs once they are pushed to the addon, and the rollout status is only set by the
+// conditions reporting the desired configs, see setRolloutStatus.
+func (n *addonNode) failedWithDesiredConfigs() bool {
+	return n.rolloutStatus == rolloutFailed && n.mcaUpgradeStatus != toupgrade && !n.rolledBack
+}
+
+// rollbacksOf returns the configs to roll back on the failed addon, each changed desired config is pinned to the
+// last applied config of the addon, or the last known good config of the placement if the addon already applied
+// the desired config. Nothing is rolled back if any changed config has no previous config to roll back to, or is
+// not an AddOnDeploymentConfig, since only the previous revisions of the AddOnDeploymentConfigs can be rendered.
+func (n *installStrategyNode) rollbacksOf(addon *addonNode) configRollbacks {
+	rollbacks := configRollbacks{}
+	for gr, desired := range n.desiredConfigs {
+		if desired.DesiredConfig == nil || inSpecConfigs(addon.mca, gr) {
+			continue
+		}
+		// the config is not changed since the last successful rollout on the placement
+		if lastKnownGood, ok := n.lastKnownGoodConfigs[gr]; ok && lastKnownGood == *desired.DesiredConfig {
+			continue
+		}
+		if gr != addOnDeploymentConfigGroupResource {
+			return nil
+		}
+
+		var pinned *addonv1alpha1.ConfigSpecHash
+		for _, actual := range addon.mca.Status.ConfigReferences {
+			if actual.ConfigGroupResource == gr && actual.LastAppliedConfig != nil &&
+				*actual.LastAppliedConfig != *desired.DesiredConfig {
+				pinned = actual.LastAppliedConfig
+			}
+		}
+		if lastKnownGood, ok := n.lastKnownGoodConfigs[gr]; pinned == nil && ok {
+			pinned = &lastKnownGood
+		}
+		if pinned == nil || len(pinned.SpecHash) == 0 {
+			return nil
+		}
+		rollbacks[configRollbackKey(gr)] = configRollback{Failed: *desired.DesiredConfig, Pinned: *pinned}
+	}
+	return rollbacks
+}
+
+func inSpecConfigs(addon *addonv1alpha1.ManagedClusterAddOn, gr addonv1alpha1.ConfigGroupResource) bool {
+	for _, config := range addon.Spec.Configs {
+		if config.ConfigGroupResource == gr {
+			return true
+		}
+	}
+	return false
+}
+
+// managedClusterAddonRollbackReconciler rolls the failed addons back to the previous configs by recording the
+// rollbacks in the annotation of the addons, the addons are then updated to the pinned configs in the graph.
+type managedClusterAddonRollbackReconciler struct {
+	addonClient addonv1alpha1client.Interface
+}
+
+func (d *managedClusterAddonRollbackReconciler) reconcile(
+	ctx context.Context, cma *addonv1alpha1.ClusterManagementAddOn, graph *configurationGraph) (*addonv1alpha1.ClusterManagementAddOn, reconcileState, error) {
+	var errs []error
+	for _, node := range append([]*installStrategyNode{graph.defaults}, graph.nodes...) {
+		for _, addon := range node.children {
+			rollbacks, changed := node.configRollbacks(addon)
+			if !changed {
+				continue
+			}
+			if err := d.patchConfigRollbacks(ctx, addon.mca, rollbacks); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return cma, reconcileContinue, utilerrors.NewAggregate(errs)
+}
+
+func (d *managedClusterAddonRollbackReconciler) patchConfigRollbacks(ctx context.Context,
+	addon *addonv1alpha1.ManagedClusterAddOn, rollbacks configRollbacks) error {
+	// the annotation is removed by a null value in the merge patch
+	var value interface{}
+	if len(rollbacks) > 0 {
+		data, err := json.Marshal(rollbacks)
+		if err != nil {
+			return err
+		}
+		value = string(data)
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"uid":             addon.UID,
+			"resourceVersion": addon.ResourceVersion,
+			"annotations": map[string]interface{}{
+				constants.AddonConfigRollbackAnnotationKey: value,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	klog.V(2).Infof("Patching config rollbacks of addon %s/%s with %v", addon.Namespace, addon.Name, value)
+	_, err = d.addonClient.AddonV1alpha1().ManagedClusterAddOns(addon.Namespace).Patch(
+		ctx, addon.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
@@ -0,0 +1,194 @@
+package addonconfiguration
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clienttesting "k8s.io/client-go/testing"
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	fakeaddon "open-cluster-management.io/api/client/addon/clientset/versioned/fake"
+
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/addontesting"
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/constants"
+)
+
+func newConfigSpecHash(name, hash string) addonv1alpha1.ConfigSpecHash {
+	return addonv1alpha1.ConfigSpecHash{ConfigReferent: addonv1alpha1.ConfigReferent{Name: name}, SpecHash: hash}
+}
+
+func withLastAppliedConfig(addon *addonv1alpha1.ManagedClusterAddOn, name, hash string) *addonv1alpha1.ManagedClusterAddOn {
+	lastApplied := newConfigSpecHash(name, hash)
+	addon.Status.ConfigReferences[0].LastAppliedConfig = &lastApplied
+	return addon
+}
+
+func withConfigRollbacks(addon *addonv1alpha1.ManagedClusterAddOn, rollbacks configRollbacks) *addonv1alpha1.ManagedClusterAddOn {
+	data, _ := json.Marshal(rollbacks)
+	addon.Annotations = map[string]string{constants.AddonConfigRollbackAnnotationKey: string(data)}
+	return addon
+}
+
+func TestConfigRollback(t *testing.T) {
+	upgradeFailed := newRolloutCondition(addonv1alpha1.ManagedClusterAddOnConditionProgressing,
+		metav1.ConditionFalse, addonv1alpha1.ProgressingReasonUpgradeFailed, time.Now())
+	upgradeSucceed := newRolloutCondition(addonv1alpha1.ManagedClusterAddOnConditionProgressing,
+		metav1.ConditionFalse, addonv1alpha1.ProgressingReasonUpgradeSucceed, time.Now().Add(-24*time.Hour))
+	degraded := newRolloutCondition(addonv1alpha1.ManagedClusterAddOnConditionDegraded,
+		metav1.ConditionTrue, "Degraded", time.Now())
+	degradedBeforeUpgraded := newRolloutCondition(addonv1alpha1.ManagedClusterAddOnConditionDegraded,
+		metav1.ConditionTrue, "Degraded", time.Now().Add(-48*time.Hour))
+	rollbackKey := configRollbackKey(addOnDeploymentConfigGroupResource)
+	failedRollback := configRollback{Failed: newConfigSpecHash("test1", "hash1"), Pinned: newConfigSpecHash("test1", "hash0")}
+
+	cases := []struct {
+		name              string
+		configGR          *addonv1alpha1.ConfigGroupResource
+		gates             *rolloutGates
+		lastKnownGood     *addonv1alpha1.ConfigSpecHash
+		addon             *addonv1alpha1.ManagedClusterAddOn
+		expectedRollbacks *configRollbacks
+		expectedClusters  []string
+		expectedFailed    []string
+	}{
+		{
+			name:  "roll back to the last applied config",
+			gates: &rolloutGates{AutoRollback: true},
+			addon: withLastAppliedConfig(newRolloutAddon("cluster1", false, upgradeFailed), "test1", "hash0"),
+			expectedRollbacks: &configRollbacks{
+				rollbackKey: failedRollback,
+			},
+			expectedFailed: []string{"cluster1 (UpgradeFailed)"},
+		},
+		{
+			name:          "roll back to the last known good config",
+			gates:         &rolloutGates{AutoRollback: true},
+			lastKnownGood: &failedRollback.Pinned,
+			addon:         newRolloutAddon("cluster1", true, degraded, upgradeSucceed),
+			expectedRollbacks: &configRollbacks{
+				rollbackKey: failedRollback,
+			},
+			expectedFailed: []string{"cluster1 (Degraded)"},
+		},
+		{
+			name:             "progressing condition of the previous configs",
+			gates:            &rolloutGates{AutoRollback: true, ProgressDeadline: &metav1.Duration{Duration: 10 * time.Minute}},
+			addon:            withLastAppliedConfig(newRolloutAddon("cluster1", false, upgradeSucceed), "test1", "hash0"),
+			expectedClusters: []string{"cluster1", "cluster2"},
+		},
+		{
+			name:             "degraded before the desired config is applied",
+			gates:            &rolloutGates{AutoRollback: true},
+			lastKnownGood:    &failedRollback.Pinned,
+			addon:            newRolloutAddon("cluster1", true, degradedBeforeUpgraded, upgradeSucceed),
+			expectedClusters: []string{"cluster1", "cluster2"},
+		},
+		{
+			name:           "not an AddOnDeploymentConfig",
+			configGR:       &addonv1alpha1.ConfigGroupResource{Group: "core", Resource: "Foo"},
+			gates:          &rolloutGates{AutoRollback: true},
+			addon:          withLastAppliedConfig(newRolloutAddon("cluster1", false, upgradeFailed), "test1", "hash0"),
+			expectedFailed: []string{"cluster1 (UpgradeFailed)"},
+		},
+		{
+			name:           "no previous config",
+			gates:          &rolloutGates{AutoRollback: true},
+			addon:          newRolloutAddon("cluster1", false, upgradeFailed),
+			expectedFailed: []string{"cluster1 (UpgradeFailed)"},
+		},
+		{
+			name:           "auto rollback disabled",
+			gates:          &rolloutGates{},
+			addon:          withLastAppliedConfig(newRolloutAddon("cluster1", false, upgradeFailed), "test1", "hash0"),
+			expectedFailed: []string{"cluster1 (UpgradeFailed)"},
+		},
+		{
+			name:  "pinned to the previous config",
+			gates: &rolloutGates{AutoRollback: true},
+			addon: withConfigRollbacks(withLastAppliedConfig(newRolloutAddon("cluster1", false, upgradeFailed),
+				"test1", "hash0"), configRollbacks{rollbackKey: failedRollback}),
+			expectedClusters: []string{"cluster1"},
+			expectedFailed:   []string{"cluster1 (RolledBack)"},
+		},
+		{
+			name: "stale rollback",
+			addon: withConfigRollbacks(newRolloutAddon("cluster1", true), configRollbacks{rollbackKey: {
+				Failed: newConfigSpecHash("test1", "hash2"), Pinned: newConfigSpecHash("test1", "hash0"),
+			}}),
+			expectedRollbacks: &configRollbacks{},
+			expectedClusters:  []string{"cluster2"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			gr := addOnDeploymentConfigGroupResource
+			if c.configGR != nil {
+				gr = *c.configGR
+			}
+			c.addon.Status.ConfigReferences[0].ConfigGroupResource = gr
+			configReference := newInstallConfigReference(gr.Group, gr.Resource, "test1", "hash1")
+			configReference.LastKnownGoodConfig = c.lastKnownGood
+			graph := newRolloutGraph(c.gates, time.Now(), configReference,
+				[]*addonv1alpha1.ManagedClusterAddOn{c.addon, newRolloutAddon("cluster2", false)})
+
+			var clusters []string
+			for _, addon := range graph.addonToUpdate() {
+				clusters = append(clusters, addon.mca.Namespace)
+				if addon.rolledBack {
+					pinned := addon.desiredConfigs[gr]
+					if *pinned.DesiredConfig != failedRollback.Pinned {
+						t.Errorf("expected the addon pinned to %v, but got %v", failedRollback.Pinned, pinned)
+					}
+				}
+			}
+			if !reflect.DeepEqual(clusters, c.expectedClusters) {
+				t.Errorf("expected addons to update on %v, but got %v", c.expectedClusters, clusters)
+			}
+			if c.gates != nil {
+				if failed := graph.getPlacementNodes()[rolloutPlacementRef].addonFailed(); !reflect.DeepEqual(failed, c.expectedFailed) {
+					t.Errorf("expected failed addons %v, but got %v", c.expectedFailed, failed)
+				}
+			}
+
+			fakeAddonClient := fakeaddon.NewSimpleClientset([]runtime.Object{c.addon}...)
+			reconciler := &managedClusterAddonRollbackReconciler{addonClient: fakeAddonClient}
+			if _, _, err := reconciler.reconcile(context.TODO(), addontesting.NewClusterManagementAddon("test", "", "").Build(), graph); err != nil {
+				t.Fatalf("expected no error, got err %v", err)
+			}
+
+			actions := fakeAddonClient.Actions()
+			if c.expectedRollbacks == nil {
+				addontesting.AssertNoActions(t, actions)
+				return
+			}
+			addontesting.AssertActions(t, actions, "patch")
+			patch := struct {
+				Metadata struct {
+					Annotations map[string]*string `json:"annotations"`
+				} `json:"metadata"`
+			}{}
+			if err := json.Unmarshal(actions[0].(clienttesting.PatchActionImpl).Patch, &patch); err != nil {
+				t.Fatal(err)
+			}
+			value := patch.Metadata.Annotations[constants.AddonConfigRollbackAnnotationKey]
+			if len(*c.expectedRollbacks) == 0 {
+				if value != nil {
+					t.Errorf("expected the annotation removed, but got %s", *value)
+				}
+				return
+			}
+			rollbacks := configRollbacks{}
+			if err := json.Unmarshal([]byte(*value), &rollbacks); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(rollbacks, *c.expectedRollbacks) {
+				t.Errorf("expected rollbacks %v, but got %v", *c.expectedRollbacks, rollbacks)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package addonconfiguration
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/constants"
+)
+
+// rolloutGates are the gates of the rollout on each placement, defined by the annotation
+// constants.AddonRolloutGatesAnnotationKey of the cma.
+type rolloutGates struct {
+	// MinAvailableSeconds is the minimum seconds the addon is Available after it is upgraded, before the addon
+	// counts as done and the rollout moves on.
+	MinAvailableSeconds int32 `json:"minAvailableSeconds,omitempty"`
+	// MaxFailures is the maximum number or percentage of the failed addons on the placement, the rollout is paused
+	// once more addons failed. The percentage is rounded down, and defaults to 0.
+	MaxFailures *intstr.IntOrString `json:"maxFailures,omitempty"`
+	// ProgressDeadline is the maximum duration for an addon to be upgraded and Available since its Progressing
+	// condition changed, the addon counts as failed after the deadline.
+	ProgressDeadline *metav1.Duration `json:"progressDeadline,omitempty"`
+	// AutoRollback rolls the failed addons back to their previous configs, the addons keep the previous configs
+	// until the desired configs of the placement are changed again.
+	AutoRollback bool `json:"autoRollback,omitempty"`
+}
+
+// getRolloutGates returns the rollout gates of the cma, nil if the cma has no rollout gates.
+func getRolloutGates(cma *addonv1alpha1.ClusterManagementAddOn) (*rolloutGates, error) {
+	value, ok := cma.Annotations[constants.AddonRolloutGatesAnnotationKey]
+	if !ok {
+		return nil, nil
+	}
+
+	gates := &rolloutGates{}
+	if err := json.Unmarshal([]byte(value), gates); err != nil {
+		return nil, fmt.Errorf("invalid annotation %s of addon %s: %v",
+			constants.AddonRolloutGatesAnnotationKey, cma.Name, err)
+	}
+	if gates.MinAvailableSeconds < 0 {
+		return nil, fmt.Errorf("invalid annotation %s of addon %s: minAvailableSeconds must not be negative",
+			constants.AddonRolloutGatesAnnotationKey, cma.Name)
+	}
+	if gates.ProgressDeadline != nil && gates.ProgressDeadline.Duration <= 0 {
+		return nil, fmt.Errorf("invalid annotation %s of addon %s: progressDeadline must be positive",
+			constants.AddonRolloutGatesAnnotationKey, cma.Name)
+	}
+	if _, err := gates.maxFailures(100); err != nil {
+		return nil, fmt.Errorf("invalid annotation %s of addon %s: %v",
+			constants.AddonRolloutGatesAnnotationKey, cma.Name, err)
+	}
+	return gates, nil
+}
+
+// maxFailures returns the maximum number of the failed addons out of total.
+func (g *rolloutGates) maxFailures(total int) (int, error) {
+	if g.MaxFailures == nil {
+		return 0, nil
+	}
+	maxFailures, err := intstr.GetScaledValueFromIntOrPercent(g.MaxFailures, total, false)
+	if err != nil {
+		return 0, err
+	}
+	if maxFailures < 0 {
+		return 0, fmt.Errorf("maxFailures must not be negative")
+	}
+	return maxFailures, nil
+}
+
+// setRolloutStatus sets the rollout status of the addon by the rollout gates at the time now. Without the rollout
+// gates, the addon succeeded once it is upgraded.
+func (n *addonNode) setRolloutStatus(gates *rolloutGates, now time.Time) {
+	n.rolloutStatus, n.rolloutMessage, n.requeueAfter = rolloutWaiting, "", 0
+	if gates == nil {
+		if n.mcaUpgradeStatus == upgraded {
+			n.rolloutStatus = rolloutSucceeded
+		}
+		return
+	}
+	if n.mcaUpgradeStatus == toupgrade {
+		return
+	}
+
+	progressing := n.progressingOfDesiredConfigs()
+	if progressing != nil && (progressing.Reason == addonv1alpha1.ProgressingReasonUpgradeFailed ||
+		progressing.Reason == addonv1alpha1.ProgressingReasonInstallFailed) {
+		n.rolloutStatus, n.rolloutMessage = rolloutFailed, progressing.Reason
+		return
+	}
+
+	// the addon fails if it is degraded since the desired configs are applied. An addon degraded before, e.g. by the
+	// previous configs, is not counted as succeeded, and fails once the progress deadline is exceeded.
+	degraded := meta.FindStatusCondition(n.mca.Status.Conditions, addonv1alpha1.ManagedClusterAddOnConditionDegraded)
+	isDegraded := degraded != nil && degraded.Status == metav1.ConditionTrue
+	if n.mcaUpgradeStatus == upgraded && isDegraded && progressing != nil &&
+		!degraded.LastTransitionTime.Before(&progressing.LastTransitionTime) {
+		n.rolloutStatus, n.rolloutMessage = rolloutFailed, "Degraded"
+		return
+	}
+
+	available := meta.FindStatusCondition(n.mca.Status.Conditions, addonv1alpha1.ManagedClusterAddOnConditionAvailable)
+	if n.mcaUpgradeStatus == upgraded && !isDegraded && available != nil && available.Status == metav1.ConditionTrue {
+		// the addon is available since it is upgraded, or since it becomes available after the upgrade. The
+		// Progressing condition is restarted when the desired configs are pushed, so its last transition time is
+		// never earlier than the push.
+		availableSince := available.LastTransitionTime.Time
+		if progressing != nil && progressing.LastTransitionTime.After(availableSince) {
+			availableSince = progressing.LastTransitionTime.Time
+		}
+		wait := availableSince.Add(time.Duration(gates.MinAvailableSeconds) * time.Second).Sub(now)
+		if wait <= 0 {
+			n.rolloutStatus = rolloutSucceeded
+			return
+		}
+		n.requeueAfter = wait
+		return
+	}
+
+	if gates.ProgressDeadline != nil && progressing != nil {
+		wait := progressing.LastTransitionTime.Add(gates.ProgressDeadline.Duration).Sub(now)
+		if wait <= 0 {
+			n.rolloutStatus, n.rolloutMessage = rolloutFailed, "ProgressDeadlineExceeded"
+			return
+		}
+		n.requeueAfter = wait
+	}
+}
+
+// progressingOfDesiredConfigs returns the Progressing condition of the addon if it reports the desired configs, nil
+// otherwise. The condition is restarted when the desired configs are pushed. While the addon is upgrading, the
+// succeeded reasons are left by the previous configs. Once upgraded, only the succeeded reasons are set together
+// with the last applied configs.
+func (n *addonNode) progressingOfDesiredConfigs() *metav1.Condition {
+	progressing := meta.FindStatusCondition(n.mca.Status.Conditions, addonv1alpha1.ManagedClusterAddOnConditionProgressing)
+	if progressing == nil {
+		return nil
+	}
+	succeeded := progressing.Reason == addonv1alpha1.ProgressingReasonUpgradeSucceed ||
+		progressing.Reason == addonv1alpha1.ProgressingReasonInstallSucceed
+	if (n.mcaUpgradeStatus == upgrading) == succeeded {
+		return nil
+	}
+	return progressing
+}
+
+// addonFailed returns the clusters of the failed addons with the failure, sorted by the cluster name. The rolled
+// back addons count as failed.
+func (n *installStrategyNode) addonFailed() []string {
+	var failed []string
+	for cluster, addon := range n.children {
+		switch {
+		case addon.rolledBack:
+			failed = append(failed, fmt.Sprintf("%s (RolledBack)", cluster))
+		case desiredConfigsEqual(addon.desiredConfigs, n.desiredConfigs) && addon.rolloutStatus == rolloutFailed:
+			failed = append(failed, fmt.Sprintf("%s (%s)", cluster, addon.rolloutMessage))
+		}
+	}
+	sort.Strings(failed)
+	return failed
+}
+
+// rolloutPaused returns true if more addons failed than the maxFailures of the rollout gates, no addon is updated
+// on the placement until the failed addons recover or the configs change.
+func (n *installStrategyNode) rolloutPaused() bool {
+	if n.gates == nil {
+		return false
+	}
+	maxFailures, err := n.gates.maxFailures(n.total())
+	if err != nil {
+		return true
+	}
+	return len(n.addonFailed()) > maxFailures
+}
+
+// total returns the number of the addons on the placement.
+func (n *installStrategyNode) total() int {
+	if len(n.clusters) == 0 {
+		return len(n.children)
+	}
+	return len(n.clusters)
+}
+
+// requeueAfter returns the shortest duration before the rollout status of an addon changes over time, 0 if no
+// addon is waiting for the rollout gates.
+func (g *configurationGraph) requeueAfter() time.Duration {
+	var requeueAfter time.Duration
+	for _, node := range append([]*installStrategyNode{g.defaults}, g.nodes...) {
+		for _, addon := range node.children {
+			if addon.requeueAfter > 0 && (requeueAfter == 0 || addon.requeueAfter < requeueAfter) {
+				requeueAfter = addon.requeueAfter
+			}
+		}
+	}
+	return requeueAfter
+}
+
+// setRolloutPausedCondition sets the RolloutPaused condition of the install progression of the placement, if the
+// placement has the rollout gates.
+func setRolloutPausedCondition(installProgression *addonv1alpha1.InstallProgression, node *installStrategyNode) {
+	if node.gates == nil {
+		return
+	}
+
+	total := node.total()
+	failed := node.addonFailed()
+	maxFailures, err := node.gates.maxFailures(total)
+	if err != nil {
+		return
+	}
+
+	condition := metav1.Condition{
+		Type:   constants.AddonRolloutPausedConditionType,
+		Status: metav1.ConditionFalse,
+		Reason: constants.AddonRolloutPausedReasonWithinFailureThreshold,
+		Message: fmt.Sprintf("%d/%d failed, within the max failures %d.",
+			len(failed), total, maxFailures),
+	}
+	if len(failed) > maxFailures {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = constants.AddonRolloutPausedReasonFailureThresholdExceeded
+		condition.Message = fmt.Sprintf("%d/%d failed, exceeds the max failures %d: %s",
+			len(failed), total, maxFailures, strings.Join(failed, ", "))
+	}
+	meta.SetStatusCondition(&installProgression.Conditions, condition)
+}
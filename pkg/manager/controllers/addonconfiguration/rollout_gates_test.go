@@ -0,0 +1,386 @@
+package addonconfiguration
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	fakeaddon "open-cluster-management.io/api/client/addon/clientset/versioned/fake"
+
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/addontesting"
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/constants"
+)
+
+// newRolloutAddon returns an addon with the desired config test1/hash1, upgraded if applied is true.
+func newRolloutAddon(cluster string, applied bool, conditions ...metav1.Condition) *addonv1alpha1.ManagedClusterAddOn {
+	addon := addontesting.NewAddonWithConditions("test", cluster, conditions...)
+	configReference := addonv1alpha1.ConfigReference{
+		ConfigGroupResource: addonv1alpha1.ConfigGroupResource{Group: "core", Resource: "Foo"},
+		ConfigReferent:      addonv1alpha1.ConfigReferent{Name: "test1"},
+		DesiredConfig: &addonv1alpha1.ConfigSpecHash{
+			ConfigReferent: addonv1alpha1.ConfigReferent{Name: "test1"},
+			SpecHash:       "hash1",
+		},
+	}
+	if applied {
+		configReference.LastAppliedConfig = configReference.DesiredConfig.DeepCopy()
+	}
+	addon.Status.ConfigReferences = []addonv1alpha1.ConfigReference{configReference}
+	return addon
+}
+
+var rolloutPlacementRef = addonv1alpha1.PlacementRef{Name: "placement1", Namespace: "test"}
+
+// newRolloutGraph returns a graph of the addons on the placement rolling out the config with the max concurrency 2.
+func newRolloutGraph(gates *rolloutGates, now time.Time, configReference addonv1alpha1.InstallConfigReference,
+	addons []*addonv1alpha1.ManagedClusterAddOn) *configurationGraph {
+	graph := newGraph(nil, nil)
+	graph.gates = gates
+	graph.now = now
+	var clusters []string
+	for _, addon := range addons {
+		graph.addAddonNode(addon)
+		clusters = append(clusters, addon.Namespace)
+	}
+	graph.addPlacementNode(
+		addonv1alpha1.PlacementStrategy{
+			PlacementRef: rolloutPlacementRef,
+			RolloutStrategy: addonv1alpha1.RolloutStrategy{
+				Type:          addonv1alpha1.AddonRolloutStrategyRollingUpdate,
+				RollingUpdate: &addonv1alpha1.RollingUpdate{MaxConcurrency: intstr.FromInt(2)},
+			},
+		},
+		addonv1alpha1.InstallProgression{
+			PlacementRef:     rolloutPlacementRef,
+			ConfigReferences: []addonv1alpha1.InstallConfigReference{configReference},
+		},
+		clusters,
+	)
+	return graph
+}
+
+func newRolloutCondition(conditionType string, status metav1.ConditionStatus, reason string,
+	since time.Time) metav1.Condition {
+	return metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		Reason:             reason,
+		LastTransitionTime: metav1.NewTime(since),
+	}
+}
+
+func TestRolloutGates(t *testing.T) {
+	now := time.Now()
+	maxFailures := intstr.FromInt(1)
+	upgradeSucceed := func(since time.Duration) metav1.Condition {
+		return newRolloutCondition(addonv1alpha1.ManagedClusterAddOnConditionProgressing, metav1.ConditionFalse,
+			addonv1alpha1.ProgressingReasonUpgradeSucceed, now.Add(-since))
+	}
+	available := func(since time.Duration) metav1.Condition {
+		return newRolloutCondition(addonv1alpha1.ManagedClusterAddOnConditionAvailable, metav1.ConditionTrue,
+			"Available", now.Add(-since))
+	}
+	upgrading := func(since time.Duration) metav1.Condition {
+		return newRolloutCondition(addonv1alpha1.ManagedClusterAddOnConditionProgressing, metav1.ConditionTrue,
+			addonv1alpha1.ProgressingReasonUpgrading, now.Add(-since))
+	}
+	upgradeFailed := newRolloutCondition(addonv1alpha1.ManagedClusterAddOnConditionProgressing,
+		metav1.ConditionFalse, addonv1alpha1.ProgressingReasonUpgradeFailed, now)
+
+	cases := []struct {
+		name                 string
+		gates                *rolloutGates
+		addons               []*addonv1alpha1.ManagedClusterAddOn
+		expectedClusters     []string
+		expectedRequeueAfter time.Duration
+		expectedCondition    *metav1.Condition
+	}{
+		{
+			name: "no gates",
+			addons: []*addonv1alpha1.ManagedClusterAddOn{
+				newRolloutAddon("cluster1", true),
+				newRolloutAddon("cluster2", true),
+			},
+			expectedClusters: []string{"cluster3", "cluster4"},
+		},
+		{
+			name:  "min available seconds not passed",
+			gates: &rolloutGates{MinAvailableSeconds: 60},
+			addons: []*addonv1alpha1.ManagedClusterAddOn{
+				newRolloutAddon("cluster1", true, upgradeSucceed(30*time.Second), available(time.Hour)),
+				newRolloutAddon("cluster2", true, upgradeSucceed(time.Hour), available(time.Hour)),
+			},
+			expectedClusters:     []string{"cluster1"},
+			expectedRequeueAfter: 30 * time.Second,
+			expectedCondition: &metav1.Condition{
+				Type:    constants.AddonRolloutPausedConditionType,
+				Status:  metav1.ConditionFalse,
+				Reason:  constants.AddonRolloutPausedReasonWithinFailureThreshold,
+				Message: "0/4 failed, within the max failures 0.",
+			},
+		},
+		{
+			name:  "min available seconds passed",
+			gates: &rolloutGates{MinAvailableSeconds: 60},
+			addons: []*addonv1alpha1.ManagedClusterAddOn{
+				newRolloutAddon("cluster1", true, upgradeSucceed(2*time.Minute), available(time.Hour)),
+				newRolloutAddon("cluster2", true, upgradeSucceed(time.Hour), available(time.Hour)),
+			},
+			expectedClusters: []string{"cluster3", "cluster4"},
+		},
+		{
+			name:  "not available",
+			gates: &rolloutGates{MinAvailableSeconds: 60},
+			addons: []*addonv1alpha1.ManagedClusterAddOn{
+				newRolloutAddon("cluster1", true, upgradeSucceed(time.Hour)),
+				newRolloutAddon("cluster2", true, upgradeSucceed(time.Hour), available(time.Hour)),
+			},
+			expectedClusters: []string{"cluster1"},
+		},
+		{
+			name:  "failed within max failures",
+			gates: &rolloutGates{MaxFailures: &maxFailures},
+			addons: []*addonv1alpha1.ManagedClusterAddOn{
+				newRolloutAddon("cluster1", false, upgradeFailed),
+				newRolloutAddon("cluster2", true, upgradeSucceed(time.Hour), available(time.Hour)),
+			},
+			expectedClusters: []string{"cluster3", "cluster4"},
+			expectedCondition: &metav1.Condition{
+				Type:    constants.AddonRolloutPausedConditionType,
+				Status:  metav1.ConditionFalse,
+				Reason:  constants.AddonRolloutPausedReasonWithinFailureThreshold,
+				Message: "1/4 failed, within the max failures 1.",
+			},
+		},
+		{
+			name:  "failed exceeds max failures",
+			gates: &rolloutGates{},
+			addons: []*addonv1alpha1.ManagedClusterAddOn{
+				newRolloutAddon("cluster1", false, upgradeFailed),
+				newRolloutAddon("cluster2", true, upgradeSucceed(time.Hour), available(time.Hour)),
+			},
+			expectedCondition: &metav1.Condition{
+				Type:    constants.AddonRolloutPausedConditionType,
+				Status:  metav1.ConditionTrue,
+				Reason:  constants.AddonRolloutPausedReasonFailureThresholdExceeded,
+				Message: "1/4 failed, exceeds the max failures 0: cluster1 (UpgradeFailed)",
+			},
+		},
+		{
+			name:  "progress deadline not exceeded",
+			gates: &rolloutGates{ProgressDeadline: &metav1.Duration{Duration: 5 * time.Minute}},
+			addons: []*addonv1alpha1.ManagedClusterAddOn{
+				newRolloutAddon("cluster1", false, upgrading(time.Minute)),
+				newRolloutAddon("cluster2", true, upgradeSucceed(time.Hour), available(time.Hour)),
+			},
+			expectedClusters:     []string{"cluster1"},
+			expectedRequeueAfter: 4 * time.Minute,
+		},
+		{
+			name:  "progressing condition of the previous configs",
+			gates: &rolloutGates{ProgressDeadline: &metav1.Duration{Duration: 10 * time.Minute}},
+			addons: []*addonv1alpha1.ManagedClusterAddOn{
+				withLastAppliedConfig(newRolloutAddon("cluster1", false, upgradeSucceed(24*time.Hour),
+					available(24*time.Hour)), "test1", "hash0"),
+				newRolloutAddon("cluster2", true, upgradeSucceed(time.Hour), available(time.Hour)),
+			},
+			expectedClusters: []string{"cluster1"},
+			expectedCondition: &metav1.Condition{
+				Type:    constants.AddonRolloutPausedConditionType,
+				Status:  metav1.ConditionFalse,
+				Reason:  constants.AddonRolloutPausedReasonWithinFailureThreshold,
+				Message: "0/4 failed, within the max failures 0.",
+			},
+		},
+		{
+			name:  "progress deadline exceeded",
+			gates: &rolloutGates{ProgressDeadline: &metav1.Duration{Duration: 5 * time.Minute}},
+			addons: []*addonv1alpha1.ManagedClusterAddOn{
+				newRolloutAddon("cluster1", false, upgrading(10*time.Minute)),
+				newRolloutAddon("cluster2", true, upgradeSucceed(10*time.Minute)),
+			},
+			expectedCondition: &metav1.Condition{
+				Type:    constants.AddonRolloutPausedConditionType,
+				Status:  metav1.ConditionTrue,
+				Reason:  constants.AddonRolloutPausedReasonFailureThresholdExceeded,
+				Message: "2/4 failed, exceeds the max failures 0: cluster1 (ProgressDeadlineExceeded), cluster2 (ProgressDeadlineExceeded)",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			graph := newRolloutGraph(c.gates, now, newInstallConfigReference("core", "Foo", "test1", "hash1"),
+				append(c.addons, newRolloutAddon("cluster3", false), newRolloutAddon("cluster4", false)))
+
+			var clusters []string
+			for _, addon := range graph.addonToUpdate() {
+				clusters = append(clusters, addon.mca.Namespace)
+			}
+			sort.Strings(clusters)
+			if !reflect.DeepEqual(clusters, c.expectedClusters) {
+				t.Errorf("expected addons to update on %v, but got %v", c.expectedClusters, clusters)
+			}
+			if requeueAfter := graph.requeueAfter(); requeueAfter != c.expectedRequeueAfter {
+				t.Errorf("expected requeue after %v, but got %v", c.expectedRequeueAfter, requeueAfter)
+			}
+
+			installProgression := &addonv1alpha1.InstallProgression{PlacementRef: rolloutPlacementRef}
+			setRolloutPausedCondition(installProgression, graph.getPlacementNodes()[rolloutPlacementRef])
+			if c.expectedCondition == nil {
+				return
+			}
+			if len(installProgression.Conditions) != 1 {
+				t.Fatalf("expected 1 condition, but got %v", installProgression.Conditions)
+			}
+			condition := installProgression.Conditions[0]
+			if condition.Type != c.expectedCondition.Type || condition.Status != c.expectedCondition.Status ||
+				condition.Reason != c.expectedCondition.Reason || condition.Message != c.expectedCondition.Message {
+				t.Errorf("expected condition %v, but got %v", c.expectedCondition, condition)
+			}
+		})
+	}
+}
+
+func TestGetRolloutGates(t *testing.T) {
+	cases := []struct {
+		name        string
+		annotation  string
+		expected    *rolloutGates
+		expectedErr bool
+	}{
+		{
+			name: "no annotation",
+		},
+		{
+			name:       "valid gates",
+			annotation: `{"minAvailableSeconds": 60, "maxFailures": "10%", "progressDeadline": "10m"}`,
+			expected: &rolloutGates{
+				MinAvailableSeconds: 60,
+				MaxFailures:         &intstr.IntOrString{Type: intstr.String, StrVal: "10%"},
+				ProgressDeadline:    &metav1.Duration{Duration: 10 * time.Minute},
+			},
+		},
+		{
+			name:        "invalid json",
+			annotation:  `{"minAvailableSeconds": "a"}`,
+			expectedErr: true,
+		},
+		{
+			name:        "invalid max failures",
+			annotation:  `{"maxFailures": "a"}`,
+			expectedErr: true,
+		},
+		{
+			name:        "invalid progress deadline",
+			annotation:  `{"progressDeadline": "0s"}`,
+			expectedErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cma := addontesting.NewClusterManagementAddon("test", "", "").Build()
+			if len(c.annotation) > 0 {
+				cma.Annotations = map[string]string{constants.AddonRolloutGatesAnnotationKey: c.annotation}
+			}
+			gates, err := getRolloutGates(cma)
+			if c.expectedErr && err == nil {
+				t.Errorf("expected error, but got nil")
+			}
+			if !c.expectedErr && err != nil {
+				t.Errorf("expected no error, got err %v", err)
+			}
+			if !reflect.DeepEqual(gates, c.expected) {
+				t.Errorf("expected gates %v, but got %v", c.expected, gates)
+			}
+		})
+	}
+}
+
+func TestInvalidConfigsBlockRollout(t *testing.T) {
+	configReference := newInstallConfigReference("core", "Foo", "test2", "hash2")
+	configReference.LastAppliedConfig = &addonv1alpha1.ConfigSpecHash{
+		ConfigReferent: addonv1alpha1.ConfigReferent{Name: "test1"},
+		SpecHash:       "hash1",
+	}
+	invalid := metav1.Condition{
+		Type:    constants.AddonConfigurationValidConditionType,
+		Status:  metav1.ConditionFalse,
+		Reason:  constants.AddonConfigurationValidReasonInvalid,
+		Message: "invalid configs: core/Foo test2: bad value",
+	}
+
+	cases := []struct {
+		name             string
+		conditions       []metav1.Condition
+		expectedClusters []string
+	}{
+		{
+			name:             "configs are valid",
+			expectedClusters: []string{"cluster1", "cluster2"},
+		},
+		{
+			name:       "configs are invalid",
+			conditions: []metav1.Condition{invalid},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cma := addontesting.NewClusterManagementAddon("test", "", "").
+				WithInstallProgression(addonv1alpha1.InstallProgression{
+					PlacementRef:     rolloutPlacementRef,
+					ConfigReferences: []addonv1alpha1.InstallConfigReference{configReference},
+					Conditions:       c.conditions,
+				}).Build()
+
+			graph := newGraph(nil, nil)
+			graph.addAddonNode(newRolloutAddon("cluster1", true))
+			graph.addAddonNode(newRolloutAddon("cluster2", true))
+			graph.addPlacementNode(
+				addonv1alpha1.PlacementStrategy{PlacementRef: rolloutPlacementRef},
+				cma.Status.InstallProgressions[0],
+				[]string{"cluster1", "cluster2"},
+			)
+
+			var clusters []string
+			for _, addon := range graph.addonToUpdate() {
+				clusters = append(clusters, addon.mca.Namespace)
+			}
+			sort.Strings(clusters)
+			if !reflect.DeepEqual(clusters, c.expectedClusters) {
+				t.Errorf("expected clusters %v to update, but got %v", c.expectedClusters, clusters)
+			}
+
+			fakeAddonClient := fakeaddon.NewSimpleClientset(cma)
+			reconciler := &clusterManagementAddonProgressingReconciler{addonClient: fakeAddonClient}
+			updated, _, err := reconciler.reconcile(context.TODO(), cma, graph)
+			if err != nil {
+				t.Fatal(err)
+			}
+			progressing := meta.FindStatusCondition(updated.Status.InstallProgressions[0].Conditions,
+				addonv1alpha1.ManagedClusterAddOnConditionProgressing)
+			if progressing == nil {
+				t.Fatalf("expected the progressing condition to be set")
+			}
+			blocked := progressing.Reason == constants.AddonConfigurationValidReasonInvalid
+			if blocked != (len(c.conditions) > 0) {
+				t.Errorf("unexpected progressing condition %v", progressing)
+			}
+			if blocked && progressing.Message != "the rollout is blocked by "+invalid.Message {
+				t.Errorf("unexpected progressing message %q", progressing.Message)
+			}
+			if blocked && !reflect.DeepEqual(updated.Status.InstallProgressions[0].ConfigReferences[0].LastAppliedConfig,
+				configReference.LastAppliedConfig) {
+				t.Errorf("expected the last applied config to be kept while the rollout is blocked")
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package token
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/klog/v2"
+
+	"open-cluster-management.io/addon-framework/pkg/agent"
+)
+
+const (
+	// TokenRefreshAfterAnnotationKey is the annotation on the hub kubeconfig secret recording when the token in
+	// the secret should be refreshed.
+	TokenRefreshAfterAnnotationKey = "addon.open-cluster-management.io/token-refresh-after"
+	// TokenExpirationAnnotationKey is the annotation on the hub kubeconfig secret recording when the token in
+	// the secret expires.
+	TokenExpirationAnnotationKey = "addon.open-cluster-management.io/token-expiration"
+
+	defaultTokenExpirationSeconds int64 = 3600
+	retryInterval                       = 10 * time.Second
+)
+
+// TokenRequester requests the token of the ServiceAccount of the addon agent registered with ServiceAccount
+// token, and keeps it in the hub kubeconfig secret of the addon on the managed cluster.
+type TokenRequester interface {
+	// Start starts to request the token and refresh it before it expires, until the context is done.
+	Start(ctx context.Context)
+
+	// WithExpirationSeconds sets the requested lifetime of the token, it is defaulted to 3600. The token is
+	// refreshed when 80% of its lifetime has passed.
+	WithExpirationSeconds(expirationSeconds int64) TokenRequester
+}
+
+type tokenRequester struct {
+	hubKubeClient     kubernetes.Interface
+	kubeClient        kubernetes.Interface
+	hubServer         string
+	hubCABundle       []byte
+	clusterName       string
+	addonName         string
+	secretNamespace   string
+	expirationSeconds int64
+}
+
+// NewTokenRequester returns a TokenRequester requesting the token with the bootstrap config, which is the hub
+// config of a token requester of the addon, e.g. the hub kubeconfig of the klusterlet agent, see
+// agent.DefaultTokenRequesters. The token is written to the secret "<addon name>-hub-kubeconfig" in the
+// secretNamespace of the managed cluster, together with a kubeconfig reading the token from the "token" file next
+// to it, so the agent mounting the secret picks up the refreshed token without restart.
+func NewTokenRequester(
+	bootstrapConfig *rest.Config,
+	kubeClient kubernetes.Interface,
+	clusterName, addonName, secretNamespace string,
+) (TokenRequester, error) {
+	hubKubeClient, err := kubernetes.NewForConfig(bootstrapConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	caBundle := bootstrapConfig.CAData
+	if len(caBundle) == 0 && len(bootstrapConfig.CAFile) > 0 {
+		caBundle, err = os.ReadFile(bootstrapConfig.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the hub ca bundle: %v", err)
+		}
+	}
+
+	return &tokenRequester{
+		hubKubeClient:     hubKubeClient,
+		kubeClient:        kubeClient,
+		hubServer:         bootstrapConfig.Host,
+		hubCABundle:       caBundle,
+		clusterName:       clusterName,
+		addonName:         addonName,
+		secretNamespace:   secretNamespace,
+		expirationSeconds: defaultTokenExpirationSeconds,
+	}, nil
+}
+
+func (r *tokenRequester) WithExpirationSeconds(expirationSeconds int64) TokenRequester {
+	if expirationSeconds > 0 {
+		r.expirationSeconds = expirationSeconds
+	}
+	return r
+}
+
+func (r *tokenRequester) Start(ctx context.Context) {
+	for {
+		next := retryInterval
+		refreshAfter, err := r.sync(ctx)
+		if err != nil {
+			klog.Errorf("Failed to request the token of addon %s: %v", r.addonName, err)
+		} else {
+			next = time.Until(refreshAfter)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(next):
+		}
+	}
+}
+
+// sync requests a new token if the token in the secret is due to refresh, and returns when the token should be
+// refreshed.
+func (r *tokenRequester) sync(ctx context.Context) (time.Time, error) {
+	secretName := fmt.Sprintf("%s-hub-kubeconfig", r.addonName)
+	existing, err := r.kubeClient.CoreV1().Secrets(r.secretNamespace).Get(ctx, secretName, metav1.GetOptions{})
+	switch {
+	case errors.IsNotFound(err):
+		existing = nil
+	case err != nil:
+		return time.Time{}, err
+	default:
+		refreshAfter, err := time.Parse(time.RFC3339, existing.Annotations[TokenRefreshAfterAnnotationKey])
+		if err == nil && time.Now().Before(refreshAfter) && len(existing.Data["token"]) > 0 {
+			return refreshAfter, nil
+		}
+	}
+
+	serviceAccountName := agent.DefaultServiceAccountName(r.addonName)
+	expirationSeconds := r.expirationSeconds
+	tokenRequest, err := r.hubKubeClient.CoreV1().ServiceAccounts(r.clusterName).CreateToken(ctx, serviceAccountName,
+		&authenticationv1.TokenRequest{
+			Spec: authenticationv1.TokenRequestSpec{
+				ExpirationSeconds: &expirationSeconds,
+			},
+		}, metav1.CreateOptions{})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to request token for serviceaccount %s/%s: %v",
+			r.clusterName, serviceAccountName, err)
+	}
+
+	// refresh the token after 80% of its lifetime has passed.
+	issuedAt := time.Now()
+	expiration := tokenRequest.Status.ExpirationTimestamp.Time
+	if expiration.IsZero() {
+		expiration = issuedAt.Add(time.Duration(expirationSeconds) * time.Second)
+	}
+	refreshAfter := issuedAt.Add(expiration.Sub(issuedAt) * 4 / 5)
+
+	kubeconfig, err := clientcmd.Write(clientcmdapi.Config{
+		Clusters: map[string]*clientcmdapi.Cluster{"hub": {
+			Server:                   r.hubServer,
+			CertificateAuthorityData: r.hubCABundle,
+		}},
+		AuthInfos: map[string]*clientcmdapi.AuthInfo{"agent": {
+			// a relative token file is resolved against the directory of the kubeconfig file.
+			TokenFile: "token",
+		}},
+		Contexts: map[string]*clientcmdapi.Context{"default": {
+			Cluster:  "hub",
+			AuthInfo: "agent",
+		}},
+		CurrentContext: "default",
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secretName,
+			Namespace: r.secretNamespace,
+			Annotations: map[string]string{
+				TokenRefreshAfterAnnotationKey: refreshAfter.UTC().Format(time.RFC3339),
+				TokenExpirationAnnotationKey:   expiration.UTC().Format(time.RFC3339),
+			},
+		},
+		Data: map[string][]byte{
+			"kubeconfig":   kubeconfig,
+			"token":        []byte(tokenRequest.Status.Token),
+			"cluster-name": []byte(r.clusterName),
+			"addon-name":   []byte(r.addonName),
+		},
+	}
+	if existing == nil {
+		_, err = r.kubeClient.CoreV1().Secrets(r.secretNamespace).Create(ctx, secret, metav1.CreateOptions{})
+	} else {
+		updated := existing.DeepCopy()
+		if updated.Annotations == nil {
+			updated.Annotations = map[string]string{}
+		}
+		for key, value := range secret.Annotations {
+			updated.Annotations[key] = value
+		}
+		updated.Data = secret.Data
+		_, err = r.kubeClient.CoreV1().Secrets(r.secretNamespace).Update(ctx, updated, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to write the token to secret %s/%s: %v", r.secretNamespace, secretName, err)
+	}
+
+	klog.V(4).Infof("The token of serviceaccount %s/%s expires at %s and is refreshed after %s",
+		r.clusterName, serviceAccountName, expiration.UTC().Format(time.RFC3339),
+		refreshAfter.UTC().Format(time.RFC3339))
+	return refreshAfter, nil
+}
@@ -0,0 +1,150 @@
+package token
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"open-cluster-management.io/addon-framework/pkg/addonmanager/addontesting"
+)
+
+const agentNs = "open-cluster-management-agent-addon"
+
+func newHubClient(token string) *kubefake.Clientset {
+	hubClient := kubefake.NewSimpleClientset()
+	hubClient.PrependReactor("create", "serviceaccounts",
+		func(action clienttesting.Action) (handled bool, ret runtime.Object, err error) {
+			if action.GetSubresource() != "token" {
+				return false, nil, nil
+			}
+			request := action.(clienttesting.CreateAction).GetObject().(*authenticationv1.TokenRequest).DeepCopy()
+			request.Status = authenticationv1.TokenRequestStatus{
+				Token: token,
+				ExpirationTimestamp: metav1.NewTime(
+					time.Now().Add(time.Duration(*request.Spec.ExpirationSeconds) * time.Second)),
+			}
+			return true, request, nil
+		})
+	return hubClient
+}
+
+func newSecret(refreshAfter time.Time) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-hub-kubeconfig",
+			Namespace: agentNs,
+			Annotations: map[string]string{
+				TokenRefreshAfterAnnotationKey: refreshAfter.UTC().Format(time.RFC3339),
+			},
+		},
+		Data: map[string][]byte{"token": []byte("old")},
+	}
+}
+
+func TestSync(t *testing.T) {
+	cases := []struct {
+		name               string
+		existingSecrets    []runtime.Object
+		validateHubActions func(t *testing.T, actions []clienttesting.Action)
+		validateActions    func(t *testing.T, actions []clienttesting.Action)
+	}{
+		{
+			name: "request token and create secret",
+			validateHubActions: func(t *testing.T, actions []clienttesting.Action) {
+				addontesting.AssertActions(t, actions, "create")
+				if actions[0].GetNamespace() != "cluster1" ||
+					actions[0].(clienttesting.CreateAction).GetResource().Resource != "serviceaccounts" ||
+					actions[0].GetSubresource() != "token" {
+					t.Errorf("unexpected token request %v", actions[0])
+				}
+				request := actions[0].(clienttesting.CreateAction).GetObject().(*authenticationv1.TokenRequest)
+				if *request.Spec.ExpirationSeconds != 600 {
+					t.Errorf("expected expiration seconds 600, but got %d", *request.Spec.ExpirationSeconds)
+				}
+			},
+			validateActions: func(t *testing.T, actions []clienttesting.Action) {
+				addontesting.AssertActions(t, actions, "get", "create")
+				secret := actions[1].(clienttesting.CreateAction).GetObject().(*corev1.Secret)
+				if string(secret.Data["token"]) != "new" {
+					t.Errorf("expected token new, but got %q", secret.Data["token"])
+				}
+				if string(secret.Data["cluster-name"]) != "cluster1" || string(secret.Data["addon-name"]) != "test" {
+					t.Errorf("unexpected cluster name and addon name in secret: %v", secret.Data)
+				}
+				kubeconfig, err := clientcmd.Load(secret.Data["kubeconfig"])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if kubeconfig.Clusters["hub"].Server != "https://hub" {
+					t.Errorf("expected hub server https://hub, but got %s", kubeconfig.Clusters["hub"].Server)
+				}
+				if kubeconfig.AuthInfos["agent"].TokenFile != "token" || len(kubeconfig.AuthInfos["agent"].Token) > 0 {
+					t.Errorf("expected the kubeconfig to read the token file, but got %v", kubeconfig.AuthInfos["agent"])
+				}
+				refreshAfter, err := time.Parse(time.RFC3339, secret.Annotations[TokenRefreshAfterAnnotationKey])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if refreshAfter.After(time.Now().Add(8*time.Minute)) || refreshAfter.Before(time.Now().Add(7*time.Minute)) {
+					t.Errorf("expected the token to be refreshed after 80%% of its lifetime, but got %s", refreshAfter)
+				}
+			},
+		},
+		{
+			name:            "token is not due to refresh",
+			existingSecrets: []runtime.Object{newSecret(time.Now().Add(time.Hour))},
+			validateHubActions: func(t *testing.T, actions []clienttesting.Action) {
+				addontesting.AssertNoActions(t, actions)
+			},
+			validateActions: func(t *testing.T, actions []clienttesting.Action) {
+				addontesting.AssertActions(t, actions, "get")
+			},
+		},
+		{
+			name:            "refresh token",
+			existingSecrets: []runtime.Object{newSecret(time.Now().Add(-time.Minute))},
+			validateHubActions: func(t *testing.T, actions []clienttesting.Action) {
+				addontesting.AssertActions(t, actions, "create")
+			},
+			validateActions: func(t *testing.T, actions []clienttesting.Action) {
+				addontesting.AssertActions(t, actions, "get", "update")
+				secret := actions[1].(clienttesting.UpdateAction).GetObject().(*corev1.Secret)
+				if string(secret.Data["token"]) != "new" {
+					t.Errorf("expected token new, but got %q", secret.Data["token"])
+				}
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			hubClient := newHubClient("new")
+			kubeClient := kubefake.NewSimpleClientset(c.existingSecrets...)
+
+			requester := &tokenRequester{
+				hubKubeClient:     hubClient,
+				kubeClient:        kubeClient,
+				hubServer:         "https://hub",
+				clusterName:       "cluster1",
+				addonName:         "test",
+				secretNamespace:   agentNs,
+				expirationSeconds: defaultTokenExpirationSeconds,
+			}
+			requester.WithExpirationSeconds(600)
+
+			if _, err := requester.sync(context.TODO()); err != nil {
+				t.Fatalf("expected no error, but got %v", err)
+			}
+			c.validateHubActions(t, hubClient.Actions())
+			c.validateActions(t, kubeClient.Actions())
+		})
+	}
+}
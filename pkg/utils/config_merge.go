@@ -0,0 +1,117 @@
+package utils
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"open-cluster-management.io/addon-framework/pkg/agent"
+)
+
+// MergeValuesWithSources merges the values b from the source into the values a with the strategy, and returns the
+// merged values. The source of each merged value is recorded in the sources if it is not nil, keyed by the key of
+// the value, the keys of the nested values are joined with ".". The a and b are not changed.
+func MergeValuesWithSources(strategy agent.ConfigMergeStrategy, a, b map[string]interface{}, source string,
+	sources map[string][]string) (map[string]interface{}, error) {
+	switch strategy {
+	case agent.ConfigMergeStrategyReplace, agent.ConfigMergeStrategyDeepMerge, agent.ConfigMergeStrategyAppendLists:
+	default:
+		return nil, fmt.Errorf("unsupported config merge strategy %q", strategy)
+	}
+
+	merged := map[string]interface{}{}
+	for k, v := range a {
+		merged[k] = v
+	}
+	for k, v := range b {
+		if strategy == agent.ConfigMergeStrategyReplace {
+			setValueSource(sources, k, source)
+			merged[k] = v
+			continue
+		}
+		merged[k] = mergeValue(strategy, merged[k], v, k, source, sources)
+	}
+	return merged, nil
+}
+
+// mergeValue merges the value b into the value a of the key recursively.
+func mergeValue(strategy agent.ConfigMergeStrategy, a, b interface{}, key, source string,
+	sources map[string][]string) interface{} {
+	bMap, isMap := toStringKeyMap(b)
+	if isMap {
+		aMap, ok := toStringKeyMap(a)
+		if !ok {
+			// a is replaced by the map b, the sources of a are not kept.
+			setValueSource(sources, key, "")
+			aMap = map[string]interface{}{}
+		}
+		for k, v := range bMap {
+			aMap[k] = mergeValue(strategy, aMap[k], v, key+"."+k, source, sources)
+		}
+		return aMap
+	}
+
+	if strategy == agent.ConfigMergeStrategyAppendLists {
+		if appended, ok := appendLists(a, b); ok {
+			if sources != nil {
+				sources[key] = append(sources[key], source)
+			}
+			return appended
+		}
+	}
+
+	setValueSource(sources, key, source)
+	return b
+}
+
+// setValueSource sets the source of the value of the key, and removes the sources of the nested values since the
+// value is replaced. An empty source only removes the sources.
+func setValueSource(sources map[string][]string, key, source string) {
+	if sources == nil {
+		return
+	}
+	for k := range sources {
+		if k == key || strings.HasPrefix(k, key+".") {
+			delete(sources, k)
+		}
+	}
+	if len(source) > 0 {
+		sources[key] = []string{source}
+	}
+}
+
+// toStringKeyMap copies the map with the string keys to a map[string]interface{}, e.g. the NodeSelector of
+// map[string]string, so the maps of different types can be merged.
+func toStringKeyMap(value interface{}) (map[string]interface{}, bool) {
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String {
+		return nil, false
+	}
+	m := make(map[string]interface{}, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		m[iter.Key().String()] = iter.Value().Interface()
+	}
+	return m, true
+}
+
+// appendLists returns a new list of the items of b appended to the items of a, if both a and b are lists. The
+// lists of the same type are appended to a list of the type, e.g. the Tolerations of []corev1.Toleration.
+func appendLists(a, b interface{}) (interface{}, bool) {
+	av, bv := reflect.ValueOf(a), reflect.ValueOf(b)
+	if av.Kind() != reflect.Slice || bv.Kind() != reflect.Slice {
+		return nil, false
+	}
+	if av.Type() == bv.Type() {
+		appended := reflect.MakeSlice(av.Type(), 0, av.Len()+bv.Len())
+		return reflect.AppendSlice(reflect.AppendSlice(appended, av), bv).Interface(), true
+	}
+
+	appended := make([]interface{}, 0, av.Len()+bv.Len())
+	for _, list := range []reflect.Value{av, bv} {
+		for i := 0; i < list.Len(); i++ {
+			appended = append(appended, list.Index(i).Interface())
+		}
+	}
+	return appended, true
+}
@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	certificatesv1 "k8s.io/api/certificates/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"open-cluster-management.io/addon-framework/pkg/agent"
+)
+
+const (
+	// maxSignResponseSize is the max size of the certificate returned by the signing service.
+	maxSignResponseSize = 1 << 20
+	// csrNameHeader is the http header carrying the name of the csr sent to the signing service.
+	csrNameHeader = "X-CSR-Name"
+)
+
+type httpCSRSigner struct {
+	endpoint string
+	client   *http.Client
+}
+
+// NewHTTPCSRSigner returns a CSRSigner which signs the csr with an external signing service. The PEM encoded
+// certificate request is posted to the endpoint, and the service is expected to respond with
+//   - 200 and the PEM encoded certificate in the body if the csr is signed.
+//   - 202 if the signing is still in progress, the csr will be posted again later.
+//
+// Any other response is taken as a failure. http.DefaultClient is used if client is nil.
+func NewHTTPCSRSigner(endpoint string, client *http.Client) agent.CSRSigner {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &httpCSRSigner{
+		endpoint: endpoint,
+		client:   client,
+	}
+}
+
+func (s *httpCSRSigner) Sign(ctx context.Context, csr *certificatesv1.CertificateSigningRequest) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(csr.Spec.Request))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-pem-file")
+	req.Header.Set(csrNameHeader, csr.Name)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSignResponseSize))
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if len(body) == 0 {
+			return nil, fmt.Errorf("empty certificate returned by the signing service %s", s.endpoint)
+		}
+		return body, nil
+	case http.StatusAccepted:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("the signing service %s responded with status %d: %s",
+			s.endpoint, resp.StatusCode, string(bytes.TrimSpace(body)))
+	}
+}
+
+// NewCSRSigningHandler returns a http handler serving the protocol of NewHTTPCSRSigner with the given signer.
+// It is a stand-in of an external signing service, which is useful in development and tests, e.g. serving
+// DefaultSignerWithExpiry.
+func NewCSRSigningHandler(signer agent.CSRSigner) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		request, err := io.ReadAll(io.LimitReader(r.Body, maxSignResponseSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, err := parseCSR(request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		csr := &certificatesv1.CertificateSigningRequest{
+			ObjectMeta: metav1.ObjectMeta{Name: r.Header.Get(csrNameHeader)},
+			Spec:       certificatesv1.CertificateSigningRequestSpec{Request: request},
+		}
+		cert, err := signer.Sign(r.Context(), csr)
+		switch {
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		case len(cert) == 0:
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.Header().Set("Content-Type", "application/x-pem-file")
+			_, _ = w.Write(cert)
+		}
+	})
+}
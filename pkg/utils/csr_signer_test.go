@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openshift/library-go/pkg/crypto"
+	"github.com/stretchr/testify/assert"
+	certificatesv1 "k8s.io/api/certificates/v1"
+
+	"open-cluster-management.io/addon-framework/pkg/agent"
+)
+
+type pendingSigner struct{}
+
+func (s *pendingSigner) Sign(ctx context.Context, csr *certificatesv1.CertificateSigningRequest) ([]byte, error) {
+	return nil, nil
+}
+
+type failedSigner struct{}
+
+func (s *failedSigner) Sign(ctx context.Context, csr *certificatesv1.CertificateSigningRequest) ([]byte, error) {
+	return nil, fmt.Errorf("pki is unavailable")
+}
+
+func TestHTTPCSRSigner(t *testing.T) {
+	caConfig, err := crypto.MakeSelfSignedCAConfig("test", 10)
+	if err != nil {
+		t.Fatalf("Failed to generate self signed CA config: %v", err)
+	}
+	ca, key, err := caConfig.GetPEMBytes()
+	if err != nil {
+		t.Fatalf("Failed to get ca cert/key: %v", err)
+	}
+
+	cases := []struct {
+		name         string
+		signer       agent.CSRSigner
+		csr          *certificatesv1.CertificateSigningRequest
+		expectCert   bool
+		expectErr    bool
+		expectCN     string
+		expectExpiry time.Duration
+	}{
+		{
+			name:         "signed",
+			signer:       DefaultSignerWithExpiry(key, ca, 24*time.Hour),
+			csr:          newCSR("test", "cluster1"),
+			expectCert:   true,
+			expectCN:     "test",
+			expectExpiry: 24 * time.Hour,
+		},
+		{
+			name:   "pending",
+			signer: &pendingSigner{},
+			csr:    newCSR("test", "cluster1"),
+		},
+		{
+			name:      "sign failed",
+			signer:    &failedSigner{},
+			csr:       newCSR("test", "cluster1"),
+			expectErr: true,
+		},
+		{
+			name:   "invalid request",
+			signer: DefaultSignerWithExpiry(key, ca, 24*time.Hour),
+			csr: func() *certificatesv1.CertificateSigningRequest {
+				csr := newCSR("test", "cluster1")
+				csr.Spec.Request = []byte("invalid")
+				return csr
+			}(),
+			expectErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			server := httptest.NewServer(NewCSRSigningHandler(c.signer))
+			defer server.Close()
+
+			cert, err := NewHTTPCSRSigner(server.URL, server.Client()).Sign(context.TODO(), c.csr)
+			if c.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			if !c.expectCert {
+				assert.Empty(t, cert)
+				return
+			}
+
+			certs, err := crypto.CertsFromPEM(cert)
+			assert.NoError(t, err)
+			assert.Equal(t, c.expectCN, certs[0].Subject.CommonName)
+			assert.WithinDuration(t, time.Now().Add(c.expectExpiry), certs[0].NotAfter, time.Minute)
+		})
+	}
+}
+
+func TestHTTPCSRSignerUnavailable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	_, err := NewHTTPCSRSigner(server.URL, nil).Sign(context.TODO(), newCSR("test", "cluster1"))
+	assert.ErrorContains(t, err, "unavailable")
+}
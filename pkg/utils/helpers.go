@@ -339,3 +339,15 @@ func IsOwnedByCMA(addon *addonapiv1alpha1.ManagedClusterAddOn) bool {
 	}
 	return false
 }
+
+// IsServiceAccountTokenRegistered returns true if the addon agent is registered with ServiceAccount token, the
+// registration controller tracks the ServiceAccount of the agent in the related objects of the addon in this case.
+func IsServiceAccountTokenRegistered(addon *addonapiv1alpha1.ManagedClusterAddOn) bool {
+	for _, obj := range addon.Status.RelatedObjects {
+		if obj.Group == "" && obj.Resource == "serviceaccounts" && obj.Namespace == addon.Namespace &&
+			obj.Name == agent.DefaultServiceAccountName(addon.Name) {
+			return true
+		}
+	}
+	return false
+}
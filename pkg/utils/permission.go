@@ -8,6 +8,7 @@ import (
 	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/client-go/kubernetes"
 	rbacclientv1 "k8s.io/client-go/kubernetes/typed/rbac/v1"
 	"k8s.io/utils/pointer"
@@ -19,6 +20,12 @@ import (
 
 const (
 	RoleRefKindUser = "User"
+
+	// PermissionConfigLabelKey is the label set on the hub RBAC resources created by the RBACPermissionBuilder,
+	// the value of the label is the name of the addon creating the resource. It is used to find the resources to
+	// prune once the addon is deleted. A resource shared by multiple addons keeps the label of the first one, the
+	// addons sharing it are tracked by the owner references.
+	PermissionConfigLabelKey = "addon.open-cluster-management.io/permission-config"
 )
 
 // RBACPermissionBuilder builds a agent.PermissionConfigFunc that applies Kubernetes RBAC policies.
@@ -41,10 +48,58 @@ type RBACPermissionBuilder interface {
 	// WithStaticRole ensures a role binding to the hub cluster.
 	WithStaticRoleBinding(clusterRole *rbacv1.RoleBinding) RBACPermissionBuilder
 
+	// BindClusterRoleToAgentGroup is a shortcut that ensures a cluster role and binds it to the default group of
+	// the addon agent with a role binding in the managed cluster namespace. The role binding also binds the
+	// ServiceAccount of the addon agent if it is registered with ServiceAccount token, see
+	// IsServiceAccountTokenRegistered. BindClusterRoleToGroup and BindRoleToGroup with the default group do not
+	// grant the ServiceAccount anything.
+	BindClusterRoleToAgentGroup(clusterRole *rbacv1.ClusterRole) RBACPermissionBuilder
+	// BindRoleTemplateToAgentGroup is a shortcut that ensures a role expanded from the template in the managed
+	// cluster namespace and binds it to the default group (and the ServiceAccount) of the addon agent.
+	BindRoleTemplateToAgentGroup(template RoleTemplateFunc) RBACPermissionBuilder
+	// WithRoleTemplate ensures a role expanded from the template in the managed cluster namespace.
+	WithRoleTemplate(template RoleTemplateFunc) RBACPermissionBuilder
+
 	// Build wraps up the builder chain, and return a agent.PermissionConfigFunc.
 	Build() agent.PermissionConfigFunc
 }
 
+// RoleTemplateFunc expands a role for the addon agent of a managed cluster. The namespace of the returned role
+// is always overridden by the managed cluster name.
+type RoleTemplateFunc func(cluster *clusterv1.ManagedCluster, addon *addonapiv1alpha1.ManagedClusterAddOn) *rbacv1.Role
+
+// AgentRoleTemplate returns a RoleTemplateFunc of a role named "open-cluster-management:<addon name>:agent"
+// with the given rules. Bound with BindRoleTemplateToAgentGroup, the addon agent can only access the resources
+// in its own managed cluster namespace.
+func AgentRoleTemplate(rules ...rbacv1.PolicyRule) RoleTemplateFunc {
+	return func(cluster *clusterv1.ManagedCluster, addon *addonapiv1alpha1.ManagedClusterAddOn) *rbacv1.Role {
+		return &rbacv1.Role{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("open-cluster-management:%s:agent", addon.Name),
+				Namespace: cluster.Name,
+			},
+			Rules: rules,
+		}
+	}
+}
+
+// DefaultAgentRoleTemplate returns a RoleTemplateFunc that allows the addon agent to read the configmaps and
+// the ManagedClusterAddOn in its own managed cluster namespace.
+func DefaultAgentRoleTemplate() RoleTemplateFunc {
+	return AgentRoleTemplate(
+		rbacv1.PolicyRule{
+			Verbs:     []string{"get", "list", "watch"},
+			Resources: []string{"configmaps"},
+			APIGroups: []string{""},
+		},
+		rbacv1.PolicyRule{
+			Verbs:     []string{"get", "list", "watch"},
+			Resources: []string{"managedclusteraddons"},
+			APIGroups: []string{addonapiv1alpha1.GroupName},
+		},
+	)
+}
+
 var _ RBACPermissionBuilder = &permissionBuilder{}
 
 type permissionBuilder struct {
@@ -136,9 +191,38 @@ func (p *permissionBuilder) BindRoleToGroup(role *rbacv1.Role, userGroup string)
 		})
 }
 
+func (p *permissionBuilder) BindClusterRoleToAgentGroup(clusterRole *rbacv1.ClusterRole) RBACPermissionBuilder {
+	p.WithStaticClusterRole(clusterRole)
+	p.u.fns = append(p.u.fns, func(cluster *clusterv1.ManagedCluster, addon *addonapiv1alpha1.ManagedClusterAddOn) error {
+		return p.applyRoleBinding(cluster, addon, agentGroupRoleBinding("ClusterRole", clusterRole.Name, cluster, addon))
+	})
+	return p
+}
+
+func (p *permissionBuilder) BindRoleTemplateToAgentGroup(template RoleTemplateFunc) RBACPermissionBuilder {
+	p.u.fns = append(p.u.fns, func(cluster *clusterv1.ManagedCluster, addon *addonapiv1alpha1.ManagedClusterAddOn) error {
+		role := template(cluster, addon)
+		if err := p.applyRole(cluster, addon, role); err != nil {
+			return err
+		}
+		return p.applyRoleBinding(cluster, addon, agentGroupRoleBinding("Role", role.Name, cluster, addon))
+	})
+	return p
+}
+
+func (p *permissionBuilder) WithRoleTemplate(template RoleTemplateFunc) RBACPermissionBuilder {
+	p.u.fns = append(p.u.fns, func(cluster *clusterv1.ManagedCluster, addon *addonapiv1alpha1.ManagedClusterAddOn) error {
+		return p.applyRole(cluster, addon, template(cluster, addon))
+	})
+	return p
+}
+
 func (p *permissionBuilder) WithStaticClusterRole(clusterRole *rbacv1.ClusterRole) RBACPermissionBuilder {
 	p.u.fns = append(p.u.fns, func(cluster *clusterv1.ManagedCluster, addon *addonapiv1alpha1.ManagedClusterAddOn) error {
-		_, _, err := ApplyClusterRole(context.TODO(), p.kubeClient.RbacV1(), clusterRole)
+		required := clusterRole.DeepCopy()
+		ensurePermissionConfigLabel(&required.ObjectMeta, addon)
+		ensureClusterManagementAddonOwnerReference(&required.ObjectMeta, addon)
+		_, _, err := ApplyClusterRole(context.TODO(), p.kubeClient.RbacV1(), required)
 		return err
 	})
 	return p
@@ -146,7 +230,10 @@ func (p *permissionBuilder) WithStaticClusterRole(clusterRole *rbacv1.ClusterRol
 
 func (p *permissionBuilder) WithStaticClusterRoleBinding(binding *rbacv1.ClusterRoleBinding) RBACPermissionBuilder {
 	p.u.fns = append(p.u.fns, func(cluster *clusterv1.ManagedCluster, addon *addonapiv1alpha1.ManagedClusterAddOn) error {
-		_, _, err := ApplyClusterRoleBinding(context.TODO(), p.kubeClient.RbacV1(), binding)
+		required := binding.DeepCopy()
+		ensurePermissionConfigLabel(&required.ObjectMeta, addon)
+		ensureClusterManagementAddonOwnerReference(&required.ObjectMeta, addon)
+		_, _, err := ApplyClusterRoleBinding(context.TODO(), p.kubeClient.RbacV1(), required)
 		return err
 	})
 	return p
@@ -154,20 +241,14 @@ func (p *permissionBuilder) WithStaticClusterRoleBinding(binding *rbacv1.Cluster
 
 func (p *permissionBuilder) WithStaticRole(role *rbacv1.Role) RBACPermissionBuilder {
 	p.u.fns = append(p.u.fns, func(cluster *clusterv1.ManagedCluster, addon *addonapiv1alpha1.ManagedClusterAddOn) error {
-		role.Namespace = cluster.Name
-		ensureAddonOwnerReference(&role.ObjectMeta, addon)
-		_, _, err := ApplyRole(context.TODO(), p.kubeClient.RbacV1(), role)
-		return err
+		return p.applyRole(cluster, addon, role)
 	})
 	return p
 }
 
 func (p *permissionBuilder) WithStaticRoleBinding(binding *rbacv1.RoleBinding) RBACPermissionBuilder {
 	p.u.fns = append(p.u.fns, func(cluster *clusterv1.ManagedCluster, addon *addonapiv1alpha1.ManagedClusterAddOn) error {
-		binding.Namespace = cluster.Name
-		ensureAddonOwnerReference(&binding.ObjectMeta, addon)
-		_, _, err := ApplyRoleBinding(context.TODO(), p.kubeClient.RbacV1(), binding)
-		return err
+		return p.applyRoleBinding(cluster, addon, binding)
 	})
 	return p
 }
@@ -176,6 +257,83 @@ func (p *permissionBuilder) Build() agent.PermissionConfigFunc {
 	return p.u.build()
 }
 
+func (p *permissionBuilder) applyRole(
+	cluster *clusterv1.ManagedCluster, addon *addonapiv1alpha1.ManagedClusterAddOn, role *rbacv1.Role) error {
+	required := role.DeepCopy()
+	required.Namespace = cluster.Name
+	ensurePermissionConfigLabel(&required.ObjectMeta, addon)
+	ensureAddonOwnerReference(&required.ObjectMeta, addon)
+	_, _, err := ApplyRole(context.TODO(), p.kubeClient.RbacV1(), required)
+	return err
+}
+
+func (p *permissionBuilder) applyRoleBinding(
+	cluster *clusterv1.ManagedCluster, addon *addonapiv1alpha1.ManagedClusterAddOn, binding *rbacv1.RoleBinding) error {
+	required := binding.DeepCopy()
+	required.Namespace = cluster.Name
+	ensurePermissionConfigLabel(&required.ObjectMeta, addon)
+	ensureAddonOwnerReference(&required.ObjectMeta, addon)
+	_, _, err := ApplyRoleBinding(context.TODO(), p.kubeClient.RbacV1(), required)
+	return err
+}
+
+// agentGroupRoleBinding returns a role binding in the managed cluster namespace that binds the role to the
+// group of the addon agent in this managed cluster. The ServiceAccount of the agent is bound as well only if the
+// agent is registered with ServiceAccount token, otherwise anyone able to create the ServiceAccount in the managed
+// cluster namespace would get the permissions of the agent.
+func agentGroupRoleBinding(roleKind, roleName string,
+	cluster *clusterv1.ManagedCluster, addon *addonapiv1alpha1.ManagedClusterAddOn) *rbacv1.RoleBinding {
+	binding := &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      roleName, // The same name as the role
+			Namespace: cluster.Name,
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     roleKind,
+			Name:     roleName,
+		},
+		Subjects: []rbacv1.Subject{
+			{
+				APIGroup: rbacv1.GroupName,
+				Kind:     rbacv1.GroupKind,
+				Name:     agent.DefaultGroups(cluster.Name, addon.Name)[0],
+			},
+		},
+	}
+	if IsServiceAccountTokenRegistered(addon) {
+		binding.Subjects = append(binding.Subjects, rbacv1.Subject{
+			Kind:      rbacv1.ServiceAccountKind,
+			Name:      agent.DefaultServiceAccountName(addon.Name),
+			Namespace: cluster.Name,
+		})
+	}
+	return binding
+}
+
+// IsServiceAccountBound returns true if one of the subjects is the ServiceAccount, or a group the ServiceAccount
+// belongs to.
+func IsServiceAccountBound(subjects []rbacv1.Subject, namespace, name string) bool {
+	for _, subject := range subjects {
+		switch subject.Kind {
+		case rbacv1.ServiceAccountKind:
+			if subject.Namespace == namespace && subject.Name == name {
+				return true
+			}
+		case rbacv1.UserKind:
+			if subject.Name == fmt.Sprintf("system:serviceaccount:%s:%s", namespace, name) {
+				return true
+			}
+		case rbacv1.GroupKind:
+			if subject.Name == "system:serviceaccounts" || subject.Name == "system:serviceaccounts:"+namespace ||
+				subject.Name == "system:authenticated" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 type unionPermissionBuilder struct {
 	fns []agent.PermissionConfigFunc
 }
@@ -203,6 +361,201 @@ func ensureAddonOwnerReference(metadata *metav1.ObjectMeta, addon *addonapiv1alp
 	}
 }
 
+// ensureClusterManagementAddonOwnerReference sets the ClusterManagementAddOn owning the addon as the owner
+// of a cluster scoped resource, a cluster scoped resource cannot be owned by the namespaced ManagedClusterAddOn.
+func ensureClusterManagementAddonOwnerReference(metadata *metav1.ObjectMeta, addon *addonapiv1alpha1.ManagedClusterAddOn) {
+	for _, owner := range addon.OwnerReferences {
+		if owner.Kind != "ClusterManagementAddOn" || owner.Name != addon.Name {
+			continue
+		}
+		MergeOwnerRefs(&metadata.OwnerReferences, metav1.OwnerReference{
+			APIVersion: owner.APIVersion,
+			Kind:       owner.Kind,
+			Name:       owner.Name,
+			UID:        owner.UID,
+		}, false)
+		return
+	}
+}
+
+func ensurePermissionConfigLabel(metadata *metav1.ObjectMeta, addon *addonapiv1alpha1.ManagedClusterAddOn) {
+	if metadata.Labels == nil {
+		metadata.Labels = map[string]string{}
+	}
+	metadata.Labels[PermissionConfigLabelKey] = addon.Name
+}
+
+// mergeObjectMeta merges the labels and owner references of the required object meta into the existing one,
+// and returns true if the existing object meta is modified.
+func mergeObjectMeta(existing *metav1.ObjectMeta, required metav1.ObjectMeta) bool {
+	modified := false
+	for key, value := range required.Labels {
+		if existing.Labels == nil {
+			existing.Labels = map[string]string{}
+		}
+		if existingValue, ok := existing.Labels[key]; ok && existingValue == value {
+			continue
+		}
+		// the resource shared by multiple addons is not relabelled by each of them.
+		if _, ok := existing.Labels[key]; ok && key == PermissionConfigLabelKey {
+			continue
+		}
+		existing.Labels[key] = value
+		modified = true
+	}
+
+	for _, owner := range required.OwnerReferences {
+		if MergeOwnerRefs(&existing.OwnerReferences, owner, false) {
+			modified = true
+		}
+	}
+	return modified
+}
+
+// PruneRBACPermissions deletes the roles and role bindings created by the RBACPermissionBuilder for an addon
+// in the cluster namespace. The ones shared with other addons are kept, only the owner reference of the addon is
+// removed from them.
+func PruneRBACPermissions(ctx context.Context, client rbacclientv1.RbacV1Interface, clusterName, addonName string) error {
+	listOptions := permissionConfigListOptions()
+	var errs []error
+
+	roleBindings, err := client.RoleBindings(clusterName).List(ctx, listOptions)
+	if err != nil {
+		return err
+	}
+	for i := range roleBindings.Items {
+		binding := &roleBindings.Items[i]
+		switch releasePermissionConfig(&binding.ObjectMeta, "ManagedClusterAddOn", addonName) {
+		case permissionConfigDelete:
+			err = client.RoleBindings(clusterName).Delete(ctx, binding.Name, metav1.DeleteOptions{})
+		case permissionConfigUpdate:
+			_, err = client.RoleBindings(clusterName).Update(ctx, binding, metav1.UpdateOptions{})
+		default:
+			continue
+		}
+		if err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, err)
+		}
+	}
+
+	roles, err := client.Roles(clusterName).List(ctx, listOptions)
+	if err != nil {
+		return err
+	}
+	for i := range roles.Items {
+		role := &roles.Items[i]
+		switch releasePermissionConfig(&role.ObjectMeta, "ManagedClusterAddOn", addonName) {
+		case permissionConfigDelete:
+			err = client.Roles(clusterName).Delete(ctx, role.Name, metav1.DeleteOptions{})
+		case permissionConfigUpdate:
+			_, err = client.Roles(clusterName).Update(ctx, role, metav1.UpdateOptions{})
+		default:
+			continue
+		}
+		if err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, err)
+		}
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
+
+// PruneClusterScopedRBACPermissions deletes the cluster roles and cluster role bindings created by the
+// RBACPermissionBuilder for an addon. The cluster scoped resources are shared by all the managed clusters,
+// so it should only be called once the addon is removed from all the managed clusters. The ones shared with
+// other addons are kept, only the owner reference of the addon is removed from them.
+func PruneClusterScopedRBACPermissions(ctx context.Context, client rbacclientv1.RbacV1Interface, addonName string) error {
+	listOptions := permissionConfigListOptions()
+	var errs []error
+
+	clusterRoleBindings, err := client.ClusterRoleBindings().List(ctx, listOptions)
+	if err != nil {
+		return err
+	}
+	for i := range clusterRoleBindings.Items {
+		binding := &clusterRoleBindings.Items[i]
+		switch releasePermissionConfig(&binding.ObjectMeta, "ClusterManagementAddOn", addonName) {
+		case permissionConfigDelete:
+			err = client.ClusterRoleBindings().Delete(ctx, binding.Name, metav1.DeleteOptions{})
+		case permissionConfigUpdate:
+			_, err = client.ClusterRoleBindings().Update(ctx, binding, metav1.UpdateOptions{})
+		default:
+			continue
+		}
+		if err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, err)
+		}
+	}
+
+	clusterRoles, err := client.ClusterRoles().List(ctx, listOptions)
+	if err != nil {
+		return err
+	}
+	for i := range clusterRoles.Items {
+		clusterRole := &clusterRoles.Items[i]
+		switch releasePermissionConfig(&clusterRole.ObjectMeta, "ClusterManagementAddOn", addonName) {
+		case permissionConfigDelete:
+			err = client.ClusterRoles().Delete(ctx, clusterRole.Name, metav1.DeleteOptions{})
+		case permissionConfigUpdate:
+			_, err = client.ClusterRoles().Update(ctx, clusterRole, metav1.UpdateOptions{})
+		default:
+			continue
+		}
+		if err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, err)
+		}
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
+
+type permissionConfigAction int
+
+const (
+	permissionConfigKeep permissionConfigAction = iota
+	permissionConfigDelete
+	permissionConfigUpdate
+)
+
+// releasePermissionConfig releases the RBAC resource from the addon. The resource is deleted if it is owned or
+// labelled by the addon and no other addon owns it. If other addons of the owner kind still own the resource,
+// the owner reference of the addon is removed, and the label is moved to one of the other addons.
+func releasePermissionConfig(objectMeta *metav1.ObjectMeta, ownerKind, addonName string) permissionConfigAction {
+	owned := false
+	var owners []metav1.OwnerReference
+	var otherAddons []string
+	for _, owner := range objectMeta.OwnerReferences {
+		if owner.Kind == ownerKind && owner.Name == addonName {
+			owned = true
+			continue
+		}
+		owners = append(owners, owner)
+		if owner.Kind == ownerKind {
+			otherAddons = append(otherAddons, owner.Name)
+		}
+	}
+	labelled := objectMeta.Labels[PermissionConfigLabelKey] == addonName
+
+	switch {
+	case !owned && !labelled:
+		return permissionConfigKeep
+	case len(otherAddons) == 0:
+		return permissionConfigDelete
+	}
+
+	objectMeta.OwnerReferences = owners
+	if labelled {
+		objectMeta.Labels[PermissionConfigLabelKey] = otherAddons[0]
+	}
+	return permissionConfigUpdate
+}
+
+// permissionConfigListOptions selects all the RBAC resources created by the RBACPermissionBuilder, the ones of an
+// addon are then selected by the owner references and the label.
+func permissionConfigListOptions() metav1.ListOptions {
+	return metav1.ListOptions{LabelSelector: PermissionConfigLabelKey}
+}
+
 // ApplyClusterRole merges objectmeta, requires rules, aggregation rules are not allowed for now.
 func ApplyClusterRole(ctx context.Context, client rbacclientv1.ClusterRolesGetter, required *rbacv1.ClusterRole) (*rbacv1.ClusterRole, bool, error) {
 	if required.AggregationRule != nil && len(required.AggregationRule.ClusterRoleSelectors) != 0 {
@@ -221,8 +574,9 @@ func ApplyClusterRole(ctx context.Context, client rbacclientv1.ClusterRolesGette
 	}
 
 	existingCopy := existing.DeepCopy()
+	metaModified := mergeObjectMeta(&existingCopy.ObjectMeta, required.ObjectMeta)
 	contentSame := equality.Semantic.DeepEqual(existingCopy.Rules, required.Rules)
-	if contentSame {
+	if contentSame && !metaModified {
 		return existingCopy, false, nil
 	}
 
@@ -267,10 +621,11 @@ func ApplyClusterRoleBinding(ctx context.Context,
 		}
 	}
 
+	metaModified := mergeObjectMeta(&existingCopy.ObjectMeta, requiredCopy.ObjectMeta)
 	subjectsAreSame := equality.Semantic.DeepEqual(existingCopy.Subjects, requiredCopy.Subjects)
 	roleRefIsSame := equality.Semantic.DeepEqual(existingCopy.RoleRef, requiredCopy.RoleRef)
 
-	if subjectsAreSame && roleRefIsSame {
+	if subjectsAreSame && roleRefIsSame && !metaModified {
 		return existingCopy, false, nil
 	}
 
@@ -296,8 +651,9 @@ func ApplyRole(ctx context.Context, client rbacclientv1.RolesGetter, required *r
 
 	existingCopy := existing.DeepCopy()
 
+	metaModified := mergeObjectMeta(&existingCopy.ObjectMeta, required.ObjectMeta)
 	contentSame := equality.Semantic.DeepEqual(existingCopy.Rules, required.Rules)
-	if contentSame {
+	if contentSame && !metaModified {
 		return existingCopy, false, nil
 	}
 
@@ -339,10 +695,11 @@ func ApplyRoleBinding(ctx context.Context, client rbacclientv1.RoleBindingsGette
 		}
 	}
 
+	metaModified := mergeObjectMeta(&existingCopy.ObjectMeta, requiredCopy.ObjectMeta)
 	subjectsAreSame := equality.Semantic.DeepEqual(existingCopy.Subjects, requiredCopy.Subjects)
 	roleRefIsSame := equality.Semantic.DeepEqual(existingCopy.RoleRef, requiredCopy.RoleRef)
 
-	if subjectsAreSame && roleRefIsSame {
+	if subjectsAreSame && roleRefIsSame && !metaModified {
 		return existingCopy, false, nil
 	}
 
@@ -7,9 +7,12 @@ import (
 	"github.com/stretchr/testify/assert"
 	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/fake"
 	"open-cluster-management.io/api/addon/v1alpha1"
 	v1 "open-cluster-management.io/api/cluster/v1"
+
+	"open-cluster-management.io/addon-framework/pkg/agent"
 )
 
 func TestPermissionBuilder(t *testing.T) {
@@ -60,3 +63,261 @@ func TestPermissionBuilder(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, updatingRole2.UID, actualRole2.UID)
 }
+
+func TestPermissionBuilderLabelsAndOwners(t *testing.T) {
+	testCluster := &v1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+	}
+	testAddon := &v1alpha1.ManagedClusterAddOn{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: testCluster.Name,
+			Name:      "test-addon",
+			UID:       "addon-uid",
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					APIVersion: v1alpha1.GroupVersion.String(),
+					Kind:       "ClusterManagementAddOn",
+					Name:       "test-addon",
+					UID:        "cma-uid",
+				},
+			},
+		},
+	}
+	// the existing role is created before the permission config label is introduced.
+	existingRole := &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testCluster.Name, Name: "foo"},
+	}
+	fakeKubeClient := fake.NewSimpleClientset(existingRole)
+	permissionConfigFn := NewRBACPermissionConfigBuilder(fakeKubeClient).
+		BindClusterRoleToGroup(&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "foo"}}, "test-group").
+		BindRoleToGroup(&rbacv1.Role{ObjectMeta: metav1.ObjectMeta{Name: "foo"}}, "test-group").
+		Build()
+
+	assert.NoError(t, permissionConfigFn(testCluster, testAddon))
+
+	clusterRole, err := fakeKubeClient.RbacV1().ClusterRoles().Get(context.TODO(), "foo", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "test-addon", clusterRole.Labels[PermissionConfigLabelKey])
+	assert.Len(t, clusterRole.OwnerReferences, 1)
+	assert.Equal(t, types.UID("cma-uid"), clusterRole.OwnerReferences[0].UID)
+
+	clusterRoleBinding, err := fakeKubeClient.RbacV1().ClusterRoleBindings().Get(context.TODO(), "foo", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "test-addon", clusterRoleBinding.Labels[PermissionConfigLabelKey])
+	assert.Len(t, clusterRoleBinding.OwnerReferences, 1)
+
+	role, err := fakeKubeClient.RbacV1().Roles(testCluster.Name).Get(context.TODO(), "foo", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "test-addon", role.Labels[PermissionConfigLabelKey])
+	assert.Len(t, role.OwnerReferences, 1)
+	assert.Equal(t, types.UID("addon-uid"), role.OwnerReferences[0].UID)
+
+	roleBinding, err := fakeKubeClient.RbacV1().RoleBindings(testCluster.Name).Get(context.TODO(), "foo", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "test-addon", roleBinding.Labels[PermissionConfigLabelKey])
+	assert.Len(t, roleBinding.OwnerReferences, 1)
+
+	assert.NoError(t, PruneRBACPermissions(context.TODO(), fakeKubeClient.RbacV1(), testCluster.Name, testAddon.Name))
+	roles, err := fakeKubeClient.RbacV1().Roles(testCluster.Name).List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, roles.Items)
+	roleBindings, err := fakeKubeClient.RbacV1().RoleBindings(testCluster.Name).List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, roleBindings.Items)
+
+	assert.NoError(t, PruneClusterScopedRBACPermissions(context.TODO(), fakeKubeClient.RbacV1(), testAddon.Name))
+	clusterRoles, err := fakeKubeClient.RbacV1().ClusterRoles().List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, clusterRoles.Items)
+	clusterRoleBindings, err := fakeKubeClient.RbacV1().ClusterRoleBindings().List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, clusterRoleBindings.Items)
+}
+
+func TestPermissionBuilderAgentGroup(t *testing.T) {
+	testAddon := &v1alpha1.ManagedClusterAddOn{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-addon"},
+	}
+	fakeKubeClient := fake.NewSimpleClientset()
+	permissionConfigFn := NewRBACPermissionConfigBuilder(fakeKubeClient).
+		BindRoleTemplateToAgentGroup(DefaultAgentRoleTemplate()).
+		BindClusterRoleToAgentGroup(&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "foo"}}).
+		Build()
+
+	for _, clusterName := range []string{"cluster1", "cluster2"} {
+		cluster := &v1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: clusterName}}
+		assert.NoError(t, permissionConfigFn(cluster, testAddon))
+
+		expectedGroup := agent.DefaultGroups(clusterName, testAddon.Name)[0]
+
+		role, err := fakeKubeClient.RbacV1().Roles(clusterName).Get(
+			context.TODO(), "open-cluster-management:test-addon:agent", metav1.GetOptions{})
+		assert.NoError(t, err)
+		assert.Len(t, role.Rules, 2)
+
+		binding, err := fakeKubeClient.RbacV1().RoleBindings(clusterName).Get(
+			context.TODO(), "open-cluster-management:test-addon:agent", metav1.GetOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "Role", binding.RoleRef.Kind)
+		assert.Equal(t, role.Name, binding.RoleRef.Name)
+		assert.Equal(t, expectedGroup, binding.Subjects[0].Name)
+
+		clusterRoleBinding, err := fakeKubeClient.RbacV1().RoleBindings(clusterName).Get(
+			context.TODO(), "foo", metav1.GetOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "ClusterRole", clusterRoleBinding.RoleRef.Kind)
+		assert.Equal(t, expectedGroup, clusterRoleBinding.Subjects[0].Name)
+		// the ServiceAccount of the agent is not bound if the agent is registered with csr.
+		assert.Len(t, binding.Subjects, 1)
+		assert.Len(t, clusterRoleBinding.Subjects, 1)
+	}
+
+	_, err := fakeKubeClient.RbacV1().ClusterRoles().Get(context.TODO(), "foo", metav1.GetOptions{})
+	assert.NoError(t, err)
+	clusterRoleBindings, err := fakeKubeClient.RbacV1().ClusterRoleBindings().List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, clusterRoleBindings.Items)
+}
+
+func TestPermissionBuilderAgentServiceAccount(t *testing.T) {
+	testAddon := &v1alpha1.ManagedClusterAddOn{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-addon", Namespace: "cluster1"},
+		Status: v1alpha1.ManagedClusterAddOnStatus{
+			RelatedObjects: []v1alpha1.ObjectReference{
+				{Resource: "serviceaccounts", Namespace: "cluster1", Name: agent.DefaultServiceAccountName("test-addon")},
+			},
+		},
+	}
+	cluster := &v1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: "cluster1"}}
+	fakeKubeClient := fake.NewSimpleClientset()
+	permissionConfigFn := NewRBACPermissionConfigBuilder(fakeKubeClient).
+		BindRoleTemplateToAgentGroup(DefaultAgentRoleTemplate()).
+		Build()
+	assert.NoError(t, permissionConfigFn(cluster, testAddon))
+
+	binding, err := fakeKubeClient.RbacV1().RoleBindings("cluster1").Get(
+		context.TODO(), "open-cluster-management:test-addon:agent", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, binding.Subjects, 2)
+	assert.True(t, IsServiceAccountBound(binding.Subjects, "cluster1", agent.DefaultServiceAccountName("test-addon")))
+}
+
+func TestIsServiceAccountBound(t *testing.T) {
+	cases := []struct {
+		name     string
+		subjects []rbacv1.Subject
+		expected bool
+	}{
+		{
+			name:     "serviceaccount",
+			subjects: []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Namespace: "cluster1", Name: "sa"}},
+			expected: true,
+		},
+		{
+			name:     "serviceaccount in other namespace",
+			subjects: []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Namespace: "cluster2", Name: "sa"}},
+		},
+		{
+			name:     "serviceaccount user",
+			subjects: []rbacv1.Subject{{Kind: rbacv1.UserKind, Name: "system:serviceaccount:cluster1:sa"}},
+			expected: true,
+		},
+		{
+			name:     "serviceaccounts group of the namespace",
+			subjects: []rbacv1.Subject{{Kind: rbacv1.GroupKind, Name: "system:serviceaccounts:cluster1"}},
+			expected: true,
+		},
+		{
+			name:     "agent group",
+			subjects: []rbacv1.Subject{{Kind: rbacv1.GroupKind, Name: agent.DefaultGroups("cluster1", "test")[0]}},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, IsServiceAccountBound(c.subjects, "cluster1", "sa"))
+		})
+	}
+}
+
+func TestPermissionBuilderSharedByAddons(t *testing.T) {
+	testCluster := &v1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+	}
+	newTestAddon := func(name string) *v1alpha1.ManagedClusterAddOn {
+		return &v1alpha1.ManagedClusterAddOn{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: testCluster.Name,
+				Name:      name,
+				UID:       types.UID(name + "-uid"),
+				OwnerReferences: []metav1.OwnerReference{
+					{
+						APIVersion: v1alpha1.GroupVersion.String(),
+						Kind:       "ClusterManagementAddOn",
+						Name:       name,
+						UID:        types.UID(name + "-cma-uid"),
+					},
+				},
+			},
+		}
+	}
+	addon1, addon2 := newTestAddon("addon1"), newTestAddon("addon2")
+
+	fakeKubeClient := fake.NewSimpleClientset()
+	permissionConfigFn := NewRBACPermissionConfigBuilder(fakeKubeClient).
+		BindClusterRoleToGroup(&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "shared"}}, "test-group").
+		BindRoleToGroup(&rbacv1.Role{ObjectMeta: metav1.ObjectMeta{Name: "shared"}}, "test-group").
+		Build()
+	assert.NoError(t, permissionConfigFn(testCluster, addon1))
+	assert.NoError(t, permissionConfigFn(testCluster, addon2))
+
+	// the shared resources are not relabelled by the second addon
+	clusterRole, err := fakeKubeClient.RbacV1().ClusterRoles().Get(context.TODO(), "shared", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "addon1", clusterRole.Labels[PermissionConfigLabelKey])
+	assert.Len(t, clusterRole.OwnerReferences, 2)
+	role, err := fakeKubeClient.RbacV1().Roles(testCluster.Name).Get(context.TODO(), "shared", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "addon1", role.Labels[PermissionConfigLabelKey])
+	assert.Len(t, role.OwnerReferences, 2)
+
+	// pruning the first addon keeps the resources for the second one
+	assert.NoError(t, PruneRBACPermissions(context.TODO(), fakeKubeClient.RbacV1(), testCluster.Name, addon1.Name))
+	assert.NoError(t, PruneClusterScopedRBACPermissions(context.TODO(), fakeKubeClient.RbacV1(), addon1.Name))
+	for _, obj := range []metav1.Object{
+		func() metav1.Object {
+			clusterRole, err := fakeKubeClient.RbacV1().ClusterRoles().Get(context.TODO(), "shared", metav1.GetOptions{})
+			assert.NoError(t, err)
+			return clusterRole
+		}(),
+		func() metav1.Object {
+			binding, err := fakeKubeClient.RbacV1().ClusterRoleBindings().Get(context.TODO(), "shared", metav1.GetOptions{})
+			assert.NoError(t, err)
+			return binding
+		}(),
+		func() metav1.Object {
+			role, err := fakeKubeClient.RbacV1().Roles(testCluster.Name).Get(context.TODO(), "shared", metav1.GetOptions{})
+			assert.NoError(t, err)
+			return role
+		}(),
+		func() metav1.Object {
+			binding, err := fakeKubeClient.RbacV1().RoleBindings(testCluster.Name).Get(context.TODO(), "shared", metav1.GetOptions{})
+			assert.NoError(t, err)
+			return binding
+		}(),
+	} {
+		assert.Equal(t, "addon2", obj.GetLabels()[PermissionConfigLabelKey])
+		assert.Len(t, obj.GetOwnerReferences(), 1)
+		assert.Equal(t, "addon2", obj.GetOwnerReferences()[0].Name)
+	}
+
+	// pruning the second addon deletes the resources
+	assert.NoError(t, PruneRBACPermissions(context.TODO(), fakeKubeClient.RbacV1(), testCluster.Name, addon2.Name))
+	assert.NoError(t, PruneClusterScopedRBACPermissions(context.TODO(), fakeKubeClient.RbacV1(), addon2.Name))
+	clusterRoles, err := fakeKubeClient.RbacV1().ClusterRoles().List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, clusterRoles.Items)
+	roles, err := fakeKubeClient.RbacV1().Roles(testCluster.Name).List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, roles.Items)
+}